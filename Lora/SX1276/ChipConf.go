@@ -0,0 +1,153 @@
+package SX1276
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"periph.io/x/conn/v3/physic"
+)
+
+const fxosc = 32000000
+
+// bwHz maps the ModemConfig1 Bw field to its bandwidth in Hz.
+var bwHz = [...]uint64{
+	7800,
+	uint64(BW_1),
+	uint64(BW_2),
+	uint64(BW_3),
+	uint64(BW_4),
+	uint64(BW_5),
+	uint64(BW_6),
+	uint64(BW_7),
+	uint64(BW_8),
+	500000,
+}
+
+func frfFromFreq(freq physic.Frequency) uint64 {
+	return (uint64(freq) << 19) / fxosc
+}
+
+// freqFromFrf rounds up so that frfFromFreq(freqFromFrf(frf)) == frf.
+func freqFromFrf(frf uint64) physic.Frequency {
+	return physic.Frequency((frf*fxosc + 1<<19 - 1) >> 19)
+}
+
+func bwFromIndex(idx byte) (uint64, error) {
+	if int(idx) >= len(bwHz) {
+		return 0, fmt.Errorf("reserved bandwidth index %d", idx)
+	}
+	return bwHz[idx], nil
+}
+
+func txPowerFromPaConfig(paConfig byte, lu *LoraUtils) uint8 {
+	outputPower, paBoost := lu.getTxPower(paConfig)
+	if paBoost {
+		return outputPower + 2
+	}
+	maxPower := float64(paConfig >> 4 & 0x07)
+	pOut := 10.8 + 0.6*maxPower - float64(15-outputPower)
+	if pOut < 0 {
+		return 0
+	}
+	return uint8(math.Round(pOut))
+}
+
+func (gl *GoLora) decodeConf(regs map[byte]byte) (LoraConf, error) {
+	lu := gl.LoraUtils
+	bw, err := bwFromIndex(lu.getBW(regs[internal.REG_MODEM_CONFIG_1]))
+	if err != nil {
+		return LoraConf{}, err
+	}
+	frf := lu.getFreq([]byte{regs[internal.REG_FRF_MSB], regs[internal.REG_FRF_MID], regs[internal.REG_FRF_LSB]})
+	return LoraConf{
+		TxPower:        txPowerFromPaConfig(regs[internal.REG_PA_CONFIG], lu),
+		SF:             lu.getSF(regs[internal.REG_MODEM_CONFIG_2]),
+		BW:             bw,
+		Denum:          lu.getCodingRate(regs[internal.REG_MODEM_CONFIG_1]) + 4,
+		PreambleLength: lu.getPreamble([]byte{regs[internal.REG_PREAMBLE_MSB], regs[internal.REG_PREAMBLE_LSB]}),
+		SyncWord:       regs[internal.REG_SYNC_WORD],
+		Frequency:      freqFromFrf(frf),
+		Header:         Header(lu.getHeader(regs[internal.REG_MODEM_CONFIG_1])),
+		EnableCrc:      lu.getCrc(regs[internal.REG_MODEM_CONFIG_2]),
+		InvertIQ: InvertIQ{
+			TX: regs[internal.REG_INVERT_IQ]&internal.INVERT_IQ_TX_OFF == 0,
+			RX: regs[internal.REG_INVERT_IQ]&internal.INVERT_IQ_RX != 0,
+		},
+	}, nil
+}
+
+func (gl *GoLora) readConfFromChipUnsafe() (LoraConf, error) {
+	regs := make(map[byte]byte, len(confRegisters))
+	for _, reg := range confRegisters {
+		val, err := gl.readReg(reg)
+		if err != nil {
+			return LoraConf{}, fmt.Errorf("failed to read register 0x%02X: %w", reg, err)
+		}
+		gl.shadow.set(reg, val)
+		regs[reg] = val
+	}
+	return gl.decodeConf(regs)
+}
+
+func (gl *GoLora) ReadConfFromChip() (LoraConf, error) {
+	var conf LoraConf
+	err := gl.do(context.Background(), func(ctx context.Context) error {
+		var err error
+		conf, err = gl.readConfFromChipUnsafe()
+		return err
+	})
+	return conf, err
+}
+
+type ConfMismatch struct {
+	Field  string
+	Cached any
+	Actual any
+}
+
+func (m ConfMismatch) String() string {
+	return fmt.Sprintf("%s: cached %v, chip %v", m.Field, m.Cached, m.Actual)
+}
+
+// Diff compares two configurations the way the chip sees them, so values that
+// encode to the same register contents (e.g. frequencies within one FRF step)
+// are not reported.
+func Diff(cached, actual LoraConf) []ConfMismatch {
+	var mismatches []ConfMismatch
+	add := func(field string, c, a any) {
+		mismatches = append(mismatches, ConfMismatch{Field: field, Cached: c, Actual: a})
+	}
+	if cached.TxPower != actual.TxPower {
+		add("TxPower", cached.TxPower, actual.TxPower)
+	}
+	if cached.SF != actual.SF {
+		add("SF", cached.SF, actual.SF)
+	}
+	if cached.BW != actual.BW {
+		add("BW", cached.BW, actual.BW)
+	}
+	if cached.Denum != actual.Denum {
+		add("Denum", cached.Denum, actual.Denum)
+	}
+	if cached.PreambleLength != actual.PreambleLength {
+		add("PreambleLength", cached.PreambleLength, actual.PreambleLength)
+	}
+	if cached.SyncWord != actual.SyncWord {
+		add("SyncWord", cached.SyncWord, actual.SyncWord)
+	}
+	if frfFromFreq(cached.Frequency)&0xffffff != frfFromFreq(actual.Frequency)&0xffffff {
+		add("Frequency", uint64(cached.Frequency), uint64(actual.Frequency))
+	}
+	if cached.Header != actual.Header {
+		add("Header", cached.Header, actual.Header)
+	}
+	if cached.EnableCrc != actual.EnableCrc {
+		add("EnableCrc", cached.EnableCrc, actual.EnableCrc)
+	}
+	if cached.InvertIQ != actual.InvertIQ {
+		add("InvertIQ", cached.InvertIQ, actual.InvertIQ)
+	}
+	return mismatches
+}
@@ -0,0 +1,122 @@
+package SX1276
+
+import (
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestGoLora_ReadConfFromChip(t *testing.T) {
+	t.Run("it Should decode what Begin wrote", func(t *testing.T) {
+		fc := newFakeChip()
+		conf := newAppliedLoraConf()
+		conf.Frequency = 868100000
+		conf.Header = Implicit
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), conf)
+		assert.NoError(t, gl.Begin())
+
+		chipConf, err := gl.ReadConfFromChip()
+		assert.NoError(t, err)
+		assert.Empty(t, Diff(gl.GetConf(), chipConf))
+		assert.Equal(t, gl.Conf.SF, chipConf.SF)
+		assert.Equal(t, gl.Conf.BW, chipConf.BW)
+		assert.Equal(t, gl.Conf.Denum, chipConf.Denum)
+		assert.Equal(t, gl.Conf.TxPower, chipConf.TxPower)
+		assert.Equal(t, Implicit, chipConf.Header)
+		assert.InDelta(t, 868100000, float64(chipConf.Frequency), 62)
+	})
+
+	t.Run("it Should round-trip 500 kHz", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+
+		next := gl.GetConf()
+		next.SF = 8
+		next.BW = 500000
+		assert.NoError(t, gl.ApplyConfig(next))
+		assert.Equal(t, next, gl.GetConf())
+		assert.Equal(t, byte(0x90), fc.reg(internal.REG_MODEM_CONFIG_1)&0xf0)
+
+		chipConf, err := gl.ReadConfFromChip()
+		assert.NoError(t, err)
+		assert.Empty(t, Diff(gl.GetConf(), chipConf))
+		assert.Equal(t, uint64(500000), AirtimeParams(chipConf).BW)
+	})
+
+	t.Run("it Should report registers changed behind the driver's back", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+
+		fc.poke(internal.REG_MODEM_CONFIG_2, 0xc4)
+		fc.poke(internal.REG_MODEM_CONFIG_1, 0x92)
+		fc.poke(internal.REG_SYNC_WORD, 0x34)
+		chipConf, err := gl.ReadConfFromChip()
+		assert.NoError(t, err)
+		assert.Equal(t, []ConfMismatch{
+			{Field: "SF", Cached: uint8(7), Actual: uint8(12)},
+			{Field: "BW", Cached: uint64(BW_7), Actual: uint64(500000)},
+			{Field: "SyncWord", Cached: uint8(0x12), Actual: uint8(0x34)},
+		}, Diff(gl.GetConf(), chipConf))
+	})
+
+	t.Run("it Should decode IQ inversion", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		tests := []struct {
+			reg  byte
+			want InvertIQ
+		}{
+			{reg: 0x27, want: InvertIQ{}},
+			{reg: 0x67, want: InvertIQ{RX: true}},
+			{reg: 0x26, want: InvertIQ{TX: true}},
+			{reg: 0x66, want: InvertIQ{TX: true, RX: true}},
+		}
+		for _, tt := range tests {
+			fc.poke(internal.REG_INVERT_IQ, tt.reg)
+			chipConf, err := gl.ReadConfFromChip()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, chipConf.InvertIQ, "reg 0x%02x", tt.reg)
+		}
+	})
+
+	t.Run("it Should reject reserved bandwidth values", func(t *testing.T) {
+		fc := newFakeChip()
+		fc.poke(internal.REG_MODEM_CONFIG_1, 0xa2)
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf())
+		_, err := gl.ReadConfFromChip()
+		assert.EqualError(t, err, "reserved bandwidth index 10")
+	})
+
+	t.Run("it Should return read errors", func(t *testing.T) {
+		gl := NewGoLoraSX1276(testsDrvMock(nil, assert.AnError)(), newDefLoraConf())
+		_, err := gl.ReadConfFromChip()
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}
+
+func TestFreqFromFrf_RoundTrip(t *testing.T) {
+	for _, freq := range []physic.Frequency{433175000, 868100000, 868300000, 869525000, 902300000, 923200000} {
+		frf := frfFromFreq(freq)
+		assert.Equal(t, frf, frfFromFreq(freqFromFrf(frf)))
+	}
+}
+
+func TestTxPowerFromPaConfig(t *testing.T) {
+	lu := newLoraUtils()
+	assert.Equal(t, uint8(17), txPowerFromPaConfig(0x8f, lu))
+	assert.Equal(t, uint8(2), txPowerFromPaConfig(0x80, lu))
+	assert.Equal(t, uint8(15), txPowerFromPaConfig(0x7f, lu))
+	assert.Equal(t, uint8(0), txPowerFromPaConfig(0x00, lu))
+}
+
+func TestDiff_IgnoresSameFrfStep(t *testing.T) {
+	a := newAppliedLoraConf()
+	b := a
+	b.Frequency = a.Frequency + 10
+	assert.Empty(t, Diff(a, b))
+	b.Frequency = a.Frequency + 1000
+	assert.Len(t, Diff(a, b), 1)
+}
@@ -0,0 +1,154 @@
+package SX1276
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+)
+
+type confStep struct {
+	name    string
+	changed func(current, next LoraConf) bool
+	apply   func(gl *GoLora, conf LoraConf) error
+}
+
+var confSteps = []confStep{
+	{
+		name:    "tx power",
+		changed: func(current, next LoraConf) bool { return current.TxPower != next.TxPower },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setTxPowerUnsafe(conf.TxPower) },
+	},
+	{
+		name:    "spreading factor",
+		changed: func(current, next LoraConf) bool { return current.SF != next.SF },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setSFUnsafe(conf.SF) },
+	},
+	{
+		name:    "bandwidth",
+		changed: func(current, next LoraConf) bool { return current.BW != next.BW },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setBWUnsafe(conf.BW) },
+	},
+	{
+		name:    "coding rate",
+		changed: func(current, next LoraConf) bool { return current.Denum != next.Denum },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setCodingRateUnsafe(conf.Denum) },
+	},
+	{
+		name:    "preamble",
+		changed: func(current, next LoraConf) bool { return current.PreambleLength != next.PreambleLength },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setPreambleUnsafe(conf.PreambleLength) },
+	},
+	{
+		name:    "sync word",
+		changed: func(current, next LoraConf) bool { return current.SyncWord != next.SyncWord },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setSyncWordUnsafe(conf.SyncWord) },
+	},
+	{
+		name:    "frequency",
+		changed: func(current, next LoraConf) bool { return current.Frequency != next.Frequency },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setFrequencyUnsafe(conf.Frequency) },
+	},
+	{
+		name:    "header",
+		changed: func(current, next LoraConf) bool { return current.Header != next.Header },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setHeaderUnsafe(conf.Header) },
+	},
+	{
+		name:    "crc",
+		changed: func(current, next LoraConf) bool { return current.EnableCrc != next.EnableCrc },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setCrcUnsafe(conf.EnableCrc) },
+	},
+	{
+		name:    "invert iq",
+		changed: func(current, next LoraConf) bool { return current.InvertIQ != next.InvertIQ },
+		apply:   func(gl *GoLora, conf LoraConf) error { return gl.setInvertIQUnsafe(conf.InvertIQ) },
+	},
+}
+
+// confRegisters are the registers touched by confSteps, saved before
+// ApplyConfig so a failed apply can put the chip back where it was.
+var confRegisters = []byte{
+	internal.REG_PA_CONFIG,
+	internal.REG_FRF_MSB,
+	internal.REG_FRF_MID,
+	internal.REG_FRF_LSB,
+	internal.REG_MODEM_CONFIG_1,
+	internal.REG_MODEM_CONFIG_2,
+	internal.REG_PREAMBLE_MSB,
+	internal.REG_PREAMBLE_LSB,
+	internal.REG_MODEM_CONFIG_3,
+	internal.REG_DETECTION_OPTIMIZE,
+	internal.REG_DETECTION_THRESHOLD,
+	internal.REG_SYNC_WORD,
+	internal.REG_INVERT_IQ,
+	internal.REG_INVERT_IQ_2,
+}
+
+func (gl *GoLora) readRegMany(regs []byte) ([]byte, error) {
+	values := make([]byte, len(regs))
+	for idx, reg := range regs {
+		val, err := gl.readRegShadow(reg)
+		if err != nil {
+			return nil, err
+		}
+		values[idx] = val
+	}
+	return values, nil
+}
+
+func (gl *GoLora) rollbackConf(snapshot []byte, prevConf LoraConf) error {
+	gl.Conf = prevConf
+	var errs []error
+	for idx, reg := range confRegisters {
+		if err := gl.writeRegShadow(reg, snapshot[idx]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore register 0x%02X: %w", reg, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (gl *GoLora) applyConfUnsafe(conf LoraConf) error {
+	prevConf := gl.Conf
+	prevMode := gl.Mode
+	snapshot, err := gl.readRegMany(confRegisters)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot registers: %w", err)
+	}
+	if err := gl.changeModeUnsafe(Idle); err != nil {
+		return fmt.Errorf("failed to set Idle mode: %w", err)
+	}
+
+	var errs []error
+	for _, step := range confSteps {
+		if !step.changed(prevConf, conf) {
+			continue
+		}
+		if err := step.apply(gl, conf); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set %s: %w", step.name, err))
+		}
+	}
+	if len(errs) == 0 {
+		if err := gl.verifyShadowUnsafe(confRegisters); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		if err := gl.rollbackConf(snapshot, prevConf); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if prevMode != Tx && prevMode != Idle {
+		if err := gl.changeModeUnsafe(prevMode); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore mode: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (gl *GoLora) ApplyConfig(conf LoraConf) error {
+	return gl.doConf("apply config", func() error {
+		return gl.applyConfUnsafe(conf)
+	})
+}
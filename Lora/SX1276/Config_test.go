@@ -0,0 +1,153 @@
+package SX1276
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAppliedLoraConf() LoraConf {
+	return LoraConf{
+		TxPower:        14,
+		SF:             7,
+		BW:             uint64(BW_7),
+		Denum:          5,
+		PreambleLength: 8,
+		SyncWord:       0x12,
+		Frequency:      868000000,
+		Header:         Explicit,
+		EnableCrc:      true,
+	}
+}
+
+func TestGoLora_ApplyConfig(t *testing.T) {
+	t.Run("it Should apply every changed setting", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+
+		next := gl.GetConf()
+		next.SF = 10
+		next.SyncWord = 0x34
+		next.Frequency = 915000000
+		err := gl.ApplyConfig(next)
+		assert.NoError(t, err)
+		assert.Equal(t, next, gl.Conf)
+		assert.Equal(t, byte(0xa0), fc.reg(internal.REG_MODEM_CONFIG_2)&0xf0)
+		assert.Equal(t, byte(0x34), fc.reg(internal.REG_SYNC_WORD))
+		assert.Equal(t, byte(0xe4), fc.reg(internal.REG_FRF_MSB))
+	})
+
+	t.Run("it Should follow SF and bandwidth with LowDataRateOptimize", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+		assert.Equal(t, byte(0x04), fc.reg(internal.REG_MODEM_CONFIG_3))
+
+		tests := []struct {
+			sf   uint8
+			bw   BW
+			want byte
+		}{
+			{sf: 12, bw: BW_7, want: 0x0c},
+			{sf: 11, bw: BW_7, want: 0x0c},
+			{sf: 11, bw: BW_8, want: 0x04},
+			{sf: 7, bw: BW_7, want: 0x04},
+		}
+		for _, tt := range tests {
+			next := gl.GetConf()
+			next.SF = tt.sf
+			next.BW = uint64(tt.bw)
+			assert.NoError(t, gl.ApplyConfig(next))
+			assert.Equal(t, tt.want, fc.reg(internal.REG_MODEM_CONFIG_3), "SF%d BW%d", tt.sf, tt.bw)
+		}
+	})
+
+	t.Run("it Should roll back registers and conf if a setter fails", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+		prevConf := gl.GetConf()
+		prevModem2 := fc.reg(internal.REG_MODEM_CONFIG_2)
+
+		fc.writeErrs[internal.REG_SYNC_WORD] = errors.New("sync word write err")
+		next := prevConf
+		next.SF = 12
+		next.SyncWord = 0x34
+		err := gl.ApplyConfig(next)
+		assert.ErrorContains(t, err, "failed to set sync word: sync word write err")
+		assert.Equal(t, prevConf, gl.Conf)
+
+		delete(fc.writeErrs, internal.REG_SYNC_WORD)
+		assert.Equal(t, prevModem2, fc.reg(internal.REG_MODEM_CONFIG_2))
+		assert.Equal(t, byte(0x04), fc.reg(internal.REG_MODEM_CONFIG_3))
+	})
+
+	t.Run("it Should aggregate every failing setter", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+
+		fc.writeErrs[internal.REG_SYNC_WORD] = errors.New("sync word write err")
+		fc.writeErrs[internal.REG_PREAMBLE_LSB] = errors.New("preamble write err")
+		next := gl.GetConf()
+		next.PreambleLength = 12
+		next.SyncWord = 0x34
+		err := gl.ApplyConfig(next)
+		assert.ErrorContains(t, err, "failed to set preamble")
+		assert.ErrorContains(t, err, "failed to set sync word")
+	})
+
+	t.Run("it Should apply IQ inversion per direction", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		fc.poke(internal.REG_INVERT_IQ, 0x27)
+
+		next := gl.GetConf()
+		next.InvertIQ = InvertIQ{RX: true}
+		assert.NoError(t, gl.ApplyConfig(next))
+		assert.Equal(t, next, gl.GetConf())
+		assert.Equal(t, byte(0x67), fc.reg(internal.REG_INVERT_IQ))
+		assert.Equal(t, byte(0x19), fc.reg(internal.REG_INVERT_IQ_2))
+
+		next.InvertIQ = InvertIQ{}
+		assert.NoError(t, gl.ApplyConfig(next))
+		assert.Equal(t, byte(0x27), fc.reg(internal.REG_INVERT_IQ))
+		assert.Equal(t, byte(0x1d), fc.reg(internal.REG_INVERT_IQ_2))
+	})
+
+	t.Run("it Should roll back if readback does not match", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+		prevConf := gl.GetConf()
+
+		fc.stuck[internal.REG_SYNC_WORD] = 0x12
+		next := prevConf
+		next.SyncWord = 0x34
+		err := gl.ApplyConfig(next)
+		assert.ErrorContains(t, err, "register 0x39 readback mismatch")
+		assert.Equal(t, prevConf, gl.Conf)
+	})
+
+	t.Run("it Should return to the previous receive mode", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+		assert.NoError(t, gl.Begin())
+		assert.NoError(t, gl.ChangeMode(RxContinuous))
+
+		next := gl.GetConf()
+		next.SF = 9
+		assert.NoError(t, gl.ApplyConfig(next))
+		assert.Equal(t, RxContinuous, gl.Mode)
+	})
+}
+
+func TestGoLora_Begin_ReportsFailingSetter(t *testing.T) {
+	fc := newFakeChip()
+	fc.writeErrs[internal.REG_MODEM_CONFIG_2] = errors.New("modem config write err")
+	gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+	err := gl.Begin()
+	assert.EqualError(t, err, "failed to set spreading factor: modem config write err")
+}
@@ -0,0 +1,289 @@
+package SX1276
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	DutyCycleStateVersion  = 1
+	defaultDutyCycleWindow = time.Hour
+)
+
+// SubBand is a frequency range sharing one duty-cycle budget. Min and Max are
+// plain hertz like LoraConf.Frequency; Min is inside the band and Max is not,
+// so bands that touch never both claim the frequency between them.
+type SubBand struct {
+	Name  string
+	Min   physic.Frequency
+	Max   physic.Frequency
+	Limit float64
+}
+
+// EU868SubBands are the ETSI EN 300 220 sub-bands used by LoRaWAN in Europe.
+// 865-868 MHz and g1 both allow 1% but are separate budgets, so h1.4 takes the
+// ERC 70-03 name. The gaps between bands are left out; a limiter using this
+// table does not restrict them, so stay off them.
+var EU868SubBands = []SubBand{
+	{Name: "g", Min: 863000000, Max: 865000000, Limit: 0.001},
+	{Name: "h1.4", Min: 865000000, Max: 868000000, Limit: 0.01},
+	{Name: "g1", Min: 868000000, Max: 868600000, Limit: 0.01},
+	{Name: "g2", Min: 868700000, Max: 869200000, Limit: 0.001},
+	{Name: "g3", Min: 869400000, Max: 869650000, Limit: 0.1},
+	{Name: "g4", Min: 869700000, Max: 870000000, Limit: 0.01},
+}
+
+type DutyCycleEntry struct {
+	Band    string        `json:"band"`
+	Start   time.Time     `json:"start"`
+	Airtime time.Duration `json:"airtime"`
+}
+
+func (e DutyCycleEntry) end() time.Time {
+	return e.Start.Add(e.Airtime)
+}
+
+type DutyCycleState struct {
+	Version int              `json:"version"`
+	Window  time.Duration    `json:"window"`
+	Entries []DutyCycleEntry `json:"entries"`
+}
+
+// DutyCycleLimiter keeps the on-air time of each sub-band over a sliding
+// window. Frequencies outside every sub-band are not limited at all: Admit
+// always lets them through, so the table must cover every frequency the
+// radio may transmit on.
+type DutyCycleLimiter struct {
+	window time.Duration
+	bands  []SubBand
+
+	mu    sync.Mutex
+	usage map[string][]DutyCycleEntry
+}
+
+func NewDutyCycleLimiter(window time.Duration, bands []SubBand) *DutyCycleLimiter {
+	if window <= 0 {
+		window = defaultDutyCycleWindow
+	}
+	return &DutyCycleLimiter{
+		window: window,
+		bands:  append([]SubBand(nil), bands...),
+		usage:  map[string][]DutyCycleEntry{},
+	}
+}
+
+func (l *DutyCycleLimiter) band(freq physic.Frequency) (SubBand, bool) {
+	for _, b := range l.bands {
+		if freq >= b.Min && freq < b.Max {
+			return b, true
+		}
+	}
+	return SubBand{}, false
+}
+
+func (l *DutyCycleLimiter) budget(b SubBand) time.Duration {
+	return time.Duration(float64(l.window) * b.Limit)
+}
+
+// pruneLocked drops transmissions that ended before the window started and
+// returns what is left for the band.
+func (l *DutyCycleLimiter) pruneLocked(band string, now time.Time) []DutyCycleEntry {
+	entries := l.usage[band]
+	cut := 0
+	for cut < len(entries) && !entries[cut].end().After(now.Add(-l.window)) {
+		cut++
+	}
+	entries = entries[cut:]
+	if len(entries) == 0 {
+		delete(l.usage, band)
+	} else {
+		l.usage[band] = entries
+	}
+	return entries
+}
+
+func usedAirtime(entries []DutyCycleEntry) time.Duration {
+	var used time.Duration
+	for _, e := range entries {
+		used += e.Airtime
+	}
+	return used
+}
+
+// Admit reports whether a transmission of airtime may start at now. When it
+// may not, retryAfter is how long until it would fit, or negative if it is
+// longer than the band's whole budget.
+func (l *DutyCycleLimiter) Admit(freq physic.Frequency, airtime time.Duration, now time.Time) (retryAfter time.Duration, ok bool) {
+	b, limited := l.band(freq)
+	if !limited {
+		return 0, true
+	}
+	budget := l.budget(b)
+	if airtime > budget {
+		return -1, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.pruneLocked(b.Name, now)
+	excess := usedAirtime(entries) + airtime - budget
+	if excess <= 0 {
+		return 0, true
+	}
+	for _, e := range entries {
+		excess -= e.Airtime
+		if excess <= 0 {
+			return e.end().Add(l.window).Sub(now), false
+		}
+	}
+	return l.window, false
+}
+
+func (l *DutyCycleLimiter) Record(freq physic.Frequency, start time.Time, airtime time.Duration) {
+	b, limited := l.band(freq)
+	if !limited {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := append(l.pruneLocked(b.Name, start), DutyCycleEntry{Band: b.Name, Start: start, Airtime: airtime})
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Start.Before(entries[j].Start) })
+	l.usage[b.Name] = entries
+}
+
+// Remaining is the on-air time still available on freq's sub-band at now.
+func (l *DutyCycleLimiter) Remaining(freq physic.Frequency, now time.Time) time.Duration {
+	b, limited := l.band(freq)
+	if !limited {
+		return l.window
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	left := l.budget(b) - usedAirtime(l.pruneLocked(b.Name, now))
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+type DutyCycleUsage struct {
+	Band   SubBand
+	Used   time.Duration
+	Budget time.Duration
+}
+
+// Usage reports every sub-band's on-air time within the window ending at now.
+func (l *DutyCycleLimiter) Usage(now time.Time) []DutyCycleUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usage := make([]DutyCycleUsage, len(l.bands))
+	for i, b := range l.bands {
+		usage[i] = DutyCycleUsage{Band: b, Used: usedAirtime(l.pruneLocked(b.Name, now)), Budget: l.budget(b)}
+	}
+	return usage
+}
+
+// NextAllowed is how long to wait from now before a transmission of airtime
+// on freq is admitted; zero means it may go right away.
+func (l *DutyCycleLimiter) NextAllowed(freq physic.Frequency, airtime time.Duration, now time.Time) time.Duration {
+	wait, ok := l.Admit(freq, airtime, now)
+	if ok {
+		return 0
+	}
+	return wait
+}
+
+func (l *DutyCycleLimiter) State() DutyCycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := DutyCycleState{Version: DutyCycleStateVersion, Window: l.window, Entries: []DutyCycleEntry{}}
+	for _, entries := range l.usage {
+		st.Entries = append(st.Entries, entries...)
+	}
+	sort.SliceStable(st.Entries, func(i, j int) bool { return st.Entries[i].Start.Before(st.Entries[j].Start) })
+	return st
+}
+
+// Restore replaces the accounting with st, e.g. one saved before a restart.
+// st must have been saved with the same window.
+func (l *DutyCycleLimiter) Restore(st DutyCycleState) error {
+	if st.Version != DutyCycleStateVersion {
+		return fmt.Errorf("unsupported duty cycle state version %d", st.Version)
+	}
+	if st.Window != l.window {
+		return fmt.Errorf("duty cycle state window %s does not match the limiter's %s", st.Window, l.window)
+	}
+	usage := map[string][]DutyCycleEntry{}
+	for _, e := range st.Entries {
+		usage[e.Band] = append(usage[e.Band], e)
+	}
+	for _, entries := range usage {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Start.Before(entries[j].Start) })
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.usage = usage
+	return nil
+}
+
+func SaveDutyCycleState(path string, st DutyCycleState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func LoadDutyCycleState(path string) (DutyCycleState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return DutyCycleState{}, err
+	}
+	var st DutyCycleState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return DutyCycleState{}, fmt.Errorf("failed to parse duty cycle state: %w", err)
+	}
+	return st, nil
+}
+
+// SetDutyCycleLimiter makes SendPacket and any TxQueue without its own
+// admitter check every transmission against adm; nil removes the limit.
+func (gl *GoLora) SetDutyCycleLimiter(adm TxAdmitter) {
+	_ = gl.do(context.Background(), func(ctx context.Context) error {
+		gl.dutyCycle = adm
+		return nil
+	})
+}
+
+// admitUnsafe checks conf's frequency budget for a payload of the given size.
+func (gl *GoLora) admitUnsafe(adm TxAdmitter, conf LoraConf, payloadLength int) (time.Duration, error) {
+	onAir := airtime(conf, uint16(payloadLength))
+	if adm == nil {
+		return onAir, nil
+	}
+	if wait, ok := adm.Admit(conf.Frequency, onAir, time.Now()); !ok {
+		return onAir, &DutyCycleError{RetryAfter: wait}
+	}
+	return onAir, nil
+}
+
+type DutyCycleError struct {
+	RetryAfter time.Duration
+}
+
+func (e *DutyCycleError) Error() string {
+	if e.RetryAfter < 0 {
+		return "duty cycle limit reached: packet exceeds the sub-band budget"
+	}
+	return fmt.Sprintf("duty cycle limit reached: retry after %s", e.RetryAfter)
+}
+
+func (e *DutyCycleError) Is(target error) bool {
+	return target == ErrDutyCycle
+}
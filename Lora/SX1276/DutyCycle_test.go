@@ -0,0 +1,160 @@
+package SX1276
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"periph.io/x/conn/v3/physic"
+)
+
+func TestDutyCycleLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewDutyCycleLimiter(time.Hour, EU868SubBands)
+	const g1 = 868100000
+
+	assert.Equal(t, 36*time.Second, l.Remaining(g1, now))
+	wait, ok := l.Admit(g1, 20*time.Second, now)
+	assert.True(t, ok)
+	assert.Zero(t, wait)
+	l.Record(g1, now, 20*time.Second)
+	l.Record(g1, now.Add(10*time.Minute), 10*time.Second)
+	assert.Equal(t, 6*time.Second, l.Remaining(g1, now.Add(11*time.Minute)))
+
+	// 10s needs the first 20s entry to expire: it ended at now+20s
+	wait, ok = l.Admit(g1, 10*time.Second, now.Add(11*time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, time.Hour+20*time.Second-11*time.Minute, wait)
+	assert.Equal(t, wait, l.NextAllowed(g1, 10*time.Second, now.Add(11*time.Minute)))
+	assert.Zero(t, l.NextAllowed(g1, 10*time.Second, now.Add(time.Hour+20*time.Second)))
+
+	// other sub-bands keep their own budget
+	assert.Equal(t, 360*time.Second, l.Remaining(869525000, now))
+	_, ok = l.Admit(869525000, 5*time.Second, now.Add(11*time.Minute))
+	assert.True(t, ok)
+
+	wait, ok = l.Admit(g1, time.Minute, now)
+	assert.False(t, ok)
+	assert.Negative(t, wait, "longer than the whole budget")
+
+	_, ok = l.Admit(915000000, time.Hour, now)
+	assert.True(t, ok, "frequencies outside the table are not limited")
+}
+
+func TestDutyCycleLimiter_BandEdges(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewDutyCycleLimiter(time.Hour, EU868SubBands)
+	tests := []struct {
+		name string
+		freq physic.Frequency
+		want time.Duration
+	}{
+		{name: "below the shared edge", freq: 864999999, want: 3600 * time.Millisecond},
+		{name: "on the shared edge", freq: 865000000, want: 36 * time.Second},
+		{name: "top of h1.4", freq: 867999999, want: 36 * time.Second},
+		{name: "bottom of g1", freq: 868000000, want: 36 * time.Second},
+		{name: "top of g1", freq: 868599999, want: 36 * time.Second},
+		{name: "gap above g1", freq: 868600000, want: time.Hour},
+		{name: "bottom of g2", freq: 868700000, want: 3600 * time.Millisecond},
+		{name: "top of the band", freq: 870000000, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, l.Remaining(tt.freq, now))
+		})
+	}
+
+	// airtime on an edge is charged to the band above it alone
+	l.Record(865000000, now, 10*time.Second)
+	assert.Equal(t, 26*time.Second, l.Remaining(867100000, now))
+	assert.Equal(t, 3600*time.Millisecond, l.Remaining(864000000, now))
+	l.Record(868000000, now, 10*time.Second)
+	assert.Equal(t, 26*time.Second, l.Remaining(868100000, now))
+	assert.Equal(t, 26*time.Second, l.Remaining(867100000, now))
+}
+
+func TestDutyCycleLimiter_Persist(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewDutyCycleLimiter(time.Hour, EU868SubBands)
+	l.Record(868100000, now, 30*time.Second)
+	l.Record(869525000, now, 100*time.Second)
+
+	path := filepath.Join(t.TempDir(), "dc.json")
+	assert.NoError(t, SaveDutyCycleState(path, l.State()))
+	st, err := LoadDutyCycleState(path)
+	assert.NoError(t, err)
+
+	restored := NewDutyCycleLimiter(time.Hour, EU868SubBands)
+	assert.NoError(t, restored.Restore(st))
+	assert.Equal(t, 6*time.Second, restored.Remaining(868100000, now.Add(time.Minute)))
+	assert.Equal(t, 260*time.Second, restored.Remaining(869525000, now.Add(time.Minute)))
+
+	other := NewDutyCycleLimiter(10*time.Minute, EU868SubBands)
+	assert.EqualError(t, other.Restore(st), "duty cycle state window 1h0m0s does not match the limiter's 10m0s")
+	assert.Equal(t, 6*time.Second, other.Remaining(868100000, now.Add(time.Minute)))
+
+	st.Version = 9
+	assert.EqualError(t, restored.Restore(st), "unsupported duty cycle state version 9")
+}
+
+func TestGoLora_SendPacket_DutyCycle(t *testing.T) {
+	fc := newFakeChip()
+	conf := newAppliedLoraConf()
+	conf.SF = 12
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), conf))
+	defer gl.Close()
+	l := NewDutyCycleLimiter(time.Hour, EU868SubBands)
+	gl.SetDutyCycleLimiter(l)
+
+	// an SF12 packet of 51 bytes is ~2.5s on air, so the 36s g1 budget
+	// allows 14 of them
+	payload := make([]byte, 51)
+	sent := 0
+	var err error
+	for ; sent < 20; sent++ {
+		if err = gl.SendPacket(context.Background(), payload); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, 14, sent)
+	assert.ErrorIs(t, err, ErrDutyCycle)
+	var dcErr *DutyCycleError
+	if assert.ErrorAs(t, err, &dcErr) {
+		assert.Greater(t, dcErr.RetryAfter, 59*time.Minute)
+	}
+	assert.Less(t, l.Remaining(conf.Frequency, time.Now()), gl.GetAirtime(51))
+
+	q := NewTxQueue(gl, TxQueueConfig{})
+	defer q.Close()
+	h, _ := q.Enqueue(TxRequest{Payload: payload})
+	assert.Equal(t, TxDutyCycleDeferred, waitTx(t, h).Status)
+	h, _ = q.Enqueue(TxRequest{Payload: payload, Overrides: TxOverrides{Frequency: 869525000}})
+	assert.Equal(t, TxSent, waitTx(t, h).Status, "g3 has its own budget")
+}
+
+func TestTxQueue_WaitForDutyCycle(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+	defer gl.Close()
+	band := SubBand{Name: "test", Min: 868000000, Max: 868000001, Limit: 0.5}
+	l := NewDutyCycleLimiter(200*time.Millisecond, []SubBand{band})
+	q := NewTxQueue(gl, TxQueueConfig{Admitter: l, WaitForDutyCycle: true})
+	defer q.Close()
+
+	payload := make([]byte, 10)
+	assert.Less(t, 2*gl.GetAirtime(10), 100*time.Millisecond)
+	assert.Greater(t, 3*gl.GetAirtime(10), 100*time.Millisecond)
+	var handles []*TxHandle
+	for i := 0; i < 3; i++ {
+		h, _ := q.Enqueue(TxRequest{Payload: payload})
+		handles = append(handles, h)
+	}
+	first := waitTx(t, handles[0])
+	waitTx(t, handles[1])
+	last := waitTx(t, handles[2])
+	assert.Equal(t, TxSent, last.Status)
+	assert.GreaterOrEqual(t, last.Start.Sub(first.Start), 200*time.Millisecond)
+	assert.Len(t, fc.transmitted(), 3)
+}
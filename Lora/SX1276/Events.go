@@ -0,0 +1,276 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const subscriberQueueLen = 256
+
+// Packet is a received packet or, for OnTxDone, one that was sent. Conf holds
+// the radio settings it went over the air with.
+type Packet struct {
+	Data     []byte
+	RSSI     int
+	SNR      float64
+	Received time.Time
+	Conf     LoraConf
+}
+
+// EventData is what a handler receives. Packet is set for OnRxDone unless the
+// packet could not be read, in which case Err says why; Err is also set for
+// OnTxTimeout. OnTxDone carries the sent payload in Packet when the
+// transmission went through SendPacket or a TxQueue. State is only meaningful
+// for OnStateChanged.
+type EventData struct {
+	Event  Event
+	Time   time.Time
+	Packet *Packet
+	Err    error
+	State  StateChange
+}
+
+func (e Event) String() string {
+	switch e {
+	case OnRxDone:
+		return "RxDone"
+	case OnTxDone:
+		return "TxDone"
+	case OnTxTimeout:
+		return "TxTimeout"
+	case OnStateChanged:
+		return "StateChanged"
+	}
+	return fmt.Sprintf("Event(%d)", int(e))
+}
+
+func validEvent(event Event) bool {
+	switch event {
+	case OnRxDone, OnTxDone, OnTxTimeout, OnStateChanged:
+		return true
+	}
+	return false
+}
+
+// subscriber delivers events to one handler from its own goroutine, so a slow
+// or panicking handler never delays the radio or the other subscribers.
+type subscriber struct {
+	event   Event
+	handler func(EventData)
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	queue   []EventData
+	dropped uint64
+	removed bool
+	closed  bool
+	panics  uint64
+}
+
+func newSubscriber(event Event, handler func(EventData)) *subscriber {
+	sub := &subscriber{event: event, handler: handler, wake: make(chan struct{}, 1)}
+	go sub.run()
+	return sub
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) push(ev EventData) {
+	s.mu.Lock()
+	if s.removed || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.queue) == subscriberQueueLen {
+		s.queue[0] = EventData{}
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, ev)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// stop ends delivery. With drain set the queued events are still handed to
+// the handler first, which is how a closing radio flushes its last events.
+func (s *subscriber) stop(drain bool) {
+	s.mu.Lock()
+	if drain {
+		s.closed = true
+	} else {
+		s.removed = true
+		s.queue = nil
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *subscriber) next() (EventData, bool) {
+	for {
+		s.mu.Lock()
+		if s.removed {
+			s.mu.Unlock()
+			return EventData{}, false
+		}
+		if len(s.queue) > 0 {
+			ev := s.queue[0]
+			s.queue[0] = EventData{}
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return ev, true
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return EventData{}, false
+		}
+		<-s.wake
+	}
+}
+
+func (s *subscriber) run() {
+	for {
+		ev, ok := s.next()
+		if !ok {
+			return
+		}
+		s.deliver(ev)
+	}
+}
+
+func (s *subscriber) deliver(ev EventData) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.mu.Lock()
+			s.panics++
+			s.mu.Unlock()
+		}
+	}()
+	s.handler(ev)
+}
+
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[Event][]*subscriber
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[Event][]*subscriber{}}
+}
+
+func (b *eventBus) subscribe(event Event, handler func(EventData)) (*subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	sub := newSubscriber(event, handler)
+	b.subs[event] = append(b.subs[event], sub)
+	return sub, nil
+}
+
+func (b *eventBus) remove(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[sub.event]
+	for idx, s := range subs {
+		if s == sub {
+			b.subs[sub.event] = append(subs[:idx:idx], subs[idx+1:]...)
+			break
+		}
+	}
+	sub.stop(false)
+}
+
+func (b *eventBus) publish(ev EventData) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs[ev.Event] {
+		sub.push(ev)
+	}
+}
+
+func (b *eventBus) has(events ...Event) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, event := range events {
+		if len(b.subs[event]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for event, subs := range b.subs {
+		for _, sub := range subs {
+			sub.stop(true)
+		}
+		delete(b.subs, event)
+	}
+}
+
+type Subscription struct {
+	bus  *eventBus
+	sub  *subscriber
+	once sync.Once
+}
+
+// Unsubscribe stops delivery and discards events not yet handed to the
+// handler. It is safe to call more than once and from inside the handler.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.bus.remove(s.sub)
+	})
+}
+
+// Dropped counts events discarded because the handler fell more than
+// subscriberQueueLen events behind.
+func (s *Subscription) Dropped() uint64 {
+	s.sub.mu.Lock()
+	defer s.sub.mu.Unlock()
+	return s.sub.dropped
+}
+
+// Panics counts handler calls that panicked and were recovered.
+func (s *Subscription) Panics() uint64 {
+	s.sub.mu.Lock()
+	defer s.sub.mu.Unlock()
+	return s.sub.panics
+}
+
+// Subscribe calls handler for every occurrence of event, in order, until the
+// subscription is cancelled or the radio is closed.
+func (gl *GoLora) Subscribe(event Event, handler func(EventData)) (*Subscription, error) {
+	if !validEvent(event) {
+		return nil, errors.New("event not recognized")
+	}
+	sub, err := gl.bus.subscribe(event, handler)
+	if err != nil {
+		return nil, err
+	}
+	if event == OnRxDone || event == OnTxDone {
+		// wake the owner so it starts watching the interrupt pin
+		_ = gl.do(context.Background(), func(ctx context.Context) error { return nil })
+	}
+	return &Subscription{bus: gl.bus, sub: sub}, nil
+}
+
+func (gl *GoLora) publish(ev EventData) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	gl.bus.publish(ev)
+}
@@ -0,0 +1,144 @@
+package SX1276
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitEvent(t *testing.T, ch <-chan EventData) EventData {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+	return EventData{}
+}
+
+func TestGoLora_Subscribe_SeveralHandlers(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeRadioDrv(fc), newAppliedLoraConf()))
+	defer gl.Close()
+
+	rx1 := make(chan EventData, 1)
+	rx2 := make(chan EventData, 1)
+	tx := make(chan EventData, 1)
+	for _, sub := range []struct {
+		event Event
+		ch    chan EventData
+	}{{OnRxDone, rx1}, {OnRxDone, rx2}, {OnTxDone, tx}} {
+		ch := sub.ch
+		_, err := gl.Subscribe(sub.event, func(ev EventData) { ch <- ev })
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("out")))
+	sent := waitEvent(t, tx)
+	assert.Equal(t, OnTxDone, sent.Event)
+	if assert.NotNil(t, sent.Packet) {
+		assert.Equal(t, []byte("out"), sent.Packet.Data)
+		assert.Equal(t, newAppliedLoraConf(), sent.Packet.Conf)
+	}
+
+	assert.NoError(t, gl.ChangeMode(RxContinuous))
+	fc.poke(internal.REG_PKT_RSSI_VALUE, 100)
+	fc.poke(internal.REG_PKT_SNR_VALUE, 0xf8)
+	fc.injectPacket([]byte("in"))
+	for _, ch := range []chan EventData{rx1, rx2} {
+		ev := waitEvent(t, ch)
+		assert.NoError(t, ev.Err)
+		if assert.NotNil(t, ev.Packet) {
+			assert.Equal(t, []byte("in"), ev.Packet.Data)
+			assert.Equal(t, -57, ev.Packet.RSSI)
+			assert.Equal(t, -2.0, ev.Packet.SNR)
+			assert.Equal(t, newAppliedLoraConf(), ev.Packet.Conf)
+		}
+	}
+	pkt, err := gl.ReceivePacket()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("in"), pkt, "packet read by the interrupt handler is still returned")
+}
+
+func TestGoLora_Subscribe_TxTimeoutPayload(t *testing.T) {
+	fc := newFakeChip()
+	fc.txHang = true
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+	defer gl.Close()
+
+	timeouts := make(chan EventData, 1)
+	_, err := gl.Subscribe(OnTxTimeout, func(ev EventData) { timeouts <- ev })
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, gl.SendPacket(ctx, []byte("x")))
+	assert.ErrorIs(t, waitEvent(t, timeouts).Err, context.DeadlineExceeded)
+}
+
+func TestGoLora_Subscribe_PanicIsolationAndOrder(t *testing.T) {
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(newFakeChip()), newAppliedLoraConf()))
+	defer gl.Close()
+
+	panicky, err := gl.Subscribe(OnTxDone, func(EventData) { panic("handler bug") })
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var order []time.Time
+	done := make(chan struct{})
+	const sends = 20
+	_, err = gl.Subscribe(OnTxDone, func(ev EventData) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, ev.Time)
+		if len(order) == sends {
+			close(done)
+		}
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < sends; i++ {
+		assert.NoError(t, gl.SendPacket(context.Background(), []byte{byte(i)}))
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow subscriber did not get every event")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(order); i++ {
+		assert.False(t, order[i].Before(order[i-1]), "events delivered out of order")
+	}
+	assert.Eventually(t, func() bool { return panicky.Panics() == sends }, time.Second, time.Millisecond)
+}
+
+func TestGoLora_Subscribe_Unsubscribe(t *testing.T) {
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(newFakeChip()), newAppliedLoraConf()))
+
+	calls := make(chan EventData, 4)
+	sub, err := gl.Subscribe(OnTxDone, func(ev EventData) { calls <- ev })
+	assert.NoError(t, err)
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("a")))
+	waitEvent(t, calls)
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("b")))
+	select {
+	case <-calls:
+		t.Error("handler called after Unsubscribe")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_, err = gl.Subscribe(99, func(EventData) {})
+	assert.EqualError(t, err, "event not recognized")
+	assert.NoError(t, gl.Close())
+	_, err = gl.Subscribe(OnTxDone, func(EventData) {})
+	assert.ErrorIs(t, err, ErrClosed)
+}
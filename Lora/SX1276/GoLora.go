@@ -4,12 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	airtimecalc "github.com/Fsyahputra/GoLora/Lora/airtime"
 	"github.com/Fsyahputra/GoLora/driver"
 	"periph.io/x/conn/v3/physic"
 )
@@ -24,59 +24,92 @@ type LoraConf struct {
 	Frequency      physic.Frequency
 	Header         Header
 	EnableCrc      bool
+	InvertIQ       InvertIQ
+}
+
+// InvertIQ selects I/Q inversion for each direction. LoRaWAN gateways
+// transmit inverted and nodes listen inverted, so uplinks and node-to-node
+// links use neither.
+type InvertIQ struct {
+	TX bool
+	RX bool
 }
 type GoLora struct {
 	*driver.Driver
 	*LoraUtils
-	Conf         LoraConf
-	mu           sync.Mutex
-	cb           func()
-	txDoneAtomic atomic.Int32
-	cbStopper    chan struct{}
-	Mode         LoraMode
-	txDoneFlag   int32
+	Conf   LoraConf
+	Mode   LoraMode
+	shadow shadowRegs
+
+	cmds      chan radioCmd
+	closing   context.Context
+	stopCmds  context.CancelFunc
+	quit      chan struct{}
+	ownerDone chan struct{}
+	closeOnce sync.Once
+
+	bus       *eventBus
+	state     atomic.Int32
+	rxPending *Packet
+	dutyCycle TxAdmitter
+	stats     *radioStats
 }
 
+const (
+	resetPulseTime = 300 * time.Millisecond
+	resetBootTime  = 1000 * time.Millisecond
+
+	txTimeoutMargin   = 100 * time.Millisecond
+	fallbackTxTimeout = 10 * time.Second
+)
+
 type RegVal struct {
 	Reg byte
 	Val byte
 }
 
 func (gl *GoLora) configure() error {
-	var err error
-	err = gl.setTxPowerUnsafe(gl.Conf.TxPower)
-	err = gl.setSFUnsafe(gl.Conf.SF)
-	err = gl.setBWUnsafe(gl.Conf.BW)
-	err = gl.setCodingRateUnsafe(gl.Conf.Denum)
-	err = gl.setPreambleUnsafe(gl.Conf.PreambleLength)
-	err = gl.setSyncWordUnsafe(gl.Conf.SyncWord)
-	err = gl.setFrequencyUnsafe(gl.Conf.Frequency)
-	err = gl.setHeaderUnsafe(gl.Conf.Header)
-	err = gl.setCrcUnsafe(gl.Conf.EnableCrc)
-	return err
+	for _, step := range confSteps {
+		if err := step.apply(gl, gl.Conf); err != nil {
+			return fmt.Errorf("failed to set %s: %w", step.name, err)
+		}
+	}
+	return nil
 }
 
 func NewGoLoraSX1276(drv *driver.Driver, conf LoraConf) *GoLora {
+	closing, stopCmds := context.WithCancel(context.Background())
 	gl := &GoLora{
-		Driver:       drv,
-		LoraUtils:    &LoraUtils{},
-		Conf:         conf,
-		mu:           sync.Mutex{},
-		cb:           nil,
-		txDoneAtomic: atomic.Int32{},
-		cbStopper:    nil,
-		Mode:         0,
-	}
-
+		Driver:    drv,
+		LoraUtils: &LoraUtils{},
+		Conf:      conf,
+		Mode:      0,
+		cmds:      make(chan radioCmd),
+		closing:   closing,
+		stopCmds:  stopCmds,
+		quit:      make(chan struct{}),
+		ownerDone: make(chan struct{}),
+		bus:       newEventBus(),
+		stats:     newRadioStats(Sleep),
+	}
+	go gl.ownerLoop()
 	return gl
 }
 
 func (gl *GoLora) Begin() error {
-	if err := gl.Reset(); err != nil {
+	return gl.BeginContext(context.Background())
+}
+
+func (gl *GoLora) BeginContext(ctx context.Context) error {
+	return gl.do(ctx, func(ctx context.Context) error {
+		return gl.beginUnsafe(ctx)
+	})
+}
+
+func (gl *GoLora) beginUnsafe(ctx context.Context) error {
+	if err := gl.resetUnsafe(ctx); err != nil {
 		return err
 	}
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
 	modVer, err := gl.readReg(internal.REG_VERSION)
 	if err != nil {
 		return err
@@ -87,7 +120,7 @@ func (gl *GoLora) Begin() error {
 	if err := gl.changeModeUnsafe(Sleep); err != nil {
 		return fmt.Errorf("failed to set sleep mode: %w", err)
 	}
-	currentLna, err := gl.readReg(internal.REG_LNA)
+	currentLna, err := gl.readRegShadow(internal.REG_LNA)
 	if err != nil {
 		return err
 	}
@@ -102,6 +135,7 @@ func (gl *GoLora) Begin() error {
 	if err = gl.changeModeUnsafe(Idle); err != nil {
 		return fmt.Errorf("failed to set Idle mode: %w", err)
 	}
+	gl.setStateUnsafe(StateReady)
 	return nil
 }
 
@@ -119,41 +153,107 @@ func (gl *GoLora) writeReg(reg byte, value uint8) error {
 	writeReg := gl.setWriteMask(reg)
 	byteValue := value
 	if err := gl.ModComm.SendToMod(writeReg, byteValue); err != nil {
+		gl.shadow.invalidate(reg)
 		return err
 	}
+	gl.shadow.set(reg, value)
 	return nil
 }
 
+// writeRegRaw writes a register of the FSK page, which overlaps the LoRa one,
+// without consulting or updating the shadow.
+func (gl *GoLora) writeRegRaw(reg byte, value uint8) error {
+	return gl.ModComm.SendToMod(gl.setWriteMask(reg), value)
+}
+
 func (gl *GoLora) Reset() error {
+	return gl.ResetContext(context.Background())
+}
+
+func (gl *GoLora) ResetContext(ctx context.Context) error {
+	return gl.do(ctx, func(ctx context.Context) error {
+		return gl.resetUnsafe(ctx)
+	})
+}
+
+// resetUnsafe returns the chip to its power-on defaults, so Begin is needed
+// again afterwards. It always releases the reset line, even when ctx is cancelled
+// mid-pulse, so the chip boots back into standby instead of staying held.
+func (gl *GoLora) resetUnsafe(ctx context.Context) error {
+	gl.shadow.invalidateAll()
+	gl.setStateUnsafe(StateUninitialised)
 	err := gl.RSTPin.Low()
 	if err != nil {
 		return err
 	}
-	time.Sleep(300 * time.Millisecond)
+	waitErr := sleepCtx(ctx, resetPulseTime)
 	err = gl.RSTPin.High()
 	if err != nil {
 		return err
 	}
-	time.Sleep(1000 * time.Millisecond)
-	return nil
+	if waitErr != nil {
+		return waitErr
+	}
+	return sleepCtx(ctx, resetBootTime)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// standbyOnCancel puts the radio back into standby when err comes from ctx
+// being cancelled, so an aborted operation never leaves it in TX or RX.
+func (gl *GoLora) standbyOnCancel(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr == nil || !errors.Is(err, ctxErr) {
+		return err
+	}
+	if sErr := gl.changeModeUnsafe(Idle); sErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to set Idle mode: %w", sErr))
+	}
+	return err
 }
 
 func (gl *GoLora) changeModeUnsafe(mode LoraMode) error {
+	if err := gl.mapDio0Unsafe(mode); err != nil {
+		return err
+	}
 	modeVal := gl.LoraUtils.changeMode(mode)
 	if err := gl.writeReg(internal.REG_OP_MODE, modeVal); err != nil {
 		return err
 	}
-	gl.Mode = mode
+	gl.setModeUnsafe(mode)
+	if gl.State() != StateUninitialised {
+		gl.setStateUnsafe(stateForMode(mode))
+	}
 	return nil
 }
 
+// doConf runs a configuration change on the owner. Begin must have run, as
+// it resets the chip and applies Conf itself, and the modem registers must
+// not change under a packet that is being transmitted.
+func (gl *GoLora) doConf(op string, fn func() error) error {
+	return gl.do(context.Background(), func(ctx context.Context) error {
+		if err := gl.rejectUnsafe(op, StateUninitialised, StateTransmitting); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
 func (gl *GoLora) ChangeMode(mode LoraMode) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.changeModeUnsafe(mode); err != nil {
-		return err
-	}
-	return nil
+	return gl.do(context.Background(), func(ctx context.Context) error {
+		if err := gl.rejectUnsafe("change mode", StateUninitialised, StateTransmitting); err != nil {
+			return err
+		}
+		return gl.changeModeUnsafe(mode)
+	})
 }
 
 func (gl *GoLora) setTxPowerUnsafe(txPower uint8) error {
@@ -170,7 +270,7 @@ func (gl *GoLora) setTxPowerUnsafe(txPower uint8) error {
 
 	chipTx := tx - 2
 	txReg := gl.LoraUtils.setTxPower(chipTx)
-	if err := gl.writeReg(internal.REG_PA_CONFIG, txReg); err != nil {
+	if err := gl.writeRegShadow(internal.REG_PA_CONFIG, txReg); err != nil {
 		return err
 	}
 	gl.Conf.TxPower = tx
@@ -178,12 +278,9 @@ func (gl *GoLora) setTxPowerUnsafe(txPower uint8) error {
 }
 
 func (gl *GoLora) SetTXPower(txPower uint8) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setTxPowerUnsafe(txPower); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set tx power", func() error {
+		return gl.setTxPowerUnsafe(txPower)
+	})
 }
 
 func (gl *GoLora) writeRegMany(Regs []byte, Values []byte) error {
@@ -199,7 +296,7 @@ func (gl *GoLora) writeRegMany(Regs []byte, Values []byte) error {
 		regValues[i] = regValue{Reg: Reg, Value: Values[i]}
 	}
 	for _, reg := range regValues {
-		if err := gl.writeReg(reg.Reg, reg.Value); err != nil {
+		if err := gl.writeRegShadow(reg.Reg, reg.Value); err != nil {
 			return err
 		}
 	}
@@ -208,7 +305,7 @@ func (gl *GoLora) writeRegMany(Regs []byte, Values []byte) error {
 
 func (gl *GoLora) setFrequencyUnsafe(freq physic.Frequency) error {
 	gl.Conf.Frequency = freq
-	frf := (uint64(freq) << 19) / 32000000
+	frf := frfFromFreq(freq)
 	freqBytes := gl.LoraUtils.setFreq(frf)
 	registers := []byte{internal.REG_FRF_MSB, internal.REG_FRF_MID, internal.REG_FRF_LSB}
 	if err := gl.writeRegMany(registers, freqBytes); err != nil {
@@ -218,12 +315,9 @@ func (gl *GoLora) setFrequencyUnsafe(freq physic.Frequency) error {
 }
 
 func (gl *GoLora) SetFrequency(freq physic.Frequency) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setFrequencyUnsafe(freq); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set frequency", func() error {
+		return gl.setFrequencyUnsafe(freq)
+	})
 }
 
 func (gl *GoLora) setSFUnsafe(sf uint8) error {
@@ -236,7 +330,7 @@ func (gl *GoLora) setSFUnsafe(sf uint8) error {
 	}
 	gl.Conf.SF = sf
 	sfReg := gl.LoraUtils.setSF(sf)
-	currentConf, err := gl.readReg(internal.REG_MODEM_CONFIG_2)
+	currentConf, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_2)
 	if err != nil {
 		return err
 	}
@@ -252,16 +346,13 @@ func (gl *GoLora) setSFUnsafe(sf uint8) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return gl.setLowDataRateOptimizeUnsafe()
 }
 
 func (gl *GoLora) SetSF(sf uint8) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setSFUnsafe(sf); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set spreading factor", func() error {
+		return gl.setSFUnsafe(sf)
+	})
 }
 
 func (gl *GoLora) setBWUnsafe(bw uint64) error {
@@ -289,40 +380,51 @@ func (gl *GoLora) setBWUnsafe(bw uint64) error {
 			threshold = uint64(bwval.bwThres)
 			break
 		} else {
-			threshold = uint64(BW_8)
+			threshold = bwHz[9]
 			sbw = 9
 		}
 	}
 	bwReg := gl.LoraUtils.setBW(sbw)
-	currentConf, err := gl.readReg(internal.REG_MODEM_CONFIG_1)
-	currentConfFourthMSB := currentConf & 0x0f
-	overWrittenConf := currentConfFourthMSB | bwReg
+	currentConf, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_1)
 	if err != nil {
 		return err
 	}
-	if err := gl.writeReg(internal.REG_MODEM_CONFIG_1, overWrittenConf); err != nil {
+	currentConfFourthMSB := currentConf & 0x0f
+	overWrittenConf := currentConfFourthMSB | bwReg
+	if err := gl.writeRegShadow(internal.REG_MODEM_CONFIG_1, overWrittenConf); err != nil {
 		return err
 	}
 	gl.Conf.BW = threshold
-	return nil
+	return gl.setLowDataRateOptimizeUnsafe()
 }
 
-func (gl *GoLora) SetBW(bw uint64) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setBWUnsafe(bw); err != nil {
+// setLowDataRateOptimizeUnsafe sets the LowDataRateOptimize bit the
+// configured SF and bandwidth need; it follows every change of either.
+func (gl *GoLora) setLowDataRateOptimizeUnsafe() error {
+	modemConfig, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_3)
+	if err != nil {
 		return err
 	}
-	return nil
+	modemConfig &^= internal.MODEM_CONFIG_3_LOW_DATA_RATE_OPTIMIZE
+	if lowDataRateOptimize(gl.Conf) {
+		modemConfig |= internal.MODEM_CONFIG_3_LOW_DATA_RATE_OPTIMIZE
+	}
+	return gl.writeRegShadow(internal.REG_MODEM_CONFIG_3, modemConfig)
+}
+
+func (gl *GoLora) SetBW(bw uint64) error {
+	return gl.doConf("set bandwidth", func() error {
+		return gl.setBWUnsafe(bw)
+	})
 }
 
 func (gl *GoLora) setCrcUnsafe(enable bool) error {
-	currentModemConf, err := gl.readReg(internal.REG_MODEM_CONFIG_2)
+	currentModemConf, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_2)
 	if err != nil {
 		return err
 	}
 	updatedConf := gl.LoraUtils.setCrc(enable, currentModemConf)
-	if err := gl.writeReg(internal.REG_MODEM_CONFIG_2, updatedConf); err != nil {
+	if err := gl.writeRegShadow(internal.REG_MODEM_CONFIG_2, updatedConf); err != nil {
 		return err
 	}
 	gl.Conf.EnableCrc = enable
@@ -330,12 +432,9 @@ func (gl *GoLora) setCrcUnsafe(enable bool) error {
 }
 
 func (gl *GoLora) SetCrc(enable bool) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setCrcUnsafe(enable); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set crc", func() error {
+		return gl.setCrcUnsafe(enable)
+	})
 }
 
 func (gl *GoLora) setPreambleUnsafe(length uint16) error {
@@ -349,45 +448,74 @@ func (gl *GoLora) setPreambleUnsafe(length uint16) error {
 }
 
 func (gl *GoLora) SetPreamble(length uint16) error {
-
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setPreambleUnsafe(length); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set preamble", func() error {
+		return gl.setPreambleUnsafe(length)
+	})
 }
 
 func (gl *GoLora) setSyncWordUnsafe(syncWord uint8) error {
-	if err := gl.writeReg(internal.REG_SYNC_WORD, syncWord); err != nil {
+	if err := gl.writeRegShadow(internal.REG_SYNC_WORD, syncWord); err != nil {
 		return err
 	}
 	gl.Conf.SyncWord = syncWord
 	return nil
 }
 
+// SetSyncWord writes any sync word; see CheckSyncWord for ones to avoid.
 func (gl *GoLora) SetSyncWord(syncWord uint8) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setSyncWordUnsafe(syncWord); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set sync word", func() error {
+		return gl.setSyncWordUnsafe(syncWord)
+	})
 }
 
-func (gl *GoLora) CheckConn() error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	version, err := gl.readReg(internal.REG_VERSION)
+// setInvertIQUnsafe follows the register values of Semtech's reference
+// driver: RegInvertIQ carries one bit per direction, and RegInvertIQ2 must be
+// 0x19 whenever either is inverted.
+func (gl *GoLora) setInvertIQUnsafe(iq InvertIQ) error {
+	current, err := gl.readRegShadow(internal.REG_INVERT_IQ)
 	if err != nil {
 		return err
 	}
-	if version != 0x12 {
-		return errors.New("check Your Connection")
+	reg := current &^ (internal.INVERT_IQ_RX | internal.INVERT_IQ_TX_OFF)
+	if iq.RX {
+		reg |= internal.INVERT_IQ_RX
+	}
+	if !iq.TX {
+		reg |= internal.INVERT_IQ_TX_OFF
 	}
+	reg2 := internal.INVERT_IQ_2_OFF
+	if iq.TX || iq.RX {
+		reg2 = internal.INVERT_IQ_2_ON
+	}
+	if err := gl.writeRegMany([]byte{internal.REG_INVERT_IQ, internal.REG_INVERT_IQ_2}, []byte{reg, reg2}); err != nil {
+		return err
+	}
+	gl.Conf.InvertIQ = iq
 	return nil
 }
 
+// SetInvertIQ inverts the I and Q signals for transmitting and receiving
+// separately. A node listens for its downlinks with rx set and a gateway
+// sends them with tx set.
+func (gl *GoLora) SetInvertIQ(tx, rx bool) error {
+	return gl.doConf("set invert iq", func() error {
+		return gl.setInvertIQUnsafe(InvertIQ{TX: tx, RX: rx})
+	})
+}
+
+func (gl *GoLora) CheckConn() error {
+	return gl.do(context.Background(), func(ctx context.Context) error {
+		version, err := gl.readReg(internal.REG_VERSION)
+		if err != nil {
+			return err
+		}
+		if version != 0x12 {
+			return errors.New("check Your Connection")
+		}
+		return nil
+	})
+}
+
 func (gl *GoLora) setFifoPtr(ptr uint8) error {
 	if err := gl.writeReg(internal.REG_FIFO_ADDR_PTR, ptr); err != nil {
 		return err
@@ -404,46 +532,113 @@ func (gl *GoLora) sendToFifo(buff []byte) error {
 	return nil
 }
 
-func (gl *GoLora) waitTxDone(ctx context.Context) error {
-outerLoop:
+func (gl *GoLora) waitIrq(ctx context.Context, mask byte) error {
+	ticker := time.NewTicker(irqPollInterval)
+	defer ticker.Stop()
 	for {
+		readVal, err := gl.readReg(internal.REG_IRQ_FLAGS)
+		if err != nil {
+			return err
+		}
+		if readVal&mask != 0 {
+			return nil
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
-
-			readVal, err := gl.readReg(internal.REG_IRQ_FLAGS)
-			if err != nil {
-				return err
-			}
-			if readVal&internal.IRQ_TX_DONE_MASK != 0 {
-
-				break outerLoop
-			}
-			time.Sleep(1 * time.Millisecond)
+		case <-ticker.C:
 		}
 	}
+}
+
+func (gl *GoLora) waitTxDone(ctx context.Context) error {
+	if err := gl.waitIrq(ctx, internal.IRQ_TX_DONE_MASK); err != nil {
+		return err
+	}
 	if err := gl.writeReg(internal.REG_IRQ_FLAGS, internal.IRQ_TX_DONE_MASK); err != nil {
 		return err
 	}
 	return nil
 }
 
+// TxTimeout is how long SendPacket waits for TxDone when its context has no
+// deadline: twice the packet's airtime plus a margin for SPI and scheduling.
+func (gl *GoLora) TxTimeout(payloadLength uint16) time.Duration {
+	return txTimeout(gl.GetConf(), payloadLength)
+}
+
+func txTimeout(conf LoraConf, payloadLength uint16) time.Duration {
+	if conf.SF == 0 || conf.BW == 0 || conf.Denum < 5 {
+		return fallbackTxTimeout
+	}
+	return 2*airtime(conf, payloadLength) + txTimeoutMargin
+}
+
 func (gl *GoLora) SendPacket(ctx context.Context, buff []byte) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	err := gl.sendPacketUnsafe(buff)
-	if err != nil {
+	return gl.do(ctx, func(ctx context.Context) error {
+		if err := gl.requireUnsafe("send packet", StateReady, StateSleeping, StateReceiving); err != nil {
+			return err
+		}
+		onAir, err := gl.admitUnsafe(gl.dutyCycle, gl.Conf, len(buff))
+		if err != nil {
+			return err
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, txTimeout(gl.Conf, uint16(len(buff))))
+			defer cancel()
+		}
+		start := time.Now()
+		if err := gl.transmitUnsafe(ctx, buff); err != nil {
+			return err
+		}
+		if gl.dutyCycle != nil {
+			gl.dutyCycle.Record(gl.Conf.Frequency, start, onAir)
+		}
+		return nil
+	})
+}
+
+// transmitUnsafe sends buff and, once TxDone is seen, puts the radio back into
+// the receive mode it was listening in before the transmission.
+func (gl *GoLora) transmitUnsafe(ctx context.Context, buff []byte) error {
+	prevMode := gl.Mode
+	if err := gl.sendPacketUnsafe(buff); err != nil {
 		return err
 	}
 	if err := gl.waitTxDone(ctx); err != nil {
-		return err
+		if ctx.Err() != nil {
+			gl.stats.update(func(st *RadioStats) { st.TxTimeouts++ })
+			gl.publish(EventData{Event: OnTxTimeout, Err: err})
+		}
+		return gl.standbyOnCancel(ctx, err)
+	}
+	gl.txSucceededUnsafe(gl.Conf, len(buff))
+	gl.txFinishedUnsafe()
+	gl.publish(EventData{Event: OnTxDone, Packet: sentPacket(gl.Conf, buff)})
+	if prevMode == RxContinuous || prevMode == RxSingle {
+		if err := gl.changeModeUnsafe(prevMode); err != nil {
+			return fmt.Errorf("failed to resume receive mode: %w", err)
+		}
 	}
 	return nil
+}
 
+func sentPacket(conf LoraConf, buff []byte) *Packet {
+	return &Packet{Data: append([]byte(nil), buff...), Conf: conf}
+}
+
+// txFinishedUnsafe records that the chip left TX on its own after TxDone.
+func (gl *GoLora) txFinishedUnsafe() {
+	if gl.Mode != Tx {
+		return
+	}
+	gl.setModeUnsafe(Idle)
+	gl.setStateUnsafe(StateReady)
 }
 
 func (gl *GoLora) sendPacketUnsafe(buff []byte) error {
+	gl.stats.update(func(st *RadioStats) { st.TxAttempts++ })
 	if err := gl.changeModeUnsafe(Idle); err != nil {
 		return err
 	}
@@ -464,35 +659,20 @@ func (gl *GoLora) sendPacketUnsafe(buff []byte) error {
 }
 
 func (gl *GoLora) SendPacketWithTxCb(buff []byte) error {
-	gl.mu.Lock()
-	err := gl.sendPacketUnsafe(buff)
-	gl.mu.Unlock()
-	if err != nil {
-		return err
-	}
-
-	timeout := 300 * time.Millisecond
-	timer := time.After(timeout)
-	for {
-		select {
-		case <-timer:
-			return errors.New("timeout reached")
-		default:
-			if gl.txDoneAtomic.Load() == 1 {
-				gl.txDoneAtomic.Store(0)
-				return nil
-			}
-			time.Sleep(1 * time.Millisecond)
-		}
+	err := gl.SendPacket(context.Background(), buff)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errors.New("timeout reached")
 	}
+	return err
 }
+
 func (gl *GoLora) setHeaderUnsafe(header Header) error {
-	currentConf, err := gl.readReg(internal.REG_MODEM_CONFIG_1)
+	currentConf, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_1)
 	if err != nil {
 		return err
 	}
 	newConf := gl.LoraUtils.setHeader(bool(header), currentConf)
-	if err := gl.writeReg(internal.REG_MODEM_CONFIG_1, newConf); err != nil {
+	if err := gl.writeRegShadow(internal.REG_MODEM_CONFIG_1, newConf); err != nil {
 		return err
 	}
 	gl.Conf.Header = header
@@ -500,30 +680,107 @@ func (gl *GoLora) setHeaderUnsafe(header Header) error {
 }
 
 func (gl *GoLora) SetHeader(header Header) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setHeaderUnsafe(header); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set header", func() error {
+		return gl.setHeaderUnsafe(header)
+	})
 }
 
+// ReceivePacket returns the packet the interrupt handler already pulled out of
+// the FIFO for an OnRxDone event, if any, and otherwise reads the FIFO.
 func (gl *GoLora) ReceivePacket() ([]byte, error) {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	irq, err := gl.readReg(internal.REG_IRQ_FLAGS)
+	var data []byte
+	err := gl.do(context.Background(), func(ctx context.Context) error {
+		if err := gl.requireUnsafe("receive packet", StateReady, StateReceiving); err != nil {
+			return err
+		}
+		if gl.rxPending != nil {
+			data = gl.rxPending.Data
+			gl.rxPending = nil
+			return nil
+		}
+		pkt, err := gl.rxDoneUnsafe()
+		if err != nil {
+			return err
+		}
+		data = pkt.Data
+		return nil
+	})
+	return data, err
+}
+
+// ReceivePacketContext puts the radio in continuous RX if it is not already
+// listening and blocks until a packet arrives or ctx is done, in which case
+// the radio is left in standby. The wait happens off the owner, so the radio
+// stays usable meanwhile and the packet is published as OnRxDone as well.
+func (gl *GoLora) ReceivePacketContext(ctx context.Context) ([]byte, error) {
+	got := make(chan EventData, 1)
+	sub, err := gl.Subscribe(OnRxDone, func(ev EventData) {
+		select {
+		case got <- ev:
+		default:
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err := gl.LoraUtils.checkData(irq); err != nil {
-		_ = gl.writeReg(internal.REG_IRQ_FLAGS, internal.IRQ_PAYLOAD_CRC_ERROR_MASK) // reset bit crc payload error
+	defer sub.Unsubscribe()
+	err = gl.do(ctx, func(ctx context.Context) error {
+		if err := gl.requireUnsafe("receive packet", StateReady, StateSleeping, StateReceiving); err != nil {
+			return err
+		}
+		if gl.Mode != RxContinuous && gl.Mode != RxSingle {
+			return gl.changeModeUnsafe(RxContinuous)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	select {
+	case ev := <-got:
+		if ev.Err != nil {
+			return nil, ev.Err
+		}
+		_ = gl.do(context.Background(), func(ctx context.Context) error {
+			if gl.rxPending == ev.Packet {
+				gl.rxPending = nil
+			}
+			return nil
+		})
+		return ev.Packet.Data, nil
+	case <-ctx.Done():
+		err := gl.do(context.Background(), func(ctx context.Context) error {
+			return gl.changeModeUnsafe(Idle)
+		})
+		if err != nil && !errors.Is(err, ErrClosed) {
+			return nil, errors.Join(ctx.Err(), fmt.Errorf("failed to set Idle mode: %w", err))
+		}
+		return nil, ctx.Err()
+	case <-gl.closing.Done():
+		return nil, ErrClosed
+	}
+}
 
-	if err := gl.changeModeUnsafe(Idle); err != nil {
+func (gl *GoLora) receivePacketUnsafe() ([]byte, error) {
+	irq, err := gl.readReg(internal.REG_IRQ_FLAGS)
+	if err != nil {
+		return nil, err
+	}
+	err = gl.LoraUtils.checkData(irq)
+	if err == nil && gl.Conf.Header == Explicit && irq&internal.IRQ_VALID_HEADER_MASK == 0 {
+		err = ErrHeader
+	}
+	if err != nil {
+		_ = gl.writeReg(internal.REG_IRQ_FLAGS, internal.IRQ_PAYLOAD_CRC_ERROR_MASK|internal.IRQ_VALID_HEADER_MASK|internal.IRQ_RX_DONE_MASK) // reset bit crc payload error
 		return nil, err
 	}
 
+	if gl.Mode != RxContinuous {
+		if err := gl.changeModeUnsafe(Idle); err != nil {
+			return nil, err
+		}
+	}
+
 	pktLen := byte(0)
 	if gl.Conf.Header {
 		pktLen, err = gl.readReg(internal.REG_RX_NB_BYTES)
@@ -541,10 +798,13 @@ func (gl *GoLora) ReceivePacket() ([]byte, error) {
 		return nil, err
 	}
 	data := make([]byte, int(pktLen))
-	for i := 0; uint8(i) < pktLen; i++ {
+	for i := range data {
 		data[i], err = gl.readReg(internal.REG_FIFO)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if err != nil {
+	if err := gl.writeReg(internal.REG_IRQ_FLAGS, internal.IRQ_VALID_HEADER_MASK|internal.IRQ_RX_DONE_MASK); err != nil {
 		return nil, err
 	}
 	return data, nil
@@ -559,12 +819,12 @@ func (gl *GoLora) setCodingRateUnsafe(denum uint8) error {
 		denum = 8
 	}
 	var cr = denum - 4
-	currentModemConf, err := gl.readReg(internal.REG_MODEM_CONFIG_1)
+	currentModemConf, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_1)
 	if err != nil {
 		return err
 	}
 	crReg := gl.LoraUtils.setCodingRate(cr, currentModemConf)
-	if err := gl.writeReg(internal.REG_MODEM_CONFIG_1, crReg); err != nil {
+	if err := gl.writeRegShadow(internal.REG_MODEM_CONFIG_1, crReg); err != nil {
 		return err
 	}
 	gl.Conf.Denum = denum
@@ -572,205 +832,150 @@ func (gl *GoLora) setCodingRateUnsafe(denum uint8) error {
 }
 
 func (gl *GoLora) SetCodingRate(denum uint8) error {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	if err := gl.setCodingRateUnsafe(denum); err != nil {
-		return err
-	}
-	return nil
+	return gl.doConf("set coding rate", func() error {
+		return gl.setCodingRateUnsafe(denum)
+	})
 }
 
 func (gl *GoLora) IsReceived() (bool, error) {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	data, err := gl.readReg(internal.REG_IRQ_FLAGS)
-	data = data & internal.IRQ_RX_DONE_MASK
 	isExists := false
-	if err != nil {
-		return false, err
-	}
-	if data != 0 {
-		isExists = true
-	} else {
-		isExists = false
-	}
-	return isExists, nil
-}
-
-func (gl *GoLora) waitForInterrupt(timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-
-	for {
-		ok, err := gl.CbPin.ReadVal()
+	err := gl.do(context.Background(), func(ctx context.Context) error {
+		data, err := gl.readReg(internal.REG_IRQ_FLAGS)
 		if err != nil {
 			return err
 		}
-		if ok {
-			fmt.Println("Interrupt Detected")
-			return nil
-		}
-		if time.Now().After(deadline) {
-			return errors.New("timeout reached")
-		}
-		time.Sleep(time.Millisecond)
-	}
+		isExists = gl.rxPending != nil || data&internal.IRQ_RX_DONE_MASK != 0
+		return nil
+	})
+	return isExists, err
 }
 
-func (gl *GoLora) waitForPacket(millis time.Duration) error {
-	gl.mu.Lock()
-	if err := gl.changeModeUnsafe(Idle); err != nil {
-		return err
-	}
-	if err := gl.writeReg(internal.REG_IRQ_FLAGS, 0x40); err != nil {
-		return err
-	}
-	if err := gl.writeReg(internal.REG_DIO_MAPPING_1, 0x00); err != nil {
-		return err
+// RegisterCb calls cb on every event until a value is sent on, or the
+// caller closes, the returned channel.
+//
+// Deprecated: use Subscribe, which returns a Subscription and passes the
+// event payload to the handler.
+func (gl *GoLora) RegisterCb(event Event, cb func()) (chan struct{}, error) {
+	if event != OnRxDone && event != OnTxDone {
+		return nil, errors.New("event not recognized")
 	}
-
-	if err := gl.changeModeUnsafe(RxContinuous); err != nil {
-		return err
+	sub, err := gl.Subscribe(event, func(EventData) { cb() })
+	if err != nil {
+		return nil, err
 	}
-	gl.mu.Unlock()
+	thStopper := make(chan struct{})
+	go func() {
+		select {
+		case <-thStopper:
+		case <-gl.quit:
+		}
+		sub.Unsubscribe()
+	}()
+	return thStopper, nil
+}
 
-	if err := gl.waitForInterrupt(millis); err != nil {
+func (gl *GoLora) readRegCmd(reg byte) (byte, error) {
+	var val byte
+	err := gl.do(context.Background(), func(ctx context.Context) error {
+		var err error
+		val, err = gl.readReg(reg)
 		return err
-	}
-	return nil
+	})
+	return val, err
 }
 
-func (gl *GoLora) waitForTxDone(millis time.Duration) error {
-	gl.mu.Lock()
-	if err := gl.changeModeUnsafe(Idle); err != nil {
-		return err
+// rxDoneUnsafe is where every receive path ends: the packet that raised
+// RxDone is read, counted in the stats and published as OnRxDone, with the
+// error instead when it could not be read.
+func (gl *GoLora) rxDoneUnsafe() (*Packet, error) {
+	pkt, err := gl.readPacketUnsafe()
+	if errors.Is(err, errNoPacket) {
+		return nil, err
 	}
-
-	if err := gl.writeReg(internal.REG_DIO_MAPPING_1, 0x40); err != nil {
+	if err != nil {
+		gl.countRxErrorUnsafe(err)
+	} else {
+		gl.stats.packet(pkt)
 	}
-	gl.mu.Unlock()
+	gl.publish(EventData{Event: OnRxDone, Packet: pkt, Err: err})
+	return pkt, err
+}
 
-	if err := gl.waitForInterrupt(millis); err != nil {
-		return err
+// readPacketUnsafe reads the packet that raised RxDone together with the
+// signal quality the modem measured for it.
+func (gl *GoLora) readPacketUnsafe() (*Packet, error) {
+	data, err := gl.receivePacketUnsafe()
+	if err != nil {
+		return nil, err
 	}
-	gl.mu.Lock()
-	if err := gl.writeReg(internal.REG_IRQ_FLAGS, 0x08); err != nil {
+	pkt := &Packet{Data: data, Received: time.Now(), Conf: gl.Conf}
+	rssi, err := gl.readReg(internal.REG_PKT_RSSI_VALUE)
+	if err != nil {
+		return nil, err
 	}
-	gl.mu.Unlock()
-	return nil
-}
-
-func (gl *GoLora) rxDoneWrapper() func() bool {
-	return func() bool {
-		err := gl.waitForPacket(3000 * time.Millisecond)
-		if err != nil {
-			return false
-		}
-		return true
+	snr, err := gl.readReg(internal.REG_PKT_SNR_VALUE)
+	if err != nil {
+		return nil, err
 	}
+	pkt.RSSI = pktRssiDbm(rssi, gl.Conf.Frequency)
+	pkt.SNR = pktSnrDb(snr)
+	return pkt, nil
 }
 
-func (gl *GoLora) txDoneWrapper() func() bool {
-
-	return func() bool {
-		err := gl.waitForTxDone(10000 * time.Millisecond)
-		if err != nil {
-			return false
-		}
-		return true
+// pktRssiDbm applies the RSSI offset of the port the frequency is served by;
+// Conf.Frequency holds plain hertz.
+func pktRssiDbm(raw byte, freq physic.Frequency) int {
+	if freq > 0 && freq < 525000000 {
+		return int(raw) - 164
 	}
+	return int(raw) - 157
 }
 
-func (gl *GoLora) eventChecker(event Event) (func() bool, error) {
-	var checkerFunc func() bool = nil
-	var err error
-	if event == OnRxDone {
-		checkerFunc = gl.rxDoneWrapper()
-	} else if event == OnTxDone {
-		checkerFunc = gl.txDoneWrapper()
-	} else {
-		err = errors.New("event not recognized")
-	}
-	return checkerFunc, err
+func pktSnrDb(raw byte) float64 {
+	return float64(int8(raw)) / 4
 }
 
-func (gl *GoLora) runCb() {
-	if gl.cb != nil {
-		gl.cb()
-	}
+func (gl *GoLora) GetLastPktRSSI() (uint8, error) {
+	return gl.readRegCmd(internal.REG_PKT_RSSI_VALUE)
 }
 
-func (gl *GoLora) cbDaemon(eventChecker func() bool, ch chan struct{}) {
-	t := time.NewTicker(1 * time.Millisecond)
-	defer t.Stop()
-	for {
-		select {
-		case <-ch:
-			return
-		case <-t.C:
-			isHappen := eventChecker()
-			if isHappen {
-				gl.txDoneAtomic.Store(1)
-				gl.runCb()
-			} else {
-				continue
-			}
-		}
-	}
+func (gl *GoLora) GetLastPktSNR() (uint8, error) {
+	return gl.readRegCmd(internal.REG_PKT_SNR_VALUE)
 }
 
-func (gl *GoLora) RegisterCb(event Event, cb func()) (chan struct{}, error) {
-	thStopper := make(chan struct{})
-	gl.mu.Lock()
-	gl.mu.Unlock()
-	checkerFunc, err := gl.eventChecker(event)
-	if err != nil {
-		return nil, err
-	}
-	gl.cb = cb
-	gl.cbStopper = thStopper
-	go gl.cbDaemon(checkerFunc, thStopper)
-	return thStopper, nil // TODO: Change Return Values to object with stop method instead
+func (gl *GoLora) Destroy() error {
+	return gl.DestroyContext(context.Background())
 }
 
-func (gl *GoLora) GetLastPktRSSI() (uint8, error) {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	rssi, err := gl.readReg(internal.REG_PKT_RSSI_VALUE)
-	if err != nil {
-		return 0, err
-	}
-	return rssi, nil
+func (gl *GoLora) DestroyContext(ctx context.Context) error {
+	return gl.closeWith(ctx, func(ctx context.Context) error {
+		if err := gl.changeModeUnsafe(Sleep); err != nil {
+			return err
+		}
+		return gl.resetUnsafe(ctx)
+	})
 }
 
-func (gl *GoLora) GetLastPktSNR() (uint8, error) {
-	gl.mu.Lock()
-	defer gl.mu.Unlock()
-	snr, err := gl.readReg(internal.REG_PKT_SNR_VALUE)
-	if err != nil {
-		return 0, err
-	}
-	return snr, nil
+// Close puts the radio to sleep and stops the owner goroutine. A call still
+// waiting on the radio, such as a receive, is cancelled first. Every later
+// call on gl fails with ErrClosed, except Close and Destroy which return nil.
+func (gl *GoLora) Close() error {
+	return gl.closeWith(context.Background(), func(ctx context.Context) error {
+		return gl.changeModeUnsafe(Sleep)
+	})
 }
 
-func (gl *GoLora) Destroy() error {
-	defer func() {
-		if gl.cbStopper != nil { // TODO: Use Close Method
-			close(gl.cbStopper)
-			gl.cb = nil
-			gl.cbStopper = nil
-		}
-	}()
-	if err := gl.ChangeMode(Sleep); err != nil {
-		return err
-	}
-	if err := gl.Reset(); err != nil {
-		return err
+func (gl *GoLora) closeWith(ctx context.Context, fn func(ctx context.Context) error) error {
+	defer gl.shutdown()
+	err := gl.doFinal(ctx, fn)
+	if errors.Is(err, ErrClosed) {
+		return nil
 	}
-	return nil
+	return err
 }
 
 func (gl *GoLora) DumpRegisters() ([]RegVal, error) {
-	regRange := 0x43
+	regRange := int(internal.REG_LAST) + 1
 	registers := make([]byte, regRange)
 	regVal := make([]RegVal, len(registers))
 	values := make([]byte, len(registers))
@@ -778,18 +983,19 @@ func (gl *GoLora) DumpRegisters() ([]RegVal, error) {
 	for i := 0; i < regRange; i++ {
 		registers[i] = byte(i)
 	}
-	gl.mu.Lock()
-	for idx, reg := range registers {
-		values[idx], err = gl.readReg(reg)
-		if err != nil {
-			break
-		}
-		regVal[idx] = RegVal{
-			Reg: reg,
-			Val: values[idx],
+	err = gl.do(context.Background(), func(ctx context.Context) error {
+		for idx, reg := range registers {
+			values[idx], err = gl.readReg(reg)
+			if err != nil {
+				return err
+			}
+			regVal[idx] = RegVal{
+				Reg: reg,
+				Val: values[idx],
+			}
 		}
-	}
-	gl.mu.Unlock()
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -797,30 +1003,41 @@ func (gl *GoLora) DumpRegisters() ([]RegVal, error) {
 }
 
 func (gl *GoLora) GetConf() LoraConf {
-	return gl.Conf
+	var conf LoraConf
+	if err := gl.do(context.Background(), func(ctx context.Context) error {
+		conf = gl.Conf
+		return nil
+	}); err != nil {
+		return gl.Conf
+	}
+	return conf
 }
 
 func (gl *GoLora) GetAirtime(payloadLength uint16) time.Duration {
-	SF := float64(gl.Conf.SF)
-	BW := gl.Conf.BW
-	CR := float64(gl.Conf.Denum - 4)
-	PL := float64(payloadLength)
-	var H float64
-	if gl.Conf.Header == Implicit {
-		H = 1
-	} else {
-		H = 0
+	return airtime(gl.GetConf(), payloadLength)
+}
+
+// airtime estimates the time on air for conf.
+func airtime(conf LoraConf, payloadLength uint16) time.Duration {
+	return AirtimeParams(conf).TimeOnAir(int(payloadLength))
+}
+
+// AirtimeParams describes conf to the airtime package, with the
+// LowDataRateOptimize setting the driver programs for it.
+func AirtimeParams(conf LoraConf) airtimecalc.Params {
+	return airtimecalc.Params{
+		SF:                  conf.SF,
+		BW:                  conf.BW,
+		Denum:               conf.Denum,
+		PreambleLength:      conf.PreambleLength,
+		ImplicitHeader:      conf.Header == Implicit,
+		CRC:                 conf.EnableCrc,
+		LowDataRateOptimize: lowDataRateOptimize(conf),
 	}
-	var DE float64
-	if gl.Conf.SF > 11 {
-		DE = 1
-	} else {
-		DE = 0
-	}
-	Ts := math.Pow(2, SF) / float64(BW)
-	Tpreamble := (float64(gl.Conf.PreambleLength) + 4.25) * Ts
-	payloadSymb := 8 + math.Max(math.Ceil((8*PL-4*SF+28+16-20*H)/(4*(SF-2*DE)))*(CR+4), 0)
-	Tpayload := payloadSymb * Ts
-	totalTimeSec := Tpreamble + Tpayload
-	return time.Duration(totalTimeSec * float64(time.Second))
+}
+
+// lowDataRateOptimize is the LowDataRateOptimize setting conf needs, shared
+// by the airtime estimate and the modem register.
+func lowDataRateOptimize(conf LoraConf) bool {
+	return airtimecalc.LowDataRateRequired(conf.SF, conf.BW)
 }
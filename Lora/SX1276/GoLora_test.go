@@ -1,11 +1,14 @@
 package SX1276
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
 	"github.com/Fsyahputra/GoLora/driver"
 	"github.com/stretchr/testify/assert"
 	"periph.io/x/conn/v3/physic"
@@ -66,6 +69,203 @@ func (mc *mockModConn) ReadFromMod(reg byte) (byte, error) {
 	return mc.read(reg)
 }
 
+type fakeChip struct {
+	mu        sync.Mutex
+	regs      [0x80]byte
+	writeErrs map[byte]error
+	stuck     map[byte]byte
+	reads     map[byte]int
+	writes    map[byte]int
+	writeLog  []RegVal
+	fifo      [256]byte
+	sent      [][]byte
+	txRegs    [][0x80]byte
+	txHang    bool
+	// rssiSeq is returned by successive RegRssiValue reads before falling
+	// back to the register.
+	rssiSeq []byte
+}
+
+func newFakeChip() *fakeChip {
+	fc := &fakeChip{
+		writeErrs: map[byte]error{},
+		stuck:     map[byte]byte{},
+		reads:     map[byte]int{},
+		writes:    map[byte]int{},
+	}
+	fc.regs[0x42] = 0x12
+	return fc
+}
+
+func (fc *fakeChip) SendToMod(reg, val byte) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	reg &= 0x7f
+	fc.writes[reg]++
+	fc.writeLog = append(fc.writeLog, RegVal{Reg: reg, Val: val})
+	if err, ok := fc.writeErrs[reg]; ok {
+		return err
+	}
+	if stuckVal, ok := fc.stuck[reg]; ok {
+		val = stuckVal
+	}
+	switch reg {
+	case internal.REG_FIFO:
+		fc.fifo[fc.regs[internal.REG_FIFO_ADDR_PTR]] = val
+		fc.regs[internal.REG_FIFO_ADDR_PTR]++
+		return nil
+	case internal.REG_IRQ_FLAGS:
+		fc.regs[reg] &^= val
+		return nil
+	case internal.REG_OP_MODE:
+		if val&0x07 == internal.MODE_TX {
+			fc.transmit()
+			if !fc.txHang {
+				val = val&^0x07 | internal.MODE_STDBY
+			}
+		}
+	}
+	fc.regs[reg] = val
+	return nil
+}
+
+// transmit finishes a transmission instantly: the payload is logged, TxDone
+// is raised and the chip falls back to standby like the real one does.
+func (fc *fakeChip) transmit() {
+	base := fc.regs[internal.REG_FIFO_TX_BASE_ADDR]
+	pkt := make([]byte, fc.regs[internal.REG_PAYLOAD_LENGTH])
+	for i := range pkt {
+		pkt[i] = fc.fifo[base+byte(i)]
+	}
+	fc.sent = append(fc.sent, pkt)
+	fc.txRegs = append(fc.txRegs, fc.regs)
+	if !fc.txHang {
+		fc.regs[internal.REG_IRQ_FLAGS] |= internal.IRQ_TX_DONE_MASK
+	}
+}
+
+func (fc *fakeChip) ReadFromMod(reg byte) (byte, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	reg &= 0x7f
+	fc.reads[reg]++
+	if reg == internal.REG_RSSI_VALUE && len(fc.rssiSeq) > 0 {
+		val := fc.rssiSeq[0]
+		fc.rssiSeq = fc.rssiSeq[1:]
+		return val, nil
+	}
+	if reg == internal.REG_FIFO {
+		val := fc.fifo[fc.regs[internal.REG_FIFO_ADDR_PTR]]
+		fc.regs[internal.REG_FIFO_ADDR_PTR]++
+		return val, nil
+	}
+	return fc.regs[reg], nil
+}
+
+// ReadVal lets the fake act as the DIO0 pin, following RegDioMapping1.
+func (fc *fakeChip) ReadVal() (bool, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	irq := fc.regs[internal.REG_IRQ_FLAGS]
+	switch fc.regs[internal.REG_DIO_MAPPING_1] >> 6 {
+	case 0:
+		return irq&internal.IRQ_RX_DONE_MASK != 0, nil
+	case 1:
+		return irq&internal.IRQ_TX_DONE_MASK != 0, nil
+	}
+	return false, nil
+}
+
+func (fc *fakeChip) injectPacket(pkt []byte) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	base := fc.regs[internal.REG_FIFO_RX_BASE_ADDR]
+	for i, b := range pkt {
+		fc.fifo[base+byte(i)] = b
+	}
+	fc.regs[internal.REG_FIFO_RX_CURRENT_ADDR] = base
+	fc.regs[internal.REG_RX_NB_BYTES] = byte(len(pkt))
+	fc.regs[internal.REG_PAYLOAD_LENGTH] = byte(len(pkt))
+	fc.regs[internal.REG_IRQ_FLAGS] |= internal.IRQ_VALID_HEADER_MASK | internal.IRQ_RX_DONE_MASK
+}
+
+func (fc *fakeChip) transmitted() [][]byte {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([][]byte(nil), fc.sent...)
+}
+
+func (fc *fakeChip) poke(reg, val byte) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.regs[reg] = val
+}
+
+func (fc *fakeChip) resetCounters() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.reads = map[byte]int{}
+	fc.writes = map[byte]int{}
+	fc.writeLog = nil
+}
+
+func (fc *fakeChip) writesTo(reg byte) []byte {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var vals []byte
+	for _, rv := range fc.writeLog {
+		if rv.Reg == reg {
+			vals = append(vals, rv.Val)
+		}
+	}
+	return vals
+}
+
+func (fc *fakeChip) readCount(reg byte) int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.reads[reg]
+}
+
+func (fc *fakeChip) writeCount(reg byte) int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.writes[reg]
+}
+
+func (fc *fakeChip) reg(reg byte) byte {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.regs[reg]
+}
+
+func fakeChipDrv(fc *fakeChip) *driver.Driver {
+	return &driver.Driver{
+		RSTPin: &mockRstPin{
+			lowFunc:  func() error { return nil },
+			highFunc: func() error { return nil },
+		},
+		CbPin:   &mockCbPin{readValFunc: func() (bool, error) { return false, nil }},
+		ModComm: fc,
+	}
+}
+
+// markReady stands in for Begin, which mock drivers cannot get through.
+func markReady(gl *GoLora) *GoLora {
+	_ = gl.do(context.Background(), func(ctx context.Context) error {
+		gl.setModeUnsafe(Idle)
+		gl.setStateUnsafe(StateReady)
+		return nil
+	})
+	return gl
+}
+
+func fakeRadioDrv(fc *fakeChip) *driver.Driver {
+	drv := fakeChipDrv(fc)
+	drv.CbPin = fc
+	return drv
+}
+
 func testsDrvMock(SendErr error, ReadErr error) func() *driver.Driver {
 	return func() *driver.Driver {
 		return &driver.Driver{
@@ -277,7 +477,7 @@ func TestGoLora_SetTXPower(t *testing.T) {
 	for _, tt := range errorTest {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(tt.mockdrv(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(tt.mockdrv(), newDefLoraConf()))
 			defTxPwr := gl.Conf.TxPower
 			assert.Equal(t, defTxPwr, uint8(0))
 			err := gl.SetTXPower(uint8(tt.txPower))
@@ -323,7 +523,7 @@ func TestGoLora_SetTXPower(t *testing.T) {
 
 	for _, tt := range defaultTest {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(tt.mockdrv(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(tt.mockdrv(), newDefLoraConf()))
 			err := gl.SetTXPower(uint8(tt.txPower))
 			if tt.want == nil {
 				assert.NoError(t, err)
@@ -357,6 +557,19 @@ func TestGoLora_SetSyncWord(t *testing.T) {
 					},
 				}
 			},
+			syncWord: 0x12,
+		},
+		{
+			name: "Should write a sync word CheckSyncWord warns about",
+			mockDrv: func() *driver.Driver {
+				return &driver.Driver{
+					ModComm: &mockModConn{
+						send: func(reg, val byte) error {
+							return nil
+						},
+					},
+				}
+			},
 			syncWord: 0x00,
 		},
 		{
@@ -380,7 +593,7 @@ func TestGoLora_SetSyncWord(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(tt.mockDrv(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(tt.mockDrv(), newDefLoraConf()))
 			err := gl.SetSyncWord(tt.syncWord)
 			if tt.want == nil {
 				assert.NoError(t, err)
@@ -462,7 +675,7 @@ func TestGoLora_SetSF(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(tt.mockDrv(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(tt.mockDrv(), newDefLoraConf()))
 			err := gl.SetSF(tt.sf)
 			if tt.want == nil {
 				assert.NoError(t, err)
@@ -506,7 +719,7 @@ func TestGoLora_SetSF(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(test2mockDrv(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(test2mockDrv(), newDefLoraConf()))
 			err := gl.SetSF(tt.sf)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want, gl.Conf.SF)
@@ -522,7 +735,7 @@ func TestGoLora_SetSF(t *testing.T) {
 	}
 
 	t.Run(tests3.name, func(t *testing.T) {
-		gl := NewGoLoraSX1276(test2mockDrv(), newDefLoraConf())
+		gl := markReady(NewGoLoraSX1276(test2mockDrv(), newDefLoraConf()))
 		err := gl.SetSF(tests3.sf)
 		assert.NoError(t, err)
 		assert.Equal(t, tests3.sf, gl.Conf.SF)
@@ -564,7 +777,7 @@ func TestGoLora_SetPreamble(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(tt.sendErr, tt.readErr)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(tt.sendErr, tt.readErr)(), newDefLoraConf()))
 			if err := gl.SetPreamble(tt.preamble); err != nil {
 				assert.EqualError(t, err, tt.sendErr.Error())
 				return
@@ -578,7 +791,7 @@ func TestGoLora_SetHeader(t *testing.T) {
 	driversList, tests := createDrvMockAndTest()
 	for idx, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(driversList[idx], newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(driversList[idx], newDefLoraConf()))
 			err := gl.SetHeader(Explicit)
 			if err != nil {
 				assert.Error(t, err)
@@ -603,7 +816,7 @@ func TestGoLora_SetHeader(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf()))
 			_ = gl.SetHeader(tt.header)
 			assert.Equal(t, tt.header, gl.Conf.Header)
 		})
@@ -614,7 +827,7 @@ func TestGoLora_SetFrequency(t *testing.T) {
 	testDrvMock, tests := createDrvMockAndTest()
 	for idx, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testDrvMock[idx], newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testDrvMock[idx], newDefLoraConf()))
 			err := gl.SetFrequency(920 * physic.MegaHertz)
 			if err != nil {
 				assert.Error(t, err)
@@ -640,7 +853,7 @@ func TestGoLora_SetFrequency(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf()))
 			_ = gl.SetFrequency(tt.freq)
 			assert.Equal(t, tt.freq, gl.Conf.Frequency)
 		})
@@ -651,7 +864,7 @@ func TestGoLora_SetCrc(t *testing.T) {
 	driverList, tests := createDrvMockAndTest()
 	for idx, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(driverList[idx], newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(driverList[idx], newDefLoraConf()))
 			err := gl.SetCrc(true)
 			if err != nil {
 				assert.Error(t, err)
@@ -677,7 +890,7 @@ func TestGoLora_SetCrc(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf()))
 			_ = gl.SetCrc(tt.crc)
 			assert.Equal(t, tt.crc, gl.Conf.EnableCrc)
 		})
@@ -688,7 +901,7 @@ func TestGoLora_SetCodingRate(t *testing.T) {
 	driverList, tests := createDrvMockAndTest()
 	for idx, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(driverList[idx], newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(driverList[idx], newDefLoraConf()))
 			err := gl.SetCodingRate(1)
 			if err != nil {
 				assert.Error(t, err)
@@ -721,7 +934,7 @@ func TestGoLora_SetCodingRate(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf()))
 			_ = gl.SetCodingRate(tt.denum)
 			assert.Equal(t, tt.want, gl.Conf.Denum)
 		})
@@ -732,7 +945,7 @@ func TestGoLora_SetBW(t *testing.T) {
 	driverList, tests := createDrvMockAndTest()
 	for idx, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(driverList[idx], newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(driverList[idx], newDefLoraConf()))
 			err := gl.SetBW(10)
 			if err != nil {
 				assert.Error(t, err)
@@ -757,7 +970,12 @@ func TestGoLora_SetBW(t *testing.T) {
 		{
 			name: "it Should Set SBW to 9 if BW to high",
 			bw:   int(BW_8) + 1,
-			want: int(BW_8),
+			want: 500000,
+		},
+		{
+			name: "it Should keep 500 kHz at SBW 9",
+			bw:   500000,
+			want: 500000,
 		},
 		{
 			name: "it Should set SBW to 6 if BW is int(BW_7) - 1",
@@ -773,7 +991,7 @@ func TestGoLora_SetBW(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf()))
 			_ = gl.SetBW(uint64(tt.bw))
 			assert.Equal(t, tt.want, int(gl.Conf.BW))
 		})
@@ -823,7 +1041,7 @@ func TestGoLora_ChangeMode(t *testing.T) {
 
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(testsDrvMock(nil, nil)(), newDefLoraConf()))
 			_ = gl.ChangeMode(tt.mode)
 			assert.Equal(t, tt.mode, gl.Mode)
 		})
@@ -1209,8 +1427,8 @@ func TestGoLora_SendPacket(t *testing.T) {
 	driverList, tests := createDrvMockAndTest()
 	for idx, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(driverList[idx], newDefLoraConf())
-			err := gl.SendPacket([]byte("test data"))
+			gl := markReady(NewGoLoraSX1276(driverList[idx], newDefLoraConf()))
+			err := gl.SendPacket(context.Background(), []byte("test data"))
 			if err != nil {
 				assert.Error(t, err)
 				return
@@ -1281,7 +1499,7 @@ func TestGoLora_ReceivePacket(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gl := NewGoLoraSX1276(drvMock(tt.sendErr, tt.readErr, tt.irq), newDefLoraConf())
+			gl := markReady(NewGoLoraSX1276(drvMock(tt.sendErr, tt.readErr, tt.irq), newDefLoraConf()))
 			_, err := gl.ReceivePacket()
 			if tt.want == nil {
 				assert.NoError(t, err)
@@ -1291,3 +1509,121 @@ func TestGoLora_ReceivePacket(t *testing.T) {
 		})
 	}
 }
+
+func TestGoLora_SendPacket_CancelLeavesStandby(t *testing.T) {
+	fc := newFakeChip()
+	fc.txHang = true
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := gl.SendPacket(ctx, []byte("stuck"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, internal.MODE_STDBY, fc.reg(internal.REG_OP_MODE)&0x07)
+}
+
+func TestGoLora_TxTimeout(t *testing.T) {
+	conf := newAppliedLoraConf()
+	conf.SF = 12
+	conf.BW = 125000
+	gl := NewGoLoraSX1276(fakeChipDrv(newFakeChip()), conf)
+	timeout := gl.TxTimeout(32)
+	assert.Greater(t, timeout, 2*gl.GetAirtime(32))
+	assert.Greater(t, timeout, 300*time.Millisecond, "SF12 airtime exceeds the old fixed timeout")
+
+	unset := NewGoLoraSX1276(fakeChipDrv(newFakeChip()), newDefLoraConf())
+	assert.Equal(t, fallbackTxTimeout, unset.TxTimeout(32))
+}
+
+func TestGoLora_ReceivePacketContext(t *testing.T) {
+	t.Run("It Should return the packet once RxDone is raised", func(t *testing.T) {
+		fc := newFakeChip()
+		conf := newAppliedLoraConf()
+		conf.Header = true
+		gl := markReady(NewGoLoraSX1276(fakeRadioDrv(fc), conf))
+		defer gl.Close()
+		events := make(chan EventData, 1)
+		sub, err := gl.Subscribe(OnRxDone, func(ev EventData) { events <- ev })
+		assert.NoError(t, err)
+		defer sub.Unsubscribe()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done := make(chan []byte, 1)
+		go func() {
+			pkt, err := gl.ReceivePacketContext(ctx)
+			assert.NoError(t, err)
+			done <- pkt
+		}()
+		assert.Eventually(t, func() bool { return gl.State() == StateReceiving }, time.Second, time.Millisecond)
+		assert.NoError(t, gl.SetSyncWord(0x34), "a pending receive must not hold the radio")
+		fc.injectPacket([]byte("ping"))
+
+		assert.Equal(t, []byte("ping"), <-done)
+		ev := <-events
+		if assert.NotNil(t, ev.Packet) {
+			assert.Equal(t, []byte("ping"), ev.Packet.Data)
+		}
+		assert.Equal(t, internal.MODE_RX_CONTINUOUS, fc.reg(internal.REG_OP_MODE)&0x07)
+		_, err = gl.ReceivePacket()
+		assert.Error(t, err, "the packet must not be handed out twice")
+	})
+	t.Run("It Should leave the radio in standby when cancelled", func(t *testing.T) {
+		fc := newFakeChip()
+		gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := gl.ReceivePacketContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, internal.MODE_STDBY, fc.reg(internal.REG_OP_MODE)&0x07)
+	})
+}
+
+func TestGoLora_ResetContext_Cancel(t *testing.T) {
+	var mu sync.Mutex
+	var rstLog []string
+	drv := fakeChipDrv(newFakeChip())
+	drv.RSTPin = &mockRstPin{
+		lowFunc: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			rstLog = append(rstLog, "low")
+			return nil
+		},
+		highFunc: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			rstLog = append(rstLog, "high")
+			return nil
+		},
+	}
+	gl := NewGoLoraSX1276(drv, newDefLoraConf())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := gl.ResetContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), resetPulseTime)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"low", "high"}, rstLog, "reset line must be released on cancel")
+}
+
+func TestAirtimeParams_LowDataRateOptimize(t *testing.T) {
+	tests := []struct {
+		sf   uint8
+		bw   uint64
+		want bool
+	}{
+		{sf: 7, bw: 125000, want: false},
+		{sf: 11, bw: 125000, want: true},
+		{sf: 12, bw: 125000, want: true},
+		{sf: 12, bw: 250000, want: true},
+		{sf: 11, bw: 250000, want: false},
+		{sf: 10, bw: 62500, want: true},
+	}
+	for _, tt := range tests {
+		conf := LoraConf{SF: tt.sf, BW: tt.bw, Denum: 5, PreambleLength: 8}
+		assert.Equal(t, tt.want, AirtimeParams(conf).LowDataRateOptimize, "SF%d BW%d", tt.sf, tt.bw)
+	}
+}
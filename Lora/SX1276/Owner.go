@@ -0,0 +1,166 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+)
+
+// All SPI traffic for a GoLora goes through a single owner goroutine. Public
+// methods wrap their *Unsafe counterparts in a command and wait for the owner
+// to run it, so a transaction such as "load FIFO, start TX, wait for TxDone"
+// can never interleave with another caller's. Close first cancels the
+// command holding the owner, if any, so a receive or test transmission that
+// would never end on its own cannot keep the radio from closing.
+
+var ErrClosed = errors.New("radio closed")
+
+const irqPollInterval = time.Millisecond
+
+type radioCmd struct {
+	ctx  context.Context
+	fn   func(ctx context.Context) error
+	done chan error
+	// final marks the command Close runs after cancelling the others.
+	final bool
+}
+
+func (gl *GoLora) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return gl.send(radioCmd{ctx: ctx, fn: fn})
+}
+
+// doFinal cancels whatever holds the owner and runs fn after it; every other
+// command fails with ErrClosed from here on.
+func (gl *GoLora) doFinal(ctx context.Context, fn func(ctx context.Context) error) error {
+	gl.stopCmds()
+	return gl.send(radioCmd{ctx: ctx, fn: fn, final: true})
+}
+
+func (gl *GoLora) send(cmd radioCmd) error {
+	cmd.done = make(chan error, 1)
+	var closing <-chan struct{}
+	if !cmd.final {
+		closing = gl.closing.Done()
+	}
+	select {
+	case gl.cmds <- cmd:
+	case <-cmd.ctx.Done():
+		return cmd.ctx.Err()
+	case <-closing:
+		return ErrClosed
+	case <-gl.quit:
+		return ErrClosed
+	}
+	return <-cmd.done
+}
+
+func (gl *GoLora) runCmd(cmd radioCmd) {
+	defer func() {
+		if r := recover(); r != nil {
+			cmd.done <- fmt.Errorf("radio command panicked: %v", r)
+		}
+	}()
+	if err := cmd.ctx.Err(); err != nil {
+		cmd.done <- err
+		return
+	}
+	if cmd.final {
+		cmd.done <- cmd.fn(cmd.ctx)
+		return
+	}
+	if gl.closing.Err() != nil {
+		cmd.done <- ErrClosed
+		return
+	}
+	ctx, cancel := context.WithCancelCause(cmd.ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(gl.closing, func() { cancel(ErrClosed) })
+	defer stop()
+	err := cmd.fn(ctx)
+	if errors.Is(err, context.Canceled) && context.Cause(ctx) == ErrClosed {
+		err = ErrClosed
+	}
+	cmd.done <- err
+}
+
+func (gl *GoLora) ownerLoop() {
+	defer close(gl.ownerDone)
+	ticker := time.NewTicker(irqPollInterval)
+	defer ticker.Stop()
+	for {
+		var tick <-chan time.Time
+		if gl.wantIrq() {
+			tick = ticker.C
+		}
+		select {
+		case cmd := <-gl.cmds:
+			gl.runCmd(cmd)
+		case <-tick:
+			gl.pollIrqUnsafe()
+		case <-gl.quit:
+			gl.setStateUnsafe(StateClosed)
+			gl.bus.close()
+			return
+		}
+	}
+}
+
+func (gl *GoLora) shutdown() {
+	gl.stopCmds()
+	gl.closeOnce.Do(func() {
+		close(gl.quit)
+	})
+	<-gl.ownerDone
+}
+
+func (gl *GoLora) wantIrq() bool {
+	return gl.bus.has(OnRxDone, OnTxDone)
+}
+
+// mapDio0Unsafe routes DIO0 to the interrupt that ends the given mode so the
+// owner can tell a finished transmission from a received packet.
+func (gl *GoLora) mapDio0Unsafe(mode LoraMode) error {
+	var dio0 byte
+	switch mode {
+	case Tx:
+		dio0 = 0x40
+	case RxContinuous, RxSingle:
+		dio0 = 0x00
+	default:
+		return nil
+	}
+	current, err := gl.readRegShadow(internal.REG_DIO_MAPPING_1)
+	if err != nil {
+		return err
+	}
+	return gl.writeRegShadow(internal.REG_DIO_MAPPING_1, current&0x3f|dio0)
+}
+
+// pollIrqUnsafe services DIO0 while it is high; handling an interrupt clears
+// its flag, which drops the pin again. Boards without the pin wired fall back
+// to reading RegIrqFlags on every tick.
+func (gl *GoLora) pollIrqUnsafe() {
+	if gl.CbPin != nil {
+		level, err := gl.CbPin.ReadVal()
+		if err != nil || !level {
+			return
+		}
+	}
+	irq, err := gl.readReg(internal.REG_IRQ_FLAGS)
+	if err != nil {
+		return
+	}
+	if irq&internal.IRQ_TX_DONE_MASK != 0 {
+		_ = gl.writeReg(internal.REG_IRQ_FLAGS, internal.IRQ_TX_DONE_MASK)
+		gl.txFinishedUnsafe()
+		gl.publish(EventData{Event: OnTxDone})
+	}
+	if irq&internal.IRQ_RX_DONE_MASK != 0 {
+		if pkt, err := gl.rxDoneUnsafe(); err == nil {
+			gl.rxPending = pkt
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package SX1276
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pattern(id, size int) []byte {
+	return bytes.Repeat([]byte{byte(id)}, size)
+}
+
+func TestGoLora_ConcurrentSendPacket(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+	const senders = 32
+
+	var wg sync.WaitGroup
+	for id := 1; id <= senders; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			assert.NoError(t, gl.SendPacket(context.Background(), pattern(id, 8+id)))
+		}(id)
+	}
+	wg.Wait()
+
+	sent := fc.transmitted()
+	assert.Len(t, sent, senders)
+	seen := map[byte]bool{}
+	for _, pkt := range sent {
+		if !assert.NotEmpty(t, pkt) {
+			continue
+		}
+		id := pkt[0]
+		assert.Equal(t, pattern(int(id), 8+int(id)), pkt, "payload of sender %d was interleaved", id)
+		assert.False(t, seen[id], "sender %d transmitted twice", id)
+		seen[id] = true
+	}
+}
+
+func TestGoLora_ConcurrentReceiveAndModeChanges(t *testing.T) {
+	fc := newFakeChip()
+	conf := newAppliedLoraConf()
+	conf.Header = true
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), conf))
+	assert.NoError(t, gl.ChangeMode(RxContinuous))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for id := 1; ctx.Err() == nil; id = id%200 + 1 {
+			fc.injectPacket(pattern(id, 20))
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				pkt, err := gl.ReceivePacket()
+				if err != nil {
+					continue
+				}
+				if assert.Len(t, pkt, 20) {
+					assert.Equal(t, pattern(int(pkt[0]), 20), pkt)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			assert.NoError(t, gl.ChangeMode(RxContinuous))
+			assert.NoError(t, gl.SetSyncWord(0x12))
+			_, _ = gl.IsReceived()
+			_ = gl.GetConf()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestGoLora_RegisterCb_FakeRadio(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeRadioDrv(fc), newAppliedLoraConf()))
+	defer gl.shutdown()
+
+	fired := make(chan struct{}, 4)
+	stopper, err := gl.RegisterCb(OnRxDone, func() { fired <- struct{}{} })
+	assert.NoError(t, err)
+	defer func() { stopper <- struct{}{} }()
+	assert.NoError(t, gl.ChangeMode(RxContinuous))
+
+	fc.injectPacket([]byte("hello"))
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("OnRxDone callback not called")
+	}
+	pkt, err := gl.ReceivePacket()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), pkt)
+}
+
+func TestGoLora_DoAfterClose(t *testing.T) {
+	fc := newFakeChip()
+	gl := NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf())
+	gl.shutdown()
+	gl.shutdown()
+	assert.ErrorIs(t, gl.SetSyncWord(0x34), ErrClosed)
+	assert.ErrorIs(t, gl.SendPacket(context.Background(), []byte("x")), ErrClosed)
+}
+
+func TestGoLora_DoCancelledBeforeRun(t *testing.T) {
+	fc := newFakeChip()
+	gl := NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf())
+	defer gl.shutdown()
+
+	release := make(chan struct{})
+	go func() {
+		_ = gl.do(context.Background(), func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ran := false
+	err := gl.do(ctx, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	close(release)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, ran)
+}
+
+func TestGoLora_DoRecoversPanic(t *testing.T) {
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(newFakeChip()), newDefLoraConf()))
+	defer gl.shutdown()
+	err := gl.do(context.Background(), func(ctx context.Context) error {
+		panic("boom")
+	})
+	assert.EqualError(t, err, "radio command panicked: boom")
+	assert.NoError(t, gl.SetSyncWord(0x34), "owner must survive a panicking command")
+}
+
+func TestGoLora_CloseCancelsPendingCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(gl *GoLora) error
+		want error
+	}{
+		{name: "receive", run: func(gl *GoLora) error {
+			_, err := gl.ReceivePacketContext(context.Background())
+			return err
+		}, want: ErrClosed},
+		// an endless test is stopped by cancelling it, which is not an error
+		{name: "endless test mode", run: func(gl *GoLora) error {
+			return gl.RunTestMode(context.Background(), TestModeConfig{Signal: TestModulated})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newFakeChip()
+			gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+			done := make(chan error, 1)
+			go func() { done <- tt.run(gl) }()
+			assert.Eventually(t, func() bool { return gl.State() != StateReady }, time.Second, time.Millisecond)
+
+			closed := make(chan error, 1)
+			go func() { closed <- gl.Close() }()
+			select {
+			case err := <-closed:
+				assert.NoError(t, err)
+			case <-time.After(2 * time.Second):
+				t.Fatal("Close blocked behind the pending command")
+			}
+			select {
+			case err := <-done:
+				if tt.want == nil {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("pending command did not return")
+			}
+			assert.Equal(t, StateClosed, gl.State())
+			assert.ErrorIs(t, gl.SetSyncWord(0x34), ErrClosed)
+		})
+	}
+}
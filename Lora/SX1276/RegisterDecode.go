@@ -0,0 +1,94 @@
+package SX1276
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+)
+
+type RegAddr byte
+
+func (a RegAddr) String() string {
+	return fmt.Sprintf("0x%02X", byte(a))
+}
+
+func (a RegAddr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+type DecodedField struct {
+	Name  string `json:"name"`
+	Raw   byte   `json:"raw"`
+	Value string `json:"value"`
+}
+
+type DecodedRegister struct {
+	Addr   RegAddr        `json:"addr"`
+	Name   string         `json:"name"`
+	Raw    byte           `json:"raw"`
+	Fields []DecodedField `json:"fields,omitempty"`
+}
+
+// DumpPage guesses which register page a dump was taken in from the
+// LongRangeMode bit of RegOpMode, defaulting to LoRa.
+func DumpPage(dump []RegVal) RegisterPage {
+	for _, rv := range dump {
+		if rv.Reg == internal.REG_OP_MODE && rv.Val&internal.MODE_LONG_RANGE_MODE == 0 {
+			return FskPage
+		}
+	}
+	return LoraPage
+}
+
+func DecodeRegister(page RegisterPage, rv RegVal) DecodedRegister {
+	decoded := DecodedRegister{Addr: RegAddr(rv.Reg), Raw: rv.Val}
+	desc, ok := LookupRegister(page, rv.Reg)
+	if !ok {
+		decoded.Name = "Unused"
+		return decoded
+	}
+	decoded.Name = desc.Name
+	for _, bf := range desc.Fields {
+		raw := bf.extract(rv.Val)
+		decoded.Fields = append(decoded.Fields, DecodedField{
+			Name:  bf.Name,
+			Raw:   raw,
+			Value: bf.Format(raw),
+		})
+	}
+	return decoded
+}
+
+func DecodeRegisters(dump []RegVal) []DecodedRegister {
+	page := DumpPage(dump)
+	decoded := make([]DecodedRegister, len(dump))
+	for idx, rv := range dump {
+		decoded[idx] = DecodeRegister(page, rv)
+	}
+	return decoded
+}
+
+func RenderRegistersText(w io.Writer, regs []DecodedRegister) error {
+	for _, reg := range regs {
+		if _, err := fmt.Fprintf(w, "%s %-20s = 0x%02X\n", reg.Addr, reg.Name, reg.Raw); err != nil {
+			return err
+		}
+		if len(reg.Fields) == 1 && reg.Fields[0].Name == reg.Name {
+			continue
+		}
+		for _, f := range reg.Fields {
+			if _, err := fmt.Fprintf(w, "     %s.%s = %s\n", reg.Name, f.Name, f.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func RenderRegistersJSON(w io.Writer, regs []DecodedRegister) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(regs)
+}
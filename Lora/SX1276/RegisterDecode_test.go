@@ -0,0 +1,93 @@
+package SX1276
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRegister(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  RegisterPage
+		rv    RegVal
+		reg   string
+		field string
+		want  string
+	}{
+		{name: "bandwidth", page: LoraPage, rv: RegVal{Reg: 0x1d, Val: 0x72}, reg: "ModemConfig1", field: "Bw", want: "125 kHz"},
+		{name: "fractional bandwidth", page: LoraPage, rv: RegVal{Reg: 0x1d, Val: 0x12}, reg: "ModemConfig1", field: "Bw", want: "10.4 kHz"},
+		{name: "coding rate", page: LoraPage, rv: RegVal{Reg: 0x1d, Val: 0x72}, reg: "ModemConfig1", field: "CodingRate", want: "4/5"},
+		{name: "spreading factor", page: LoraPage, rv: RegVal{Reg: 0x1e, Val: 0xc4}, reg: "ModemConfig2", field: "SpreadingFactor", want: "SF12"},
+		{name: "crc", page: LoraPage, rv: RegVal{Reg: 0x1e, Val: 0xc4}, reg: "ModemConfig2", field: "RxPayloadCrcOn", want: "true"},
+		{name: "op mode", page: LoraPage, rv: RegVal{Reg: 0x01, Val: 0x85}, reg: "OpMode", field: "Mode", want: "RXCONTINUOUS"},
+		{name: "negative snr", page: LoraPage, rv: RegVal{Reg: 0x19, Val: 0xf6}, reg: "PktSnrValue", field: "PacketSnr", want: "-2.50 dB"},
+		{name: "pa dac", page: LoraPage, rv: RegVal{Reg: 0x4d, Val: 0x87}, reg: "PaDac", field: "PaDac", want: "+20 dBm on PA_BOOST"},
+		{name: "agc thresh", page: LoraPage, rv: RegVal{Reg: 0x63, Val: 0xa5}, reg: "AgcThresh2", field: "AgcStep3", want: "5"},
+		{name: "tx iq inverted", page: LoraPage, rv: RegVal{Reg: 0x33, Val: 0x26}, reg: "InvertIQ", field: "InvertIQTXOff", want: "false"},
+		{name: "tx iq normal", page: LoraPage, rv: RegVal{Reg: 0x33, Val: 0x27}, reg: "InvertIQ", field: "InvertIQTXOff", want: "true"},
+		{name: "fsk page", page: FskPage, rv: RegVal{Reg: 0x30, Val: 0x90}, reg: "PacketConfig1", field: "CrcOn", want: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded := DecodeRegister(tt.page, tt.rv)
+			assert.Equal(t, tt.reg, decoded.Name)
+			for _, f := range decoded.Fields {
+				if f.Name == tt.field {
+					assert.Equal(t, tt.want, f.Value)
+					return
+				}
+			}
+			t.Errorf("field %s not decoded", tt.field)
+		})
+	}
+
+	t.Run("it Should mark registers outside the table as unused", func(t *testing.T) {
+		decoded := DecodeRegister(LoraPage, RegVal{Reg: 0x02, Val: 0x1a})
+		assert.Equal(t, "Unused", decoded.Name)
+		assert.Empty(t, decoded.Fields)
+	})
+}
+
+func TestDecodeRegisters_PicksPageFromOpMode(t *testing.T) {
+	fsk := DecodeRegisters([]RegVal{{Reg: 0x01, Val: 0x01}, {Reg: 0x02, Val: 0x1a}})
+	assert.Equal(t, "BitrateMsb", fsk[1].Name)
+	lora := DecodeRegisters([]RegVal{{Reg: 0x01, Val: 0x81}, {Reg: 0x02, Val: 0x1a}})
+	assert.Equal(t, "Unused", lora[1].Name)
+}
+
+func TestRenderRegisters(t *testing.T) {
+	regs := DecodeRegisters([]RegVal{{Reg: 0x1d, Val: 0x72}, {Reg: 0x39, Val: 0x34}})
+
+	var text bytes.Buffer
+	assert.NoError(t, RenderRegistersText(&text, regs))
+	assert.Equal(t, ""+
+		"0x1D ModemConfig1         = 0x72\n"+
+		"     ModemConfig1.Bw = 125 kHz\n"+
+		"     ModemConfig1.CodingRate = 4/5\n"+
+		"     ModemConfig1.ImplicitHeaderModeOn = explicit header\n"+
+		"0x39 SyncWord             = 0x34\n", text.String())
+
+	var out bytes.Buffer
+	assert.NoError(t, RenderRegistersJSON(&out, regs))
+	var parsed []map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &parsed))
+	assert.Equal(t, "0x1D", parsed[0]["addr"])
+	assert.Equal(t, "ModemConfig1", parsed[0]["name"])
+	assert.Len(t, parsed[0]["fields"], 3)
+}
+
+func TestGoLora_DumpRegisters_FullRange(t *testing.T) {
+	fc := newFakeChip()
+	fc.poke(0x4d, 0x84)
+	fc.poke(0x70, 0xd0)
+	gl := NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf())
+	dump, err := gl.DumpRegisters()
+	assert.NoError(t, err)
+	assert.Len(t, dump, 0x71)
+	assert.Equal(t, RegVal{Reg: 0x4d, Val: 0x84}, dump[0x4d])
+	assert.Equal(t, RegVal{Reg: 0x70, Val: 0xd0}, dump[0x70])
+}
@@ -0,0 +1,423 @@
+package SX1276
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type RegAccess int
+
+const (
+	RegRW RegAccess = iota
+	RegRO
+	// RegRC registers are flags cleared by writing ones, e.g. RegIrqFlags.
+	RegRC
+)
+
+type RegisterPage int
+
+const (
+	LoraPage RegisterPage = iota
+	FskPage
+)
+
+type BitField struct {
+	Name   string
+	Hi, Lo uint8
+	Format func(val byte) string
+}
+
+func (bf BitField) extract(reg byte) byte {
+	width := bf.Hi - bf.Lo + 1
+	return reg >> bf.Lo & byte(1<<width-1)
+}
+
+type RegisterDesc struct {
+	Addr   byte
+	Name   string
+	Access RegAccess
+	Fields []BitField
+}
+
+func regField(name string, hi, lo uint8) BitField {
+	return BitField{Name: name, Hi: hi, Lo: lo, Format: func(val byte) string {
+		return strconv.Itoa(int(val))
+	}}
+}
+
+func regFlag(name string, bit uint8) BitField {
+	return BitField{Name: name, Hi: bit, Lo: bit, Format: func(val byte) string {
+		return strconv.FormatBool(val != 0)
+	}}
+}
+
+func regEnum(name string, hi, lo uint8, names ...string) BitField {
+	return BitField{Name: name, Hi: hi, Lo: lo, Format: func(val byte) string {
+		if int(val) < len(names) && names[val] != "" {
+			return names[val]
+		}
+		return fmt.Sprintf("reserved (%d)", val)
+	}}
+}
+
+func regCustom(name string, hi, lo uint8, format func(val byte) string) BitField {
+	return BitField{Name: name, Hi: hi, Lo: lo, Format: format}
+}
+
+func byteReg(addr byte, name string, access RegAccess) RegisterDesc {
+	return RegisterDesc{Addr: addr, Name: name, Access: access, Fields: []BitField{regField(name, 7, 0)}}
+}
+
+var opModeNames = []string{"SLEEP", "STDBY", "FSTX", "TX", "FSRX", "RXCONTINUOUS", "RXSINGLE", "CAD"}
+
+func formatBw(val byte) string {
+	bw, err := bwFromIndex(val)
+	if err != nil {
+		return fmt.Sprintf("reserved (%d)", val)
+	}
+	if bw%1000 == 0 {
+		return fmt.Sprintf("%d kHz", bw/1000)
+	}
+	return fmt.Sprintf("%.1f kHz", float64(bw)/1000)
+}
+
+func formatCodingRate(val byte) string {
+	if val < 1 || val > 4 {
+		return fmt.Sprintf("reserved (%d)", val)
+	}
+	return fmt.Sprintf("4/%d", val+4)
+}
+
+func formatSF(val byte) string {
+	if val < 6 || val > 12 {
+		return fmt.Sprintf("reserved (%d)", val)
+	}
+	return fmt.Sprintf("SF%d", val)
+}
+
+func formatSnr(val byte) string {
+	return fmt.Sprintf("%.2f dB", float64(int8(val))/4)
+}
+
+func formatPktRssi(val byte) string {
+	return fmt.Sprintf("%d dBm (HF port)", int(val)-157)
+}
+
+var commonRegisters = []RegisterDesc{
+	{Addr: 0x00, Name: "Fifo", Access: RegRW, Fields: []BitField{regField("Fifo", 7, 0)}},
+	byteReg(0x06, "FrfMsb", RegRW),
+	byteReg(0x07, "FrfMid", RegRW),
+	byteReg(0x08, "FrfLsb", RegRW),
+	{Addr: 0x09, Name: "PaConfig", Access: RegRW, Fields: []BitField{
+		regEnum("PaSelect", 7, 7, "RFO", "PA_BOOST"),
+		regField("MaxPower", 6, 4),
+		regField("OutputPower", 3, 0),
+	}},
+	{Addr: 0x0A, Name: "PaRamp", Access: RegRW, Fields: []BitField{
+		regField("ModulationShaping", 6, 5),
+		regEnum("PaRamp", 3, 0, "3.4 ms", "2 ms", "1 ms", "500 us", "250 us", "125 us", "100 us", "62 us", "50 us", "40 us", "31 us", "25 us", "20 us", "15 us", "12 us", "10 us"),
+	}},
+	{Addr: 0x0B, Name: "Ocp", Access: RegRW, Fields: []BitField{
+		regFlag("OcpOn", 5),
+		regField("OcpTrim", 4, 0),
+	}},
+	{Addr: 0x0C, Name: "Lna", Access: RegRW, Fields: []BitField{
+		regEnum("LnaGain", 7, 5, "", "G1 (max)", "G2", "G3", "G4", "G5", "G6 (min)"),
+		regField("LnaBoostLf", 4, 3),
+		regEnum("LnaBoostHf", 1, 0, "default", "", "", "boost on"),
+	}},
+	{Addr: 0x40, Name: "DioMapping1", Access: RegRW, Fields: []BitField{
+		regField("Dio0Mapping", 7, 6),
+		regField("Dio1Mapping", 5, 4),
+		regField("Dio2Mapping", 3, 2),
+		regField("Dio3Mapping", 1, 0),
+	}},
+	{Addr: 0x41, Name: "DioMapping2", Access: RegRW, Fields: []BitField{
+		regField("Dio4Mapping", 7, 6),
+		regField("Dio5Mapping", 5, 4),
+		regEnum("MapPreambleDetect", 0, 0, "Rssi", "PreambleDetect"),
+	}},
+	{Addr: 0x42, Name: "Version", Access: RegRO, Fields: []BitField{
+		regCustom("Version", 7, 0, func(val byte) string { return fmt.Sprintf("0x%02X", val) }),
+	}},
+	{Addr: 0x44, Name: "PllHop", Access: RegRW, Fields: []BitField{regFlag("FastHopOn", 7)}},
+	{Addr: 0x4B, Name: "Tcxo", Access: RegRW, Fields: []BitField{regFlag("TcxoInputOn", 4)}},
+	{Addr: 0x4D, Name: "PaDac", Access: RegRW, Fields: []BitField{
+		regEnum("PaDac", 2, 0, "", "", "", "", "default", "", "", "+20 dBm on PA_BOOST"),
+	}},
+	byteReg(0x5B, "FormerTemp", RegRO),
+	{Addr: 0x5D, Name: "BitRateFrac", Access: RegRW, Fields: []BitField{regField("BitRateFrac", 3, 0)}},
+	{Addr: 0x61, Name: "AgcRef", Access: RegRW, Fields: []BitField{regField("AgcReferenceLevel", 5, 0)}},
+	{Addr: 0x62, Name: "AgcThresh1", Access: RegRW, Fields: []BitField{regField("AgcStep1", 4, 0)}},
+	{Addr: 0x63, Name: "AgcThresh2", Access: RegRW, Fields: []BitField{
+		regField("AgcStep2", 7, 4),
+		regField("AgcStep3", 3, 0),
+	}},
+	{Addr: 0x64, Name: "AgcThresh3", Access: RegRW, Fields: []BitField{
+		regField("AgcStep4", 7, 4),
+		regField("AgcStep5", 3, 0),
+	}},
+	{Addr: 0x70, Name: "Pll", Access: RegRW, Fields: []BitField{
+		regEnum("PllBandwidth", 7, 6, "75 kHz", "150 kHz", "225 kHz", "300 kHz"),
+	}},
+}
+
+var loraRegisters = []RegisterDesc{
+	{Addr: 0x01, Name: "OpMode", Access: RegRW, Fields: []BitField{
+		regEnum("LongRangeMode", 7, 7, "FSK/OOK", "LoRa"),
+		regFlag("AccessSharedReg", 6),
+		regFlag("LowFrequencyModeOn", 3),
+		regEnum("Mode", 2, 0, opModeNames...),
+	}},
+	byteReg(0x0D, "FifoAddrPtr", RegRW),
+	byteReg(0x0E, "FifoTxBaseAddr", RegRW),
+	byteReg(0x0F, "FifoRxBaseAddr", RegRW),
+	byteReg(0x10, "FifoRxCurrentAddr", RegRO),
+	{Addr: 0x11, Name: "IrqFlagsMask", Access: RegRW, Fields: []BitField{
+		regFlag("RxTimeoutMask", 7),
+		regFlag("RxDoneMask", 6),
+		regFlag("PayloadCrcErrorMask", 5),
+		regFlag("ValidHeaderMask", 4),
+		regFlag("TxDoneMask", 3),
+		regFlag("CadDoneMask", 2),
+		regFlag("FhssChangeChannelMask", 1),
+		regFlag("CadDetectedMask", 0),
+	}},
+	{Addr: 0x12, Name: "IrqFlags", Access: RegRC, Fields: []BitField{
+		regFlag("RxTimeout", 7),
+		regFlag("RxDone", 6),
+		regFlag("PayloadCrcError", 5),
+		regFlag("ValidHeader", 4),
+		regFlag("TxDone", 3),
+		regFlag("CadDone", 2),
+		regFlag("FhssChangeChannel", 1),
+		regFlag("CadDetected", 0),
+	}},
+	byteReg(0x13, "RxNbBytes", RegRO),
+	byteReg(0x14, "RxHeaderCntValueMsb", RegRO),
+	byteReg(0x15, "RxHeaderCntValueLsb", RegRO),
+	byteReg(0x16, "RxPacketCntValueMsb", RegRO),
+	byteReg(0x17, "RxPacketCntValueLsb", RegRO),
+	{Addr: 0x18, Name: "ModemStat", Access: RegRO, Fields: []BitField{
+		regCustom("RxCodingRate", 7, 5, formatCodingRate),
+		regFlag("ModemClear", 4),
+		regFlag("HeaderInfoValid", 3),
+		regFlag("RxOnGoing", 2),
+		regFlag("SignalSynchronized", 1),
+		regFlag("SignalDetected", 0),
+	}},
+	{Addr: 0x19, Name: "PktSnrValue", Access: RegRO, Fields: []BitField{regCustom("PacketSnr", 7, 0, formatSnr)}},
+	{Addr: 0x1A, Name: "PktRssiValue", Access: RegRO, Fields: []BitField{regCustom("PacketRssi", 7, 0, formatPktRssi)}},
+	{Addr: 0x1B, Name: "RssiValue", Access: RegRO, Fields: []BitField{regCustom("Rssi", 7, 0, formatPktRssi)}},
+	{Addr: 0x1C, Name: "HopChannel", Access: RegRO, Fields: []BitField{
+		regFlag("PllTimeout", 7),
+		regFlag("CrcOnPayload", 6),
+		regField("FhssPresentChannel", 5, 0),
+	}},
+	{Addr: 0x1D, Name: "ModemConfig1", Access: RegRW, Fields: []BitField{
+		regCustom("Bw", 7, 4, formatBw),
+		regCustom("CodingRate", 3, 1, formatCodingRate),
+		regEnum("ImplicitHeaderModeOn", 0, 0, "explicit header", "implicit header"),
+	}},
+	{Addr: 0x1E, Name: "ModemConfig2", Access: RegRW, Fields: []BitField{
+		regCustom("SpreadingFactor", 7, 4, formatSF),
+		regFlag("TxContinuousMode", 3),
+		regFlag("RxPayloadCrcOn", 2),
+		regField("SymbTimeoutMsb", 1, 0),
+	}},
+	byteReg(0x1F, "SymbTimeoutLsb", RegRW),
+	byteReg(0x20, "PreambleMsb", RegRW),
+	byteReg(0x21, "PreambleLsb", RegRW),
+	byteReg(0x22, "PayloadLength", RegRW),
+	byteReg(0x23, "MaxPayloadLength", RegRW),
+	byteReg(0x24, "HopPeriod", RegRW),
+	byteReg(0x25, "FifoRxByteAddr", RegRO),
+	{Addr: 0x26, Name: "ModemConfig3", Access: RegRW, Fields: []BitField{
+		regFlag("LowDataRateOptimize", 3),
+		regFlag("AgcAutoOn", 2),
+	}},
+	byteReg(0x27, "PpmCorrection", RegRW),
+	{Addr: 0x28, Name: "FeiMsb", Access: RegRO, Fields: []BitField{regField("FreqError", 3, 0)}},
+	byteReg(0x29, "FeiMid", RegRO),
+	byteReg(0x2A, "FeiLsb", RegRO),
+	byteReg(0x2C, "RssiWideband", RegRO),
+	byteReg(0x2F, "IfFreq2", RegRW),
+	byteReg(0x30, "IfFreq1", RegRW),
+	{Addr: 0x31, Name: "DetectOptimize", Access: RegRW, Fields: []BitField{
+		regFlag("AutomaticIFOn", 7),
+		regEnum("DetectionOptimize", 2, 0, "", "", "", "SF7 to SF12", "", "SF6"),
+	}},
+	{Addr: 0x33, Name: "InvertIQ", Access: RegRW, Fields: []BitField{
+		regFlag("InvertIQRX", 6),
+		regFlag("InvertIQTXOff", 0),
+	}},
+	byteReg(0x36, "HighBwOptimize1", RegRW),
+	byteReg(0x37, "DetectionThreshold", RegRW),
+	byteReg(0x39, "SyncWord", RegRW),
+	byteReg(0x3A, "HighBwOptimize2", RegRW),
+	byteReg(0x3B, "InvertIQ2", RegRW),
+}
+
+var fskRegisters = []RegisterDesc{
+	{Addr: 0x01, Name: "OpMode", Access: RegRW, Fields: []BitField{
+		regEnum("LongRangeMode", 7, 7, "FSK/OOK", "LoRa"),
+		regEnum("ModulationType", 6, 5, "FSK", "OOK"),
+		regFlag("LowFrequencyModeOn", 3),
+		regEnum("Mode", 2, 0, "SLEEP", "STDBY", "FSTX", "TX", "FSRX", "RX"),
+	}},
+	byteReg(0x02, "BitrateMsb", RegRW),
+	byteReg(0x03, "BitrateLsb", RegRW),
+	{Addr: 0x04, Name: "FdevMsb", Access: RegRW, Fields: []BitField{regField("Fdev", 5, 0)}},
+	byteReg(0x05, "FdevLsb", RegRW),
+	{Addr: 0x0D, Name: "RxConfig", Access: RegRW, Fields: []BitField{
+		regFlag("RestartRxOnCollision", 7),
+		regFlag("RestartRxWithoutPllLock", 6),
+		regFlag("RestartRxWithPllLock", 5),
+		regFlag("AfcAutoOn", 4),
+		regFlag("AgcAutoOn", 3),
+		regField("RxTrigger", 2, 0),
+	}},
+	{Addr: 0x0E, Name: "RssiConfig", Access: RegRW, Fields: []BitField{
+		regField("RssiOffset", 7, 3),
+		regField("RssiSmoothing", 2, 0),
+	}},
+	byteReg(0x0F, "RssiCollision", RegRW),
+	byteReg(0x10, "RssiThresh", RegRW),
+	byteReg(0x11, "RssiValue", RegRO),
+	{Addr: 0x12, Name: "RxBw", Access: RegRW, Fields: []BitField{
+		regField("RxBwMant", 4, 3),
+		regField("RxBwExp", 2, 0),
+	}},
+	{Addr: 0x13, Name: "AfcBw", Access: RegRW, Fields: []BitField{
+		regField("RxBwMantAfc", 4, 3),
+		regField("RxBwExpAfc", 2, 0),
+	}},
+	{Addr: 0x14, Name: "OokPeak", Access: RegRW, Fields: []BitField{
+		regFlag("BitSyncOn", 5),
+		regEnum("OokThreshType", 4, 3, "fixed", "peak", "average"),
+		regField("OokPeakTheshStep", 2, 0),
+	}},
+	byteReg(0x15, "OokFix", RegRW),
+	{Addr: 0x16, Name: "OokAvg", Access: RegRW, Fields: []BitField{
+		regField("OokPeakThreshDec", 7, 5),
+		regField("OokAverageOffset", 3, 2),
+		regField("OokAverageThreshFilt", 1, 0),
+	}},
+	{Addr: 0x1A, Name: "AfcFei", Access: RegRW, Fields: []BitField{
+		regFlag("AgcStart", 4),
+		regFlag("AfcClear", 1),
+		regFlag("AfcAutoClearOn", 0),
+	}},
+	byteReg(0x1B, "AfcMsb", RegRW),
+	byteReg(0x1C, "AfcLsb", RegRW),
+	byteReg(0x1D, "FeiMsb", RegRO),
+	byteReg(0x1E, "FeiLsb", RegRO),
+	{Addr: 0x1F, Name: "PreambleDetect", Access: RegRW, Fields: []BitField{
+		regFlag("PreambleDetectorOn", 7),
+		regField("PreambleDetectorSize", 6, 5),
+		regField("PreambleDetectorTol", 4, 0),
+	}},
+	byteReg(0x20, "RxTimeout1", RegRW),
+	byteReg(0x21, "RxTimeout2", RegRW),
+	byteReg(0x22, "RxTimeout3", RegRW),
+	byteReg(0x23, "RxDelay", RegRW),
+	{Addr: 0x24, Name: "Osc", Access: RegRW, Fields: []BitField{
+		regFlag("RcCalStart", 3),
+		regField("ClkOut", 2, 0),
+	}},
+	byteReg(0x25, "PreambleMsb", RegRW),
+	byteReg(0x26, "PreambleLsb", RegRW),
+	{Addr: 0x27, Name: "SyncConfig", Access: RegRW, Fields: []BitField{
+		regField("AutoRestartRxMode", 7, 6),
+		regEnum("PreamblePolarity", 5, 5, "0xAA", "0x55"),
+		regFlag("SyncOn", 4),
+		regField("SyncSize", 2, 0),
+	}},
+	byteReg(0x28, "SyncValue1", RegRW),
+	byteReg(0x29, "SyncValue2", RegRW),
+	byteReg(0x2A, "SyncValue3", RegRW),
+	byteReg(0x2B, "SyncValue4", RegRW),
+	byteReg(0x2C, "SyncValue5", RegRW),
+	byteReg(0x2D, "SyncValue6", RegRW),
+	byteReg(0x2E, "SyncValue7", RegRW),
+	byteReg(0x2F, "SyncValue8", RegRW),
+	{Addr: 0x30, Name: "PacketConfig1", Access: RegRW, Fields: []BitField{
+		regEnum("PacketFormat", 7, 7, "fixed length", "variable length"),
+		regEnum("DcFree", 6, 5, "none", "manchester", "whitening"),
+		regFlag("CrcOn", 4),
+		regFlag("CrcAutoClearOff", 3),
+		regField("AddressFiltering", 2, 1),
+		regEnum("CrcWhiteningType", 0, 0, "CCITT", "IBM"),
+	}},
+	{Addr: 0x31, Name: "PacketConfig2", Access: RegRW, Fields: []BitField{
+		regEnum("DataMode", 6, 6, "continuous", "packet"),
+		regFlag("IoHomeOn", 5),
+		regFlag("BeaconOn", 3),
+		regField("PayloadLengthMsb", 2, 0),
+	}},
+	byteReg(0x32, "PayloadLength", RegRW),
+	byteReg(0x33, "NodeAdrs", RegRW),
+	byteReg(0x34, "BroadcastAdrs", RegRW),
+	{Addr: 0x35, Name: "FifoThresh", Access: RegRW, Fields: []BitField{
+		regEnum("TxStartCondition", 7, 7, "FifoLevel", "FifoEmpty"),
+		regField("FifoThreshold", 5, 0),
+	}},
+	byteReg(0x36, "SeqConfig1", RegRW),
+	byteReg(0x37, "SeqConfig2", RegRW),
+	byteReg(0x38, "TimerResol", RegRW),
+	byteReg(0x39, "Timer1Coef", RegRW),
+	byteReg(0x3A, "Timer2Coef", RegRW),
+	{Addr: 0x3B, Name: "ImageCal", Access: RegRW, Fields: []BitField{
+		regFlag("AutoImageCalOn", 7),
+		regFlag("ImageCalStart", 6),
+		regFlag("ImageCalRunning", 5),
+		regFlag("TempChange", 3),
+		regField("TempThreshold", 2, 1),
+		regFlag("TempMonitorOff", 0),
+	}},
+	byteReg(0x3C, "Temp", RegRO),
+	{Addr: 0x3D, Name: "LowBat", Access: RegRW, Fields: []BitField{
+		regFlag("LowBatOn", 3),
+		regField("LowBatTrim", 2, 0),
+	}},
+	{Addr: 0x3E, Name: "IrqFlags1", Access: RegRC, Fields: []BitField{
+		regFlag("ModeReady", 7),
+		regFlag("RxReady", 6),
+		regFlag("TxReady", 5),
+		regFlag("PllLock", 4),
+		regFlag("Rssi", 3),
+		regFlag("Timeout", 2),
+		regFlag("PreambleDetect", 1),
+		regFlag("SyncAddressMatch", 0),
+	}},
+	{Addr: 0x3F, Name: "IrqFlags2", Access: RegRC, Fields: []BitField{
+		regFlag("FifoFull", 7),
+		regFlag("FifoEmpty", 6),
+		regFlag("FifoLevel", 5),
+		regFlag("FifoOverrun", 4),
+		regFlag("PacketSent", 3),
+		regFlag("PayloadReady", 2),
+		regFlag("CrcOk", 1),
+		regFlag("LowBat", 0),
+	}},
+}
+
+func buildRegisterTable(pages ...[]RegisterDesc) map[byte]RegisterDesc {
+	table := make(map[byte]RegisterDesc)
+	for _, page := range pages {
+		for _, desc := range page {
+			table[desc.Addr] = desc
+		}
+	}
+	return table
+}
+
+var registerTables = map[RegisterPage]map[byte]RegisterDesc{
+	LoraPage: buildRegisterTable(commonRegisters, loraRegisters),
+	FskPage:  buildRegisterTable(commonRegisters, fskRegisters),
+}
+
+func LookupRegister(page RegisterPage, addr byte) (RegisterDesc, bool) {
+	desc, ok := registerTables[page][addr]
+	return desc, ok
+}
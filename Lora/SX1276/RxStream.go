@@ -0,0 +1,198 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type OverflowPolicy int
+
+const (
+	DropOldest OverflowPolicy = iota
+	DropNewest
+)
+
+const defaultStreamBuffer = 32
+
+type StreamConfig struct {
+	// BufferSize is how many packets are held while the reader is busy, on
+	// top of the one already waiting on the channel; zero means
+	// defaultStreamBuffer.
+	BufferSize int
+	Overflow   OverflowPolicy
+}
+
+// RxResult carries either a received packet or the error that prevented
+// reading one, such as a CRC failure.
+type RxResult struct {
+	Packet *Packet
+	Err    error
+}
+
+type packetRing struct {
+	buf  []RxResult
+	head int
+	size int
+}
+
+func newPacketRing(capacity int) *packetRing {
+	return &packetRing{buf: make([]RxResult, capacity)}
+}
+
+// push stores res and reports whether a result was discarded to make room.
+func (r *packetRing) push(res RxResult, policy OverflowPolicy) bool {
+	if r.size == len(r.buf) {
+		if policy == DropNewest {
+			return true
+		}
+		r.buf[r.head] = RxResult{}
+		r.head = (r.head + 1) % len(r.buf)
+		r.size--
+		r.push(res, policy)
+		return true
+	}
+	r.buf[(r.head+r.size)%len(r.buf)] = res
+	r.size++
+	return false
+}
+
+func (r *packetRing) pop() (RxResult, bool) {
+	if r.size == 0 {
+		return RxResult{}, false
+	}
+	res := r.buf[r.head]
+	r.buf[r.head] = RxResult{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.size--
+	return res, true
+}
+
+type RxStream struct {
+	C <-chan RxResult
+
+	sub    *Subscription
+	policy OverflowPolicy
+	wake   chan struct{}
+
+	mu       sync.Mutex
+	ring     *packetRing
+	received uint64
+	dropped  uint64
+}
+
+func (s *RxStream) push(ev EventData) {
+	s.mu.Lock()
+	s.received++
+	if s.ring.push(RxResult{Packet: ev.Packet, Err: ev.Err}, s.policy) {
+		s.dropped++
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *RxStream) pop() (RxResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ring.pop()
+}
+
+// Received counts packets the radio handed to the stream, dropped or not.
+func (s *RxStream) Received() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+// Dropped counts packets lost because the reader fell behind.
+func (s *RxStream) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped + s.sub.Dropped()
+}
+
+func (s *RxStream) pump(ctx context.Context, out chan<- RxResult, stop func()) {
+	defer close(out)
+	defer stop()
+	for {
+		res, ok := s.pop()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case out <- res:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReceiveStream switches the radio to continuous receive and delivers every
+// packet on the returned stream's channel until ctx is done. The channel is
+// then closed and, if the stream started the receive, the radio goes back to
+// sleep or standby as before; a radio that was already receiving is left so.
+func (gl *GoLora) ReceiveStream(ctx context.Context, cfg StreamConfig) (*RxStream, error) {
+	size := cfg.BufferSize
+	if size < 0 {
+		return nil, errors.New("stream buffer size must not be negative")
+	}
+	if size == 0 {
+		size = defaultStreamBuffer
+	}
+	stream := &RxStream{
+		policy: cfg.Overflow,
+		wake:   make(chan struct{}, 1),
+		ring:   newPacketRing(size),
+	}
+	sub, err := gl.Subscribe(OnRxDone, stream.push)
+	if err != nil {
+		return nil, err
+	}
+	stream.sub = sub
+	var started bool
+	back := Idle
+	err = gl.do(ctx, func(ctx context.Context) error {
+		if err := gl.requireUnsafe("receive packet", StateReady, StateSleeping, StateReceiving); err != nil {
+			return err
+		}
+		if gl.Mode == RxContinuous {
+			return nil
+		}
+		if gl.Mode == Sleep {
+			back = Sleep
+		}
+		if err := gl.changeModeUnsafe(RxContinuous); err != nil {
+			return err
+		}
+		started = true
+		return nil
+	})
+	if err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+
+	out := make(chan RxResult)
+	stream.C = out
+	go stream.pump(ctx, out, func() {
+		sub.Unsubscribe()
+		if !started {
+			return
+		}
+		_ = gl.do(context.Background(), func(ctx context.Context) error {
+			if gl.Mode != RxContinuous {
+				return nil
+			}
+			return gl.changeModeUnsafe(back)
+		})
+	})
+	return stream, nil
+}
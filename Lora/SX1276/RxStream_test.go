@@ -0,0 +1,157 @@
+package SX1276
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// deliver injects pkt and waits until the stream has taken it.
+func deliver(t *testing.T, fc *fakeChip, stream *RxStream, pkt []byte) {
+	t.Helper()
+	before := stream.Received()
+	fc.injectPacket(pkt)
+	assert.Eventually(t, func() bool { return stream.Received() > before }, time.Second, time.Millisecond)
+}
+
+func readStream(t *testing.T, stream *RxStream) RxResult {
+	t.Helper()
+	select {
+	case res := <-stream.C:
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("no packet on stream")
+	}
+	return RxResult{}
+}
+
+func newStreamRadio(t *testing.T) (*fakeChip, *GoLora) {
+	fc := newFakeChip()
+	conf := newAppliedLoraConf()
+	gl := markReady(NewGoLoraSX1276(fakeRadioDrv(fc), conf))
+	t.Cleanup(func() { _ = gl.Close() })
+	return fc, gl
+}
+
+func TestGoLora_ReceiveStream(t *testing.T) {
+	fc, gl := newStreamRadio(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := gl.ReceiveStream(ctx, StreamConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, StateReceiving, gl.State())
+
+	for _, payload := range []string{"one", "two", "three"} {
+		fc.injectPacket([]byte(payload))
+		res := readStream(t, stream)
+		assert.NoError(t, res.Err)
+		if assert.NotNil(t, res.Packet) {
+			assert.Equal(t, payload, string(res.Packet.Data))
+		}
+	}
+
+	fc.poke(internal.REG_IRQ_FLAGS, internal.IRQ_PAYLOAD_CRC_ERROR_MASK)
+	fc.injectPacket([]byte("bad"))
+	res := readStream(t, stream)
+	assert.EqualError(t, res.Err, "packet damaged or lost in transmit")
+
+	cancel()
+	for range stream.C {
+	}
+	assert.Eventually(t, func() bool { return gl.State() == StateReady }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(0), stream.Dropped())
+}
+
+func TestGoLora_ReceiveStream_RestoresMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode LoraMode
+		want RadioState
+	}{
+		{name: "from standby", mode: Idle, want: StateReady},
+		{name: "from sleep", mode: Sleep, want: StateSleeping},
+		{name: "already receiving", mode: RxContinuous, want: StateReceiving},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, gl := newStreamRadio(t)
+			assert.NoError(t, gl.ChangeMode(tt.mode))
+			ctx, cancel := context.WithCancel(context.Background())
+			stream, err := gl.ReceiveStream(ctx, StreamConfig{})
+			assert.NoError(t, err)
+			assert.Equal(t, StateReceiving, gl.State())
+
+			cancel()
+			// the stream is stopped by the time its channel closes
+			for range stream.C {
+			}
+			assert.Equal(t, tt.want, gl.State())
+		})
+	}
+}
+
+func TestGoLora_ReceiveStream_Overflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		want   []string
+	}{
+		// the first packet is already handed to the channel when the rest arrive
+		{name: "drop oldest", policy: DropOldest, want: []string{"p1", "p4", "p5"}},
+		{name: "drop newest", policy: DropNewest, want: []string{"p1", "p2", "p3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc, gl := newStreamRadio(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := gl.ReceiveStream(ctx, StreamConfig{BufferSize: 2, Overflow: tt.policy})
+			assert.NoError(t, err)
+
+			deliver(t, fc, stream, []byte("p1"))
+			assert.Eventually(t, func() bool {
+				stream.mu.Lock()
+				defer stream.mu.Unlock()
+				return stream.ring.size == 0
+			}, time.Second, time.Millisecond)
+			for _, payload := range []string{"p2", "p3", "p4", "p5"} {
+				deliver(t, fc, stream, []byte(payload))
+			}
+
+			var got []string
+			for range tt.want {
+				got = append(got, string(readStream(t, stream).Packet.Data))
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, uint64(2), stream.Dropped())
+		})
+	}
+}
+
+func TestGoLora_ReceiveStream_Errors(t *testing.T) {
+	gl := NewGoLoraSX1276(fakeChipDrv(newFakeChip()), newAppliedLoraConf())
+	defer gl.Close()
+	_, err := gl.ReceiveStream(context.Background(), StreamConfig{})
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = markReady(gl).ReceiveStream(context.Background(), StreamConfig{BufferSize: -1})
+	assert.EqualError(t, err, "stream buffer size must not be negative")
+}
+
+func TestPacketRing(t *testing.T) {
+	ring := newPacketRing(3)
+	for i := 1; i <= 4; i++ {
+		dropped := ring.push(RxResult{Packet: &Packet{Data: []byte{byte(i)}}}, DropOldest)
+		assert.Equal(t, i == 4, dropped)
+	}
+	var got []byte
+	for {
+		res, ok := ring.pop()
+		if !ok {
+			break
+		}
+		got = append(got, res.Packet.Data[0])
+	}
+	assert.Equal(t, []byte{2, 3, 4}, got)
+}
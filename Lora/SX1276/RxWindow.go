@@ -0,0 +1,77 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+)
+
+// ErrRxTimeout is returned by ReceiveSingle when no preamble showed up in
+// the window.
+var ErrRxTimeout = errors.New("receive window timed out")
+
+const (
+	MinSymbTimeout = 4
+	MaxSymbTimeout = 0x3ff
+)
+
+func (gl *GoLora) setSymbTimeoutUnsafe(symbols uint16) error {
+	if symbols < MinSymbTimeout || symbols > MaxSymbTimeout {
+		return fmt.Errorf("symbol timeout %d outside %d-%d", symbols, MinSymbTimeout, MaxSymbTimeout)
+	}
+	modemConfig, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_2)
+	if err != nil {
+		return err
+	}
+	if err := gl.writeRegShadow(internal.REG_MODEM_CONFIG_2, modemConfig&^0x03|byte(symbols>>8)); err != nil {
+		return err
+	}
+	return gl.writeReg(internal.REG_SYMB_TIMEOUT_LSB, byte(symbols))
+}
+
+// ReceiveSingle opens one receive window in RxSingle mode. The chip gives up
+// if it finds no preamble within symbols symbol periods, and ReceiveSingle
+// returns ErrRxTimeout; a packet whose preamble was found is received to the
+// end. The radio is left in standby.
+func (gl *GoLora) ReceiveSingle(ctx context.Context, symbols uint16) (*Packet, error) {
+	var pkt *Packet
+	err := gl.do(ctx, func(ctx context.Context) error {
+		if err := gl.requireUnsafe("receive single", StateReady, StateSleeping, StateReceiving); err != nil {
+			return err
+		}
+		if err := gl.changeModeUnsafe(Idle); err != nil {
+			return err
+		}
+		if err := gl.setSymbTimeoutUnsafe(symbols); err != nil {
+			return err
+		}
+		rxIrqs := internal.IRQ_RX_TIMEOUT_MASK | internal.IRQ_RX_DONE_MASK | internal.IRQ_PAYLOAD_CRC_ERROR_MASK | internal.IRQ_VALID_HEADER_MASK
+		if err := gl.writeReg(internal.REG_IRQ_FLAGS, rxIrqs); err != nil {
+			return err
+		}
+		if err := gl.changeModeUnsafe(RxSingle); err != nil {
+			return err
+		}
+		if err := gl.waitIrq(ctx, internal.IRQ_RX_DONE_MASK|internal.IRQ_RX_TIMEOUT_MASK); err != nil {
+			return gl.standbyOnCancel(ctx, err)
+		}
+		irq, err := gl.readReg(internal.REG_IRQ_FLAGS)
+		if err != nil {
+			return err
+		}
+		if irq&internal.IRQ_RX_DONE_MASK == 0 {
+			if err := gl.writeReg(internal.REG_IRQ_FLAGS, internal.IRQ_RX_TIMEOUT_MASK); err != nil {
+				return err
+			}
+			return errors.Join(ErrRxTimeout, gl.changeModeUnsafe(Idle))
+		}
+		pkt, err = gl.rxDoneUnsafe()
+		if err != nil {
+			return errors.Join(err, gl.changeModeUnsafe(Idle))
+		}
+		return nil
+	})
+	return pkt, err
+}
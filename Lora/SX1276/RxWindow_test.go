@@ -0,0 +1,100 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoLora_SetInvertIQ(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	fc.poke(internal.REG_INVERT_IQ, 0x27)
+	tests := []struct {
+		tx, rx  bool
+		iq, iq2 byte
+	}{
+		{tx: false, rx: true, iq: 0x67, iq2: 0x19},
+		{tx: true, rx: false, iq: 0x26, iq2: 0x19},
+		{tx: true, rx: true, iq: 0x66, iq2: 0x19},
+		{tx: false, rx: false, iq: 0x27, iq2: 0x1d},
+	}
+	for _, tt := range tests {
+		assert.NoError(t, gl.SetInvertIQ(tt.tx, tt.rx))
+		assert.Equal(t, tt.iq, fc.reg(internal.REG_INVERT_IQ), "tx %v rx %v", tt.tx, tt.rx)
+		assert.Equal(t, tt.iq2, fc.reg(internal.REG_INVERT_IQ_2), "tx %v rx %v", tt.tx, tt.rx)
+		assert.Equal(t, InvertIQ{TX: tt.tx, RX: tt.rx}, gl.GetConf().InvertIQ)
+	}
+}
+
+func TestGoLora_ReceiveSingle(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	fc.poke(internal.REG_MODEM_CONFIG_2, 0x74)
+	fc.poke(internal.REG_PKT_RSSI_VALUE, 60)
+	fc.poke(internal.REG_PKT_SNR_VALUE, 0xf0)
+	events := make(chan EventData, 1)
+	_, err := gl.Subscribe(OnRxDone, func(ev EventData) { events <- ev })
+	assert.NoError(t, err)
+
+	done := make(chan *Packet, 1)
+	go func() {
+		pkt, err := gl.ReceiveSingle(context.Background(), 0x123)
+		assert.NoError(t, err)
+		done <- pkt
+	}()
+	assert.Eventually(t, func() bool {
+		return fc.reg(internal.REG_OP_MODE) == internal.MODE_LONG_RANGE_MODE|internal.MODE_RX_SINGLE
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, byte(0x75), fc.reg(internal.REG_MODEM_CONFIG_2))
+	assert.Equal(t, byte(0x23), fc.reg(internal.REG_SYMB_TIMEOUT_LSB))
+	fc.injectPacket([]byte("downlink"))
+
+	pkt := <-done
+	if assert.NotNil(t, pkt) {
+		assert.Equal(t, []byte("downlink"), pkt.Data)
+		assert.Equal(t, -97, pkt.RSSI)
+		assert.Equal(t, -4.0, pkt.SNR)
+	}
+	assert.Equal(t, Idle, gl.Mode)
+	assert.Equal(t, StateReady, gl.State())
+	st := gl.Stats()
+	assert.Equal(t, uint64(1), st.RxPackets)
+	assert.Equal(t, uint64(1), st.RSSI.Count)
+	assert.InDelta(t, -97, st.RSSI.Sum, 0.01)
+	select {
+	case ev := <-events:
+		assert.Same(t, pkt, ev.Packet)
+		assert.NoError(t, ev.Err)
+	case <-time.After(time.Second):
+		t.Fatal("ReceiveSingle did not publish OnRxDone")
+	}
+}
+
+func TestGoLora_ReceiveSingle_Timeout(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := gl.ReceiveSingle(context.Background(), MinSymbTimeout)
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return fc.reg(internal.REG_OP_MODE) == internal.MODE_LONG_RANGE_MODE|internal.MODE_RX_SINGLE
+	}, time.Second, time.Millisecond)
+	fc.poke(internal.REG_IRQ_FLAGS, internal.IRQ_RX_TIMEOUT_MASK)
+
+	assert.ErrorIs(t, <-done, ErrRxTimeout)
+	assert.Zero(t, fc.reg(internal.REG_IRQ_FLAGS))
+	assert.Equal(t, StateReady, gl.State())
+
+	_, err := gl.ReceiveSingle(context.Background(), MaxSymbTimeout+1)
+	assert.EqualError(t, err, "symbol timeout 1024 outside 4-1023")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = gl.ReceiveSingle(ctx, 8)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, Idle, gl.Mode)
+}
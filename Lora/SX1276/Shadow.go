@@ -0,0 +1,129 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+)
+
+// shadowedRegisters are configuration registers the chip never changes on its
+// own, so their last written value can be trusted until the next reset.
+var shadowedRegisters = []byte{
+	internal.REG_FRF_MSB,
+	internal.REG_FRF_MID,
+	internal.REG_FRF_LSB,
+	internal.REG_PA_CONFIG,
+	internal.REG_LNA,
+	internal.REG_FIFO_TX_BASE_ADDR,
+	internal.REG_FIFO_RX_BASE_ADDR,
+	internal.REG_MODEM_CONFIG_1,
+	internal.REG_MODEM_CONFIG_2,
+	internal.REG_PREAMBLE_MSB,
+	internal.REG_PREAMBLE_LSB,
+	internal.REG_MODEM_CONFIG_3,
+	internal.REG_DETECTION_OPTIMIZE,
+	internal.REG_DETECTION_THRESHOLD,
+	internal.REG_SYNC_WORD,
+	internal.REG_INVERT_IQ,
+	internal.REG_INVERT_IQ_2,
+	internal.REG_DIO_MAPPING_1,
+}
+
+var isShadowed = func() [0x80]bool {
+	var table [0x80]bool
+	for _, reg := range shadowedRegisters {
+		table[reg] = true
+	}
+	return table
+}()
+
+type shadowRegs struct {
+	vals  [0x80]byte
+	valid [0x80]bool
+}
+
+func (sr *shadowRegs) get(reg byte) (byte, bool) {
+	reg &= 0x7f
+	return sr.vals[reg], sr.valid[reg]
+}
+
+func (sr *shadowRegs) set(reg byte, val byte) {
+	reg &= 0x7f
+	if !isShadowed[reg] {
+		return
+	}
+	sr.vals[reg] = val
+	sr.valid[reg] = true
+}
+
+func (sr *shadowRegs) invalidate(reg byte) {
+	sr.valid[reg&0x7f] = false
+}
+
+func (sr *shadowRegs) invalidateAll() {
+	sr.valid = [0x80]bool{}
+}
+
+func (gl *GoLora) readRegShadow(reg byte) (byte, error) {
+	if val, ok := gl.shadow.get(reg); ok {
+		return val, nil
+	}
+	val, err := gl.readReg(reg)
+	if err != nil {
+		return 0, err
+	}
+	gl.shadow.set(reg, val)
+	return val, nil
+}
+
+func (gl *GoLora) writeRegShadow(reg byte, value byte) error {
+	if val, ok := gl.shadow.get(reg); ok && val == value {
+		return nil
+	}
+	return gl.writeReg(reg, value)
+}
+
+func (gl *GoLora) syncShadowUnsafe() error {
+	gl.shadow.invalidateAll()
+	for _, reg := range shadowedRegisters {
+		if _, err := gl.readRegShadow(reg); err != nil {
+			return fmt.Errorf("failed to sync register 0x%02X: %w", reg, err)
+		}
+	}
+	return nil
+}
+
+func (gl *GoLora) verifyShadowUnsafe(regs []byte) error {
+	var errs []error
+	for _, reg := range regs {
+		want, ok := gl.shadow.get(reg)
+		if !ok {
+			continue
+		}
+		got, err := gl.readReg(reg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read back register 0x%02X: %w", reg, err))
+			continue
+		}
+		if got != want {
+			gl.shadow.set(reg, got)
+			errs = append(errs, fmt.Errorf("register 0x%02X readback mismatch: wrote 0x%02X, read 0x%02X", reg, want, got))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (gl *GoLora) InvalidateShadow() {
+	_ = gl.do(context.Background(), func(ctx context.Context) error {
+		gl.shadow.invalidateAll()
+		return nil
+	})
+}
+
+func (gl *GoLora) SyncShadow() error {
+	return gl.do(context.Background(), func(ctx context.Context) error {
+		return gl.syncShadowUnsafe()
+	})
+}
@@ -0,0 +1,84 @@
+package SX1276
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoLora_Shadow_SkipsRoundTrips(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+
+	assert.NoError(t, gl.SetSF(9))
+	assert.Equal(t, 1, fc.readCount(internal.REG_MODEM_CONFIG_2))
+	assert.Equal(t, 1, fc.writeCount(internal.REG_MODEM_CONFIG_2))
+
+	fc.resetCounters()
+	assert.NoError(t, gl.SetSF(9))
+	assert.NoError(t, gl.SetCrc(true))
+	assert.Equal(t, 0, fc.readCount(internal.REG_MODEM_CONFIG_2))
+	assert.Equal(t, 1, fc.writeCount(internal.REG_MODEM_CONFIG_2))
+	assert.Equal(t, byte(0x94), fc.reg(internal.REG_MODEM_CONFIG_2))
+}
+
+func TestGoLora_Shadow_FrequencyHopping(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+	assert.NoError(t, gl.SetFrequency(868100000))
+
+	fc.resetCounters()
+	assert.NoError(t, gl.SetFrequency(868300000))
+	assert.Equal(t, 0, fc.writeCount(internal.REG_FRF_MSB))
+	assert.Equal(t, 1, fc.writeCount(internal.REG_FRF_MID))
+	assert.Equal(t, 1, fc.writeCount(internal.REG_FRF_LSB))
+}
+
+func TestGoLora_Shadow_InvalidateAndSync(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+	assert.NoError(t, gl.SetSyncWord(0x34))
+
+	fc.poke(internal.REG_SYNC_WORD, 0x12)
+	assert.NoError(t, gl.SetSyncWord(0x34))
+	assert.Equal(t, byte(0x12), fc.reg(internal.REG_SYNC_WORD), "stale shadow should skip the write")
+
+	gl.InvalidateShadow()
+	assert.NoError(t, gl.SetSyncWord(0x34))
+	assert.Equal(t, byte(0x34), fc.reg(internal.REG_SYNC_WORD))
+
+	fc.poke(internal.REG_MODEM_CONFIG_1, 0x72)
+	assert.NoError(t, gl.SyncShadow())
+	fc.resetCounters()
+	assert.NoError(t, gl.SetHeader(Implicit))
+	assert.Equal(t, 0, fc.readCount(internal.REG_MODEM_CONFIG_1))
+	assert.Equal(t, byte(0x73), fc.reg(internal.REG_MODEM_CONFIG_1))
+}
+
+func TestGoLora_Shadow_ResetInvalidates(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+	assert.NoError(t, gl.SetCodingRate(5))
+
+	assert.NoError(t, gl.Reset())
+	markReady(gl)
+	fc.resetCounters()
+	assert.NoError(t, gl.SetCodingRate(5))
+	assert.Equal(t, 1, fc.readCount(internal.REG_MODEM_CONFIG_1))
+}
+
+func TestGoLora_Shadow_FailedWriteInvalidates(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+	assert.NoError(t, gl.SetSyncWord(0x12))
+
+	fc.writeErrs[internal.REG_SYNC_WORD] = errors.New("sync word write err")
+	assert.Error(t, gl.SetSyncWord(0x34))
+	delete(fc.writeErrs, internal.REG_SYNC_WORD)
+
+	fc.resetCounters()
+	assert.NoError(t, gl.SetSyncWord(0x12))
+	assert.Equal(t, 1, fc.writeCount(internal.REG_SYNC_WORD))
+}
@@ -0,0 +1,173 @@
+package SX1276
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+)
+
+const SnapshotVersion = 1
+
+type SnapshotReg struct {
+	Addr  RegAddr `json:"addr"`
+	Name  string  `json:"name,omitempty"`
+	Value byte    `json:"value"`
+}
+
+type RegisterSnapshot struct {
+	Version   int           `json:"version"`
+	TakenAt   time.Time     `json:"takenAt"`
+	Registers []SnapshotReg `json:"registers"`
+}
+
+func (a *RegAddr) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	val, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 8)
+	if err != nil {
+		return fmt.Errorf("invalid register address %q", s)
+	}
+	*a = RegAddr(val)
+	return nil
+}
+
+func NewRegisterSnapshot(dump []RegVal) RegisterSnapshot {
+	page := DumpPage(dump)
+	snap := RegisterSnapshot{
+		Version:   SnapshotVersion,
+		TakenAt:   time.Now().UTC(),
+		Registers: make([]SnapshotReg, len(dump)),
+	}
+	for idx, rv := range dump {
+		desc, _ := LookupRegister(page, rv.Reg)
+		snap.Registers[idx] = SnapshotReg{Addr: RegAddr(rv.Reg), Name: desc.Name, Value: rv.Val}
+	}
+	return snap
+}
+
+func (s RegisterSnapshot) RegVals() []RegVal {
+	regs := make([]RegVal, len(s.Registers))
+	for idx, reg := range s.Registers {
+		regs[idx] = RegVal{Reg: byte(reg.Addr), Val: reg.Value}
+	}
+	return regs
+}
+
+func (gl *GoLora) Snapshot() (RegisterSnapshot, error) {
+	dump, err := gl.DumpRegisters()
+	if err != nil {
+		return RegisterSnapshot{}, err
+	}
+	return NewRegisterSnapshot(dump), nil
+}
+
+func SaveSnapshot(path string, snap RegisterSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func LoadSnapshot(path string) (RegisterSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RegisterSnapshot{}, err
+	}
+	var snap RegisterSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return RegisterSnapshot{}, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	if snap.Version != SnapshotVersion {
+		return RegisterSnapshot{}, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return snap, nil
+}
+
+// restorableMode maps a snapshot's operating mode to the one it is safe to
+// resume in; transient modes such as TX would replay whatever is in the FIFO.
+func restorableMode(opMode byte) byte {
+	switch opMode & 0x07 {
+	case internal.MODE_SLEEP, internal.MODE_STDBY, internal.MODE_RX_CONTINUOUS:
+		return opMode & 0x87
+	default:
+		return opMode&0x80 | internal.MODE_STDBY
+	}
+}
+
+func (gl *GoLora) restoreSnapshotUnsafe(snap RegisterSnapshot) error {
+	regs := snap.RegVals()
+	page := DumpPage(regs)
+	opMode := byte(internal.MODE_LONG_RANGE_MODE | internal.MODE_STDBY)
+	hasOpMode := false
+	for _, rv := range regs {
+		switch rv.Reg {
+		case internal.REG_OP_MODE:
+			opMode = rv.Val
+			hasOpMode = true
+		case internal.REG_VERSION:
+			version, err := gl.readReg(internal.REG_VERSION)
+			if err != nil {
+				return err
+			}
+			if version != rv.Val {
+				return fmt.Errorf("snapshot taken from chip version 0x%02X, got 0x%02X", rv.Val, version)
+			}
+		}
+	}
+	if !hasOpMode {
+		return errors.New("snapshot has no RegOpMode entry")
+	}
+
+	if err := gl.writeReg(internal.REG_OP_MODE, opMode&0x80|internal.MODE_SLEEP); err != nil {
+		return fmt.Errorf("failed to set sleep mode: %w", err)
+	}
+	gl.setModeUnsafe(Sleep)
+	gl.shadow.invalidateAll()
+	for _, rv := range regs {
+		if rv.Reg == internal.REG_FIFO || rv.Reg == internal.REG_OP_MODE {
+			continue
+		}
+		desc, ok := LookupRegister(page, rv.Reg)
+		if !ok || desc.Access != RegRW {
+			continue
+		}
+		if err := gl.writeReg(rv.Reg, rv.Val); err != nil {
+			return fmt.Errorf("failed to restore %s (0x%02X): %w", desc.Name, rv.Reg, err)
+		}
+	}
+
+	mode := restorableMode(opMode)
+	if err := gl.writeReg(internal.REG_OP_MODE, mode); err != nil {
+		return fmt.Errorf("failed to restore mode: %w", err)
+	}
+	gl.setModeUnsafe(LoraMode(mode & 0x07))
+	if page != LoraPage {
+		gl.setStateUnsafe(StateUninitialised)
+		return nil
+	}
+	conf, err := gl.readConfFromChipUnsafe()
+	if err != nil {
+		return err
+	}
+	gl.Conf = conf
+	gl.setStateUnsafe(stateForMode(gl.Mode))
+	return nil
+}
+
+func (gl *GoLora) RestoreSnapshot(snap RegisterSnapshot) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return gl.doConf("restore snapshot", func() error {
+		return gl.restoreSnapshotUnsafe(snap)
+	})
+}
@@ -0,0 +1,85 @@
+package SX1276
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoLora_SnapshotRoundTrip(t *testing.T) {
+	src := newFakeChip()
+	conf := newAppliedLoraConf()
+	conf.SF = 10
+	conf.SyncWord = 0x34
+	srcGl := markReady(NewGoLoraSX1276(fakeChipDrv(src), conf))
+	assert.NoError(t, srcGl.Begin())
+	assert.NoError(t, srcGl.ChangeMode(RxContinuous))
+	src.poke(internal.REG_IRQ_FLAGS, 0x48)
+	src.poke(internal.REG_PA_DAC, 0x87)
+
+	snap, err := srcGl.Snapshot()
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "radio.json")
+	assert.NoError(t, SaveSnapshot(path, snap))
+
+	loaded, err := LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, snap.RegVals(), loaded.RegVals())
+
+	dst := newFakeChip()
+	dstGl := markReady(NewGoLoraSX1276(fakeChipDrv(dst), newDefLoraConf()))
+	assert.NoError(t, dstGl.RestoreSnapshot(loaded))
+
+	for _, reg := range []byte{internal.REG_MODEM_CONFIG_1, internal.REG_MODEM_CONFIG_2, internal.REG_SYNC_WORD, internal.REG_FRF_MSB, internal.REG_PA_DAC} {
+		assert.Equal(t, src.reg(reg), dst.reg(reg), "register 0x%02X", reg)
+	}
+	assert.Equal(t, 0, dst.writeCount(internal.REG_IRQ_FLAGS))
+	assert.Equal(t, 0, dst.writeCount(internal.REG_VERSION))
+	assert.Equal(t, 0, dst.writeCount(internal.REG_FIFO))
+
+	modes := dst.writesTo(internal.REG_OP_MODE)
+	assert.Equal(t, []byte{0x80, 0x85}, modes)
+	assert.Equal(t, internal.REG_OP_MODE, dst.writeLog[len(dst.writeLog)-1].Reg)
+	assert.Equal(t, RxContinuous, dstGl.Mode)
+	assert.Empty(t, Diff(srcGl.GetConf(), dstGl.GetConf()))
+}
+
+func TestGoLora_RestoreSnapshot_TransientModeBecomesStandby(t *testing.T) {
+	snap := NewRegisterSnapshot([]RegVal{{Reg: internal.REG_OP_MODE, Val: 0x83}})
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+	assert.NoError(t, gl.RestoreSnapshot(snap))
+	assert.Equal(t, []byte{0x80, 0x81}, fc.writesTo(internal.REG_OP_MODE))
+	assert.Equal(t, Idle, gl.Mode)
+}
+
+func TestGoLora_RestoreSnapshot_Rejects(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newDefLoraConf()))
+
+	t.Run("it Should reject other chip versions", func(t *testing.T) {
+		snap := NewRegisterSnapshot([]RegVal{{Reg: internal.REG_OP_MODE, Val: 0x81}, {Reg: internal.REG_VERSION, Val: 0x22}})
+		assert.EqualError(t, gl.RestoreSnapshot(snap), "snapshot taken from chip version 0x22, got 0x12")
+	})
+
+	t.Run("it Should reject unknown snapshot versions", func(t *testing.T) {
+		snap := NewRegisterSnapshot(nil)
+		snap.Version = 99
+		assert.EqualError(t, gl.RestoreSnapshot(snap), "unsupported snapshot version 99")
+
+		path := filepath.Join(t.TempDir(), "radio.json")
+		assert.NoError(t, SaveSnapshot(path, snap))
+		_, err := LoadSnapshot(path)
+		assert.EqualError(t, err, "unsupported snapshot version 99")
+	})
+
+	t.Run("it Should reject malformed files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "radio.json")
+		assert.NoError(t, os.WriteFile(path, []byte(`{"version":1,"registers":[{"addr":"zz","value":1}]}`), 0o644))
+		_, err := LoadSnapshot(path)
+		assert.ErrorContains(t, err, `invalid register address "zz"`)
+	})
+}
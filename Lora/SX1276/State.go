@@ -0,0 +1,102 @@
+package SX1276
+
+import (
+	"errors"
+	"fmt"
+)
+
+type RadioState int32
+
+const (
+	StateUninitialised RadioState = iota
+	StateReady
+	StateSleeping
+	StateTransmitting
+	StateReceiving
+	StateClosed
+)
+
+func (s RadioState) String() string {
+	switch s {
+	case StateUninitialised:
+		return "uninitialised"
+	case StateReady:
+		return "ready"
+	case StateSleeping:
+		return "sleeping"
+	case StateTransmitting:
+		return "transmitting"
+	case StateReceiving:
+		return "receiving"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("RadioState(%d)", int32(s))
+}
+
+type StateChange struct {
+	From RadioState
+	To   RadioState
+}
+
+var ErrInvalidState = errors.New("invalid radio state")
+
+type StateError struct {
+	Op    string
+	State RadioState
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("cannot %s while radio is %s", e.Op, e.State)
+}
+
+func (e *StateError) Is(target error) bool {
+	return target == ErrInvalidState
+}
+
+func stateForMode(mode LoraMode) RadioState {
+	switch mode {
+	case Sleep:
+		return StateSleeping
+	case Tx:
+		return StateTransmitting
+	case RxContinuous, RxSingle:
+		return StateReceiving
+	}
+	return StateReady
+}
+
+// State can be read from any goroutine; it is only written by the owner.
+func (gl *GoLora) State() RadioState {
+	return RadioState(gl.state.Load())
+}
+
+func (gl *GoLora) setStateUnsafe(state RadioState) {
+	prev := RadioState(gl.state.Swap(int32(state)))
+	if prev == state {
+		return
+	}
+	gl.publish(EventData{Event: OnStateChanged, State: StateChange{From: prev, To: state}})
+}
+
+// requireUnsafe fails op unless the radio is in one of the allowed states.
+func (gl *GoLora) requireUnsafe(op string, allowed ...RadioState) error {
+	state := gl.State()
+	for _, s := range allowed {
+		if s == state {
+			return nil
+		}
+	}
+	return &StateError{Op: op, State: state}
+}
+
+// rejectUnsafe fails op if the radio is in any of the given states.
+func (gl *GoLora) rejectUnsafe(op string, rejected ...RadioState) error {
+	state := gl.State()
+	for _, s := range rejected {
+		if s == state {
+			return &StateError{Op: op, State: state}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package SX1276
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+type stateRecorder struct {
+	mu      sync.Mutex
+	changes []StateChange
+}
+
+func (sr *stateRecorder) record(ev EventData) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.changes = append(sr.changes, ev.State)
+}
+
+func (sr *stateRecorder) waitFor(t *testing.T, want []StateChange) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		sr.mu.Lock()
+		defer sr.mu.Unlock()
+		return len(sr.changes) >= len(want)
+	}, time.Second, time.Millisecond)
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	assert.Equal(t, want, sr.changes)
+}
+
+func TestGoLora_State_Begin(t *testing.T) {
+	fc := newFakeChip()
+	gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+	assert.Equal(t, StateUninitialised, gl.State())
+
+	err := gl.SendPacket(context.Background(), []byte("early"))
+	assert.ErrorIs(t, err, ErrInvalidState)
+	assert.EqualError(t, err, "cannot send packet while radio is uninitialised")
+	_, err = gl.ReceivePacket()
+	assert.ErrorIs(t, err, ErrInvalidState)
+	assert.ErrorIs(t, gl.ChangeMode(RxContinuous), ErrInvalidState)
+	err = gl.SetSF(9)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	assert.EqualError(t, err, "cannot set spreading factor while radio is uninitialised")
+	assert.ErrorIs(t, gl.ApplyConfig(newAppliedLoraConf()), ErrInvalidState)
+	assert.Empty(t, fc.writeLog, "nothing may reach the bus before Begin")
+
+	assert.NoError(t, gl.Begin())
+	assert.Equal(t, StateReady, gl.State())
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("hello")))
+}
+
+func TestGoLora_State_ResumesReceiveAfterTx(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+	rec := &stateRecorder{}
+	sub, err := gl.Subscribe(OnStateChanged, rec.record)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	assert.NoError(t, gl.ChangeMode(RxContinuous))
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("hello")))
+	assert.Equal(t, StateReceiving, gl.State())
+	assert.Equal(t, internal.MODE_RX_CONTINUOUS, fc.reg(internal.REG_OP_MODE)&0x07)
+	rec.waitFor(t, []StateChange{
+		{From: StateReady, To: StateReceiving},
+		{From: StateReceiving, To: StateReady},
+		{From: StateReady, To: StateTransmitting},
+		{From: StateTransmitting, To: StateReady},
+		{From: StateReady, To: StateReceiving},
+	})
+}
+
+func TestGoLora_State_RejectsConfigWhileTransmitting(t *testing.T) {
+	fc := newFakeChip()
+	fc.txHang = true
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+
+	assert.NoError(t, gl.ChangeMode(Tx))
+	assert.Equal(t, StateTransmitting, gl.State())
+	err := gl.SetSF(12)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	assert.EqualError(t, err, "cannot set spreading factor while radio is transmitting")
+	assert.ErrorIs(t, gl.ApplyConfig(newAppliedLoraConf()), ErrInvalidState)
+	assert.ErrorIs(t, gl.SendPacket(context.Background(), []byte("x")), ErrInvalidState)
+	assert.NotEqual(t, uint8(12), gl.GetConf().SF)
+}
+
+func TestGoLora_State_Close(t *testing.T) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+	rec := &stateRecorder{}
+	_, err := gl.Subscribe(OnStateChanged, rec.record)
+	assert.NoError(t, err)
+
+	assert.NoError(t, gl.Close())
+	assert.NoError(t, gl.Close())
+	assert.NoError(t, gl.Destroy())
+	assert.Equal(t, StateClosed, gl.State())
+	assert.Equal(t, internal.MODE_SLEEP, fc.reg(internal.REG_OP_MODE)&0x07)
+	assert.ErrorIs(t, gl.SetSF(9), ErrClosed)
+	rec.waitFor(t, []StateChange{
+		{From: StateReady, To: StateSleeping},
+		{From: StateSleeping, To: StateClosed},
+	})
+}
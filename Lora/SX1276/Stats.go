@@ -0,0 +1,202 @@
+package SX1276
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsWindow = 256
+
+// Bucket upper bounds of the RSSI (dBm) and SNR (dB) histograms.
+var (
+	RssiBuckets = []float64{-130, -120, -110, -100, -90, -80, -70, -60, -50}
+	SnrBuckets  = []float64{-20, -15, -10, -5, 0, 5, 10}
+)
+
+func (m LoraMode) String() string {
+	switch m {
+	case Sleep:
+		return "Sleep"
+	case Idle:
+		return "Idle"
+	case Tx:
+		return "Tx"
+	case RxSingle:
+		return "RxSingle"
+	case RxContinuous:
+		return "RxContinuous"
+	}
+	return fmt.Sprintf("LoraMode(%d)", byte(m))
+}
+
+// Histogram counts samples per bucket: Counts[i] holds samples at most
+// Bounds[i] and above Bounds[i-1]; the last count is everything above the
+// last bound.
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+func newHistogram(bounds []float64, samples []float64) Histogram {
+	h := Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+	for _, v := range samples {
+		h.observe(v)
+	}
+	return h
+}
+
+func (h *Histogram) observe(v float64) {
+	h.Counts[sort.SearchFloat64s(h.Bounds, v)]++
+	h.Count++
+	h.Sum += v
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// RadioStats is a snapshot of the counters since the radio was created or
+// the stats were last reset. RSSI and SNR only cover the last 256 packets;
+// RSSITotal and SNRTotal count every packet since the radio was created and
+// never go down, not even on ResetStats.
+type RadioStats struct {
+	Since          time.Time
+	TxAttempts     uint64
+	TxSuccess      uint64
+	TxTimeouts     uint64
+	RxPackets      uint64
+	RxCrcErrors    uint64
+	RxHeaderErrors uint64
+	OnAir          time.Duration
+	ModeTime       map[LoraMode]time.Duration
+	RSSI           Histogram
+	SNR            Histogram
+	RSSITotal      Histogram
+	SNRTotal       Histogram
+}
+
+func emptyRadioStats(now time.Time) RadioStats {
+	return RadioStats{
+		Since:     now,
+		ModeTime:  map[LoraMode]time.Duration{},
+		RSSITotal: newHistogram(RssiBuckets, nil),
+		SNRTotal:  newHistogram(SnrBuckets, nil),
+	}
+}
+
+// radioStats is written by the owner goroutine and read from anywhere.
+type radioStats struct {
+	mu       sync.Mutex
+	cur      RadioStats
+	mode     LoraMode
+	modeFrom time.Time
+	rssi     []float64
+	snr      []float64
+}
+
+func newRadioStats(mode LoraMode) *radioStats {
+	now := time.Now()
+	return &radioStats{
+		cur:      emptyRadioStats(now),
+		mode:     mode,
+		modeFrom: now,
+	}
+}
+
+func (s *radioStats) update(fn func(st *RadioStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.cur)
+}
+
+func (s *radioStats) enterMode(mode LoraMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.cur.ModeTime[s.mode] += now.Sub(s.modeFrom)
+	s.mode, s.modeFrom = mode, now
+}
+
+func pushWindow(window []float64, v float64) []float64 {
+	window = append(window, v)
+	if len(window) > statsWindow {
+		window = window[len(window)-statsWindow:]
+	}
+	return window
+}
+
+func (s *radioStats) packet(pkt *Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur.RxPackets++
+	s.cur.RSSITotal.observe(float64(pkt.RSSI))
+	s.cur.SNRTotal.observe(pkt.SNR)
+	s.rssi = pushWindow(s.rssi, float64(pkt.RSSI))
+	s.snr = pushWindow(s.snr, pkt.SNR)
+}
+
+func (s *radioStats) snapshot() RadioStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.cur
+	st.ModeTime = make(map[LoraMode]time.Duration, len(s.cur.ModeTime)+1)
+	for mode, d := range s.cur.ModeTime {
+		st.ModeTime[mode] = d
+	}
+	st.ModeTime[s.mode] += time.Since(s.modeFrom)
+	st.RSSI = newHistogram(RssiBuckets, s.rssi)
+	st.SNR = newHistogram(SnrBuckets, s.snr)
+	st.RSSITotal = s.cur.RSSITotal.clone()
+	st.SNRTotal = s.cur.SNRTotal.clone()
+	return st
+}
+
+func (s *radioStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	rssi, snr := s.cur.RSSITotal, s.cur.SNRTotal
+	s.cur = emptyRadioStats(now)
+	s.cur.RSSITotal, s.cur.SNRTotal = rssi, snr
+	s.modeFrom = now
+	s.rssi, s.snr = nil, nil
+}
+
+// Stats can be called from any goroutine; it does not wait for the radio.
+func (gl *GoLora) Stats() RadioStats {
+	return gl.stats.snapshot()
+}
+
+func (gl *GoLora) ResetStats() {
+	gl.stats.reset()
+}
+
+// setModeUnsafe records a mode the chip has entered.
+func (gl *GoLora) setModeUnsafe(mode LoraMode) {
+	gl.Mode = mode
+	gl.stats.enterMode(mode)
+}
+
+func (gl *GoLora) txSucceededUnsafe(conf LoraConf, payloadLength int) {
+	onAir := airtime(conf, uint16(payloadLength))
+	gl.stats.update(func(st *RadioStats) {
+		st.TxSuccess++
+		st.OnAir += onAir
+	})
+}
+
+func (gl *GoLora) countRxErrorUnsafe(err error) {
+	gl.stats.update(func(st *RadioStats) {
+		switch err {
+		case ErrCrc:
+			st.RxCrcErrors++
+		case ErrHeader:
+			st.RxHeaderErrors++
+		}
+	})
+}
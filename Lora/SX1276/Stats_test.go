@@ -0,0 +1,121 @@
+package SX1276
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoLora_Stats_Tx(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("hello")))
+	assert.NoError(t, gl.SendPacket(context.Background(), []byte("world!")))
+
+	fc.txHang = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, gl.SendPacket(ctx, []byte("x")), context.DeadlineExceeded)
+
+	st := gl.Stats()
+	assert.Equal(t, uint64(3), st.TxAttempts)
+	assert.Equal(t, uint64(2), st.TxSuccess)
+	assert.Equal(t, uint64(1), st.TxTimeouts)
+	assert.Equal(t, gl.GetAirtime(5)+gl.GetAirtime(6), st.OnAir)
+	assert.Greater(t, st.ModeTime[Tx], time.Duration(0))
+	assert.Greater(t, st.ModeTime[Idle], time.Duration(0))
+
+	gl.ResetStats()
+	st = gl.Stats()
+	assert.Zero(t, st.TxAttempts)
+	assert.Zero(t, st.OnAir)
+	assert.Zero(t, st.ModeTime[Tx])
+}
+
+func TestGoLora_Stats_Rx(t *testing.T) {
+	fc, gl := newStreamRadio(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := gl.ReceiveStream(ctx, StreamConfig{})
+	assert.NoError(t, err)
+
+	fc.poke(internal.REG_PKT_RSSI_VALUE, 60)
+	fc.poke(internal.REG_PKT_SNR_VALUE, 28)
+	deliver(t, fc, stream, []byte("one"))
+	fc.poke(internal.REG_PKT_SNR_VALUE, 0xf0)
+	deliver(t, fc, stream, []byte("two"))
+
+	fc.poke(internal.REG_IRQ_FLAGS, internal.IRQ_PAYLOAD_CRC_ERROR_MASK)
+	deliver(t, fc, stream, []byte("crc"))
+	fc.mu.Lock()
+	fc.regs[internal.REG_IRQ_FLAGS] = internal.IRQ_RX_DONE_MASK
+	fc.mu.Unlock()
+	assert.Eventually(t, func() bool { return gl.Stats().RxHeaderErrors == 1 }, time.Second, time.Millisecond)
+
+	st := gl.Stats()
+	assert.Equal(t, uint64(2), st.RxPackets)
+	assert.Equal(t, uint64(1), st.RxCrcErrors)
+	assert.Greater(t, st.ModeTime[RxContinuous], time.Duration(0))
+
+	// RSSI 60-157 = -97 dBm twice; SNR 7 dB and -4 dB
+	assert.Equal(t, uint64(2), st.RSSI.Count)
+	assert.Equal(t, uint64(2), st.RSSI.Counts[4])
+	assert.InDelta(t, -194, st.RSSI.Sum, 0.01)
+	assert.Equal(t, []uint64{0, 0, 0, 0, 1, 0, 1, 0}, st.SNR.Counts)
+	assert.InDelta(t, 3, st.SNR.Sum, 0.01)
+	assert.Equal(t, st.RSSI, st.RSSITotal)
+	assert.Equal(t, st.SNR, st.SNRTotal)
+
+	// the totals keep counting once the window has rolled over
+	for i := 0; i < statsWindow; i++ {
+		gl.stats.packet(&Packet{RSSI: -125, SNR: -12})
+	}
+	st = gl.Stats()
+	assert.Equal(t, uint64(statsWindow), st.RSSI.Count)
+	assert.Equal(t, uint64(statsWindow+2), st.RSSITotal.Count)
+	assert.Equal(t, uint64(2), st.RSSITotal.Counts[4])
+	assert.Equal(t, uint64(statsWindow), st.RSSITotal.Counts[1])
+	assert.Equal(t, uint64(statsWindow), st.SNRTotal.Counts[2])
+
+	gl.ResetStats()
+	st = gl.Stats()
+	assert.Zero(t, st.RxPackets)
+	assert.Zero(t, st.RSSI.Count)
+	assert.Equal(t, uint64(statsWindow+2), st.RSSITotal.Count)
+	assert.Equal(t, uint64(statsWindow+2), st.SNRTotal.Count)
+}
+
+func TestGoLora_Stats_Concurrent(t *testing.T) {
+	_, gl := newQueueRadio(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_ = gl.SendPacket(context.Background(), []byte{byte(j)})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				st := gl.Stats()
+				st.ModeTime[Tx] = 0
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(80), gl.Stats().TxSuccess)
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{0, 10}, []float64{-5, 0, 5, 10, 15})
+	assert.Equal(t, []uint64{2, 2, 1}, h.Counts)
+	assert.Equal(t, uint64(5), h.Count)
+	assert.Equal(t, 25.0, h.Sum)
+	assert.Equal(t, "RxContinuous", RxContinuous.String())
+	assert.Equal(t, "LoraMode(7)", LoraMode(7).String())
+}
@@ -0,0 +1,53 @@
+package SX1276
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SyncWordPrivate is the chip's reset value, used by private networks.
+	SyncWordPrivate uint8 = 0x12
+	// SyncWordPublic is the LoRaWAN public network sync word.
+	SyncWordPublic uint8 = 0x34
+)
+
+var syncWordPresets = map[string]uint8{
+	"private": SyncWordPrivate,
+	"public":  SyncWordPublic,
+	"lorawan": SyncWordPublic,
+}
+
+// ParseSyncWord accepts a preset name ("private", "public" or "lorawan") or
+// a number such as "0x12".
+func ParseSyncWord(s string) (uint8, error) {
+	if sw, ok := syncWordPresets[strings.ToLower(s)]; ok {
+		return sw, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown sync word %q", s)
+	}
+	return uint8(v), nil
+}
+
+// SyncWordWarning describes a sync word that the chip accepts but that is
+// known to misbehave.
+type SyncWordWarning struct {
+	SyncWord uint8
+	Reason   string
+}
+
+func (w *SyncWordWarning) Error() string {
+	return fmt.Sprintf("sync word 0x%02X: %s", w.SyncWord, w.Reason)
+}
+
+// CheckSyncWord warns about sync words known to misbehave. The sync word is
+// matched nibble by nibble, and a zero nibble weakens preamble detection.
+func CheckSyncWord(sw uint8) error {
+	if sw>>4 == 0 || sw&0x0f == 0 {
+		return &SyncWordWarning{SyncWord: sw, Reason: "a zero nibble gives unreliable preamble detection"}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package SX1276
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyncWord(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint8
+		wantErr string
+	}{
+		{in: "private", want: SyncWordPrivate},
+		{in: "Public", want: SyncWordPublic},
+		{in: "lorawan", want: 0x34},
+		{in: "0x2b", want: 0x2b},
+		{in: "18", want: 0x12},
+		{in: "0x100", wantErr: `unknown sync word "0x100"`},
+		{in: "ttn", wantErr: `unknown sync word "ttn"`},
+	}
+	for _, tt := range tests {
+		got, err := ParseSyncWord(tt.in)
+		if tt.wantErr != "" {
+			assert.EqualError(t, err, tt.wantErr)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestCheckSyncWord(t *testing.T) {
+	tests := []struct {
+		sw      uint8
+		warning string
+	}{
+		{sw: SyncWordPrivate},
+		{sw: SyncWordPublic},
+		{sw: 0x2b},
+		{sw: 0x10, warning: "sync word 0x10: a zero nibble gives unreliable preamble detection"},
+		{sw: 0xf0, warning: "sync word 0xF0: a zero nibble gives unreliable preamble detection"},
+	}
+	for _, tt := range tests {
+		err := CheckSyncWord(tt.sw)
+		if tt.warning == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		var w *SyncWordWarning
+		assert.ErrorAs(t, err, &w)
+		assert.EqualError(t, err, tt.warning)
+	}
+}
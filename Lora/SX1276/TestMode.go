@@ -0,0 +1,204 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"periph.io/x/conn/v3/physic"
+)
+
+// TestSignal is what RunTestMode puts on the air.
+type TestSignal int
+
+const (
+	// TestModulated repeats Payload back to back using the LoRa
+	// TxContinuousMode, at the configured SF, bandwidth and coding rate.
+	TestModulated TestSignal = iota
+	// TestCarrier is an unmodulated carrier (CW), sent by the FSK modem with
+	// a frequency deviation of zero.
+	TestCarrier
+)
+
+func (s TestSignal) String() string {
+	switch s {
+	case TestModulated:
+		return "modulated"
+	case TestCarrier:
+		return "carrier"
+	}
+	return fmt.Sprintf("TestSignal(%d)", int(s))
+}
+
+// TestModeConfig describes a pre-compliance test transmission. A zero
+// Frequency or TxPower keeps the configured one, a zero Duration transmits
+// until the context is cancelled and an empty Payload sends a 0x55 pattern.
+type TestModeConfig struct {
+	Signal    TestSignal
+	Frequency physic.Frequency
+	TxPower   uint8
+	Duration  time.Duration
+	Payload   []byte
+}
+
+var defaultTestPayload = []byte{
+	0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55,
+	0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55,
+}
+
+func (c TestModeConfig) validate() error {
+	var errs []error
+	if c.Signal != TestModulated && c.Signal != TestCarrier {
+		errs = append(errs, fmt.Errorf("unknown test signal %v", c.Signal))
+	}
+	if c.Duration < 0 {
+		errs = append(errs, errors.New("test duration must not be negative"))
+	}
+	if len(c.Payload) > 255 {
+		errs = append(errs, fmt.Errorf("test payload of %d bytes does not fit the FIFO", len(c.Payload)))
+	}
+	return errors.Join(errs...)
+}
+
+// RunTestMode transmits without a break for CE/FCC pre-compliance
+// measurements. It holds the radio for the whole test, ignores the duty-cycle
+// limiter and, however the test ends, leaves the radio in standby with the
+// configuration it had before. Cancelling ctx ends the test early and returns
+// ctx's error, except when Duration is zero: then cancelling is how the test
+// is stopped and RunTestMode returns nil.
+func (gl *GoLora) RunTestMode(ctx context.Context, cfg TestModeConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	return gl.do(ctx, func(ctx context.Context) (err error) {
+		if err := gl.requireUnsafe("run test mode", StateReady, StateSleeping); err != nil {
+			return err
+		}
+		prevConf := gl.Conf
+		snapshot, err := gl.readRegMany(confRegisters)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot registers: %w", err)
+		}
+		carrier := cfg.Signal == TestCarrier
+		defer func() {
+			if rErr := gl.endTestModeUnsafe(carrier, snapshot, prevConf); rErr != nil {
+				err = errors.Join(err, rErr)
+			}
+		}()
+
+		if carrier {
+			err = gl.startCarrierUnsafe(cfg)
+		} else {
+			err = gl.startModulatedUnsafe(cfg)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to start %v test: %w", cfg.Signal, err)
+		}
+		if cfg.Duration == 0 {
+			<-ctx.Done()
+			return nil
+		}
+		return sleepCtx(ctx, cfg.Duration)
+	})
+}
+
+func (gl *GoLora) setTestChannelUnsafe(cfg TestModeConfig) error {
+	if cfg.Frequency != 0 {
+		if err := gl.setFrequencyUnsafe(cfg.Frequency); err != nil {
+			return err
+		}
+	}
+	if cfg.TxPower != 0 {
+		if err := gl.setTxPowerUnsafe(cfg.TxPower); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gl *GoLora) startModulatedUnsafe(cfg TestModeConfig) error {
+	if err := gl.changeModeUnsafe(Idle); err != nil {
+		return err
+	}
+	if err := gl.setTestChannelUnsafe(cfg); err != nil {
+		return err
+	}
+	modemConfig, err := gl.readRegShadow(internal.REG_MODEM_CONFIG_2)
+	if err != nil {
+		return err
+	}
+	if err := gl.writeRegShadow(internal.REG_MODEM_CONFIG_2, modemConfig|internal.MODEM_CONFIG_2_TX_CONTINUOUS); err != nil {
+		return err
+	}
+	payload := cfg.Payload
+	if len(payload) == 0 {
+		payload = defaultTestPayload
+	}
+	if err := gl.setFifoPtr(0); err != nil {
+		return err
+	}
+	if err := gl.sendToFifo(payload); err != nil {
+		return err
+	}
+	if err := gl.writeReg(internal.REG_PAYLOAD_LENGTH, byte(len(payload))); err != nil {
+		return err
+	}
+	return gl.changeModeUnsafe(Tx)
+}
+
+// startCarrierUnsafe switches the chip to the FSK modem, which can only be
+// done from sleep, and transmits in continuous mode with no deviation. The
+// frequency and PA registers are shared by both modems; the FSK-only ones are
+// written raw, since their addresses hold other LoRa registers.
+func (gl *GoLora) startCarrierUnsafe(cfg TestModeConfig) error {
+	if err := gl.changeModeUnsafe(Sleep); err != nil {
+		return err
+	}
+	if err := gl.writeReg(internal.REG_OP_MODE, internal.MODE_SLEEP); err != nil {
+		return err
+	}
+	gl.shadow.invalidateAll()
+	if err := gl.writeReg(internal.REG_OP_MODE, internal.MODE_STDBY); err != nil {
+		return err
+	}
+	if err := gl.setTestChannelUnsafe(cfg); err != nil {
+		return err
+	}
+	for _, reg := range []byte{internal.REG_FSK_FDEV_MSB, internal.REG_FSK_FDEV_LSB, internal.REG_FSK_PACKET_CONFIG_2} {
+		if err := gl.writeRegRaw(reg, 0x00); err != nil {
+			return err
+		}
+	}
+	if err := gl.writeReg(internal.REG_OP_MODE, internal.MODE_TX); err != nil {
+		return err
+	}
+	gl.setModeUnsafe(Tx)
+	gl.setStateUnsafe(StateTransmitting)
+	return nil
+}
+
+// endTestModeUnsafe brings the chip back to the LoRa modem in standby and
+// rewrites every configuration register from the snapshot taken before the
+// test. The shadow was dropped when the carrier test left the LoRa modem and
+// is only rebuilt from what is written back here.
+func (gl *GoLora) endTestModeUnsafe(carrier bool, snapshot []byte, prevConf LoraConf) error {
+	var errs []error
+	if carrier {
+		if err := gl.writeReg(internal.REG_OP_MODE, internal.MODE_SLEEP); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop carrier: %w", err))
+		}
+		if err := gl.changeModeUnsafe(Sleep); err != nil {
+			errs = append(errs, fmt.Errorf("failed to return to LoRa mode: %w", err))
+		}
+		gl.shadow.invalidateAll()
+	}
+	if err := gl.changeModeUnsafe(Idle); err != nil {
+		errs = append(errs, fmt.Errorf("failed to set Idle mode: %w", err))
+	}
+	if err := gl.rollbackConf(snapshot, prevConf); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,141 @@
+package SX1276
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestModeRadio(t *testing.T) (*fakeChip, *GoLora, []byte) {
+	fc := newFakeChip()
+	gl := NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf())
+	assert.NoError(t, gl.Begin())
+	t.Cleanup(func() { _ = gl.Close() })
+	fc.txHang = true
+	return fc, gl, confRegs(fc)
+}
+
+func confRegs(fc *fakeChip) []byte {
+	vals := make([]byte, len(confRegisters))
+	for i, reg := range confRegisters {
+		vals[i] = fc.reg(reg)
+	}
+	return vals
+}
+
+func TestGoLora_RunTestMode_Modulated(t *testing.T) {
+	fc, gl, before := newTestModeRadio(t)
+	conf := gl.GetConf()
+	payload := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	err := gl.RunTestMode(context.Background(), TestModeConfig{
+		Signal:    TestModulated,
+		Frequency: 915000000,
+		TxPower:   17,
+		Duration:  10 * time.Millisecond,
+		Payload:   payload,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{payload}, fc.transmitted())
+
+	modemConfig := fc.writesTo(internal.REG_MODEM_CONFIG_2)
+	assert.NotZero(t, modemConfig[len(modemConfig)-2]&internal.MODEM_CONFIG_2_TX_CONTINUOUS)
+	assert.Zero(t, fc.reg(internal.REG_MODEM_CONFIG_2)&internal.MODEM_CONFIG_2_TX_CONTINUOUS)
+	assert.Contains(t, fc.writesTo(internal.REG_FRF_MSB), byte(0xe4))
+	assert.Contains(t, fc.writesTo(internal.REG_PA_CONFIG), byte(0x8f))
+
+	assert.Equal(t, before, confRegs(fc))
+	assert.Equal(t, conf, gl.GetConf())
+	assert.Equal(t, internal.MODE_LONG_RANGE_MODE|internal.MODE_STDBY, fc.reg(internal.REG_OP_MODE))
+	assert.Equal(t, StateReady, gl.State())
+}
+
+func TestGoLora_RunTestMode_Carrier(t *testing.T) {
+	fc, gl, before := newTestModeRadio(t)
+	fc.resetCounters()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- gl.RunTestMode(ctx, TestModeConfig{Signal: TestCarrier, Frequency: 869525000})
+	}()
+
+	assert.Eventually(t, func() bool { return gl.State() == StateTransmitting }, time.Second, time.Millisecond)
+	assert.Equal(t, internal.MODE_TX, fc.reg(internal.REG_OP_MODE))
+	assert.Equal(t, []byte{0x00}, fc.writesTo(internal.REG_FSK_FDEV_MSB))
+	assert.Equal(t, []byte{0x00}, fc.writesTo(internal.REG_FSK_FDEV_LSB))
+	cancel()
+	assert.NoError(t, <-done, "cancelling ends an open-ended test")
+
+	assert.Equal(t, []byte{
+		internal.MODE_LONG_RANGE_MODE | internal.MODE_SLEEP,
+		internal.MODE_SLEEP,
+		internal.MODE_STDBY,
+		internal.MODE_TX,
+		internal.MODE_SLEEP,
+		internal.MODE_LONG_RANGE_MODE | internal.MODE_SLEEP,
+		internal.MODE_LONG_RANGE_MODE | internal.MODE_STDBY,
+	}, fc.writesTo(internal.REG_OP_MODE))
+	assert.Equal(t, before, confRegs(fc))
+	assert.Equal(t, StateReady, gl.State())
+}
+
+func TestGoLora_RunTestMode_CarrierBypassesShadow(t *testing.T) {
+	fc, gl, _ := newTestModeRadio(t)
+	// a LoRa value that happens to equal the FSK one must not skip the write
+	assert.NoError(t, gl.do(context.Background(), func(ctx context.Context) error {
+		return gl.writeReg(internal.REG_DETECTION_OPTIMIZE, 0x00)
+	}))
+	fc.resetCounters()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- gl.RunTestMode(ctx, TestModeConfig{Signal: TestCarrier})
+	}()
+
+	assert.Eventually(t, func() bool { return gl.State() == StateTransmitting }, time.Second, time.Millisecond)
+	assert.Equal(t, []byte{0x00}, fc.writesTo(internal.REG_FSK_PACKET_CONFIG_2))
+	cancel()
+	assert.NoError(t, <-done)
+
+	assert.Zero(t, fc.reg(internal.REG_DETECTION_OPTIMIZE))
+	assert.NoError(t, gl.do(context.Background(), func(ctx context.Context) error {
+		return gl.verifyShadowUnsafe(shadowedRegisters)
+	}))
+}
+
+func TestGoLora_RunTestMode_Cancelled(t *testing.T) {
+	fc, gl, before := newTestModeRadio(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := gl.RunTestMode(ctx, TestModeConfig{Signal: TestModulated, Duration: time.Hour})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, before, confRegs(fc))
+	assert.Equal(t, StateReady, gl.State())
+}
+
+func TestGoLora_RunTestMode_Rejects(t *testing.T) {
+	_, gl, _ := newTestModeRadio(t)
+	tests := []struct {
+		name string
+		cfg  TestModeConfig
+	}{
+		{name: "unknown signal", cfg: TestModeConfig{Signal: TestSignal(9), Duration: time.Second}},
+		{name: "negative duration", cfg: TestModeConfig{Duration: -time.Second}},
+		{name: "payload too long", cfg: TestModeConfig{Duration: time.Second, Payload: make([]byte, 256)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, gl.RunTestMode(context.Background(), tt.cfg))
+		})
+	}
+
+	assert.NoError(t, gl.ChangeMode(RxContinuous))
+	err := gl.RunTestMode(context.Background(), TestModeConfig{Duration: time.Second})
+	var stateErr *StateError
+	assert.True(t, errors.As(err, &stateErr))
+	assert.Equal(t, StateReceiving, stateErr.State)
+}
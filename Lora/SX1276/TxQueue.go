@@ -0,0 +1,459 @@
+package SX1276
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"periph.io/x/conn/v3/physic"
+)
+
+type TxStatus int
+
+const (
+	TxPending TxStatus = iota
+	TxSent
+	TxTimeout
+	TxLbtBusy
+	TxDutyCycleDeferred
+	TxCancelled
+	TxFailed
+)
+
+func (s TxStatus) String() string {
+	switch s {
+	case TxPending:
+		return "pending"
+	case TxSent:
+		return "sent"
+	case TxTimeout:
+		return "timeout"
+	case TxLbtBusy:
+		return "lbt busy"
+	case TxDutyCycleDeferred:
+		return "duty cycle deferred"
+	case TxCancelled:
+		return "cancelled"
+	case TxFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("TxStatus(%d)", int(s))
+}
+
+var (
+	ErrLbtBusy     = errors.New("channel busy")
+	ErrDutyCycle   = errors.New("duty cycle limit reached")
+	ErrTxCancelled = errors.New("transmission cancelled")
+)
+
+// TxOverrides replaces parts of the radio configuration for one packet; zero
+// fields keep the current value. The configuration is restored afterwards.
+type TxOverrides struct {
+	Frequency physic.Frequency
+	SF        uint8
+	TxPower   uint8
+}
+
+func (o TxOverrides) apply(conf LoraConf) LoraConf {
+	if o.Frequency != 0 {
+		conf.Frequency = o.Frequency
+	}
+	if o.SF != 0 {
+		conf.SF = o.SF
+	}
+	if o.TxPower != 0 {
+		conf.TxPower = o.TxPower
+	}
+	return conf
+}
+
+// lbtPollInterval is how often the RSSI is sampled while listening.
+const lbtPollInterval = time.Millisecond
+
+// LbtConfig enables listen-before-talk: the channel is sampled throughout
+// Listen and the packet is not sent if any sample is above ThresholdDbm.
+type LbtConfig struct {
+	Listen       time.Duration
+	ThresholdDbm int
+}
+
+type TxRequest struct {
+	Payload   []byte
+	Priority  int
+	Overrides TxOverrides
+	Lbt       *LbtConfig
+	// Timeout bounds the wait for TxDone; zero derives it from the airtime.
+	Timeout time.Duration
+}
+
+type TxResult struct {
+	Status TxStatus
+	Err    error
+	Start  time.Time
+	End    time.Time
+	// RetryAfter is set for TxDutyCycleDeferred.
+	RetryAfter time.Duration
+}
+
+// TxAdmitter is consulted before every queued transmission, typically to
+// enforce a duty-cycle limit.
+type TxAdmitter interface {
+	Admit(freq physic.Frequency, airtime time.Duration, now time.Time) (retryAfter time.Duration, ok bool)
+	Record(freq physic.Frequency, start time.Time, airtime time.Duration)
+}
+
+type TxHandle struct {
+	done   chan struct{}
+	once   sync.Once
+	result TxResult
+}
+
+func newTxHandle() *TxHandle {
+	return &TxHandle{done: make(chan struct{})}
+}
+
+func (h *TxHandle) resolve(res TxResult) {
+	h.once.Do(func() {
+		h.result = res
+		close(h.done)
+	})
+}
+
+func (h *TxHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Result returns the outcome once the handle is resolved.
+func (h *TxHandle) Result() (TxResult, bool) {
+	select {
+	case <-h.done:
+		return h.result, true
+	default:
+		return TxResult{Status: TxPending}, false
+	}
+}
+
+func (h *TxHandle) Wait(ctx context.Context) (TxResult, error) {
+	select {
+	case <-h.done:
+		return h.result, nil
+	case <-ctx.Done():
+		return TxResult{Status: TxPending}, ctx.Err()
+	}
+}
+
+type txItem struct {
+	req    TxRequest
+	handle *TxHandle
+	seq    uint64
+	index  int
+}
+
+// txHeap orders by priority, highest first, then by arrival.
+type txHeap []*txItem
+
+func (h txHeap) Len() int { return len(h) }
+func (h txHeap) Less(i, j int) bool {
+	if h[i].req.Priority != h[j].req.Priority {
+		return h[i].req.Priority > h[j].req.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h txHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *txHeap) Push(x any) {
+	item := x.(*txItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *txHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	item.index = -1
+	*h = old[:len(old)-1]
+	return item
+}
+
+type TxQueueConfig struct {
+	// Admitter defaults to the limiter set with SetDutyCycleLimiter.
+	Admitter TxAdmitter
+	// WaitForDutyCycle holds a deferred packet until its sub-band has budget
+	// again instead of resolving it as TxDutyCycleDeferred.
+	WaitForDutyCycle bool
+	// StandbyBetween leaves the radio in standby after each packet instead
+	// of returning it to the mode it was in before.
+	StandbyBetween bool
+}
+
+type TxQueue struct {
+	gl  *GoLora
+	cfg TxQueueConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	items   txHeap
+	handles map[*TxHandle]*txItem
+	seq     uint64
+	closed  bool
+}
+
+func NewTxQueue(gl *GoLora, cfg TxQueueConfig) *TxQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &TxQueue{
+		gl:      gl,
+		cfg:     cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		handles: map[*TxHandle]*txItem{},
+	}
+	go q.run()
+	return q
+}
+
+func (q *TxQueue) Enqueue(req TxRequest) (*TxHandle, error) {
+	if len(req.Payload) == 0 || len(req.Payload) > 255 {
+		return nil, fmt.Errorf("payload length %d out of range 1-255", len(req.Payload))
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+	q.seq++
+	item := &txItem{req: req, handle: newTxHandle(), seq: q.seq}
+	heap.Push(&q.items, item)
+	q.handles[item.handle] = item
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return item.handle, nil
+}
+
+// Cancel drops a packet that has not started transmitting yet and reports
+// whether it did.
+func (q *TxQueue) Cancel(h *TxHandle) bool {
+	q.mu.Lock()
+	item, ok := q.handles[h]
+	if ok {
+		heap.Remove(&q.items, item.index)
+		delete(q.handles, h)
+	}
+	q.mu.Unlock()
+	if ok {
+		h.resolve(TxResult{Status: TxCancelled, Err: ErrTxCancelled})
+	}
+	return ok
+}
+
+func (q *TxQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Close aborts the packet on air, if any, and fails everything still queued.
+func (q *TxQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cancel()
+	<-q.done
+}
+
+func (q *TxQueue) next() (*txItem, bool) {
+	for {
+		q.mu.Lock()
+		if q.items.Len() > 0 {
+			item := heap.Pop(&q.items).(*txItem)
+			delete(q.handles, item.handle)
+			q.mu.Unlock()
+			return item, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.wake:
+		case <-q.ctx.Done():
+			return nil, false
+		case <-q.gl.quit:
+			return nil, false
+		}
+	}
+}
+
+func (q *TxQueue) run() {
+	defer close(q.done)
+	for {
+		item, ok := q.next()
+		if !ok {
+			break
+		}
+		item.handle.resolve(q.transmit(item.req))
+	}
+	q.mu.Lock()
+	q.closed = true
+	pending := q.items
+	q.items = nil
+	q.handles = map[*TxHandle]*txItem{}
+	q.mu.Unlock()
+	for _, item := range pending {
+		item.handle.resolve(TxResult{Status: TxFailed, Err: ErrClosed})
+	}
+}
+
+func (q *TxQueue) transmit(req TxRequest) TxResult {
+	for {
+		var res TxResult
+		err := q.gl.do(q.ctx, func(ctx context.Context) error {
+			res = q.gl.queuedTxUnsafe(ctx, req, q.cfg)
+			return nil
+		})
+		if err != nil {
+			return TxResult{Status: TxFailed, Err: err}
+		}
+		if res.Status != TxDutyCycleDeferred || !q.cfg.WaitForDutyCycle || res.RetryAfter < 0 {
+			return res
+		}
+		if err := q.sleep(res.RetryAfter); err != nil {
+			return TxResult{Status: TxFailed, Err: err}
+		}
+	}
+}
+
+func (q *TxQueue) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-q.ctx.Done():
+		return q.ctx.Err()
+	case <-q.gl.quit:
+		return ErrClosed
+	}
+}
+
+func (gl *GoLora) queuedTxUnsafe(ctx context.Context, req TxRequest, cfg TxQueueConfig) TxResult {
+	if err := gl.requireUnsafe("send packet", StateReady, StateSleeping, StateReceiving); err != nil {
+		return TxResult{Status: TxFailed, Err: err}
+	}
+	admitter := cfg.Admitter
+	if admitter == nil {
+		admitter = gl.dutyCycle
+	}
+	prevMode := gl.Mode
+	base := gl.Conf
+	conf := req.Overrides.apply(base)
+	onAir, err := gl.admitUnsafe(admitter, conf, len(req.Payload))
+	if err != nil {
+		var dcErr *DutyCycleError
+		if errors.As(err, &dcErr) {
+			return TxResult{Status: TxDutyCycleDeferred, Err: err, RetryAfter: dcErr.RetryAfter}
+		}
+		return TxResult{Status: TxFailed, Err: err}
+	}
+	if conf != base {
+		if err := gl.applyConfUnsafe(conf); err != nil {
+			return TxResult{Status: TxFailed, Err: err}
+		}
+	}
+
+	res := gl.lbtTransmitUnsafe(ctx, req, conf)
+	if res.Status == TxSent && admitter != nil {
+		admitter.Record(conf.Frequency, res.Start, onAir)
+	}
+
+	if conf != base {
+		if err := gl.applyConfUnsafe(base); err != nil {
+			res.Err = errors.Join(res.Err, fmt.Errorf("failed to restore config: %w", err))
+		}
+	}
+	if !cfg.StandbyBetween && gl.Mode != prevMode {
+		if err := gl.changeModeUnsafe(prevMode); err != nil {
+			res.Err = errors.Join(res.Err, fmt.Errorf("failed to restore %v mode: %w", prevMode, err))
+		}
+	}
+	return res
+}
+
+func (gl *GoLora) lbtTransmitUnsafe(ctx context.Context, req TxRequest, conf LoraConf) TxResult {
+	if req.Lbt != nil {
+		busy, err := gl.channelBusyUnsafe(ctx, *req.Lbt)
+		if err != nil {
+			return TxResult{Status: TxFailed, Err: gl.standbyOnCancel(ctx, err)}
+		}
+		if busy {
+			return TxResult{Status: TxLbtBusy, Err: ErrLbtBusy}
+		}
+	}
+
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = txTimeout(conf, uint16(len(req.Payload)))
+	}
+	txCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := gl.sendPacketUnsafe(req.Payload); err != nil {
+		return TxResult{Status: TxFailed, Err: err}
+	}
+	res := TxResult{Start: time.Now()}
+	if err := gl.waitTxDone(txCtx); err != nil {
+		res.Status = TxFailed
+		if txCtx.Err() != nil {
+			gl.stats.update(func(st *RadioStats) { st.TxTimeouts++ })
+			gl.publish(EventData{Event: OnTxTimeout, Err: err})
+			if ctx.Err() == nil {
+				res.Status = TxTimeout
+			}
+		}
+		res.Err = gl.standbyOnCancel(txCtx, err)
+		return res
+	}
+	res.End = time.Now()
+	res.Status = TxSent
+	gl.txSucceededUnsafe(conf, len(req.Payload))
+	gl.txFinishedUnsafe()
+	gl.publish(EventData{Event: OnTxDone, Packet: sentPacket(conf, req.Payload)})
+	return res
+}
+
+// channelBusyUnsafe listens on the configured channel and samples the
+// instantaneous RSSI every lbtPollInterval until Listen is up, so a burst
+// that ends before the last sample still counts.
+func (gl *GoLora) channelBusyUnsafe(ctx context.Context, lbt LbtConfig) (bool, error) {
+	if gl.Mode != RxContinuous {
+		if err := gl.changeModeUnsafe(RxContinuous); err != nil {
+			return false, err
+		}
+	}
+	deadline := time.Now().Add(lbt.Listen)
+	for {
+		if err := sleepCtx(ctx, min(time.Until(deadline), lbtPollInterval)); err != nil {
+			return false, err
+		}
+		raw, err := gl.readReg(internal.REG_RSSI_VALUE)
+		if err != nil {
+			return false, err
+		}
+		if pktRssiDbm(raw, gl.Conf.Frequency) > lbt.ThresholdDbm {
+			return true, nil
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+	}
+}
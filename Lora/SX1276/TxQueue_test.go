@@ -0,0 +1,243 @@
+package SX1276
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276/internal"
+	"github.com/stretchr/testify/assert"
+	"periph.io/x/conn/v3/physic"
+)
+
+type fakeAdmitter struct {
+	allow    bool
+	retry    time.Duration
+	recorded []time.Duration
+}
+
+func (fa *fakeAdmitter) Admit(freq physic.Frequency, airtime time.Duration, now time.Time) (time.Duration, bool) {
+	return fa.retry, fa.allow
+}
+
+func (fa *fakeAdmitter) Record(freq physic.Frequency, start time.Time, airtime time.Duration) {
+	fa.recorded = append(fa.recorded, airtime)
+}
+
+func waitTx(t *testing.T, h *TxHandle) TxResult {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	res, err := h.Wait(ctx)
+	assert.NoError(t, err)
+	return res
+}
+
+// holdOwner blocks the owner goroutine until the returned func is called.
+func holdOwner(gl *GoLora) func() {
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_ = gl.do(context.Background(), func(ctx context.Context) error {
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+	<-held
+	return func() { close(release) }
+}
+
+func newQueueRadio(t *testing.T) (*fakeChip, *GoLora) {
+	fc := newFakeChip()
+	gl := markReady(NewGoLoraSX1276(fakeChipDrv(fc), newAppliedLoraConf()))
+	t.Cleanup(func() { _ = gl.Close() })
+	return fc, gl
+}
+
+func TestTxQueue_SendsAndReturnsToRx(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	assert.NoError(t, gl.ChangeMode(RxContinuous))
+	q := NewTxQueue(gl, TxQueueConfig{})
+	defer q.Close()
+
+	h, err := q.Enqueue(TxRequest{Payload: []byte("hello")})
+	assert.NoError(t, err)
+	res := waitTx(t, h)
+	assert.Equal(t, TxSent, res.Status)
+	assert.NoError(t, res.Err)
+	assert.False(t, res.Start.IsZero())
+	assert.False(t, res.End.Before(res.Start))
+	assert.Equal(t, [][]byte{[]byte("hello")}, fc.transmitted())
+	assert.Equal(t, StateReceiving, gl.State())
+	assert.Equal(t, internal.MODE_RX_CONTINUOUS, fc.reg(internal.REG_OP_MODE)&0x07)
+}
+
+func TestTxQueue_RestoresPreviousMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  LoraMode
+		state RadioState
+	}{
+		{name: "standby", mode: Idle, state: StateReady},
+		{name: "sleep", mode: Sleep, state: StateSleeping},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc, gl := newQueueRadio(t)
+			assert.NoError(t, gl.ChangeMode(tt.mode))
+			q := NewTxQueue(gl, TxQueueConfig{})
+			defer q.Close()
+
+			// listen-before-talk switches to receive on the way
+			h, err := q.Enqueue(TxRequest{Payload: []byte("x"), Lbt: &LbtConfig{Listen: time.Millisecond, ThresholdDbm: -80}})
+			assert.NoError(t, err)
+			assert.Equal(t, TxSent, waitTx(t, h).Status)
+			assert.Equal(t, tt.state, gl.State())
+			assert.Equal(t, byte(tt.mode), fc.reg(internal.REG_OP_MODE)&0x07)
+		})
+	}
+}
+
+func TestTxQueue_Priority(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	q := NewTxQueue(gl, TxQueueConfig{StandbyBetween: true})
+	defer q.Close()
+
+	release := holdOwner(gl)
+	first, _ := q.Enqueue(TxRequest{Payload: []byte("a")})
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, time.Second, time.Millisecond)
+	var handles []*TxHandle
+	for _, req := range []TxRequest{
+		{Payload: []byte("b"), Priority: 0},
+		{Payload: []byte("c"), Priority: 5},
+		{Payload: []byte("d"), Priority: 1},
+		{Payload: []byte("e"), Priority: 5},
+	} {
+		h, err := q.Enqueue(req)
+		assert.NoError(t, err)
+		handles = append(handles, h)
+	}
+	release()
+	waitTx(t, first)
+	for _, h := range handles {
+		waitTx(t, h)
+	}
+
+	var order string
+	for _, pkt := range fc.transmitted() {
+		order += string(pkt)
+	}
+	assert.Equal(t, "acedb", order)
+	assert.Equal(t, StateReady, gl.State())
+}
+
+func TestTxQueue_Overrides(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	q := NewTxQueue(gl, TxQueueConfig{})
+	defer q.Close()
+
+	h, _ := q.Enqueue(TxRequest{Payload: []byte("x"), Overrides: TxOverrides{SF: 10, Frequency: 869525000}})
+	assert.Equal(t, TxSent, waitTx(t, h).Status)
+
+	fc.mu.Lock()
+	regs := fc.txRegs[0]
+	fc.mu.Unlock()
+	assert.Equal(t, byte(10), regs[internal.REG_MODEM_CONFIG_2]>>4)
+	assert.Equal(t, frfFromFreq(869525000), uint64(regs[internal.REG_FRF_MSB])<<16|uint64(regs[internal.REG_FRF_MID])<<8|uint64(regs[internal.REG_FRF_LSB]))
+	assert.Equal(t, newAppliedLoraConf(), gl.GetConf(), "override must not stick")
+	assert.Equal(t, byte(7), fc.reg(internal.REG_MODEM_CONFIG_2)>>4)
+}
+
+func TestTxQueue_Outcomes(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		fc.txHang = true
+		q := NewTxQueue(gl, TxQueueConfig{})
+		defer q.Close()
+		h, _ := q.Enqueue(TxRequest{Payload: []byte("x"), Timeout: 10 * time.Millisecond})
+		res := waitTx(t, h)
+		assert.Equal(t, TxTimeout, res.Status)
+		assert.ErrorIs(t, res.Err, context.DeadlineExceeded)
+		assert.Equal(t, StateReady, gl.State(), "the radio was in standby before")
+	})
+	t.Run("lbt busy", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		fc.poke(internal.REG_RSSI_VALUE, 100)
+		q := NewTxQueue(gl, TxQueueConfig{})
+		defer q.Close()
+		h, _ := q.Enqueue(TxRequest{Payload: []byte("x"), Lbt: &LbtConfig{Listen: time.Millisecond, ThresholdDbm: -80}})
+		res := waitTx(t, h)
+		assert.Equal(t, TxLbtBusy, res.Status)
+		assert.ErrorIs(t, res.Err, ErrLbtBusy)
+		assert.Empty(t, fc.transmitted())
+	})
+	t.Run("lbt clear", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		fc.poke(internal.REG_RSSI_VALUE, 30)
+		q := NewTxQueue(gl, TxQueueConfig{})
+		defer q.Close()
+		h, _ := q.Enqueue(TxRequest{Payload: []byte("x"), Lbt: &LbtConfig{Listen: time.Millisecond, ThresholdDbm: -80}})
+		assert.Equal(t, TxSent, waitTx(t, h).Status)
+	})
+	t.Run("lbt burst mid-listen", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		fc.poke(internal.REG_RSSI_VALUE, 30)
+		fc.mu.Lock()
+		fc.rssiSeq = []byte{30, 30, 100}
+		fc.mu.Unlock()
+		q := NewTxQueue(gl, TxQueueConfig{})
+		defer q.Close()
+		h, _ := q.Enqueue(TxRequest{Payload: []byte("x"), Lbt: &LbtConfig{Listen: 20 * time.Millisecond, ThresholdDbm: -80}})
+		res := waitTx(t, h)
+		assert.Equal(t, TxLbtBusy, res.Status)
+		assert.Empty(t, fc.transmitted())
+	})
+	t.Run("duty cycle deferred", func(t *testing.T) {
+		fc, gl := newQueueRadio(t)
+		adm := &fakeAdmitter{retry: time.Minute}
+		q := NewTxQueue(gl, TxQueueConfig{Admitter: adm})
+		defer q.Close()
+		h, _ := q.Enqueue(TxRequest{Payload: []byte("x")})
+		res := waitTx(t, h)
+		assert.Equal(t, TxDutyCycleDeferred, res.Status)
+		assert.Equal(t, time.Minute, res.RetryAfter)
+		assert.Empty(t, fc.transmitted())
+
+		adm.allow = true
+		h, _ = q.Enqueue(TxRequest{Payload: []byte("x")})
+		assert.Equal(t, TxSent, waitTx(t, h).Status)
+		assert.Equal(t, []time.Duration{gl.GetAirtime(1)}, adm.recorded)
+	})
+}
+
+func TestTxQueue_CancelAndClose(t *testing.T) {
+	fc, gl := newQueueRadio(t)
+	q := NewTxQueue(gl, TxQueueConfig{})
+
+	release := holdOwner(gl)
+	first, _ := q.Enqueue(TxRequest{Payload: []byte("a")})
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, time.Second, time.Millisecond)
+	second, _ := q.Enqueue(TxRequest{Payload: []byte("b")})
+	third, _ := q.Enqueue(TxRequest{Payload: []byte("c")})
+	assert.True(t, q.Cancel(second))
+	assert.False(t, q.Cancel(second))
+	res, ok := second.Result()
+	assert.True(t, ok)
+	assert.Equal(t, TxCancelled, res.Status)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	q.Close()
+	for _, h := range []*TxHandle{first, third} {
+		res := waitTx(t, h)
+		assert.NotEqual(t, TxSent, res.Status)
+	}
+	assert.Empty(t, fc.transmitted())
+	_, err := q.Enqueue(TxRequest{Payload: []byte("d")})
+	assert.ErrorIs(t, err, ErrClosed)
+	_, err = NewTxQueue(gl, TxQueueConfig{}).Enqueue(TxRequest{})
+	assert.EqualError(t, err, "payload length 0 out of range 1-255")
+}
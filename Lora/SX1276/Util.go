@@ -19,6 +19,14 @@ type BitUtils interface {
 	setPreamble(length uint16) []byte
 	checkData(irq byte) error
 	setCodingRate(cr byte, currentModemConfig byte) byte
+	getFreq(frf []byte) uint64
+	getTxPower(paConfig byte) (power byte, paBoost bool)
+	getSF(modemConfig2 byte) byte
+	getBW(modemConfig1 byte) byte
+	getCodingRate(modemConfig1 byte) byte
+	getHeader(modemConfig1 byte) bool
+	getCrc(modemConfig2 byte) bool
+	getPreamble(preamble []byte) uint16
 }
 
 type LoraUtils struct{}
@@ -40,13 +48,20 @@ func (lu *LoraUtils) setHeader(header bool, currentModemConfig byte) byte {
 	return updatedConf
 }
 
+var (
+	ErrCrc    = errors.New("packet damaged or lost in transmit")
+	ErrHeader = errors.New("packet header damaged")
+
+	errNoPacket = errors.New("no Packet Received")
+)
+
 func (lu *LoraUtils) checkData(irq byte) error {
-	if irq&internal.IRQ_RX_DONE_MASK == 0 {
-		return errors.New("no Packet Received")
+	if irq&internal.IRQ_PAYLOAD_CRC_ERROR_MASK != 0 {
+		return ErrCrc
 	}
 
-	if irq&internal.IRQ_PAYLOAD_CRC_ERROR_MASK != 0 {
-		return errors.New("packet damaged or lost in transmit")
+	if irq&internal.IRQ_RX_DONE_MASK == 0 {
+		return errNoPacket
 	}
 	return nil
 }
@@ -119,3 +134,35 @@ func (lu *LoraUtils) setBW(bw byte) byte {
 	bwReg := bw << 4 & 0xf0
 	return bwReg
 }
+
+func (lu *LoraUtils) getFreq(frf []byte) uint64 {
+	return uint64(frf[0])<<16 | uint64(frf[1])<<8 | uint64(frf[2])
+}
+
+func (lu *LoraUtils) getTxPower(paConfig byte) (byte, bool) {
+	return paConfig & 0x0f, paConfig&internal.PA_BOOST != 0
+}
+
+func (lu *LoraUtils) getSF(modemConfig2 byte) byte {
+	return modemConfig2 >> 4
+}
+
+func (lu *LoraUtils) getBW(modemConfig1 byte) byte {
+	return modemConfig1 >> 4
+}
+
+func (lu *LoraUtils) getCodingRate(modemConfig1 byte) byte {
+	return modemConfig1 >> 1 & 0x07
+}
+
+func (lu *LoraUtils) getHeader(modemConfig1 byte) bool {
+	return modemConfig1&0x01 == 0
+}
+
+func (lu *LoraUtils) getCrc(modemConfig2 byte) bool {
+	return modemConfig2&0x04 != 0
+}
+
+func (lu *LoraUtils) getPreamble(preamble []byte) uint16 {
+	return uint16(preamble[0])<<8 | uint16(preamble[1])
+}
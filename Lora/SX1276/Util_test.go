@@ -321,3 +321,39 @@ func TestLoraUtils_SetSF(t *testing.T) {
 		assert.Equal(t, test.want, result)
 	})
 }
+
+func TestLoraUtils_Getters(t *testing.T) {
+	lu := newLoraUtils()
+
+	t.Run("it Should read back what setFreq wrote", func(t *testing.T) {
+		assert.Equal(t, uint64(0x123456), lu.getFreq(lu.setFreq(0x123456)))
+	})
+
+	t.Run("it Should split pa config into power and boost", func(t *testing.T) {
+		power, boost := lu.getTxPower(lu.setTxPower(12))
+		assert.Equal(t, byte(12), power)
+		assert.True(t, boost)
+		power, boost = lu.getTxPower(0x4f)
+		assert.Equal(t, byte(15), power)
+		assert.False(t, boost)
+	})
+
+	t.Run("it Should read sf and crc from modem config 2", func(t *testing.T) {
+		conf := lu.setCrc(true, lu.setSF(9))
+		assert.Equal(t, byte(9), lu.getSF(conf))
+		assert.True(t, lu.getCrc(conf))
+		assert.False(t, lu.getCrc(lu.setCrc(false, conf)))
+	})
+
+	t.Run("it Should read bw, cr and header from modem config 1", func(t *testing.T) {
+		conf := lu.setHeader(false, lu.setCodingRate(3, lu.setBW(7)))
+		assert.Equal(t, byte(7), lu.getBW(conf))
+		assert.Equal(t, byte(3), lu.getCodingRate(conf))
+		assert.False(t, lu.getHeader(conf))
+		assert.True(t, lu.getHeader(lu.setHeader(true, conf)))
+	})
+
+	t.Run("it Should join preamble msb and lsb", func(t *testing.T) {
+		assert.Equal(t, uint16(10245), lu.getPreamble(lu.setPreamble(10245)))
+	})
+}
@@ -27,8 +27,10 @@ const (
 type Event int
 
 const (
-	OnRxDone = iota
+	OnRxDone Event = iota
 	OnTxDone
+	OnTxTimeout
+	OnStateChanged
 )
 
 const (
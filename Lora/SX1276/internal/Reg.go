@@ -33,18 +33,24 @@ const (
 	REG_RX_NB_BYTES          byte = 0x13
 	REG_PKT_SNR_VALUE        byte = 0x19
 	REG_PKT_RSSI_VALUE       byte = 0x1a
+	REG_RSSI_VALUE           byte = 0x1b
 	REG_MODEM_CONFIG_1       byte = 0x1d
 	REG_MODEM_CONFIG_2       byte = 0x1e
+	REG_SYMB_TIMEOUT_LSB     byte = 0x1f
 	REG_PREAMBLE_MSB         byte = 0x20
 	REG_PREAMBLE_LSB         byte = 0x21
 	REG_PAYLOAD_LENGTH       byte = 0x22
 	REG_MODEM_CONFIG_3       byte = 0x26
 	REG_RSSI_WIDEBAND        byte = 0x2c
 	REG_DETECTION_OPTIMIZE   byte = 0x31
+	REG_INVERT_IQ            byte = 0x33
 	REG_DETECTION_THRESHOLD  byte = 0x37
 	REG_SYNC_WORD            byte = 0x39
+	REG_INVERT_IQ_2          byte = 0x3b
 	REG_DIO_MAPPING_1        byte = 0x40
 	REG_VERSION              byte = 0x42
+	REG_PA_DAC               byte = 0x4d
+	REG_LAST                 byte = 0x70
 )
 
 // ============================
@@ -69,8 +75,20 @@ const PA_BOOST byte = 0x80
 // ============================
 const (
 	IRQ_TX_DONE_MASK           byte = 0x08
+	IRQ_VALID_HEADER_MASK      byte = 0x10
 	IRQ_PAYLOAD_CRC_ERROR_MASK byte = 0x20
 	IRQ_RX_DONE_MASK           byte = 0x40
+	IRQ_RX_TIMEOUT_MASK        byte = 0x80
+)
+
+// ============================
+// I/Q inversion
+// ============================
+const (
+	INVERT_IQ_RX     byte = 0x40
+	INVERT_IQ_TX_OFF byte = 0x01
+	INVERT_IQ_2_ON   byte = 0x19
+	INVERT_IQ_2_OFF  byte = 0x1d
 )
 
 // ============================
@@ -80,3 +98,20 @@ const (
 	PA_OUTPUT_RFO_PIN      byte = 0
 	PA_OUTPUT_PA_BOOST_PIN byte = 1
 )
+
+// ============================
+// Continuous transmission
+// ============================
+const MODEM_CONFIG_2_TX_CONTINUOUS byte = 0x08
+
+// ============================
+// Low data rate optimisation
+// ============================
+const MODEM_CONFIG_3_LOW_DATA_RATE_OPTIMIZE byte = 0x08
+
+// FSK/OOK page registers, only valid while LongRangeMode is cleared.
+const (
+	REG_FSK_FDEV_MSB        byte = 0x04
+	REG_FSK_FDEV_LSB        byte = 0x05
+	REG_FSK_PACKET_CONFIG_2 byte = 0x31
+)
@@ -0,0 +1,335 @@
+package adr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/airtime"
+)
+
+const (
+	defaultMargin     = 10
+	defaultHysteresis = 3
+	defaultHistory    = 20
+	defaultMinSamples = 5
+	defaultPowerStep  = 2
+	minSF             = 7
+	maxSF             = 12
+	minTxPower        = 2
+	maxTxPower        = 17
+)
+
+var ErrUnknownPeer = errors.New("no packets from peer")
+
+// Radio is the part of *SX1276.GoLora the controller drives.
+type Radio interface {
+	GetConf() SX1276.LoraConf
+	SetSF(sf uint8) error
+	SetBW(bw uint64) error
+	SetTXPower(txPower uint8) error
+}
+
+// Config tunes the controller; zero fields take the defaults noted.
+type Config struct {
+	// Margin is the SNR in dB to keep above the SF's demodulation floor,
+	// 10 dB by default.
+	Margin float64
+	// Hysteresis is the extra margin in dB a faster data rate or lower power
+	// needs before it is recommended, 3 dB by default.
+	Hysteresis float64
+	// History is how many SNR samples are kept per peer, 20 by default, and
+	// MinSamples how many are needed before recommending anything, 5.
+	History    int
+	MinSamples int
+	// Bandwidths the controller may pick, 125 kHz only by default.
+	Bandwidths []uint64
+	MinSF      uint8
+	MaxSF      uint8
+	MinTxPower uint8
+	MaxTxPower uint8
+	// PowerStep is the tx power granularity in dB, 2 by default.
+	PowerStep uint8
+	// FallbackAfter, when set, makes a peer silent for that long fall back to
+	// the slowest data rate at full power.
+	FallbackAfter time.Duration
+	// AutoApply makes Attach apply every changed recommendation.
+	AutoApply bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Margin == 0 {
+		c.Margin = defaultMargin
+	}
+	if c.Hysteresis == 0 {
+		c.Hysteresis = defaultHysteresis
+	}
+	if c.History <= 0 {
+		c.History = defaultHistory
+	}
+	if c.MinSamples <= 0 {
+		c.MinSamples = defaultMinSamples
+	}
+	if c.MinSamples > c.History {
+		c.MinSamples = c.History
+	}
+	if len(c.Bandwidths) == 0 {
+		c.Bandwidths = []uint64{uint64(SX1276.BW_7)}
+	}
+	if c.MinSF == 0 {
+		c.MinSF = minSF
+	}
+	if c.MaxSF == 0 {
+		c.MaxSF = maxSF
+	}
+	if c.MinTxPower == 0 {
+		c.MinTxPower = minTxPower
+	}
+	if c.MaxTxPower == 0 {
+		c.MaxTxPower = maxTxPower
+	}
+	if c.PowerStep == 0 {
+		c.PowerStep = defaultPowerStep
+	}
+	return c
+}
+
+type Recommendation struct {
+	SF      uint8
+	BW      uint64
+	TxPower uint8
+	// Margin is the SNR margin expected at these settings.
+	Margin float64
+	// Fallback is set when the peer went silent for FallbackAfter.
+	Fallback bool
+}
+
+func (r Recommendation) String() string {
+	return fmt.Sprintf("SF%d BW%d %d dBm (margin %.1f dB)", r.SF, r.BW, r.TxPower, r.Margin)
+}
+
+type sample struct {
+	snr     float64
+	bw      uint64
+	txPower uint8
+}
+
+// quality normalises the sample to a bandwidth and power independent
+// figure: SNR + 10log10(BW) - TxPower.
+func (s sample) quality() float64 {
+	return s.snr + 10*math.Log10(float64(s.bw)) - float64(s.txPower)
+}
+
+type peer struct {
+	samples []sample
+	last    time.Time
+}
+
+// Controller tracks per-peer SNR and recommends the fastest data rate and
+// lowest power that keep the configured margin. Links are assumed symmetric:
+// the peer runs the settings recommended here.
+type Controller struct {
+	cfg   Config
+	radio Radio
+
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+func New(radio Radio, cfg Config) *Controller {
+	return &Controller{
+		cfg:   cfg.withDefaults(),
+		radio: radio,
+		peers: map[string]*peer{},
+	}
+}
+
+// Observe records a packet received from peer with the settings it was
+// received with, or the radio's current ones when pkt carries none.
+func (c *Controller) Observe(peerID string, pkt SX1276.Packet) {
+	conf := pkt.Conf
+	if conf == (SX1276.LoraConf{}) {
+		conf = c.radio.GetConf()
+	}
+	at := pkt.Received
+	if at.IsZero() {
+		at = time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.peers[peerID]
+	if !ok {
+		p = &peer{}
+		c.peers[peerID] = p
+	}
+	p.samples = append(p.samples, sample{snr: pkt.SNR, bw: conf.BW, txPower: conf.TxPower})
+	if len(p.samples) > c.cfg.History {
+		p.samples = p.samples[len(p.samples)-c.cfg.History:]
+	}
+	p.last = at
+}
+
+// Forget drops everything known about peer.
+func (c *Controller) Forget(peerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, peerID)
+}
+
+type candidate struct {
+	sf      uint8
+	bw      uint64
+	bitrate float64
+}
+
+// candidates lists every allowed SF/BW, fastest first.
+func (c *Controller) candidates() []candidate {
+	var cands []candidate
+	for _, bw := range c.cfg.Bandwidths {
+		for sf := c.cfg.MinSF; sf <= c.cfg.MaxSF; sf++ {
+			cands = append(cands, candidate{sf: sf, bw: bw, bitrate: airtime.Params{SF: sf, BW: bw, Denum: 5}.Bitrate()})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].bitrate > cands[j].bitrate })
+	return cands
+}
+
+func margin(quality float64, sf uint8, bw uint64, txPower uint8) float64 {
+	snr := quality - 10*math.Log10(float64(bw)) + float64(txPower)
+	return snr - airtime.SNRLimit(sf)
+}
+
+func (c *Controller) fallback() Recommendation {
+	cands := c.candidates()
+	slowest := cands[len(cands)-1]
+	return Recommendation{SF: slowest.sf, BW: slowest.bw, TxPower: c.cfg.MaxTxPower, Fallback: true}
+}
+
+// Recommend works out the settings for peer at now. ok is false until
+// MinSamples packets have been seen, unless the peer has gone silent.
+func (c *Controller) Recommend(peerID string, now time.Time) (rec Recommendation, ok bool, err error) {
+	c.mu.Lock()
+	p, known := c.peers[peerID]
+	var samples []sample
+	var last time.Time
+	if known {
+		samples = append(samples, p.samples...)
+		last = p.last
+	}
+	c.mu.Unlock()
+	if !known {
+		return Recommendation{}, false, fmt.Errorf("%w %q", ErrUnknownPeer, peerID)
+	}
+	if c.cfg.FallbackAfter > 0 && now.Sub(last) >= c.cfg.FallbackAfter {
+		return c.fallback(), true, nil
+	}
+	if len(samples) < c.cfg.MinSamples {
+		return Recommendation{}, false, nil
+	}
+
+	quality := math.Inf(-1)
+	for _, s := range samples {
+		quality = math.Max(quality, s.quality())
+	}
+	conf := c.radio.GetConf()
+	current := margin(quality, conf.SF, conf.BW, conf.TxPower)
+	if current >= c.cfg.Margin && current < c.cfg.Margin+c.cfg.Hysteresis {
+		return Recommendation{SF: conf.SF, BW: conf.BW, TxPower: conf.TxPower, Margin: current}, true, nil
+	}
+	required := c.cfg.Margin
+	if current >= c.cfg.Margin {
+		required += c.cfg.Hysteresis
+	}
+	for _, cand := range c.candidates() {
+		if margin(quality, cand.sf, cand.bw, c.cfg.MaxTxPower) < required {
+			continue
+		}
+		power := c.cfg.MaxTxPower
+		for power >= c.cfg.MinTxPower+c.cfg.PowerStep && margin(quality, cand.sf, cand.bw, power-c.cfg.PowerStep) >= required {
+			power -= c.cfg.PowerStep
+		}
+		return Recommendation{SF: cand.sf, BW: cand.bw, TxPower: power, Margin: margin(quality, cand.sf, cand.bw, power)}, true, nil
+	}
+	// nothing keeps the margin: do the best we can
+	rec = c.fallback()
+	rec.Fallback = false
+	rec.Margin = margin(quality, rec.SF, rec.BW, rec.TxPower)
+	return rec, true, nil
+}
+
+// Apply sets the radio to rec, touching only what differs.
+func (c *Controller) Apply(rec Recommendation) error {
+	conf := c.radio.GetConf()
+	if conf.SF != rec.SF {
+		if err := c.radio.SetSF(rec.SF); err != nil {
+			return fmt.Errorf("failed to set SF: %w", err)
+		}
+	}
+	if conf.BW != rec.BW {
+		if err := c.radio.SetBW(rec.BW); err != nil {
+			return fmt.Errorf("failed to set BW: %w", err)
+		}
+	}
+	if conf.TxPower != rec.TxPower {
+		if err := c.radio.SetTXPower(rec.TxPower); err != nil {
+			return fmt.Errorf("failed to set tx power: %w", err)
+		}
+	}
+	return nil
+}
+
+// Attach feeds every packet gl receives to the controller, using peerOf to
+// tell peers apart. With AutoApply set, the new recommendation for that peer
+// is applied straight away.
+func (c *Controller) Attach(gl *SX1276.GoLora, peerOf func(*SX1276.Packet) string) (*SX1276.Subscription, error) {
+	return gl.Subscribe(SX1276.OnRxDone, func(ev SX1276.EventData) {
+		if ev.Packet == nil {
+			return
+		}
+		id := peerOf(ev.Packet)
+		c.Observe(id, *ev.Packet)
+		if !c.cfg.AutoApply {
+			return
+		}
+		if rec, ok, err := c.Recommend(id, time.Now()); err == nil && ok {
+			_ = c.Apply(rec)
+		}
+	})
+}
+
+// Run applies the fallback settings whenever a peer has been silent for
+// FallbackAfter, checking every interval until ctx is done.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) error {
+	if c.cfg.FallbackAfter <= 0 {
+		return errors.New("FallbackAfter is not set")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			c.mu.Lock()
+			var ids []string
+			for id := range c.peers {
+				ids = append(ids, id)
+			}
+			c.mu.Unlock()
+			for _, id := range ids {
+				rec, ok, err := c.Recommend(id, now)
+				if err != nil || !ok || !rec.Fallback {
+					continue
+				}
+				if err := c.Apply(rec); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
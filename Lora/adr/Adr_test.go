@@ -0,0 +1,139 @@
+package adr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRadio struct {
+	conf  SX1276.LoraConf
+	calls []string
+}
+
+func (fr *fakeRadio) GetConf() SX1276.LoraConf { return fr.conf }
+
+func (fr *fakeRadio) SetSF(sf uint8) error {
+	fr.calls = append(fr.calls, "sf")
+	fr.conf.SF = sf
+	return nil
+}
+
+func (fr *fakeRadio) SetBW(bw uint64) error {
+	fr.calls = append(fr.calls, "bw")
+	fr.conf.BW = bw
+	return nil
+}
+
+func (fr *fakeRadio) SetTXPower(txPower uint8) error {
+	fr.calls = append(fr.calls, "power")
+	fr.conf.TxPower = txPower
+	return nil
+}
+
+func newRadio(sf uint8, txPower uint8) *fakeRadio {
+	return &fakeRadio{conf: SX1276.LoraConf{SF: sf, BW: 125000, TxPower: txPower, Denum: 5}}
+}
+
+func observe(c *Controller, peer string, snr float64, n int, at time.Time) {
+	for i := 0; i < n; i++ {
+		c.Observe(peer, SX1276.Packet{SNR: snr, Received: at})
+	}
+}
+
+func TestController_Recommend(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		sf    uint8
+		power uint8
+		snr   float64
+		want  Recommendation
+	}{
+		// SF8 at 15 dBm leaves exactly 10 dB + 3 dB hysteresis
+		{name: "good link speeds up", sf: 12, power: 17, snr: 5, want: Recommendation{SF: 8, BW: 125000, TxPower: 15, Margin: 13}},
+		{name: "inside hysteresis", sf: 9, power: 17, snr: -1, want: Recommendation{SF: 9, BW: 125000, TxPower: 17, Margin: 11.5}},
+		{name: "weak link slows down", sf: 8, power: 15, snr: -8, want: Recommendation{SF: 11, BW: 125000, TxPower: 17, Margin: 11.5}},
+		{name: "hopeless link", sf: 12, power: 17, snr: -25, want: Recommendation{SF: 12, BW: 125000, TxPower: 17, Margin: -5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(newRadio(tt.sf, tt.power), Config{})
+			observe(c, "node", tt.snr, 5, now)
+			rec, ok, err := c.Recommend("node", now)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want.SF, rec.SF)
+			assert.Equal(t, tt.want.BW, rec.BW)
+			assert.Equal(t, tt.want.TxPower, rec.TxPower)
+			assert.InDelta(t, tt.want.Margin, rec.Margin, 0.01)
+		})
+	}
+}
+
+func TestController_ObserveUsesPacketConf(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// the radio moved on to SF8 at 15 dBm after these were received
+	c := New(newRadio(8, 15), Config{})
+	for i := 0; i < 5; i++ {
+		c.Observe("node", SX1276.Packet{SNR: 5, Received: now, Conf: SX1276.LoraConf{SF: 12, BW: 125000, TxPower: 17}})
+	}
+	rec, ok, err := c.Recommend("node", now)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Recommendation{SF: 8, BW: 125000, TxPower: 15}, Recommendation{SF: rec.SF, BW: rec.BW, TxPower: rec.TxPower})
+	assert.InDelta(t, 13, rec.Margin, 0.01)
+}
+
+func TestController_ApplyIsStable(t *testing.T) {
+	now := time.Now()
+	radio := newRadio(12, 17)
+	c := New(radio, Config{})
+	observe(c, "node", 5, 5, now)
+	rec, _, _ := c.Recommend("node", now)
+	assert.NoError(t, c.Apply(rec))
+	assert.Equal(t, []string{"sf", "power"}, radio.calls)
+
+	// the same link measured at the new settings must not move again
+	observe(c, "node", -2, 5, now)
+	again, _, _ := c.Recommend("node", now)
+	assert.Equal(t, rec.SF, again.SF)
+	assert.Equal(t, rec.TxPower, again.TxPower)
+}
+
+func TestController_BandwidthAndSamples(t *testing.T) {
+	now := time.Now()
+	c := New(newRadio(12, 17), Config{Bandwidths: []uint64{125000, 250000}, MinSamples: 3})
+	_, _, err := c.Recommend("node", now)
+	assert.ErrorIs(t, err, ErrUnknownPeer)
+	observe(c, "node", 15, 2, now)
+	_, ok, err := c.Recommend("node", now)
+	assert.NoError(t, err)
+	assert.False(t, ok, "not enough samples")
+	observe(c, "node", 15, 1, now)
+	rec, ok, _ := c.Recommend("node", now)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(7), rec.SF)
+	assert.Equal(t, uint64(250000), rec.BW)
+}
+
+func TestController_Fallback(t *testing.T) {
+	now := time.Now()
+	radio := newRadio(7, 2)
+	c := New(radio, Config{FallbackAfter: time.Minute})
+	observe(c, "node", 10, 5, now.Add(-2*time.Minute))
+	rec, ok, err := c.Recommend("node", now)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Recommendation{SF: 12, BW: 125000, TxPower: 17, Fallback: true}, rec)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Run(ctx, 5*time.Millisecond), context.DeadlineExceeded)
+	assert.Equal(t, uint8(12), radio.conf.SF)
+	assert.Equal(t, uint8(17), radio.conf.TxPower)
+	assert.EqualError(t, New(radio, Config{}).Run(ctx, time.Millisecond), "FallbackAfter is not set")
+}
@@ -0,0 +1,125 @@
+package airtime
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	maxPayload = 255
+	// noiseFigure is the SX1276 receiver noise figure in dB.
+	noiseFigure = 6
+	// thermalNoise is kTB for 1 Hz at room temperature, in dBm.
+	thermalNoise = -174
+)
+
+// Params are the modem settings that decide time on air. The fields mirror
+// SX1276.LoraConf: BW is in Hz and Denum is the coding rate denominator 5-8.
+type Params struct {
+	SF             uint8
+	BW             uint64
+	Denum          uint8
+	PreambleLength uint16
+	ImplicitHeader bool
+	CRC            bool
+	// LowDataRateOptimize must match the modem's LowDataRateOptimize bit;
+	// see LowDataRateRequired for when the datasheet asks for it.
+	LowDataRateOptimize bool
+}
+
+func (p Params) Validate() error {
+	var errs []error
+	if p.SF < 6 || p.SF > 12 {
+		errs = append(errs, fmt.Errorf("spreading factor %d out of range 6-12", p.SF))
+	}
+	if p.BW == 0 {
+		errs = append(errs, errors.New("bandwidth must not be zero"))
+	}
+	if p.Denum < 5 || p.Denum > 8 {
+		errs = append(errs, fmt.Errorf("coding rate 4/%d out of range 4/5-4/8", p.Denum))
+	}
+	return errors.Join(errs...)
+}
+
+// LowDataRateRequired reports whether the symbol time at sf and bw exceeds
+// the 16 ms above which the datasheet mandates LowDataRateOptimize.
+func LowDataRateRequired(sf uint8, bw uint64) bool {
+	return Params{SF: sf, BW: bw}.symbolSeconds() > 0.016
+}
+
+func (p Params) symbolSeconds() float64 {
+	return math.Pow(2, float64(p.SF)) / float64(p.BW)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
+
+func (p Params) SymbolTime() time.Duration {
+	return seconds(p.symbolSeconds())
+}
+
+// PreambleTime includes the 4.25 symbols of sync word and start frame
+// delimiter that follow the programmed preamble.
+func (p Params) PreambleTime() time.Duration {
+	return seconds((float64(p.PreambleLength) + 4.25) * p.symbolSeconds())
+}
+
+// PayloadSymbols is the number of symbols after the preamble for a payload
+// of payloadLength bytes, header and CRC included.
+func (p Params) PayloadSymbols(payloadLength int) int {
+	sf := float64(p.SF)
+	var ih, crc, de float64
+	if p.ImplicitHeader {
+		ih = 1
+	}
+	if p.CRC {
+		crc = 1
+	}
+	if p.LowDataRateOptimize {
+		de = 1
+	}
+	n := math.Ceil((8*float64(payloadLength) - 4*sf + 28 + 16*crc - 20*ih) / (4 * (sf - 2*de)))
+	return 8 + int(math.Max(n*float64(p.Denum), 0))
+}
+
+func (p Params) TimeOnAir(payloadLength int) time.Duration {
+	symbols := float64(p.PreambleLength) + 4.25 + float64(p.PayloadSymbols(payloadLength))
+	return seconds(symbols * p.symbolSeconds())
+}
+
+// MaxPayload is the largest payload, up to 255 bytes, whose time on air
+// stays within dwell. ok is false when not even an empty packet fits.
+func (p Params) MaxPayload(dwell time.Duration) (n int, ok bool) {
+	if p.TimeOnAir(0) > dwell {
+		return 0, false
+	}
+	lo, hi := 0, maxPayload
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if p.TimeOnAir(mid) <= dwell {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, true
+}
+
+// Bitrate is the useful bit rate in bits per second, after coding.
+func (p Params) Bitrate() float64 {
+	return float64(p.SF) / p.symbolSeconds() * 4 / float64(p.Denum)
+}
+
+// SNRLimit is the lowest SNR in dB the demodulator copes with at sf.
+func SNRLimit(sf uint8) float64 {
+	return -2.5 * (float64(sf) - 4)
+}
+
+// Sensitivity is the receiver sensitivity in dBm at sf and bw, from the noise
+// floor of the bandwidth, the noise figure and the demodulator SNR limit.
+func Sensitivity(sf uint8, bw uint64) float64 {
+	return thermalNoise + 10*math.Log10(float64(bw)) + noiseFigure + SNRLimit(sf)
+}
@@ -0,0 +1,133 @@
+package airtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loraWAN is the LoRaWAN default modem setup: CR 4/5, 8 symbol preamble,
+// explicit header and CRC on.
+func loraWAN(sf uint8, bw uint64) Params {
+	return Params{
+		SF:                  sf,
+		BW:                  bw,
+		Denum:               5,
+		PreambleLength:      8,
+		CRC:                 true,
+		LowDataRateOptimize: LowDataRateRequired(sf, bw),
+	}
+}
+
+func TestParams_TimeOnAir(t *testing.T) {
+	// expected values from the Semtech LoRa calculator
+	tests := []struct {
+		name    string
+		p       Params
+		length  int
+		symbols int
+		want    time.Duration
+	}{
+		{name: "SF7 BW125 10B", p: loraWAN(7, 125000), length: 10, symbols: 28, want: 41216 * time.Microsecond},
+		{name: "SF7 BW125 51B", p: loraWAN(7, 125000), length: 51, symbols: 88, want: 102656 * time.Microsecond},
+		{name: "SF9 BW125 20B", p: loraWAN(9, 125000), length: 20, symbols: 33, want: 185344 * time.Microsecond},
+		{name: "SF10 BW125 11B", p: loraWAN(10, 125000), length: 11, symbols: 23, want: 288768 * time.Microsecond},
+		{name: "SF11 BW125 10B", p: loraWAN(11, 125000), length: 10, symbols: 23, want: 577536 * time.Microsecond},
+		{name: "SF12 BW125 10B", p: loraWAN(12, 125000), length: 10, symbols: 18, want: 991232 * time.Microsecond},
+		{name: "SF12 BW125 51B", p: loraWAN(12, 125000), length: 51, symbols: 63, want: 2465792 * time.Microsecond},
+		{name: "SF8 BW500 20B", p: loraWAN(8, 500000), length: 20, symbols: 38, want: 25728 * time.Microsecond},
+		{name: "SF7 BW250 0B", p: loraWAN(7, 250000), length: 0, symbols: 13, want: 12928 * time.Microsecond},
+		{
+			name:    "SF6 implicit no CRC CR4/8",
+			p:       Params{SF: 6, BW: 125000, Denum: 8, PreambleLength: 6, ImplicitHeader: true},
+			length:  8,
+			symbols: 24,
+			want:    17536 * time.Microsecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.p.Validate())
+			assert.Equal(t, tt.symbols, tt.p.PayloadSymbols(tt.length))
+			assert.Equal(t, tt.want, tt.p.TimeOnAir(tt.length))
+			assert.Equal(t, tt.want, tt.p.PreambleTime()+time.Duration(tt.symbols)*tt.p.SymbolTime())
+		})
+	}
+}
+
+func TestParams_SymbolAndPreambleTime(t *testing.T) {
+	assert.Equal(t, 1024*time.Microsecond, loraWAN(7, 125000).SymbolTime())
+	assert.Equal(t, 32768*time.Microsecond, loraWAN(12, 125000).SymbolTime())
+	assert.Equal(t, 12544*time.Microsecond, loraWAN(7, 125000).PreambleTime())
+	assert.Equal(t, 401408*time.Microsecond, loraWAN(12, 125000).PreambleTime())
+}
+
+func TestLowDataRateRequired(t *testing.T) {
+	assert.True(t, LowDataRateRequired(12, 125000))
+	assert.True(t, LowDataRateRequired(11, 125000))
+	assert.False(t, LowDataRateRequired(10, 125000))
+	assert.True(t, LowDataRateRequired(12, 250000))
+	assert.False(t, LowDataRateRequired(11, 250000))
+	assert.True(t, LowDataRateRequired(10, 41700))
+}
+
+func TestParams_MaxPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     Params
+		dwell time.Duration
+		want  int
+		ok    bool
+	}{
+		// LoRaWAN US915 DR0 allows M=19, i.e. 24 bytes with MHDR and MIC
+		{name: "SF10 BW125 400ms", p: loraWAN(10, 125000), dwell: 400 * time.Millisecond, want: 24, ok: true},
+		{name: "SF9 BW125 400ms", p: loraWAN(9, 125000), dwell: 400 * time.Millisecond, want: 66, ok: true},
+		{name: "SF7 BW125 400ms", p: loraWAN(7, 125000), dwell: 400 * time.Millisecond, want: 255, ok: true},
+		{name: "SF12 BW125 400ms", p: loraWAN(12, 125000), dwell: 400 * time.Millisecond, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, ok := tt.p.MaxPayload(tt.dwell)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, n)
+			if ok && n < maxPayload {
+				assert.LessOrEqual(t, tt.p.TimeOnAir(n), tt.dwell)
+				assert.Greater(t, tt.p.TimeOnAir(n+1), tt.dwell)
+			}
+		})
+	}
+}
+
+func TestParams_Bitrate(t *testing.T) {
+	assert.InDelta(t, 5468.75, loraWAN(7, 125000).Bitrate(), 0.01)
+	assert.InDelta(t, 292.97, loraWAN(12, 125000).Bitrate(), 0.01)
+	assert.InDelta(t, 12500, loraWAN(8, 500000).Bitrate(), 0.01)
+}
+
+func TestSensitivity(t *testing.T) {
+	// SX1276 datasheet table 10, high frequency band. The formula leaves out
+	// the demodulator's implementation loss, so it may read up to 3 dB
+	// better than the measured figures.
+	const tolerance = 3
+	tests := []struct {
+		sf        uint8
+		bw        uint64
+		datasheet float64
+	}{
+		{sf: 7, bw: 125000, datasheet: -123},
+		{sf: 9, bw: 125000, datasheet: -129},
+		{sf: 12, bw: 125000, datasheet: -136},
+		{sf: 7, bw: 250000, datasheet: -120},
+		{sf: 7, bw: 500000, datasheet: -116},
+		{sf: 12, bw: 500000, datasheet: -129},
+	}
+	for _, tt := range tests {
+		assert.InDelta(t, tt.datasheet, Sensitivity(tt.sf, tt.bw), tolerance, "SF%d BW%d", tt.sf, tt.bw)
+	}
+}
+
+func TestParams_Validate(t *testing.T) {
+	err := Params{SF: 13, Denum: 4}.Validate()
+	assert.EqualError(t, err, "spreading factor 13 out of range 6-12\nbandwidth must not be zero\ncoding rate 4/4 out of range 4/5-4/8")
+}
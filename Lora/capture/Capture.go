@@ -0,0 +1,317 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"periph.io/x/conn/v3/physic"
+)
+
+// LinkTypeLoRaTap is the pcap link type (DLT) of packets carrying a LoRaTap
+// header, which Wireshark dissects with the "loratap" dissector.
+const LinkTypeLoRaTap = 270
+
+const (
+	loraTapVersion = 1
+	loraTapLength  = 35
+	snapLen        = 65535
+)
+
+// LoRaTap v1 flags.
+const (
+	flagIQInverted  byte = 0x02
+	flagImplicitHdr byte = 0x04
+	flagCrcOk       byte = 0x08
+	flagCrcNone     byte = 0x20
+)
+
+type Format int
+
+const (
+	PCAP Format = iota
+	PCAPNG
+)
+
+func (f Format) String() string {
+	switch f {
+	case PCAP:
+		return "pcap"
+	case PCAPNG:
+		return "pcapng"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+var ErrLinkType = errors.New("capture is not LoRaTap")
+
+// Record is one captured packet. LoRaTap has no room for the TX power, so
+// Conf.TxPower is always zero when read back, and bandwidths below 125 kHz
+// come back as zero. Only pcapng keeps the direction of a packet; records
+// read from pcap are always inbound.
+type Record struct {
+	Time     time.Time
+	Outbound bool
+	Conf     SX1276.LoraConf
+	RSSI     int
+	SNR      float64
+	Data     []byte
+}
+
+// RecordFrom turns a packet from an OnRxDone or OnTxDone event into a record.
+func RecordFrom(pkt *SX1276.Packet, outbound bool, at time.Time) Record {
+	if !pkt.Received.IsZero() {
+		at = pkt.Received
+	}
+	return Record{
+		Time:     at,
+		Outbound: outbound,
+		Conf:     pkt.Conf,
+		RSSI:     pkt.RSSI,
+		SNR:      pkt.SNR,
+		Data:     pkt.Data,
+	}
+}
+
+// Packet turns the record back into what the radio would have delivered.
+func (r Record) Packet() *SX1276.Packet {
+	return &SX1276.Packet{
+		Data:     append([]byte(nil), r.Data...),
+		RSSI:     r.RSSI,
+		SNR:      r.SNR,
+		Received: r.Time,
+		Conf:     r.Conf,
+	}
+}
+
+// loraTapRssi encodes dBm the way the LoRaTap packet_rssi field expects:
+// -139 + value, in quarter dB steps when the SNR is negative.
+func loraTapRssi(rssi int, snr float64) byte {
+	v := float64(rssi + 139)
+	if snr < 0 {
+		v *= 4
+	}
+	return byte(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+func loraTapHeader(rec Record) []byte {
+	hdr := make([]byte, loraTapLength)
+	hdr[0] = loraTapVersion
+	binary.BigEndian.PutUint16(hdr[2:], loraTapLength)
+	binary.BigEndian.PutUint32(hdr[4:], uint32(rec.Conf.Frequency))
+	hdr[8] = byte(rec.Conf.BW / 125000)
+	hdr[9] = rec.Conf.SF
+	hdr[10] = loraTapRssi(rec.RSSI, rec.SNR)
+	hdr[13] = byte(int8(math.Max(-128, math.Min(127, math.Round(rec.SNR*4)))))
+	hdr[14] = rec.Conf.SyncWord
+	binary.BigEndian.PutUint32(hdr[23:], uint32(rec.Time.UnixMicro()))
+	var flags byte
+	inverted := rec.Conf.InvertIQ.RX
+	if rec.Outbound {
+		inverted = rec.Conf.InvertIQ.TX
+	}
+	if inverted {
+		flags |= flagIQInverted
+	}
+	if rec.Conf.Header == SX1276.Implicit {
+		flags |= flagImplicitHdr
+	}
+	if rec.Conf.EnableCrc {
+		flags |= flagCrcOk
+	} else {
+		flags |= flagCrcNone
+	}
+	hdr[27] = flags
+	hdr[28] = rec.Conf.Denum
+	return hdr
+}
+
+func parseLoraTap(data []byte) (Record, error) {
+	if len(data) < 4 {
+		return Record{}, errors.New("LoRaTap header truncated")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if data[0] > loraTapVersion || length < 15 || length > len(data) {
+		return Record{}, fmt.Errorf("bad LoRaTap header: version %d, length %d", data[0], length)
+	}
+	var rec Record
+	rec.Conf.Frequency = physic.Frequency(binary.BigEndian.Uint32(data[4:]))
+	rec.Conf.BW = uint64(data[8]) * 125000
+	rec.Conf.SF = data[9]
+	rec.SNR = float64(int8(data[13])) / 4
+	if rec.SNR < 0 {
+		rec.RSSI = int(math.Round(float64(data[10])/4)) - 139
+	} else {
+		rec.RSSI = int(data[10]) - 139
+	}
+	rec.Conf.SyncWord = data[14]
+	rec.Conf.Header = SX1276.Explicit
+	rec.Conf.EnableCrc = true
+	if data[0] >= 1 && length >= loraTapLength {
+		flags := data[27]
+		rec.Conf.Header = SX1276.Header(flags&flagImplicitHdr == 0)
+		rec.Conf.EnableCrc = flags&flagCrcNone == 0
+		// the direction is not part of LoRaTap; readers that learn the
+		// packet was sent move this to InvertIQ.TX
+		rec.Conf.InvertIQ.RX = flags&flagIQInverted != 0
+		rec.Conf.Denum = data[28]
+	}
+	rec.Data = append([]byte(nil), data[length:]...)
+	return rec, nil
+}
+
+// Writer records packets to a pcap or pcapng stream with the LoRaTap link
+// type. It is safe for concurrent use. The first write error sticks: every
+// later write returns it.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+	err    error
+}
+
+// NewWriter writes the file header for format to w.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	var hdr []byte
+	switch format {
+	case PCAP:
+		hdr = pcapHeader()
+	case PCAPNG:
+		hdr = pcapngHeader()
+	default:
+		return nil, fmt.Errorf("unknown capture format %v", format)
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return nil, fmt.Errorf("failed to write %v header: %w", format, err)
+	}
+	return &Writer{w: w, format: format}, nil
+}
+
+func (cw *Writer) Write(rec Record) error {
+	frame := append(loraTapHeader(rec), rec.Data...)
+	var block []byte
+	if cw.format == PCAPNG {
+		block = pcapngPacket(rec, frame)
+	} else {
+		block = pcapPacket(rec, frame)
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.err != nil {
+		return cw.err
+	}
+	if _, err := cw.w.Write(block); err != nil {
+		cw.err = fmt.Errorf("failed to write packet: %w", err)
+	}
+	return cw.err
+}
+
+// Err is the error that stopped the writer, if any.
+func (cw *Writer) Err() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.err
+}
+
+// Attach records every packet gl receives, whichever receive call armed the
+// modem, and with withTx set every packet it sends, until detach is called.
+// Write errors are kept for Err.
+func (cw *Writer) Attach(gl *SX1276.GoLora, withTx bool) (detach func(), err error) {
+	var subs []*SX1276.Subscription
+	detach = func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}
+	events := []SX1276.Event{SX1276.OnRxDone}
+	if withTx {
+		events = append(events, SX1276.OnTxDone)
+	}
+	for _, event := range events {
+		outbound := event == SX1276.OnTxDone
+		sub, err := gl.Subscribe(event, func(ev SX1276.EventData) {
+			if ev.Packet == nil {
+				return
+			}
+			_ = cw.Write(RecordFrom(ev.Packet, outbound, ev.Time))
+		})
+		if err != nil {
+			detach()
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return detach, nil
+}
+
+func pcapHeader() []byte {
+	hdr := make([]byte, 0, 24)
+	hdr = binary.LittleEndian.AppendUint32(hdr, pcapMagicMicro)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 2)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 4)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 0)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 0)
+	hdr = binary.LittleEndian.AppendUint32(hdr, snapLen)
+	return binary.LittleEndian.AppendUint32(hdr, LinkTypeLoRaTap)
+}
+
+func pcapPacket(rec Record, frame []byte) []byte {
+	usec := rec.Time.UnixMicro()
+	block := make([]byte, 0, 16+len(frame))
+	block = binary.LittleEndian.AppendUint32(block, uint32(usec/1e6))
+	block = binary.LittleEndian.AppendUint32(block, uint32(usec%1e6))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(frame)))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(frame)))
+	return append(block, frame...)
+}
+
+// pcapngHeader is a section header followed by the single LoRaTap interface,
+// which keeps the default microsecond timestamp resolution.
+func pcapngHeader() []byte {
+	hdr := make([]byte, 0, 48)
+	hdr = binary.LittleEndian.AppendUint32(hdr, blockSectionHeader)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 28)
+	hdr = binary.LittleEndian.AppendUint32(hdr, byteOrderMagic)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 1)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 0)
+	hdr = binary.LittleEndian.AppendUint64(hdr, math.MaxUint64)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 28)
+
+	hdr = binary.LittleEndian.AppendUint32(hdr, blockInterface)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 20)
+	hdr = binary.LittleEndian.AppendUint16(hdr, LinkTypeLoRaTap)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 0)
+	hdr = binary.LittleEndian.AppendUint32(hdr, snapLen)
+	return binary.LittleEndian.AppendUint32(hdr, 20)
+}
+
+// pcapngPacket is an enhanced packet block with the direction in epb_flags.
+func pcapngPacket(rec Record, frame []byte) []byte {
+	padded := (len(frame) + 3) &^ 3
+	total := 28 + padded + 12 + 4
+	flags := uint32(epbInbound)
+	if rec.Outbound {
+		flags = epbOutbound
+	}
+	usec := uint64(rec.Time.UnixMicro())
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockEnhancedPacket)
+	block = binary.LittleEndian.AppendUint32(block, uint32(total))
+	block = binary.LittleEndian.AppendUint32(block, 0)
+	block = binary.LittleEndian.AppendUint32(block, uint32(usec>>32))
+	block = binary.LittleEndian.AppendUint32(block, uint32(usec))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(frame)))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(frame)))
+	block = append(block, frame...)
+	block = append(block, make([]byte, padded-len(frame))...)
+	block = binary.LittleEndian.AppendUint16(block, optEpbFlags)
+	block = binary.LittleEndian.AppendUint16(block, 4)
+	block = binary.LittleEndian.AppendUint32(block, flags)
+	block = binary.LittleEndian.AppendUint32(block, 0)
+	return binary.LittleEndian.AppendUint32(block, uint32(total))
+}
@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecords() []Record {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []Record{
+		{
+			Time: base.Add(1500 * time.Microsecond),
+			Conf: SX1276.LoraConf{
+				SF: 7, BW: 125000, Denum: 5, SyncWord: 0x34, Frequency: 868100000,
+				Header: SX1276.Explicit, EnableCrc: true,
+			},
+			RSSI: -97,
+			SNR:  7.25,
+			Data: []byte("uplink"),
+		},
+		{
+			Time: base.Add(time.Second),
+			Conf: SX1276.LoraConf{
+				SF: 12, BW: 250000, Denum: 8, SyncWord: 0x12, Frequency: 869525000,
+				Header: SX1276.Implicit,
+			},
+			RSSI: -121,
+			SNR:  -12.5,
+			Data: []byte{0x01, 0x02, 0x03},
+		},
+		{
+			Time:     base.Add(2 * time.Second),
+			Outbound: true,
+			Conf: SX1276.LoraConf{
+				SF: 9, BW: 500000, Denum: 6, SyncWord: 0x34, Frequency: 923300000,
+				Header: SX1276.Explicit, EnableCrc: true,
+				InvertIQ: SX1276.InvertIQ{TX: true},
+			},
+			Data: []byte("downlink!"),
+		},
+	}
+}
+
+func TestLoraTapHeader(t *testing.T) {
+	rec := testRecords()[0]
+	hdr := loraTapHeader(rec)
+	assert.Len(t, hdr, 35)
+	assert.Equal(t, []byte{
+		0x01, 0x00, 0x00, 0x23, // version 1, 35 bytes
+		0x33, 0xbe, 0x27, 0xa0, // 868100000 Hz
+		0x01, 0x07, // 125 kHz, SF7
+		42, 0, 0, 29, // RSSI -139+42, SNR 29/4
+		0x34,
+	}, hdr[:15])
+	assert.Equal(t, flagCrcOk, hdr[27])
+	assert.Equal(t, byte(5), hdr[28])
+
+	rec = testRecords()[1]
+	hdr = loraTapHeader(rec)
+	assert.Equal(t, byte(72), hdr[10], "quarter dB steps below 0 dB SNR")
+	assert.Equal(t, byte(0xce), hdr[13])
+	assert.Equal(t, flagImplicitHdr|flagCrcNone, hdr[27])
+
+	rec = testRecords()[2]
+	assert.Equal(t, flagIQInverted|flagCrcOk, loraTapHeader(rec)[27])
+	rec.Outbound = false
+	assert.Equal(t, flagCrcOk, loraTapHeader(rec)[27], "TX inversion does not apply to received packets")
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	for _, format := range []Format{PCAP, PCAPNG} {
+		t.Run(format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			assert.NoError(t, err)
+			want := testRecords()
+			for _, rec := range want {
+				assert.NoError(t, w.Write(rec))
+			}
+
+			r, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.NoError(t, err)
+			assert.Equal(t, format, r.Format())
+			got, err := ReadAll(bytes.NewReader(buf.Bytes()))
+			assert.NoError(t, err)
+			if format == PCAP {
+				want[2].Outbound = false
+				want[2].Conf.InvertIQ = SX1276.InvertIQ{RX: true}
+			}
+			if assert.Len(t, got, len(want)) {
+				for i := range want {
+					assert.True(t, want[i].Time.Equal(got[i].Time), "record %d time", i)
+					got[i].Time = want[i].Time
+				}
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestRecordFrom(t *testing.T) {
+	at := time.Unix(100, 0)
+	conf := SX1276.LoraConf{SF: 7, BW: 125000, Frequency: 868100000}
+	rec := RecordFrom(&SX1276.Packet{Data: []byte("tx"), Conf: conf}, true, at)
+	assert.Equal(t, Record{Time: at, Outbound: true, Conf: conf, Data: []byte("tx")}, rec)
+
+	received := time.Unix(99, 0)
+	pkt := &SX1276.Packet{Data: []byte("rx"), RSSI: -80, SNR: 9, Received: received, Conf: conf}
+	rec = RecordFrom(pkt, false, at)
+	assert.Equal(t, received, rec.Time)
+	assert.Equal(t, pkt, rec.Packet())
+}
+
+type failingWriter struct {
+	after int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if fw.after == 0 {
+		return 0, errors.New("disk full")
+	}
+	fw.after--
+	return len(p), nil
+}
+
+func TestWriter_Errors(t *testing.T) {
+	_, err := NewWriter(&failingWriter{}, PCAP)
+	assert.EqualError(t, err, "failed to write pcap header: disk full")
+	_, err = NewWriter(&bytes.Buffer{}, Format(7))
+	assert.EqualError(t, err, "unknown capture format Format(7)")
+
+	w, err := NewWriter(&failingWriter{after: 1}, PCAPNG)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Err())
+	rec := testRecords()[0]
+	assert.EqualError(t, w.Write(rec), "failed to write packet: disk full")
+	assert.EqualError(t, w.Write(rec), "failed to write packet: disk full")
+	assert.Error(t, w.Err())
+}
@@ -0,0 +1,281 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+	blockSectionHeader  = 0x0a0d0d0a
+	byteOrderMagic      = 0x1a2b3c4d
+
+	optEndOfOpt  = 0
+	optEpbFlags  = 2
+	optIfTsresol = 9
+	epbInbound   = 1
+	epbOutbound  = 2
+
+	// maxBlock bounds the memory a corrupt length field can make us allocate.
+	maxBlock = 1 << 20
+)
+
+type iface struct {
+	linkType uint16
+	tsresol  byte
+}
+
+// Reader reads the records of a pcap or pcapng capture written by Writer, or
+// by any other tool using the LoRaTap link type.
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+	order  binary.ByteOrder
+	nano   bool
+	ifaces []iface
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+	magic, err := cr.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) == blockSectionHeader {
+		cr.format = PCAPNG
+		return cr, nil
+	}
+	if err := cr.readPcapHeader(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *Reader) Format() Format {
+	return cr.format
+}
+
+func (cr *Reader) readPcapHeader() error {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(cr.r, hdr); err != nil {
+		return fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr) {
+		case pcapMagicMicro:
+			cr.order = order
+		case pcapMagicNano:
+			cr.order, cr.nano = order, true
+		default:
+			continue
+		}
+		if linkType := order.Uint32(hdr[20:]) & 0xffff; linkType != LinkTypeLoRaTap {
+			return fmt.Errorf("%w: link type %d", ErrLinkType, linkType)
+		}
+		return nil
+	}
+	return fmt.Errorf("not a pcap or pcapng capture: magic %x", hdr[:4])
+}
+
+// Next returns the next packet of the capture, or io.EOF once there are no
+// more.
+func (cr *Reader) Next() (Record, error) {
+	if cr.format == PCAPNG {
+		return cr.nextBlock()
+	}
+	return cr.nextPcap()
+}
+
+func (cr *Reader) nextPcap() (Record, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(cr.r, hdr); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, fmt.Errorf("pcap record header truncated: %w", err)
+		}
+		return Record{}, err
+	}
+	length := cr.order.Uint32(hdr[8:])
+	if length > maxBlock {
+		return Record{}, fmt.Errorf("pcap record of %d bytes is too large", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return Record{}, fmt.Errorf("pcap record truncated: %w", err)
+	}
+	rec, err := parseLoraTap(data)
+	if err != nil {
+		return Record{}, err
+	}
+	frac := time.Duration(cr.order.Uint32(hdr[4:]))
+	if !cr.nano {
+		frac *= time.Microsecond
+	}
+	rec.Time = time.Unix(int64(cr.order.Uint32(hdr)), int64(frac))
+	return rec, nil
+}
+
+// nextBlock reads pcapng blocks until it finds a packet; blocks other than
+// section headers, interfaces and enhanced packets are skipped.
+func (cr *Reader) nextBlock() (Record, error) {
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(cr.r, hdr); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return Record{}, fmt.Errorf("pcapng block header truncated: %w", err)
+			}
+			return Record{}, err
+		}
+		if binary.LittleEndian.Uint32(hdr) == blockSectionHeader {
+			if err := cr.readSectionHeader(hdr); err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		if cr.order == nil {
+			return Record{}, errors.New("pcapng block before the section header")
+		}
+		length := cr.order.Uint32(hdr[4:])
+		if length < 12 || length%4 != 0 || length > maxBlock {
+			return Record{}, fmt.Errorf("bad pcapng block length %d", length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(cr.r, body); err != nil {
+			return Record{}, fmt.Errorf("pcapng block truncated: %w", err)
+		}
+		body = body[:len(body)-4]
+		switch cr.order.Uint32(hdr) {
+		case blockInterface:
+			if err := cr.readInterface(body); err != nil {
+				return Record{}, err
+			}
+		case blockEnhancedPacket:
+			return cr.readPacket(body)
+		}
+	}
+}
+
+func (cr *Reader) readSectionHeader(hdr []byte) error {
+	rest := make([]byte, 4)
+	if _, err := io.ReadFull(cr.r, rest); err != nil {
+		return fmt.Errorf("pcapng section header truncated: %w", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(rest) == byteOrderMagic:
+		cr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(rest) == byteOrderMagic:
+		cr.order = binary.BigEndian
+	default:
+		return fmt.Errorf("bad pcapng byte-order magic %x", rest)
+	}
+	length := cr.order.Uint32(hdr[4:])
+	if length < 28 || length%4 != 0 || length > maxBlock {
+		return fmt.Errorf("bad pcapng section header length %d", length)
+	}
+	if _, err := cr.r.Discard(int(length) - 12); err != nil {
+		return fmt.Errorf("pcapng section header truncated: %w", err)
+	}
+	cr.ifaces = nil
+	return nil
+}
+
+func (cr *Reader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("pcapng interface block truncated")
+	}
+	ifc := iface{linkType: cr.order.Uint16(body), tsresol: 6}
+	cr.options(body[8:], func(code uint16, val []byte) {
+		if code == optIfTsresol && len(val) == 1 {
+			ifc.tsresol = val[0]
+		}
+	})
+	cr.ifaces = append(cr.ifaces, ifc)
+	return nil
+}
+
+func (cr *Reader) readPacket(body []byte) (Record, error) {
+	if len(body) < 20 {
+		return Record{}, errors.New("pcapng packet block truncated")
+	}
+	id := cr.order.Uint32(body)
+	if int(id) >= len(cr.ifaces) {
+		return Record{}, fmt.Errorf("pcapng packet on unknown interface %d", id)
+	}
+	ifc := cr.ifaces[id]
+	if ifc.linkType != LinkTypeLoRaTap {
+		return Record{}, fmt.Errorf("%w: link type %d", ErrLinkType, ifc.linkType)
+	}
+	length := int(cr.order.Uint32(body[12:]))
+	padded := (length + 3) &^ 3
+	if 20+padded > len(body) {
+		return Record{}, errors.New("pcapng packet data truncated")
+	}
+	rec, err := parseLoraTap(body[20 : 20+length])
+	if err != nil {
+		return Record{}, err
+	}
+	ts := uint64(cr.order.Uint32(body[4:]))<<32 | uint64(cr.order.Uint32(body[8:]))
+	rec.Time = tsTime(ts, ifc.tsresol)
+	cr.options(body[20+padded:], func(code uint16, val []byte) {
+		if code == optEpbFlags && len(val) == 4 {
+			rec.Outbound = cr.order.Uint32(val)&0x3 == epbOutbound
+		}
+	})
+	if rec.Outbound {
+		rec.Conf.InvertIQ.TX, rec.Conf.InvertIQ.RX = rec.Conf.InvertIQ.RX, false
+	}
+	return rec, nil
+}
+
+// options walks a pcapng option list, stopping at opt_endofopt or at the
+// first option that runs past the end.
+func (cr *Reader) options(opts []byte, fn func(code uint16, val []byte)) {
+	for len(opts) >= 4 {
+		code, length := cr.order.Uint16(opts), int(cr.order.Uint16(opts[2:]))
+		if code == optEndOfOpt || 4+length > len(opts) {
+			return
+		}
+		fn(code, opts[4:4+length])
+		opts = opts[min(len(opts), 4+(length+3)&^3):]
+	}
+}
+
+// tsTime converts a pcapng timestamp in units of 10^-n seconds, or 2^-n
+// seconds when the top bit of tsresol is set.
+func tsTime(ts uint64, tsresol byte) time.Time {
+	var perSec uint64
+	if tsresol&0x80 != 0 {
+		perSec = 1 << (tsresol & 0x7f)
+	} else {
+		perSec = uint64(math.Pow10(int(tsresol)))
+	}
+	sec, frac := ts/perSec, ts%perSec
+	return time.Unix(int64(sec), int64(float64(frac)*1e9/float64(perSec)))
+}
+
+// ReadAll reads every record of a capture.
+func ReadAll(r io.Reader) ([]Record, error) {
+	cr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for {
+		rec, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
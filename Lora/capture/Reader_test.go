@@ -0,0 +1,134 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bigEndianPcap(linkType uint32, frame []byte) []byte {
+	var b []byte
+	b = binary.BigEndian.AppendUint32(b, pcapMagicNano)
+	b = binary.BigEndian.AppendUint16(b, 2)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = binary.BigEndian.AppendUint32(b, snapLen)
+	b = binary.BigEndian.AppendUint32(b, linkType)
+	b = binary.BigEndian.AppendUint32(b, 1714564800)
+	b = binary.BigEndian.AppendUint32(b, 250)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(frame)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(frame)))
+	return append(b, frame...)
+}
+
+func TestReader_BigEndianNanoPcap(t *testing.T) {
+	frame := append(loraTapHeader(testRecords()[0]), 0xaa)
+	recs, err := ReadAll(bytes.NewReader(bigEndianPcap(LinkTypeLoRaTap, frame)))
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 1) {
+		assert.Equal(t, time.Unix(1714564800, 250), recs[0].Time)
+		assert.Equal(t, []byte{0xaa}, recs[0].Data)
+		assert.Equal(t, uint8(7), recs[0].Conf.SF)
+	}
+}
+
+func TestReader_LoraTapV0(t *testing.T) {
+	frame := []byte{
+		0x00, 0x00, 0x00, 0x0f,
+		0x33, 0xbe, 0x27, 0xa0,
+		0x02, 0x0a,
+		40, 0, 0, 0xf8,
+		0x12,
+		0x42,
+	}
+	rec, err := parseLoraTap(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(250000), rec.Conf.BW)
+	assert.Equal(t, uint8(10), rec.Conf.SF)
+	assert.Equal(t, -2.0, rec.SNR)
+	assert.Equal(t, -129, rec.RSSI)
+	assert.Equal(t, []byte{0x42}, rec.Data)
+	assert.Zero(t, rec.Conf.Denum)
+
+	_, err = parseLoraTap(frame[:3])
+	assert.Error(t, err)
+	_, err = parseLoraTap(frame[:10])
+	assert.EqualError(t, err, "bad LoRaTap header: version 0, length 15")
+}
+
+// pcapngBlock frames body as a little-endian pcapng block.
+func pcapngBlock(kind uint32, body []byte) []byte {
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, kind)
+	b = binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+}
+
+func TestReader_PcapngOptions(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, PCAPNG)
+	assert.NoError(t, err)
+	rec := testRecords()[2]
+	assert.NoError(t, w.Write(rec))
+	written := buf.Bytes()
+	shb, packet := written[:28], written[48:]
+
+	var ifc []byte
+	ifc = binary.LittleEndian.AppendUint16(ifc, LinkTypeLoRaTap)
+	ifc = binary.LittleEndian.AppendUint16(ifc, 0)
+	ifc = binary.LittleEndian.AppendUint32(ifc, snapLen)
+	ifc = append(ifc, optIfTsresol, 0, 1, 0, 3, 0, 0, 0, 0, 0, 0, 0) // milliseconds
+
+	var capture []byte
+	capture = append(capture, shb...)
+	capture = append(capture, pcapngBlock(blockInterface, ifc)...)
+	capture = append(capture, pcapngBlock(0x0bad, []byte{1, 2, 3, 4})...)
+	capture = append(capture, packet...)
+
+	recs, err := ReadAll(bytes.NewReader(capture))
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 1) {
+		assert.True(t, recs[0].Outbound)
+		assert.Equal(t, rec.Data, recs[0].Data)
+		// the microsecond timestamp is read as milliseconds
+		assert.Equal(t, rec.Time.UnixMicro(), recs[0].Time.UnixMilli())
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	frame := loraTapHeader(testRecords()[0])
+	tests := []struct {
+		name    string
+		capture []byte
+		err     string
+	}{
+		{name: "empty", capture: nil, err: "failed to read capture header: EOF"},
+		{name: "bad magic", capture: make([]byte, 24), err: "not a pcap or pcapng capture: magic 00000000"},
+		{name: "link type", capture: bigEndianPcap(105, frame), err: "capture is not LoRaTap: link type 105"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadAll(bytes.NewReader(tt.capture))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+
+	truncated := bigEndianPcap(LinkTypeLoRaTap, frame)
+	r, err := NewReader(bytes.NewReader(truncated[:len(truncated)-1]))
+	assert.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	var buf bytes.Buffer
+	_, err = NewWriter(&buf, PCAPNG)
+	assert.NoError(t, err)
+	r, err = NewReader(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
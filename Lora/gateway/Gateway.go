@@ -0,0 +1,472 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	defaultTxLead      = 50 * time.Millisecond
+	defaultMaxAdvance  = 10 * time.Second
+	defaultUplinkQueue = 64
+	defaultPreamble    = 8
+	minTxPower         = 2
+	maxTxPower         = 17
+)
+
+var (
+	ErrTooLate   = errors.New("downlink is too late to be sent")
+	ErrTooEarly  = errors.New("downlink is too far in the future")
+	ErrCollision = errors.New("downlink overlaps another downlink")
+	ErrTxFreq    = errors.New("downlink frequency not allowed")
+	ErrNoGPS     = errors.New("gateway has no GPS time reference")
+	ErrStopped   = errors.New("gateway stopped")
+)
+
+// Radio is the part of *SX1276.GoLora the gateway drives.
+type Radio interface {
+	ApplyConfig(conf SX1276.LoraConf) error
+	ChangeMode(mode SX1276.LoraMode) error
+	SendPacket(ctx context.Context, buff []byte) error
+}
+
+// Config describes a single-channel gateway; zero fields take the defaults
+// noted.
+type Config struct {
+	// RX is the one channel and data rate the gateway listens on. Its sync
+	// word is used for downlinks too.
+	RX SX1276.LoraConf
+	// Region, when set, limits downlink frequencies and power.
+	Region      *region.Region
+	AntennaGain float64
+	// TxLead is how long before a downlink starts the radio leaves the
+	// channel to be configured, 50 ms by default.
+	TxLead time.Duration
+	// MaxAdvance is how far ahead a downlink may be scheduled, 10 s by
+	// default.
+	MaxAdvance time.Duration
+	// UplinkQueue is how many uplinks wait for the protocol before new ones
+	// are dropped, 64 by default.
+	UplinkQueue int
+}
+
+func (c Config) withDefaults() Config {
+	if c.TxLead <= 0 {
+		c.TxLead = defaultTxLead
+	}
+	if c.MaxAdvance <= 0 {
+		c.MaxAdvance = defaultMaxAdvance
+	}
+	if c.UplinkQueue <= 0 {
+		c.UplinkQueue = defaultUplinkQueue
+	}
+	return c
+}
+
+// Uplink is a packet heard on the channel. Tmst is the gateway counter when
+// it ended, see Gateway.Tmst.
+type Uplink struct {
+	Packet SX1276.Packet
+	Tmst   uint32
+}
+
+// Downlink is a packet to send. It goes out as soon as the radio is free
+// when Immediate is set, and otherwise when the gateway counter reaches
+// Tmst.
+type Downlink struct {
+	Data      []byte
+	Frequency physic.Frequency
+	SF        uint8
+	BW        uint64
+	Denum     uint8
+	// Preamble defaults to 8 symbols.
+	Preamble uint16
+	// Power is the EIRP in dBm; the antenna gain is taken off before it is
+	// set on the radio.
+	Power     float64
+	InvertIQ  bool
+	NoCRC     bool
+	Immediate bool
+	Tmst      uint32
+}
+
+// TxHandle follows a scheduled downlink. At is when it is due to start and
+// Power the EIRP it goes out with, which differs from Downlink.Power when
+// the radio or the region cannot set that.
+type TxHandle struct {
+	At    time.Time
+	Power float64
+
+	done chan struct{}
+	once sync.Once
+	sent time.Time
+	err  error
+}
+
+func (h *TxHandle) resolve(sent time.Time, err error) {
+	h.once.Do(func() {
+		h.sent, h.err = sent, err
+		close(h.done)
+	})
+}
+
+func (h *TxHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait returns when the downlink left the antenna, or why it did not.
+func (h *TxHandle) Wait(ctx context.Context) (time.Time, error) {
+	select {
+	case <-h.done:
+		return h.sent, h.err
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	}
+}
+
+// Stats counts since the gateway was created. RxReceived includes packets
+// that failed their CRC; RxDropped are good packets the protocol did not
+// take in time.
+type Stats struct {
+	RxReceived   uint64
+	RxOK         uint64
+	RxDropped    uint64
+	DownReceived uint64
+	TxSent       uint64
+	TxFailed     uint64
+}
+
+type pendingTx struct {
+	dl     Downlink
+	conf   SX1276.LoraConf
+	end    time.Time
+	handle *TxHandle
+}
+
+// Gateway is a single-channel packet forwarder core: it keeps the radio
+// listening on one channel, timestamps what it hears and fits downlinks in
+// between.
+type Gateway struct {
+	cfg   Config
+	radio Radio
+	up    chan Uplink
+	epoch time.Time
+	wake  chan struct{}
+
+	mu      sync.Mutex
+	pending []*pendingTx
+	current *pendingTx
+	stopped bool
+	stats   Stats
+}
+
+func New(radio Radio, cfg Config) (*Gateway, error) {
+	if cfg.RX.Frequency == 0 || cfg.RX.SF == 0 || cfg.RX.BW == 0 {
+		return nil, errors.New("config has no receive channel")
+	}
+	cfg = cfg.withDefaults()
+	return &Gateway{
+		cfg:   cfg,
+		radio: radio,
+		up:    make(chan Uplink, cfg.UplinkQueue),
+		epoch: time.Now(),
+		wake:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Tmst is the gateway counter at t: microseconds since the gateway was
+// created, wrapping every 71 minutes like a concentrator's.
+func (g *Gateway) Tmst(t time.Time) uint32 {
+	return uint32(t.Sub(g.epoch).Microseconds())
+}
+
+// timeOf is when the counter reads tmst, taking the nearest wrap.
+func (g *Gateway) timeOf(tmst uint32) time.Time {
+	now := time.Now()
+	delta := int32(tmst - g.Tmst(now))
+	return now.Add(time.Duration(delta) * time.Microsecond)
+}
+
+// Uplinks delivers the packets the gateway hears.
+func (g *Gateway) Uplinks() <-chan Uplink {
+	return g.up
+}
+
+func (g *Gateway) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+// HandlePacket takes a packet heard on the channel; Attach arranges this for
+// a GoLora radio.
+func (g *Gateway) HandlePacket(pkt *SX1276.Packet) {
+	p := *pkt
+	if p.Received.IsZero() {
+		p.Received = time.Now()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.RxReceived++
+	g.stats.RxOK++
+	select {
+	case g.up <- Uplink{Packet: p, Tmst: g.Tmst(p.Received)}:
+	default:
+		g.stats.RxDropped++
+	}
+}
+
+// HandleRxError counts a packet that could not be read, such as one that
+// failed its CRC.
+func (g *Gateway) HandleRxError(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.RxReceived++
+}
+
+// Attach feeds every packet gl receives to the gateway.
+func (g *Gateway) Attach(gl *SX1276.GoLora) (*SX1276.Subscription, error) {
+	return gl.Subscribe(SX1276.OnRxDone, func(ev SX1276.EventData) {
+		switch {
+		case ev.Packet != nil:
+			g.HandlePacket(ev.Packet)
+		case ev.Err != nil:
+			g.HandleRxError(ev.Err)
+		}
+	})
+}
+
+// Schedule queues dl. Errors are ErrTooLate, ErrTooEarly, ErrCollision,
+// ErrTxFreq and ErrStopped; the handle reports the transmission itself.
+func (g *Gateway) Schedule(dl Downlink) (*TxHandle, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.DownReceived++
+	if g.stopped {
+		return nil, ErrStopped
+	}
+	if r := g.cfg.Region; r != nil && (dl.Frequency < r.Min || dl.Frequency > r.Max) {
+		return nil, fmt.Errorf("%w: %d Hz outside %s", ErrTxFreq, dl.Frequency, r.Name)
+	}
+	conf := g.txConf(dl)
+	onAir := timeOnAir(conf, len(dl.Data))
+	now := time.Now()
+	earliest := now.Add(g.cfg.TxLead)
+
+	var at time.Time
+	if dl.Immediate {
+		at = g.firstGap(earliest, onAir)
+	} else {
+		at = g.timeOf(dl.Tmst)
+		switch {
+		case at.Before(earliest):
+			return nil, fmt.Errorf("%w: due in %v", ErrTooLate, at.Sub(now))
+		case at.Sub(now) > g.cfg.MaxAdvance:
+			return nil, fmt.Errorf("%w: due in %v", ErrTooEarly, at.Sub(now))
+		case g.overlaps(at, at.Add(onAir)):
+			return nil, ErrCollision
+		}
+	}
+
+	p := &pendingTx{
+		dl:     dl,
+		conf:   conf,
+		end:    at.Add(onAir),
+		handle: &TxHandle{At: at, Power: float64(conf.TxPower) + g.cfg.AntennaGain, done: make(chan struct{})},
+	}
+	g.pending = append(g.pending, p)
+	sort.Slice(g.pending, func(i, j int) bool { return g.pending[i].handle.At.Before(g.pending[j].handle.At) })
+	g.signal()
+	return p.handle, nil
+}
+
+// txConf is the radio configuration for dl on the gateway's sync word.
+func (g *Gateway) txConf(dl Downlink) SX1276.LoraConf {
+	limit := float64(maxTxPower)
+	if g.cfg.Region != nil {
+		limit = float64(g.cfg.Region.MaxTxPower(g.cfg.AntennaGain))
+	}
+	power := math.Max(minTxPower, math.Min(limit, math.Floor(dl.Power-g.cfg.AntennaGain)))
+	preamble := dl.Preamble
+	if preamble == 0 {
+		preamble = defaultPreamble
+	}
+	return SX1276.LoraConf{
+		TxPower:        uint8(power),
+		SF:             dl.SF,
+		BW:             dl.BW,
+		Denum:          dl.Denum,
+		PreambleLength: preamble,
+		SyncWord:       g.cfg.RX.SyncWord,
+		Frequency:      dl.Frequency,
+		Header:         SX1276.Explicit,
+		EnableCrc:      !dl.NoCRC,
+		InvertIQ:       SX1276.InvertIQ{TX: dl.InvertIQ},
+	}
+}
+
+func timeOnAir(conf SX1276.LoraConf, n int) time.Duration {
+	return SX1276.AirtimeParams(conf).TimeOnAir(n)
+}
+
+// busy lists the downlinks holding the radio, each from when it takes the
+// radio until it is done.
+func (g *Gateway) busy() []*pendingTx {
+	all := g.pending
+	if g.current != nil {
+		all = append([]*pendingTx{g.current}, all...)
+	}
+	return all
+}
+
+func (g *Gateway) overlaps(start, end time.Time) bool {
+	for _, p := range g.busy() {
+		if start.Before(p.end.Add(g.cfg.TxLead)) && p.handle.At.Before(end.Add(g.cfg.TxLead)) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstGap is the earliest start from t on that fits onAir between the
+// downlinks already scheduled.
+func (g *Gateway) firstGap(t time.Time, onAir time.Duration) time.Time {
+	for _, p := range g.busy() {
+		if t.Before(p.end.Add(g.cfg.TxLead)) && p.handle.At.Before(t.Add(onAir).Add(g.cfg.TxLead)) {
+			t = p.end.Add(g.cfg.TxLead)
+		}
+	}
+	return t
+}
+
+func (g *Gateway) signal() {
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run listens on the channel and sends the scheduled downlinks until ctx is
+// done. Downlinks still queued then fail with ErrStopped.
+func (g *Gateway) Run(ctx context.Context) error {
+	if err := g.listen(); err != nil {
+		return err
+	}
+	defer g.stop()
+	for {
+		g.mu.Lock()
+		var next *pendingTx
+		if len(g.pending) > 0 {
+			next = g.pending[0]
+		}
+		g.mu.Unlock()
+
+		var fire <-chan time.Time
+		timer := time.NewTimer(time.Hour)
+		if next != nil {
+			timer.Reset(time.Until(next.handle.At) - g.cfg.TxLead)
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-g.wake:
+			timer.Stop()
+			continue
+		case <-fire:
+		}
+
+		g.mu.Lock()
+		if len(g.pending) == 0 || g.pending[0] != next {
+			// an earlier downlink came in meanwhile
+			g.mu.Unlock()
+			continue
+		}
+		g.pending = g.pending[1:]
+		g.current = next
+		g.mu.Unlock()
+		err := g.transmit(ctx, next)
+		g.mu.Lock()
+		g.current = nil
+		if err != nil {
+			g.stats.TxFailed++
+		} else {
+			g.stats.TxSent++
+		}
+		g.mu.Unlock()
+	}
+}
+
+func (g *Gateway) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopped = true
+	for _, p := range g.pending {
+		p.handle.resolve(time.Time{}, ErrStopped)
+	}
+	g.pending = nil
+}
+
+// transmit takes the radio off the channel, sends p when it is due and
+// listens again.
+func (g *Gateway) transmit(ctx context.Context, p *pendingTx) error {
+	err := g.send(ctx, p)
+	sent := time.Now()
+	if err != nil {
+		sent = time.Time{}
+	}
+	err = errors.Join(err, g.listen())
+	p.handle.resolve(sent, err)
+	return err
+}
+
+func (g *Gateway) send(ctx context.Context, p *pendingTx) error {
+	if err := g.radio.ChangeMode(SX1276.Idle); err != nil {
+		return fmt.Errorf("failed to leave the channel: %w", err)
+	}
+	if err := g.radio.ApplyConfig(p.conf); err != nil {
+		return fmt.Errorf("failed to configure downlink: %w", err)
+	}
+	if err := sleepUntil(ctx, p.handle.At); err != nil {
+		return err
+	}
+	if err := g.radio.SendPacket(ctx, p.dl.Data); err != nil {
+		return fmt.Errorf("failed to send downlink: %w", err)
+	}
+	return nil
+}
+
+// listen puts the radio back into continuous receive on the channel.
+func (g *Gateway) listen() error {
+	if err := g.radio.ChangeMode(SX1276.Idle); err != nil {
+		return fmt.Errorf("failed to stop the radio: %w", err)
+	}
+	if err := g.radio.ApplyConfig(g.cfg.RX); err != nil {
+		return fmt.Errorf("failed to configure the channel: %w", err)
+	}
+	if err := g.radio.ChangeMode(SX1276.RxContinuous); err != nil {
+		return fmt.Errorf("failed to start receiving: %w", err)
+	}
+	return nil
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,253 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentFrame struct {
+	data []byte
+	conf SX1276.LoraConf
+}
+
+type fakeRadio struct {
+	mu   sync.Mutex
+	conf SX1276.LoraConf
+	ops  []string
+	sent []sentFrame
+	// sendErr fails every SendPacket when set.
+	sendErr error
+}
+
+func (r *fakeRadio) ApplyConfig(conf SX1276.LoraConf) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conf = conf
+	r.ops = append(r.ops, fmt.Sprintf("apply %d iq %v/%v", conf.Frequency, conf.InvertIQ.TX, conf.InvertIQ.RX))
+	return nil
+}
+
+func (r *fakeRadio) ChangeMode(mode SX1276.LoraMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, fmt.Sprintf("mode %v", mode))
+	return nil
+}
+
+func (r *fakeRadio) SendPacket(ctx context.Context, buff []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, "send")
+	if r.sendErr != nil {
+		return r.sendErr
+	}
+	r.sent = append(r.sent, sentFrame{data: append([]byte(nil), buff...), conf: r.conf})
+	return nil
+}
+
+func (r *fakeRadio) log() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ops...)
+}
+
+func (r *fakeRadio) frames() []sentFrame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]sentFrame(nil), r.sent...)
+}
+
+var testRX = SX1276.LoraConf{
+	SF: 7, BW: 125000, Denum: 5, PreambleLength: 8, SyncWord: SX1276.SyncWordPublic,
+	Frequency: 868100000, Header: SX1276.Explicit, EnableCrc: true,
+}
+
+func newTestGateway(t *testing.T, cfg Config) (*Gateway, *fakeRadio) {
+	t.Helper()
+	if cfg.RX.Frequency == 0 {
+		cfg.RX = testRX
+	}
+	if cfg.Region == nil {
+		cfg.Region = region.EU868
+	}
+	radio := &fakeRadio{}
+	gw, err := New(radio, cfg)
+	require.NoError(t, err)
+	return gw, radio
+}
+
+// runGateway runs gw until the test ends.
+func runGateway(t *testing.T, gw *Gateway) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = gw.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func testDownlink(in time.Duration, gw *Gateway) Downlink {
+	return Downlink{
+		Data: []byte("down"), Frequency: 869525000, SF: 9, BW: 125000, Denum: 5,
+		Power: 14, InvertIQ: true, NoCRC: true, Tmst: gw.Tmst(time.Now().Add(in)),
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&fakeRadio{}, Config{})
+	assert.ErrorContains(t, err, "no receive channel")
+}
+
+func TestGateway_Tmst(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	at := time.Now().Add(3 * time.Second)
+	assert.WithinDuration(t, at, gw.timeOf(gw.Tmst(at)), time.Microsecond)
+
+	// the counter wraps every 2^32 µs; the nearest wrap is taken
+	gw.epoch = time.Now().Add(-time.Duration(1<<32-1000) * time.Microsecond)
+	soon := time.Now().Add(time.Second)
+	assert.Less(t, gw.Tmst(soon), uint32(2000000))
+	assert.WithinDuration(t, soon, gw.timeOf(gw.Tmst(soon)), time.Millisecond)
+}
+
+func TestGateway_Schedule(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	_, err := gw.Schedule(testDownlink(500*time.Millisecond, gw))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		edit func(dl *Downlink)
+		want error
+	}{
+		{name: "in the past", edit: func(dl *Downlink) { dl.Tmst = gw.Tmst(time.Now().Add(-time.Second)) }, want: ErrTooLate},
+		{name: "within the lead time", edit: func(dl *Downlink) { dl.Tmst = gw.Tmst(time.Now().Add(10 * time.Millisecond)) }, want: ErrTooLate},
+		{name: "too far ahead", edit: func(dl *Downlink) { dl.Tmst = gw.Tmst(time.Now().Add(time.Minute)) }, want: ErrTooEarly},
+		{name: "overlapping", edit: func(dl *Downlink) { dl.Tmst = gw.Tmst(time.Now().Add(520 * time.Millisecond)) }, want: ErrCollision},
+		{name: "outside the region", edit: func(dl *Downlink) { dl.Frequency = 923300000 }, want: ErrTxFreq},
+		{name: "after the other one", edit: func(dl *Downlink) { dl.Tmst = gw.Tmst(time.Now().Add(2 * time.Second)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := testDownlink(time.Second, gw)
+			tt.edit(&dl)
+			_, err := gw.Schedule(dl)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+	assert.Equal(t, uint64(len(tests)+1), gw.Stats().DownReceived)
+}
+
+func TestGateway_SchedulePower(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{AntennaGain: 2})
+	tests := []struct {
+		name  string
+		power float64
+		want  float64
+		txPow uint8
+	}{
+		{name: "as asked", power: 12, want: 12, txPow: 10},
+		{name: "above the region EIRP", power: 27, want: 16, txPow: 14},
+		{name: "below the radio minimum", power: 0, want: 4, txPow: 2},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := testDownlink(time.Duration(i+1)*time.Second, gw)
+			dl.Power = tt.power
+			h, err := gw.Schedule(dl)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, h.Power)
+			assert.Equal(t, tt.txPow, gw.pending[len(gw.pending)-1].conf.TxPower)
+		})
+	}
+}
+
+func TestGateway_Run(t *testing.T) {
+	gw, radio := newTestGateway(t, Config{})
+	runGateway(t, gw)
+	listen := []string{"mode Idle", "apply 868100000 iq false/false", "mode RxContinuous"}
+	require.Eventually(t, func() bool { return len(radio.log()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, listen, radio.log())
+
+	dl := testDownlink(300*time.Millisecond, gw)
+	timed, err := gw.Schedule(dl)
+	require.NoError(t, err)
+	dl.Immediate = true
+	dl.Data = []byte("now")
+	now, err := gw.Schedule(dl)
+	require.NoError(t, err)
+	assert.True(t, now.At.Before(timed.At), "immediate downlink fits before the timed one")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = now.Wait(ctx)
+	require.NoError(t, err)
+	sent, err := timed.Wait(ctx)
+	require.NoError(t, err)
+	assert.False(t, sent.Before(timed.At))
+
+	frames := radio.frames()
+	require.Len(t, frames, 2)
+	assert.Equal(t, "now", string(frames[0].data))
+	assert.Equal(t, "down", string(frames[1].data))
+	conf := frames[1].conf
+	assert.Equal(t, SX1276.InvertIQ{TX: true}, conf.InvertIQ)
+	assert.False(t, conf.EnableCrc)
+	assert.Equal(t, testRX.SyncWord, conf.SyncWord)
+	assert.Equal(t, uint16(8), conf.PreambleLength)
+
+	var want []string
+	want = append(want, listen...)
+	for range 2 {
+		want = append(want, "mode Idle", "apply 869525000 iq true/false", "send")
+		want = append(want, listen...)
+	}
+	assert.Equal(t, want, radio.log())
+	assert.Equal(t, uint64(2), gw.Stats().TxSent)
+}
+
+func TestGateway_Stop(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- gw.Run(ctx) }()
+
+	h, err := gw.Schedule(testDownlink(5*time.Second, gw))
+	require.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	_, err = h.Wait(context.Background())
+	assert.ErrorIs(t, err, ErrStopped)
+	_, err = gw.Schedule(testDownlink(time.Second, gw))
+	assert.ErrorIs(t, err, ErrStopped)
+}
+
+func TestGateway_HandlePacket(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{UplinkQueue: 1})
+	received := time.Now()
+	gw.HandlePacket(&SX1276.Packet{Data: []byte{1}, RSSI: -90, Received: received, Conf: testRX})
+	gw.HandlePacket(&SX1276.Packet{Data: []byte{2}})
+	gw.HandleRxError(SX1276.ErrCrc)
+
+	u := <-gw.Uplinks()
+	assert.Equal(t, []byte{1}, u.Packet.Data)
+	assert.Equal(t, gw.Tmst(received), u.Tmst)
+	assert.Equal(t, Stats{RxReceived: 3, RxOK: 2, RxDropped: 1}, gw.Stats())
+}
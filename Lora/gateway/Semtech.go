@@ -0,0 +1,440 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/lorawan"
+	"periph.io/x/conn/v3/physic"
+)
+
+// Semtech GWMP packet identifiers.
+const (
+	gwmpVersion = 2
+	pushData    = 0x00
+	pushAck     = 0x01
+	pullData    = 0x02
+	pullResp    = 0x03
+	pullAck     = 0x04
+	txAck       = 0x05
+)
+
+const (
+	defaultKeepAlive    = 10 * time.Second
+	defaultStatInterval = 30 * time.Second
+	maxDatagram         = 65507
+	statTimeLayout      = "2006-01-02 15:04:05 GMT"
+)
+
+// ErrTxpk is returned for a txpk the gateway cannot even schedule, such as
+// one using FSK.
+var ErrTxpk = errors.New("unsupported txpk")
+
+// Location is the fixed position reported in the gateway stats.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	// Altitude is in metres.
+	Altitude int
+}
+
+// ForwarderConfig describes the link to the network server; zero fields
+// take the defaults noted.
+type ForwarderConfig struct {
+	GatewayEUI lorawan.EUI64
+	// Server takes the uplinks, as host:port. ServerDown takes the PULL_DATA
+	// keepalives and sends the downlinks; it defaults to Server.
+	Server     string
+	ServerDown string
+	// KeepAlive is the PULL_DATA interval, 10 s by default.
+	KeepAlive time.Duration
+	// StatInterval is how often gateway stats are pushed, 30 s by default.
+	StatInterval time.Duration
+	Location     *Location
+	// OnError gets the errors Run carries on after, such as a failed send or
+	// a PULL_RESP that could not be understood. It may be called from more
+	// than one goroutine; nil drops them.
+	OnError func(error)
+}
+
+func (c ForwarderConfig) withDefaults() ForwarderConfig {
+	if c.ServerDown == "" {
+		c.ServerDown = c.Server
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = defaultKeepAlive
+	}
+	if c.StatInterval <= 0 {
+		c.StatInterval = defaultStatInterval
+	}
+	return c
+}
+
+type rxpk struct {
+	Time string  `json:"time"`
+	Tmst uint32  `json:"tmst"`
+	Chan int     `json:"chan"`
+	RFCh int     `json:"rfch"`
+	Freq float64 `json:"freq"`
+	Stat int     `json:"stat"`
+	Modu string  `json:"modu"`
+	Datr string  `json:"datr"`
+	Codr string  `json:"codr"`
+	RSSI int     `json:"rssi"`
+	LSNR float64 `json:"lsnr"`
+	Size int     `json:"size"`
+	Data []byte  `json:"data"`
+}
+
+type gwStat struct {
+	Time string   `json:"time"`
+	Lati *float64 `json:"lati,omitempty"`
+	Long *float64 `json:"long,omitempty"`
+	Alti *int     `json:"alti,omitempty"`
+	RxNb uint64   `json:"rxnb"`
+	RxOK uint64   `json:"rxok"`
+	RxFw uint64   `json:"rxfw"`
+	AckR float64  `json:"ackr"`
+	DwNb uint64   `json:"dwnb"`
+	TxNb uint64   `json:"txnb"`
+}
+
+type pushPayload struct {
+	RxPk []rxpk  `json:"rxpk,omitempty"`
+	Stat *gwStat `json:"stat,omitempty"`
+}
+
+// txpk keeps datr raw because FSK sends it as a number.
+type txpk struct {
+	Imme bool            `json:"imme"`
+	Tmst *uint32         `json:"tmst"`
+	Tmms *uint64         `json:"tmms"`
+	Freq float64         `json:"freq"`
+	RFCh int             `json:"rfch"`
+	Powe float64         `json:"powe"`
+	Modu string          `json:"modu"`
+	Datr json.RawMessage `json:"datr"`
+	Codr string          `json:"codr"`
+	IPol bool            `json:"ipol"`
+	Prea uint16          `json:"prea"`
+	Size int             `json:"size"`
+	Data []byte          `json:"data"`
+	NCRC bool            `json:"ncrc"`
+}
+
+type txpkAck struct {
+	Error string  `json:"error,omitempty"`
+	Warn  string  `json:"warn,omitempty"`
+	Value float64 `json:"value,omitempty"`
+}
+
+// Forwarder connects a Gateway to a network server over the Semtech UDP
+// protocol (GWMP v2).
+type Forwarder struct {
+	gw  *Gateway
+	cfg ForwarderConfig
+
+	mu sync.Mutex
+	// pushes holds the PUSH_DATA tokens not acknowledged yet.
+	pushes map[uint16]bool
+	pushed uint64
+	acked  uint64
+	rxfw   uint64
+	last   Stats
+}
+
+func NewForwarder(gw *Gateway, cfg ForwarderConfig) (*Forwarder, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("config has no server")
+	}
+	return &Forwarder{gw: gw, cfg: cfg.withDefaults(), pushes: map[uint16]bool{}}, nil
+}
+
+// Run forwards uplinks and stats and schedules downlinks until ctx is done.
+// The gateway itself must be running.
+func (f *Forwarder) Run(ctx context.Context) error {
+	up, err := net.Dial("udp", f.cfg.Server)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", f.cfg.Server, err)
+	}
+	defer up.Close()
+	down, err := net.Dial("udp", f.cfg.ServerDown)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", f.cfg.ServerDown, err)
+	}
+	defer down.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		up.Close()
+		down.Close()
+	}()
+	go f.readUp(up)
+	go f.readDown(down)
+
+	keepAlive := time.NewTicker(f.cfg.KeepAlive)
+	defer keepAlive.Stop()
+	stat := time.NewTicker(f.cfg.StatInterval)
+	defer stat.Stop()
+	if err := f.pull(down); err != nil {
+		return err
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case u := <-f.gw.Uplinks():
+			err = f.push(up, pushPayload{RxPk: []rxpk{newRxpk(u)}})
+			if err == nil {
+				f.mu.Lock()
+				f.rxfw++
+				f.mu.Unlock()
+			}
+		case <-keepAlive.C:
+			err = f.pull(down)
+		case <-stat.C:
+			err = f.push(up, pushPayload{Stat: f.stat(time.Now())})
+		}
+		if err != nil {
+			f.report(err)
+		}
+	}
+}
+
+func (f *Forwarder) report(err error) {
+	if f.cfg.OnError != nil {
+		f.cfg.OnError(err)
+	}
+}
+
+func newRxpk(u Uplink) rxpk {
+	p := u.Packet
+	stat := 0
+	if p.Conf.EnableCrc {
+		stat = 1
+	}
+	return rxpk{
+		Time: p.Received.UTC().Format(time.RFC3339Nano),
+		Tmst: u.Tmst,
+		Freq: float64(p.Conf.Frequency) / 1e6,
+		Stat: stat,
+		Modu: "LORA",
+		Datr: datr(p.Conf.SF, p.Conf.BW),
+		Codr: fmt.Sprintf("4/%d", p.Conf.Denum),
+		RSSI: p.RSSI,
+		LSNR: p.SNR,
+		Size: len(p.Data),
+		Data: p.Data,
+	}
+}
+
+func datr(sf uint8, bw uint64) string {
+	return fmt.Sprintf("SF%dBW%g", sf, float64(bw)/1000)
+}
+
+func parseDatr(s string) (uint8, uint64, error) {
+	var sf uint8
+	var khz float64
+	if _, err := fmt.Sscanf(s, "SF%dBW%g", &sf, &khz); err != nil {
+		return 0, 0, fmt.Errorf("%w: datr %q", ErrTxpk, s)
+	}
+	return sf, uint64(math.Round(khz * 1000)), nil
+}
+
+// stat builds the stats for the interval since the last call.
+func (f *Forwarder) stat(now time.Time) *gwStat {
+	st := f.gw.Stats()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := &gwStat{
+		Time: now.UTC().Format(statTimeLayout),
+		RxNb: st.RxReceived - f.last.RxReceived,
+		RxOK: st.RxOK - f.last.RxOK,
+		RxFw: f.rxfw,
+		DwNb: st.DownReceived - f.last.DownReceived,
+		TxNb: st.TxSent - f.last.TxSent,
+	}
+	if f.pushed > 0 {
+		s.AckR = 100 * float64(f.acked) / float64(f.pushed)
+	}
+	if loc := f.cfg.Location; loc != nil {
+		s.Lati, s.Long, s.Alti = &loc.Latitude, &loc.Longitude, &loc.Altitude
+	}
+	f.last = st
+	f.rxfw, f.pushed, f.acked = 0, 0, 0
+	clear(f.pushes)
+	return s
+}
+
+// header starts the datagrams the gateway sends, which all carry its EUI.
+func (f *Forwarder) header(token uint16, id byte) []byte {
+	b := []byte{gwmpVersion, byte(token >> 8), byte(token), id}
+	return append(b, f.cfg.GatewayEUI[:]...)
+}
+
+func (f *Forwarder) push(conn net.Conn, p pushPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	token := uint16(rand.N(1 << 16))
+	f.mu.Lock()
+	f.pushes[token] = true
+	f.pushed++
+	f.mu.Unlock()
+	if _, err := conn.Write(append(f.header(token, pushData), body...)); err != nil {
+		return fmt.Errorf("failed to send PUSH_DATA: %w", err)
+	}
+	return nil
+}
+
+func (f *Forwarder) pull(conn net.Conn) error {
+	if _, err := conn.Write(f.header(uint16(rand.N(1<<16)), pullData)); err != nil {
+		return fmt.Errorf("failed to send PULL_DATA: %w", err)
+	}
+	return nil
+}
+
+func (f *Forwarder) readUp(conn net.Conn) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil || n < 4 || buf[0] != gwmpVersion || buf[3] != pushAck {
+			continue
+		}
+		token := binary.BigEndian.Uint16(buf[1:3])
+		f.mu.Lock()
+		if f.pushes[token] {
+			delete(f.pushes, token)
+			f.acked++
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *Forwarder) readDown(conn net.Conn) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil || n < 4 || buf[0] != gwmpVersion {
+			continue
+		}
+		// PULL_ACK only tells the route is open; nothing to do
+		if buf[3] != pullResp {
+			continue
+		}
+		token := binary.BigEndian.Uint16(buf[1:3])
+		if err := f.pullResp(conn, token, buf[4:n]); err != nil {
+			f.report(err)
+		}
+	}
+}
+
+// pullResp schedules the txpk in body and answers with a TX_ACK.
+func (f *Forwarder) pullResp(conn net.Conn, token uint16, body []byte) error {
+	var msg struct {
+		TxPk *txpk `json:"txpk"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil || msg.TxPk == nil {
+		return fmt.Errorf("%w: malformed PULL_RESP", ErrTxpk)
+	}
+	ack, err := f.schedule(msg.TxPk)
+	if err != nil {
+		return err
+	}
+	body, err = json.Marshal(map[string]txpkAck{"txpk_ack": ack})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(f.header(token, txAck), body...)); err != nil {
+		return fmt.Errorf("failed to send TX_ACK: %w", err)
+	}
+	return nil
+}
+
+// schedule hands tx to the gateway. Refusals become TX_ACK error codes;
+// only a txpk that cannot be understood is returned as an error.
+func (f *Forwarder) schedule(tx *txpk) (txpkAck, error) {
+	dl, err := f.downlink(tx)
+	if errors.Is(err, ErrNoGPS) {
+		return txpkAck{Error: "GPS_UNLOCKED"}, nil
+	}
+	if err != nil {
+		return txpkAck{}, err
+	}
+	h, err := f.gw.Schedule(dl)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTooLate):
+		return txpkAck{Error: "TOO_LATE"}, nil
+	case errors.Is(err, ErrTooEarly):
+		return txpkAck{Error: "TOO_EARLY"}, nil
+	case errors.Is(err, ErrCollision):
+		return txpkAck{Error: "COLLISION_PACKET"}, nil
+	case errors.Is(err, ErrTxFreq):
+		return txpkAck{Error: "TX_FREQ"}, nil
+	default:
+		return txpkAck{}, err
+	}
+	if h.Power != dl.Power {
+		return txpkAck{Warn: "TX_POWER", Value: h.Power}, nil
+	}
+	return txpkAck{Error: "NONE"}, nil
+}
+
+func (f *Forwarder) downlink(tx *txpk) (Downlink, error) {
+	if tx.Modu != "LORA" {
+		return Downlink{}, fmt.Errorf("%w: modulation %q", ErrTxpk, tx.Modu)
+	}
+	var rate string
+	if err := json.Unmarshal(tx.Datr, &rate); err != nil {
+		return Downlink{}, fmt.Errorf("%w: datr %s", ErrTxpk, tx.Datr)
+	}
+	sf, bw, err := parseDatr(rate)
+	if err != nil {
+		return Downlink{}, err
+	}
+	var denum uint8
+	if _, err := fmt.Sscanf(tx.Codr, "4/%d", &denum); err != nil || denum < 5 || denum > 8 {
+		return Downlink{}, fmt.Errorf("%w: codr %q", ErrTxpk, tx.Codr)
+	}
+	dl := Downlink{
+		Data:      tx.Data,
+		Frequency: physic.Frequency(math.Round(tx.Freq * 1e6)),
+		SF:        sf,
+		BW:        bw,
+		Denum:     denum,
+		Preamble:  tx.Prea,
+		Power:     tx.Powe,
+		InvertIQ:  tx.IPol,
+		NoCRC:     tx.NCRC,
+		Immediate: tx.Imme,
+	}
+	switch {
+	case tx.Imme:
+	case tx.Tmst != nil:
+		dl.Tmst = *tx.Tmst
+	case tx.Tmms != nil:
+		return Downlink{}, ErrNoGPS
+	default:
+		return Downlink{}, fmt.Errorf("%w: no tmst", ErrTxpk)
+	}
+	return dl, nil
+}
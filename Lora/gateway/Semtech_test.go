@@ -0,0 +1,283 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/lorawan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEUI = lorawan.EUI64{0xaa, 0x55, 0x5a, 0x00, 0x00, 0x00, 0x01, 0x01}
+
+// udpStub plays the network server side of GWMP.
+type udpStub struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+func newUDPStub(t *testing.T) *udpStub {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &udpStub{t: t, conn: conn}
+}
+
+type datagram struct {
+	from  *net.UDPAddr
+	token []byte
+	id    byte
+	eui   lorawan.EUI64
+	body  []byte
+}
+
+// next returns the next datagram with identifier id, skipping the others.
+func (s *udpStub) next(id byte) datagram {
+	s.t.Helper()
+	buf := make([]byte, maxDatagram)
+	require.NoError(s.t, s.conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		require.NoError(s.t, err)
+		require.GreaterOrEqual(s.t, n, 12)
+		assert.Equal(s.t, byte(gwmpVersion), buf[0])
+		if buf[3] != id {
+			continue
+		}
+		d := datagram{from: from, token: append([]byte(nil), buf[1:3]...), id: buf[3], body: append([]byte(nil), buf[12:n]...)}
+		copy(d.eui[:], buf[4:12])
+		return d
+	}
+}
+
+func (s *udpStub) send(to *net.UDPAddr, b []byte) {
+	s.t.Helper()
+	_, err := s.conn.WriteToUDP(b, to)
+	require.NoError(s.t, err)
+}
+
+func (s *udpStub) ack(d datagram, id byte) {
+	s.send(d.from, []byte{gwmpVersion, d.token[0], d.token[1], id})
+}
+
+func (s *udpStub) pullResp(to *net.UDPAddr, token uint16, txpk string) {
+	s.send(to, append([]byte{gwmpVersion, byte(token >> 8), byte(token), pullResp}, `{"txpk":`+txpk+`}`...))
+}
+
+func newTestForwarder(t *testing.T, stub *udpStub, stat time.Duration) (*Gateway, *fakeRadio) {
+	t.Helper()
+	return newTestForwarderConfig(t, stub, ForwarderConfig{StatInterval: stat})
+}
+
+func newTestForwarderConfig(t *testing.T, stub *udpStub, cfg ForwarderConfig) (*Gateway, *fakeRadio) {
+	t.Helper()
+	gw, radio := newTestGateway(t, Config{})
+	runGateway(t, gw)
+	cfg.GatewayEUI = testEUI
+	cfg.Server = stub.conn.LocalAddr().String()
+	cfg.Location = &Location{Latitude: -6.2, Longitude: 106.8, Altitude: 8}
+	f, err := NewForwarder(gw, cfg)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = f.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return gw, radio
+}
+
+func TestNewForwarder(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	_, err := NewForwarder(gw, ForwarderConfig{})
+	assert.ErrorContains(t, err, "no server")
+}
+
+func TestDatr(t *testing.T) {
+	tests := []struct {
+		datr string
+		sf   uint8
+		bw   uint64
+	}{
+		{datr: "SF7BW125", sf: 7, bw: 125000},
+		{datr: "SF12BW500", sf: 12, bw: 500000},
+		{datr: "SF8BW500", sf: 8, bw: 500000},
+		{datr: "SF10BW62.5", sf: 10, bw: 62500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.datr, func(t *testing.T) {
+			sf, bw, err := parseDatr(tt.datr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.sf, sf)
+			assert.Equal(t, tt.bw, bw)
+			assert.Equal(t, tt.datr, datr(sf, bw))
+		})
+	}
+	_, _, err := parseDatr("50000")
+	assert.ErrorIs(t, err, ErrTxpk)
+}
+
+func TestForwarder_Uplink(t *testing.T) {
+	stub := newUDPStub(t)
+	gw, _ := newTestForwarder(t, stub, time.Hour)
+
+	pull := stub.next(pullData)
+	assert.Equal(t, testEUI, pull.eui)
+	stub.ack(pull, pullAck)
+
+	received := time.Date(2026, 10, 19, 8, 30, 0, 123456000, time.UTC)
+	gw.HandlePacket(&SX1276.Packet{Data: []byte{0x40, 1, 2, 3}, RSSI: -97, SNR: -3.25, Received: received, Conf: testRX})
+	push := stub.next(pushData)
+	assert.Equal(t, testEUI, push.eui)
+	assert.JSONEq(t, `{"rxpk":[{
+		"time": "2026-10-19T08:30:00.123456Z", "tmst": `+jsonNumber(gw.Tmst(received))+`,
+		"chan": 0, "rfch": 0, "freq": 868.1, "stat": 1, "modu": "LORA",
+		"datr": "SF7BW125", "codr": "4/5", "rssi": -97, "lsnr": -3.25,
+		"size": 4, "data": "QAECAw=="
+	}]}`, string(push.body))
+}
+
+func TestForwarder_Downlink(t *testing.T) {
+	stub := newUDPStub(t)
+	gw, radio := newTestForwarder(t, stub, time.Hour)
+	pull := stub.next(pullData)
+	stub.ack(pull, pullAck)
+
+	tests := []struct {
+		name string
+		txpk string
+		ack  string
+	}{
+		{
+			name: "immediate",
+			txpk: `{"imme":true,"freq":869.525,"rfch":0,"powe":14,"modu":"LORA","datr":"SF9BW125","codr":"4/5","ipol":true,"size":3,"data":"AQID"}`,
+			ack:  `{"txpk_ack":{"error":"NONE"}}`,
+		},
+		{
+			name: "too late",
+			txpk: `{"tmst":` + jsonNumber(gw.Tmst(time.Now().Add(-time.Second))) + `,"freq":869.525,"powe":14,"modu":"LORA","datr":"SF9BW125","codr":"4/5","ipol":true,"data":"AQID"}`,
+			ack:  `{"txpk_ack":{"error":"TOO_LATE"}}`,
+		},
+		{
+			name: "too early",
+			txpk: `{"tmst":` + jsonNumber(gw.Tmst(time.Now().Add(time.Minute))) + `,"freq":869.525,"powe":14,"modu":"LORA","datr":"SF9BW125","codr":"4/5","ipol":true,"data":"AQID"}`,
+			ack:  `{"txpk_ack":{"error":"TOO_EARLY"}}`,
+		},
+		{
+			name: "frequency",
+			txpk: `{"imme":true,"freq":923.3,"powe":14,"modu":"LORA","datr":"SF9BW125","codr":"4/5","ipol":true,"data":"AQID"}`,
+			ack:  `{"txpk_ack":{"error":"TX_FREQ"}}`,
+		},
+		{
+			name: "gps time",
+			txpk: `{"tmms":1234567890000,"freq":869.525,"powe":14,"modu":"LORA","datr":"SF9BW125","codr":"4/5","ipol":true,"data":"AQID"}`,
+			ack:  `{"txpk_ack":{"error":"GPS_UNLOCKED"}}`,
+		},
+		{
+			name: "power",
+			txpk: `{"imme":true,"freq":869.525,"powe":27,"modu":"LORA","datr":"SF9BW125","codr":"4/5","ipol":true,"data":"AQID"}`,
+			ack:  `{"txpk_ack":{"warn":"TX_POWER","value":16}}`,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.pullResp(pull.from, uint16(i+1), tt.txpk)
+			ack := stub.next(txAck)
+			assert.Equal(t, []byte{0, byte(i + 1)}, ack.token)
+			assert.Equal(t, testEUI, ack.eui)
+			assert.JSONEq(t, tt.ack, string(ack.body))
+		})
+	}
+
+	require.Eventually(t, func() bool { return len(radio.frames()) == 2 }, 2*time.Second, time.Millisecond)
+	sent := radio.frames()[0]
+	assert.Equal(t, []byte{1, 2, 3}, sent.data)
+	assert.Equal(t, SX1276.LoraConf{
+		TxPower: 14, SF: 9, BW: 125000, Denum: 5, PreambleLength: 8, SyncWord: testRX.SyncWord,
+		Frequency: 869525000, Header: SX1276.Explicit, EnableCrc: true, InvertIQ: SX1276.InvertIQ{TX: true},
+	}, sent.conf)
+	assert.Equal(t, uint8(16), radio.frames()[1].conf.TxPower)
+}
+
+func TestForwarder_OnError(t *testing.T) {
+	stub := newUDPStub(t)
+	errs := make(chan error, 1)
+	newTestForwarderConfig(t, stub, ForwarderConfig{StatInterval: time.Hour, OnError: func(err error) { errs <- err }})
+	pull := stub.next(pullData)
+
+	stub.send(pull.from, []byte{gwmpVersion, 0, 1, pullResp, '{', '}'})
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrTxpk)
+	case <-time.After(2 * time.Second):
+		t.Fatal("malformed PULL_RESP was not reported")
+	}
+}
+
+func TestForwarder_TimedDownlink(t *testing.T) {
+	stub := newUDPStub(t)
+	gw, radio := newTestForwarder(t, stub, time.Hour)
+	pull := stub.next(pullData)
+
+	at := time.Now().Add(300 * time.Millisecond)
+	stub.pullResp(pull.from, 7, `{"tmst":`+jsonNumber(gw.Tmst(at))+`,"freq":869.525,"powe":14,"modu":"LORA","datr":"SF12BW125","codr":"4/5","ipol":true,"ncrc":true,"prea":10,"data":"AQID"}`)
+	assert.JSONEq(t, `{"txpk_ack":{"error":"NONE"}}`, string(stub.next(txAck).body))
+	require.Eventually(t, func() bool { return len(radio.frames()) == 1 }, 2*time.Second, time.Millisecond)
+	assert.False(t, time.Now().Before(at))
+	conf := radio.frames()[0].conf
+	assert.Equal(t, uint8(12), conf.SF)
+	assert.Equal(t, uint16(10), conf.PreambleLength)
+	assert.False(t, conf.EnableCrc)
+}
+
+func TestForwarder_Stats(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	f, err := NewForwarder(gw, ForwarderConfig{Server: "127.0.0.1:1700", Location: &Location{Latitude: -6.2, Longitude: 106.8, Altitude: 8}})
+	require.NoError(t, err)
+	gw.HandlePacket(&SX1276.Packet{Data: []byte{1}, Conf: testRX})
+	gw.HandleRxError(SX1276.ErrCrc)
+	_, err = gw.Schedule(testDownlink(time.Second, gw))
+	require.NoError(t, err)
+	gw.stats.TxSent++
+	f.rxfw, f.pushed, f.acked = 1, 4, 3
+
+	body, err := json.Marshal(f.stat(time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"time": "2026-10-19 08:30:00 GMT", "lati": -6.2, "long": 106.8, "alti": 8,
+		"rxnb": 2, "rxok": 1, "rxfw": 1, "ackr": 75, "dwnb": 1, "txnb": 1
+	}`, string(body))
+
+	body, err = json.Marshal(f.stat(time.Date(2026, 10, 19, 8, 30, 30, 0, time.UTC)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"time": "2026-10-19 08:30:30 GMT", "lati": -6.2, "long": 106.8, "alti": 8,
+		"rxnb": 0, "rxok": 0, "rxfw": 0, "ackr": 0, "dwnb": 0, "txnb": 0
+	}`, string(body), "counts are per interval")
+}
+
+func TestForwarder_StatsPushed(t *testing.T) {
+	stub := newUDPStub(t)
+	newTestForwarder(t, stub, 100*time.Millisecond)
+	var push struct {
+		Stat *gwStat `json:"stat"`
+	}
+	require.NoError(t, json.Unmarshal(stub.next(pushData).body, &push))
+	require.NotNil(t, push.Stat)
+	assert.Equal(t, 8, *push.Stat.Alti)
+}
+
+func jsonNumber(v uint32) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
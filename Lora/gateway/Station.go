@@ -0,0 +1,612 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/lorawan"
+	"github.com/gorilla/websocket"
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	defaultTimesync = time.Minute
+	defaultEIRP     = 14
+	// maxTimesyncRTT is the longest round trip a timesync answer is trusted
+	// after.
+	maxTimesyncRTT = 100 * time.Millisecond
+	xtimeBits      = 48
+	classC         = 2
+	// LoRaWAN message types the station reports on their own.
+	mtypeJoinRequest = 0
+	mtypeProprietary = 7
+)
+
+var (
+	ErrRouterInfo   = errors.New("router-info refused the gateway")
+	ErrNoRouterConf = errors.New("no router_config received")
+)
+
+// StationConfig describes the link to a LoRa Basics Station LNS; zero fields
+// take the defaults noted.
+type StationConfig struct {
+	GatewayEUI lorawan.EUI64
+	// URI is where router-info is asked for the traffic endpoint, such as
+	// wss://lns.example.com:8887.
+	URI string
+	// Dialer and Header carry TLS settings and credentials; Dialer defaults
+	// to websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+	Header http.Header
+	// TimesyncInterval is how often the LNS is asked for GPS time, once a
+	// minute by default.
+	TimesyncInterval time.Duration
+	// Station is reported in the version message, "GoLora" by default.
+	Station string
+	// OnError gets the errors Run carries on after, such as a dnmsg that
+	// could not be scheduled or a *DnmsgError for one that was never sent.
+	// It may be called from more than one goroutine; nil drops them.
+	OnError func(error)
+}
+
+// DnmsgError reports a downlink the LNS asked for that did not go out.
+type DnmsgError struct {
+	Diid int64
+	Err  error
+}
+
+func (e *DnmsgError) Error() string {
+	return fmt.Sprintf("dnmsg %d not sent: %v", e.Diid, e.Err)
+}
+
+func (e *DnmsgError) Unwrap() error {
+	return e.Err
+}
+
+func (c StationConfig) withDefaults() StationConfig {
+	if c.Dialer == nil {
+		c.Dialer = websocket.DefaultDialer
+	}
+	if c.TimesyncInterval <= 0 {
+		c.TimesyncInterval = defaultTimesync
+	}
+	if c.Station == "" {
+		c.Station = "GoLora"
+	}
+	return c
+}
+
+// id6 writes an EUI the way Basics Station names routers.
+func id6(eui lorawan.EUI64) string {
+	return fmt.Sprintf("%x:%x:%x:%x",
+		binary.BigEndian.Uint16(eui[0:]), binary.BigEndian.Uint16(eui[2:]),
+		binary.BigEndian.Uint16(eui[4:]), binary.BigEndian.Uint16(eui[6:]))
+}
+
+// euiString writes an EUI in the dashed form of jreq messages.
+func euiString(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+	return strings.Join(parts, "-")
+}
+
+type routerConfig struct {
+	Region    string   `json:"region"`
+	FreqRange [2]int64 `json:"freq_range"`
+	// DRs lists SF, bandwidth in kHz and a downlink-only flag per data rate.
+	DRs     [][3]int `json:"DRs"`
+	MaxEIRP *float64 `json:"max_eirp"`
+}
+
+// dr is the uplink data rate index of sf and bw, or -1.
+func (rc *routerConfig) dr(sf uint8, bw uint64) int {
+	for i, d := range rc.DRs {
+		if d[0] == int(sf) && uint64(d[1])*1000 == bw && d[2] == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+type upInfo struct {
+	RCtx    int64   `json:"rctx"`
+	XTime   int64   `json:"xtime"`
+	GPSTime int64   `json:"gpstime"`
+	FTS     int     `json:"fts"`
+	RSSI    float64 `json:"rssi"`
+	SNR     float64 `json:"snr"`
+	RxTime  float64 `json:"rxtime"`
+}
+
+type dnmsg struct {
+	DevEui   string `json:"DevEui"`
+	DC       int    `json:"dC"`
+	Diid     int64  `json:"diid"`
+	Pdu      string `json:"pdu"`
+	RxDelay  int    `json:"RxDelay"`
+	RX1DR    *int   `json:"RX1DR"`
+	RX1Freq  int64  `json:"RX1Freq"`
+	RX2DR    int    `json:"RX2DR"`
+	RX2Freq  int64  `json:"RX2Freq"`
+	XTime    int64  `json:"xtime"`
+	RCtx     int64  `json:"rctx"`
+	Priority int    `json:"priority"`
+}
+
+type dntxed struct {
+	MsgType string  `json:"msgtype"`
+	Diid    int64   `json:"diid"`
+	DevEui  string  `json:"DevEui"`
+	RCtx    int64   `json:"rctx"`
+	XTime   int64   `json:"xtime"`
+	TxTime  float64 `json:"txtime"`
+	GPSTime int64   `json:"gpstime"`
+	DR      int     `json:"DR"`
+	Freq    int64   `json:"Freq"`
+}
+
+type timesync struct {
+	MsgType string `json:"msgtype"`
+	TxTime  int64  `json:"txtime,omitempty"`
+	XTime   int64  `json:"xtime,omitempty"`
+	GPSTime int64  `json:"gpstime,omitempty"`
+}
+
+// Station connects a Gateway to a network server speaking the LoRa Basics
+// Station LNS protocol.
+type Station struct {
+	gw      *Gateway
+	cfg     StationConfig
+	session int64
+
+	// wmu serialises writes, which the websocket does not allow in
+	// parallel.
+	wmu  sync.Mutex
+	conn *websocket.Conn
+
+	mu      sync.Mutex
+	router  *routerConfig
+	muxTime float64
+	muxAt   time.Time
+	// gpsOffset turns the xtime counter into GPS time once timesync worked.
+	gpsOffset int64
+	hasGPS    bool
+}
+
+func NewStation(gw *Gateway, cfg StationConfig) (*Station, error) {
+	if cfg.URI == "" {
+		return nil, errors.New("config has no URI")
+	}
+	return &Station{gw: gw, cfg: cfg.withDefaults(), session: rand.Int64N(127) + 1}, nil
+}
+
+// xtime is the station's 64-bit time of t: microseconds since the gateway
+// was created, tagged with the session so the LNS can tell restarts apart.
+func (s *Station) xtime(t time.Time) int64 {
+	return s.session<<xtimeBits | t.Sub(s.gw.epoch).Microseconds()
+}
+
+// timeOf is the local time of an xtime from this session.
+func (s *Station) timeOf(xtime int64) (time.Time, bool) {
+	if xtime>>xtimeBits != s.session {
+		return time.Time{}, false
+	}
+	return s.gw.epoch.Add(time.Duration(xtime&(1<<xtimeBits-1)) * time.Microsecond), true
+}
+
+// gpsTime is the GPS time of t in microseconds, or 0 before timesync.
+func (s *Station) gpsTime(t time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hasGPS {
+		return 0
+	}
+	return t.Sub(s.gw.epoch).Microseconds() + s.gpsOffset
+}
+
+// discover asks router-info for the traffic endpoint.
+func (s *Station) discover(ctx context.Context) (string, error) {
+	uri := strings.TrimSuffix(strings.TrimSuffix(s.cfg.URI, "/"), "/router-info") + "/router-info"
+	conn, _, err := s.cfg.Dialer.DialContext(ctx, uri, s.cfg.Header)
+	if err != nil {
+		return "", fmt.Errorf("failed to dial %s: %w", uri, err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]string{"router": id6(s.cfg.GatewayEUI)}); err != nil {
+		return "", fmt.Errorf("failed to ask router-info: %w", err)
+	}
+	var info struct {
+		URI   string `json:"uri"`
+		Error string `json:"error"`
+	}
+	if err := conn.ReadJSON(&info); err != nil {
+		return "", fmt.Errorf("failed to read router-info: %w", err)
+	}
+	if info.Error != "" || info.URI == "" {
+		return "", fmt.Errorf("%w: %q", ErrRouterInfo, info.Error)
+	}
+	return info.URI, nil
+}
+
+// Run discovers the traffic endpoint, forwards uplinks and schedules
+// downlinks until ctx is done or the connection drops. The gateway itself
+// must be running.
+func (s *Station) Run(ctx context.Context) error {
+	uri, err := s.discover(ctx)
+	if err != nil {
+		return err
+	}
+	conn, _, err := s.cfg.Dialer.DialContext(ctx, uri, s.cfg.Header)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", uri, err)
+	}
+	defer conn.Close()
+	s.wmu.Lock()
+	s.conn = conn
+	s.wmu.Unlock()
+	if err := s.send(map[string]any{
+		"msgtype":  "version",
+		"station":  s.cfg.Station,
+		"firmware": "",
+		"package":  "",
+		"model":    "SX1276",
+		"protocol": 2,
+		"features": "",
+	}); err != nil {
+		return err
+	}
+
+	msgs := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	syncTick := time.NewTicker(s.cfg.TimesyncInterval)
+	defer syncTick.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			_ = s.close()
+			return ctx.Err()
+		case rerr := <-readErr:
+			return fmt.Errorf("connection to %s lost: %w", uri, rerr)
+		case msg := <-msgs:
+			err = s.handle(msg)
+		case u := <-s.gw.Uplinks():
+			err = s.uplink(u)
+		case <-syncTick.C:
+			err = s.send(timesync{MsgType: "timesync", TxTime: s.xtime(time.Now())})
+		}
+		if err != nil {
+			s.report(err)
+		}
+	}
+}
+
+func (s *Station) report(err error) {
+	if s.cfg.OnError != nil {
+		s.cfg.OnError(err)
+	}
+}
+
+func (s *Station) send(v any) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := s.conn.WriteJSON(v); err != nil {
+		return fmt.Errorf("failed to send to the LNS: %w", err)
+	}
+	return nil
+}
+
+func (s *Station) close() error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	return s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+// refTime is the LNS's MuxTime advanced by how long ago it came, or 0.
+func (s *Station) refTime(now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.muxAt.IsZero() {
+		return 0
+	}
+	return s.muxTime + now.Sub(s.muxAt).Seconds()
+}
+
+func (s *Station) handle(msg []byte) error {
+	var head struct {
+		MsgType string  `json:"msgtype"`
+		MuxTime float64 `json:"MuxTime"`
+	}
+	if err := json.Unmarshal(msg, &head); err != nil {
+		return fmt.Errorf("malformed LNS message: %w", err)
+	}
+	if head.MuxTime != 0 {
+		s.mu.Lock()
+		s.muxTime, s.muxAt = head.MuxTime, time.Now()
+		s.mu.Unlock()
+	}
+	switch head.MsgType {
+	case "router_config":
+		var rc routerConfig
+		if err := json.Unmarshal(msg, &rc); err != nil {
+			return fmt.Errorf("malformed router_config: %w", err)
+		}
+		if f := int64(s.gw.cfg.RX.Frequency); f < rc.FreqRange[0] || f > rc.FreqRange[1] {
+			s.report(fmt.Errorf("receive channel %d Hz is outside the %s range %d-%d Hz", f, rc.Region, rc.FreqRange[0], rc.FreqRange[1]))
+		}
+		s.mu.Lock()
+		s.router = &rc
+		s.mu.Unlock()
+	case "dnmsg":
+		var dm dnmsg
+		if err := json.Unmarshal(msg, &dm); err != nil {
+			return fmt.Errorf("malformed dnmsg: %w", err)
+		}
+		return s.downlink(dm)
+	case "timesync":
+		var ts timesync
+		if err := json.Unmarshal(msg, &ts); err != nil {
+			return fmt.Errorf("malformed timesync: %w", err)
+		}
+		s.timesync(ts, time.Now())
+	}
+	return nil
+}
+
+// timesync learns GPS time from an answer to our timesync, or from one the
+// LNS sent on its own for a given xtime.
+func (s *Station) timesync(ts timesync, now time.Time) {
+	if ts.GPSTime == 0 {
+		return
+	}
+	var local int64
+	switch {
+	case ts.XTime != 0:
+		t, ok := s.timeOf(ts.XTime)
+		if !ok {
+			return
+		}
+		local = t.Sub(s.gw.epoch).Microseconds()
+	case ts.TxTime != 0:
+		sent, ok := s.timeOf(ts.TxTime)
+		if !ok || now.Sub(sent) > maxTimesyncRTT {
+			return
+		}
+		local = (sent.Sub(s.gw.epoch) + now.Sub(sent)/2).Microseconds()
+	default:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gpsOffset, s.hasGPS = ts.GPSTime-local, true
+}
+
+// uplink reports u as a jreq, updf or propdf message.
+func (s *Station) uplink(u Uplink) error {
+	s.mu.Lock()
+	rc := s.router
+	s.mu.Unlock()
+	if rc == nil {
+		return fmt.Errorf("%w: uplink dropped", ErrNoRouterConf)
+	}
+	p := u.Packet
+	info := upInfo{
+		XTime:   s.xtime(p.Received),
+		GPSTime: s.gpsTime(p.Received),
+		FTS:     -1,
+		RSSI:    float64(p.RSSI),
+		SNR:     p.SNR,
+		RxTime:  float64(p.Received.UnixMicro()) / 1e6,
+	}
+	msg, err := frameFields(p.Data)
+	if err != nil {
+		return err
+	}
+	msg["DR"] = rc.dr(p.Conf.SF, p.Conf.BW)
+	msg["Freq"] = int64(p.Conf.Frequency)
+	msg["upinfo"] = info
+	msg["RefTime"] = s.refTime(time.Now())
+	return s.send(msg)
+}
+
+// frameFields splits a LoRaWAN frame into the fields Basics Station sends.
+func frameFields(phy []byte) (map[string]any, error) {
+	if len(phy) < 5 {
+		return nil, fmt.Errorf("frame of %d bytes is too short", len(phy))
+	}
+	mhdr := phy[0]
+	mic := int32(binary.LittleEndian.Uint32(phy[len(phy)-4:]))
+	switch mtype := mhdr >> 5; {
+	case mtype == mtypeJoinRequest:
+		if len(phy) != 23 {
+			return nil, fmt.Errorf("join request of %d bytes", len(phy))
+		}
+		return map[string]any{
+			"msgtype":  "jreq",
+			"MHdr":     mhdr,
+			"JoinEui":  euiString(reversedBytes(phy[1:9])),
+			"DevEui":   euiString(reversedBytes(phy[9:17])),
+			"DevNonce": binary.LittleEndian.Uint16(phy[17:19]),
+			"MIC":      mic,
+		}, nil
+	case mtype == 2 || mtype == 4:
+		if len(phy) < 12 {
+			return nil, fmt.Errorf("data frame of %d bytes", len(phy))
+		}
+		fctrl := phy[5]
+		end := 8 + int(fctrl&0x0f)
+		if end > len(phy)-4 {
+			return nil, fmt.Errorf("FOpts overrun a %d byte frame", len(phy))
+		}
+		port, payload := -1, []byte{}
+		if end < len(phy)-4 {
+			port, payload = int(phy[end]), phy[end+1:len(phy)-4]
+		}
+		return map[string]any{
+			"msgtype":    "updf",
+			"MHdr":       mhdr,
+			"DevAddr":    int32(binary.LittleEndian.Uint32(phy[1:5])),
+			"FCtrl":      fctrl,
+			"FCnt":       binary.LittleEndian.Uint16(phy[6:8]),
+			"FOpts":      hex.EncodeToString(phy[8:end]),
+			"FPort":      port,
+			"FRMPayload": hex.EncodeToString(payload),
+			"MIC":        mic,
+		}, nil
+	case mtype == mtypeProprietary:
+		return map[string]any{"msgtype": "propdf", "FRMPayload": hex.EncodeToString(phy)}, nil
+	}
+	return nil, fmt.Errorf("MHDR 0x%02X is not an uplink", mhdr)
+}
+
+func reversedBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, v := range b {
+		r[len(b)-1-i] = v
+	}
+	return r
+}
+
+// downlink schedules dm in RX1, or in RX2 when RX1 cannot be made, and
+// confirms it with dntxed once sent. Class C downlinks without an uplink to
+// answer go out in RX2 right away.
+func (s *Station) downlink(dm dnmsg) error {
+	s.mu.Lock()
+	rc := s.router
+	s.mu.Unlock()
+	if rc == nil {
+		return fmt.Errorf("%w: dnmsg %d dropped", ErrNoRouterConf, dm.Diid)
+	}
+	pdu, err := hex.DecodeString(dm.Pdu)
+	if err != nil {
+		return fmt.Errorf("dnmsg %d has a malformed pdu: %w", dm.Diid, err)
+	}
+
+	type window struct {
+		dr   int
+		freq int64
+		at   time.Time
+	}
+	var windows []window
+	if dm.XTime != 0 {
+		up, ok := s.timeOf(dm.XTime)
+		if !ok {
+			return fmt.Errorf("dnmsg %d answers an uplink from another session", dm.Diid)
+		}
+		delay := time.Duration(max(dm.RxDelay, 1)) * time.Second
+		if dm.RX1DR != nil {
+			windows = append(windows, window{dr: *dm.RX1DR, freq: dm.RX1Freq, at: up.Add(delay)})
+		}
+		windows = append(windows, window{dr: dm.RX2DR, freq: dm.RX2Freq, at: up.Add(delay + time.Second)})
+	} else if dm.DC == classC {
+		windows = append(windows, window{dr: dm.RX2DR, freq: dm.RX2Freq})
+	} else {
+		return fmt.Errorf("dnmsg %d has no xtime", dm.Diid)
+	}
+
+	var errs []error
+	for _, w := range windows {
+		dl, err := s.downlinkAt(rc, pdu, w.dr, w.freq)
+		if err != nil {
+			return fmt.Errorf("dnmsg %d: %w", dm.Diid, err)
+		}
+		if w.at.IsZero() {
+			dl.Immediate = true
+		} else {
+			dl.Tmst = s.gw.Tmst(w.at)
+		}
+		h, err := s.gw.Schedule(dl)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		go s.confirm(dm, h, w.dr, w.freq)
+		return nil
+	}
+	return fmt.Errorf("dnmsg %d not scheduled: %w", dm.Diid, errors.Join(errs...))
+}
+
+func (s *Station) downlinkAt(rc *routerConfig, pdu []byte, dr int, freq int64) (Downlink, error) {
+	if dr < 0 || dr >= len(rc.DRs) || !loraDR(rc.DRs[dr]) {
+		return Downlink{}, fmt.Errorf("unknown DR%d", dr)
+	}
+	if freq < rc.FreqRange[0] || freq > rc.FreqRange[1] {
+		return Downlink{}, fmt.Errorf("%w: %d Hz outside %s", ErrTxFreq, freq, rc.Region)
+	}
+	power := float64(defaultEIRP)
+	switch {
+	case rc.MaxEIRP != nil:
+		power = *rc.MaxEIRP
+	case s.gw.cfg.Region != nil:
+		power = s.gw.cfg.Region.MaxEIRP
+	}
+	return Downlink{
+		Data:      pdu,
+		Frequency: physic.Frequency(freq),
+		SF:        uint8(rc.DRs[dr][0]),
+		BW:        uint64(rc.DRs[dr][1]) * 1000,
+		Denum:     5,
+		Power:     power,
+		InvertIQ:  true,
+		NoCRC:     true,
+	}, nil
+}
+
+// loraDR reports whether a router_config DR entry is a LoRa rate the radio
+// can send; FSK is [0,0,0] and undefined rates are [-1,0,0].
+func loraDR(dr [3]int) bool {
+	switch dr[1] {
+	case 125, 250, 500:
+		return dr[0] >= 7 && dr[0] <= 12
+	}
+	return false
+}
+
+// confirm sends dntxed once the downlink left the antenna.
+func (s *Station) confirm(dm dnmsg, h *TxHandle, dr int, freq int64) {
+	sent, err := h.Wait(context.Background())
+	if err != nil {
+		s.report(&DnmsgError{Diid: dm.Diid, Err: err})
+		return
+	}
+	if err := s.send(dntxed{
+		MsgType: "dntxed",
+		Diid:    dm.Diid,
+		DevEui:  dm.DevEui,
+		RCtx:    dm.RCtx,
+		XTime:   s.xtime(h.At),
+		TxTime:  float64(sent.UnixMicro()) / 1e6,
+		GPSTime: s.gpsTime(h.At),
+		DR:      dr,
+		Freq:    freq,
+	}); err != nil {
+		s.report(fmt.Errorf("failed to confirm dnmsg %d: %w", dm.Diid, err))
+	}
+}
@@ -0,0 +1,410 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lnsStub stands in for a Basics Station LNS; router-info points stations at
+// its own traffic endpoint.
+type lnsStub struct {
+	srv    *httptest.Server
+	refuse string
+	router chan string
+	in     chan map[string]any
+	conn   chan *websocket.Conn
+}
+
+func newLNSStub(t *testing.T, refuse string) *lnsStub {
+	t.Helper()
+	s := &lnsStub{
+		refuse: refuse,
+		router: make(chan string, 1),
+		in:     make(chan map[string]any, 64),
+		conn:   make(chan *websocket.Conn, 1),
+	}
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/router-info", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req struct {
+			Router string `json:"router"`
+		}
+		if conn.ReadJSON(&req) != nil {
+			return
+		}
+		s.router <- req.Router
+		if s.refuse != "" {
+			_ = conn.WriteJSON(map[string]string{"router": req.Router, "error": s.refuse})
+			return
+		}
+		_ = conn.WriteJSON(map[string]string{
+			"router": req.Router,
+			"muxs":   "muxs-::0",
+			"uri":    "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/traffic/" + req.Router,
+		})
+	})
+	mux.HandleFunc("/traffic/", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.conn <- conn
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			dec := json.NewDecoder(bytes.NewReader(msg))
+			dec.UseNumber()
+			var m map[string]any
+			if dec.Decode(&m) == nil {
+				s.in <- m
+			}
+		}
+	})
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func (s *lnsStub) uri() string {
+	return "ws" + strings.TrimPrefix(s.srv.URL, "http")
+}
+
+// next returns the next message of msgtype, skipping the others.
+func (s *lnsStub) next(t *testing.T, msgtype string) map[string]any {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case m := <-s.in:
+			if m["msgtype"] == msgtype {
+				return m
+			}
+		case <-timeout:
+			require.FailNow(t, "no "+msgtype+" message")
+		}
+	}
+}
+
+func num(t *testing.T, v any) int64 {
+	t.Helper()
+	n, ok := v.(json.Number)
+	require.True(t, ok, "%v is not a number", v)
+	i, err := n.Int64()
+	require.NoError(t, err)
+	return i
+}
+
+var testRouterConfig = map[string]any{
+	"msgtype":    "router_config",
+	"region":     "EU863",
+	"hwspec":     "sx1301/1",
+	"freq_range": []int64{863000000, 870000000},
+	"DRs": [][3]int{
+		{12, 125, 0}, {11, 125, 0}, {10, 125, 0}, {9, 125, 0},
+		{8, 125, 0}, {7, 125, 0}, {7, 250, 0}, {0, 0, 0},
+	},
+	"MuxTime": 1760862600.5,
+}
+
+// newTestStation runs a gateway and a station connected to lns, with the
+// router_config already applied.
+func newTestStation(t *testing.T, lns *lnsStub, cfg StationConfig) (*Station, *Gateway, *fakeRadio, *websocket.Conn) {
+	t.Helper()
+	gw, radio := newTestGateway(t, Config{})
+	// leave room for uplinks dated back before the test started
+	gw.epoch = gw.epoch.Add(-time.Minute)
+	runGateway(t, gw)
+	cfg.GatewayEUI = testEUI
+	cfg.URI = lns.uri()
+	st, err := NewStation(gw, cfg)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = st.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn := <-lns.conn
+	require.NoError(t, conn.WriteJSON(testRouterConfig))
+	require.Eventually(t, func() bool {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.router != nil
+	}, time.Second, time.Millisecond)
+	return st, gw, radio, conn
+}
+
+func TestNewStation(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	_, err := NewStation(gw, StationConfig{})
+	assert.ErrorContains(t, err, "no URI")
+}
+
+func TestID6(t *testing.T) {
+	assert.Equal(t, "aa55:5a00:0:101", id6(testEUI))
+}
+
+func TestFrameFields(t *testing.T) {
+	tests := []struct {
+		name string
+		phy  []byte
+		want map[string]any
+		err  string
+	}{
+		{
+			name: "data",
+			phy:  []byte{0x40, 0x01, 0x02, 0x03, 0x04, 0x01, 0x0a, 0x00, 0x06, 0x01, 0xaa, 0xbb, 0x01, 0x02, 0x03, 0x84},
+			want: map[string]any{
+				"msgtype": "updf", "MHdr": byte(0x40), "DevAddr": int32(0x04030201), "FCtrl": byte(0x01),
+				"FCnt": uint16(10), "FOpts": "06", "FPort": 1, "FRMPayload": "aabb", "MIC": int32(-2080177663),
+			},
+		},
+		{
+			name: "no port",
+			phy:  []byte{0x80, 0x01, 0x02, 0x03, 0x04, 0x00, 0x01, 0x00, 0x01, 0x02, 0x03, 0x04},
+			want: map[string]any{
+				"msgtype": "updf", "MHdr": byte(0x80), "DevAddr": int32(0x04030201), "FCtrl": byte(0),
+				"FCnt": uint16(1), "FOpts": "", "FPort": -1, "FRMPayload": "", "MIC": int32(0x04030201),
+			},
+		},
+		{
+			name: "join request",
+			phy: []byte{0x00,
+				0x02, 0x00, 0x00, 0xf0, 0x7e, 0xd5, 0xb3, 0x70,
+				0x01, 0x00, 0x00, 0xd0, 0x7e, 0xd5, 0xb3, 0x70,
+				0x34, 0x12, 0x01, 0x02, 0x03, 0x04},
+			want: map[string]any{
+				"msgtype": "jreq", "MHdr": byte(0), "JoinEui": "70-B3-D5-7E-F0-00-00-02", "DevEui": "70-B3-D5-7E-D0-00-00-01",
+				"DevNonce": uint16(0x1234), "MIC": int32(0x04030201),
+			},
+		},
+		{name: "proprietary", phy: []byte{0xe0, 1, 2, 3, 4}, want: map[string]any{"msgtype": "propdf", "FRMPayload": "e001020304"}},
+		{name: "short", phy: []byte{0x40, 1}, err: "too short"},
+		{name: "downlink", phy: []byte{0x60, 1, 2, 3, 4, 0, 0, 0, 1, 2, 3, 4}, err: "not an uplink"},
+		{name: "FOpts overrun", phy: []byte{0x40, 1, 2, 3, 4, 0x0f, 0, 0, 1, 2, 3, 4}, err: "FOpts overrun"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := frameFields(tt.phy)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStation_RouterInfoRefused(t *testing.T) {
+	lns := newLNSStub(t, "unknown router")
+	gw, _ := newTestGateway(t, Config{})
+	st, err := NewStation(gw, StationConfig{GatewayEUI: testEUI, URI: lns.uri() + "/router-info"})
+	require.NoError(t, err)
+	err = st.Run(context.Background())
+	assert.ErrorIs(t, err, ErrRouterInfo)
+	assert.ErrorContains(t, err, "unknown router")
+	assert.Equal(t, "aa55:5a00:0:101", <-lns.router)
+}
+
+func TestStation_Uplink(t *testing.T) {
+	lns := newLNSStub(t, "")
+	st, gw, _, _ := newTestStation(t, lns, StationConfig{TimesyncInterval: time.Hour})
+	version := lns.next(t, "version")
+	assert.Equal(t, "GoLora", version["station"])
+	assert.Equal(t, json.Number("2"), version["protocol"])
+
+	received := time.Now()
+	phy := []byte{0x40, 0x01, 0x02, 0x03, 0x04, 0x00, 0x0a, 0x00, 0x01, 0xaa, 0x01, 0x02, 0x03, 0x04}
+	gw.HandlePacket(&SX1276.Packet{Data: phy, RSSI: -101, SNR: 7.5, Received: received, Conf: testRX})
+	up := lns.next(t, "updf")
+	assert.Equal(t, int64(0x04030201), num(t, up["DevAddr"]))
+	assert.Equal(t, int64(10), num(t, up["FCnt"]))
+	assert.Equal(t, int64(1), num(t, up["FPort"]))
+	assert.Equal(t, "aa", up["FRMPayload"])
+	assert.Equal(t, int64(5), num(t, up["DR"]))
+	assert.Equal(t, int64(868100000), num(t, up["Freq"]))
+	ref, err := up["RefTime"].(json.Number).Float64()
+	require.NoError(t, err)
+	assert.InDelta(t, 1760862600.5, ref, 1)
+
+	info := up["upinfo"].(map[string]any)
+	assert.Equal(t, st.xtime(received), num(t, info["xtime"]))
+	assert.Equal(t, json.Number("-101"), info["rssi"])
+	assert.Equal(t, json.Number("7.5"), info["snr"])
+	assert.Equal(t, int64(0), num(t, info["gpstime"]), "no GPS time before timesync")
+}
+
+func TestStation_Downlink(t *testing.T) {
+	lns := newLNSStub(t, "")
+	st, gw, radio, conn := newTestStation(t, lns, StationConfig{TimesyncInterval: time.Hour})
+	phy := []byte{0x40, 0x01, 0x02, 0x03, 0x04, 0x00, 0x0a, 0x00, 0x01, 0x02, 0x03, 0x04}
+	rx1, rx2 := 5, 0
+
+	tests := []struct {
+		name  string
+		ago   time.Duration
+		class int
+		dr    int
+		freq  int64
+		after time.Duration
+	}{
+		{name: "RX1", ago: 700 * time.Millisecond, dr: rx1, freq: 868100000, after: time.Second},
+		{name: "RX2 when RX1 is too late", ago: 1200 * time.Millisecond, dr: rx2, freq: 869525000, after: 2 * time.Second},
+		{name: "class C", class: classC, dr: rx2, freq: 869525000},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := map[string]any{
+				"msgtype": "dnmsg", "DevEui": "00-00-00-00-00-00-00-01", "dC": tt.class, "diid": 100 + i,
+				"pdu": "60aabb", "RxDelay": 1, "RX2DR": rx2, "RX2Freq": 869525000, "rctx": 0, "priority": 0,
+			}
+			var xtime int64
+			if tt.class != classC {
+				gw.HandlePacket(&SX1276.Packet{Data: phy, Received: time.Now().Add(-tt.ago), Conf: testRX})
+				xtime = num(t, lns.next(t, "updf")["upinfo"].(map[string]any)["xtime"])
+				dm["xtime"], dm["RX1DR"], dm["RX1Freq"] = xtime, rx1, 868100000
+			}
+			require.NoError(t, conn.WriteJSON(dm))
+
+			tx := lns.next(t, "dntxed")
+			assert.Equal(t, int64(100+i), num(t, tx["diid"]))
+			assert.Equal(t, "00-00-00-00-00-00-00-01", tx["DevEui"])
+			assert.Equal(t, int64(tt.dr), num(t, tx["DR"]))
+			assert.Equal(t, tt.freq, num(t, tx["Freq"]))
+			if tt.after != 0 {
+				assert.InDelta(t, xtime+tt.after.Microseconds(), num(t, tx["xtime"]), 2)
+			}
+			at, ok := st.timeOf(num(t, tx["xtime"]))
+			require.True(t, ok)
+			assert.False(t, time.Now().Before(at))
+
+			frames := radio.frames()
+			require.Len(t, frames, i+1)
+			sent := frames[i]
+			assert.Equal(t, []byte{0x60, 0xaa, 0xbb}, sent.data)
+			assert.Equal(t, SX1276.InvertIQ{TX: true}, sent.conf.InvertIQ)
+			assert.False(t, sent.conf.EnableCrc)
+			assert.Equal(t, uint8(16), sent.conf.TxPower)
+			assert.Equal(t, tt.freq, int64(sent.conf.Frequency))
+		})
+	}
+}
+
+func TestStation_OnError(t *testing.T) {
+	lns := newLNSStub(t, "")
+	errs := make(chan error, 4)
+	_, _, radio, conn := newTestStation(t, lns, StationConfig{TimesyncInterval: time.Hour, OnError: func(err error) { errs <- err }})
+	next := func() error {
+		t.Helper()
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(3 * time.Second):
+			require.FailNow(t, "no error reported")
+			return nil
+		}
+	}
+	dnmsg := func(diid int) map[string]any {
+		return map[string]any{
+			"msgtype": "dnmsg", "DevEui": "00-00-00-00-00-00-00-01", "dC": classC, "diid": diid,
+			"pdu": "60aabb", "RX2DR": 0, "RX2Freq": 869525000, "rctx": 0, "priority": 0,
+		}
+	}
+
+	require.NoError(t, conn.WriteJSON(map[string]any{"msgtype": "dnmsg", "diid": "x"}))
+	assert.ErrorContains(t, next(), "malformed dnmsg")
+
+	radio.mu.Lock()
+	radio.sendErr = assert.AnError
+	radio.mu.Unlock()
+	require.NoError(t, conn.WriteJSON(dnmsg(42)))
+	var dnErr *DnmsgError
+	err := next()
+	require.ErrorAs(t, err, &dnErr)
+	assert.Equal(t, int64(42), dnErr.Diid)
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestStation_DownlinkAt(t *testing.T) {
+	gw, _ := newTestGateway(t, Config{})
+	st := &Station{gw: gw}
+	rc := &routerConfig{
+		Region:    "EU863",
+		FreqRange: [2]int64{863000000, 870000000},
+		DRs:       [][3]int{{12, 125, 0}, {7, 500, 0}, {0, 0, 0}, {-1, 0, 0}, {6, 125, 0}, {7, 100, 0}, {13, 125, 0}},
+	}
+	tests := []struct {
+		dr   int
+		sf   uint8
+		bw   uint64
+		want string
+	}{
+		{dr: 0, sf: 12, bw: 125000},
+		{dr: 1, sf: 7, bw: 500000},
+		{dr: 2, want: "unknown DR2"},
+		{dr: 3, want: "unknown DR3"},
+		{dr: 4, want: "unknown DR4"},
+		{dr: 5, want: "unknown DR5"},
+		{dr: 6, want: "unknown DR6"},
+		{dr: 7, want: "unknown DR7"},
+	}
+	for _, tt := range tests {
+		dl, err := st.downlinkAt(rc, []byte{1}, tt.dr, 869525000)
+		if tt.want != "" {
+			assert.EqualError(t, err, tt.want)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tt.sf, dl.SF)
+		assert.Equal(t, tt.bw, dl.BW)
+	}
+}
+
+func TestStation_Timesync(t *testing.T) {
+	lns := newLNSStub(t, "")
+	st, gw, _, conn := newTestStation(t, lns, StationConfig{TimesyncInterval: 50 * time.Millisecond})
+	req := lns.next(t, "timesync")
+	txtime := num(t, req["txtime"])
+	_, ok := st.timeOf(txtime)
+	require.True(t, ok)
+
+	const gps = int64(1_444_000_000_000_000)
+	require.NoError(t, conn.WriteJSON(map[string]any{"msgtype": "timesync", "txtime": txtime, "gpstime": gps}))
+	require.Eventually(t, func() bool { return st.gpsTime(time.Now()) != 0 }, time.Second, time.Millisecond)
+
+	received := time.Now()
+	gw.HandlePacket(&SX1276.Packet{Data: []byte{0xe0, 1, 2, 3, 4}, Received: received, Conf: testRX})
+	up := lns.next(t, "propdf")
+	sent := txtime & (1<<xtimeBits - 1)
+	want := gps + received.Sub(gw.epoch).Microseconds() - sent
+	assert.InDelta(t, want, num(t, up["upinfo"].(map[string]any)["gpstime"]), float64(maxTimesyncRTT.Microseconds()))
+
+	// the LNS may also tie GPS time to an xtime on its own
+	xtime := st.xtime(received)
+	require.NoError(t, conn.WriteJSON(map[string]any{"msgtype": "timesync", "xtime": xtime, "gpstime": gps}))
+	require.Eventually(t, func() bool { return st.gpsTime(received) == gps }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,173 @@
+package linkbudget
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/airtime"
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	// noiseFigure matches the one the airtime sensitivities assume.
+	noiseFigure  = 6
+	thermalNoise = -174
+	speedOfLight = 299792458
+
+	minRange = 1
+	maxRange = 1000e3
+)
+
+var ErrNoDataRate = errors.New("no SF/BW meets the target margin")
+
+// DefaultBandwidths are the bandwidths Suggest tries when given none.
+var DefaultBandwidths = []uint64{uint64(SX1276.BW_7), uint64(SX1276.BW_8), 500000}
+
+// Model is a propagation model. PathLoss is in dB for a distance in metres
+// and freq in plain hertz, like LoraConf.Frequency.
+type Model interface {
+	PathLoss(distance float64, freq physic.Frequency) float64
+}
+
+type FreeSpace struct{}
+
+func (FreeSpace) PathLoss(distance float64, freq physic.Frequency) float64 {
+	return 20*math.Log10(distance) + 20*math.Log10(float64(freq)) + 20*math.Log10(4*math.Pi/speedOfLight)
+}
+
+// Hata is the Okumura-Hata model for small and medium cities. Antenna heights
+// are in metres; it is defined for 150-1500 MHz, 1-20 km, base station
+// heights of 30-200 m and mobile heights of 1-10 m, and extrapolated outside.
+type Hata struct {
+	BaseHeight   float64
+	MobileHeight float64
+	Suburban     bool
+}
+
+func (h Hata) PathLoss(distance float64, freq physic.Frequency) float64 {
+	f := float64(freq) / 1e6
+	logF := math.Log10(f)
+	ahm := (1.1*logF-0.7)*h.MobileHeight - (1.56*logF - 0.8)
+	loss := 69.55 + 26.16*logF - 13.82*math.Log10(h.BaseHeight) - ahm +
+		(44.9-6.55*math.Log10(h.BaseHeight))*math.Log10(distance/1e3)
+	if h.Suburban {
+		loss -= 2*math.Pow(math.Log10(f/28), 2) + 5.4
+	}
+	return loss
+}
+
+// TwoRay is the plane-earth model: free space up to the breakpoint distance,
+// then the direct and ground-reflected rays cancel and loss grows 40 dB per
+// decade. Antenna heights are in metres.
+type TwoRay struct {
+	TxHeight float64
+	RxHeight float64
+}
+
+func (tr TwoRay) PathLoss(distance float64, freq physic.Frequency) float64 {
+	lambda := speedOfLight / float64(freq)
+	breakpoint := 4 * math.Pi * tr.TxHeight * tr.RxHeight / lambda
+	if distance < breakpoint {
+		return FreeSpace{}.PathLoss(distance, freq)
+	}
+	return 40*math.Log10(distance) - 20*math.Log10(tr.TxHeight) - 20*math.Log10(tr.RxHeight)
+}
+
+// Link is everything between the transmitter's PA and the receiver's LNA.
+// Gains are in dBi and losses in dB.
+type Link struct {
+	TxAntennaGain float64
+	RxAntennaGain float64
+	TxCableLoss   float64
+	RxCableLoss   float64
+	Model         Model
+}
+
+type Estimate struct {
+	// RSSI is the expected received power in dBm.
+	RSSI        float64
+	SNR         float64
+	Sensitivity float64
+	// Margin is how far RSSI is above Sensitivity, which is also the SNR
+	// margin over the demodulator limit.
+	Margin float64
+	// MaxRange is the distance in metres at which Margin reaches zero.
+	MaxRange float64
+}
+
+// allowedLoss is the largest path loss conf can close with the given margin.
+func (l Link) allowedLoss(conf SX1276.LoraConf, margin float64) float64 {
+	eirp := float64(conf.TxPower) + l.TxAntennaGain - l.TxCableLoss
+	return eirp + l.RxAntennaGain - l.RxCableLoss - airtime.Sensitivity(conf.SF, conf.BW) - margin
+}
+
+// Estimate works out the link for conf's TxPower, SF, BW and Frequency at
+// distance metres.
+func (l Link) Estimate(conf SX1276.LoraConf, distance float64) Estimate {
+	sens := airtime.Sensitivity(conf.SF, conf.BW)
+	rssi := float64(conf.TxPower) + l.TxAntennaGain - l.TxCableLoss -
+		l.Model.PathLoss(distance, conf.Frequency) + l.RxAntennaGain - l.RxCableLoss
+	noise := thermalNoise + 10*math.Log10(float64(conf.BW)) + noiseFigure
+	return Estimate{
+		RSSI:        rssi,
+		SNR:         rssi - noise,
+		Sensitivity: sens,
+		Margin:      rssi - sens,
+		MaxRange:    l.MaxRange(conf, 0),
+	}
+}
+
+// MaxRange is the distance in metres at which conf still has margin dB to
+// spare, searched between 1 m and 1000 km.
+func (l Link) MaxRange(conf SX1276.LoraConf, margin float64) float64 {
+	budget := l.allowedLoss(conf, margin)
+	if l.Model.PathLoss(minRange, conf.Frequency) > budget {
+		return 0
+	}
+	if l.Model.PathLoss(maxRange, conf.Frequency) <= budget {
+		return maxRange
+	}
+	// bisect in log distance; every model is monotonic in distance
+	lo, hi := math.Log10(minRange), math.Log10(maxRange)
+	for i := 0; i < 60; i++ {
+		mid := (lo + hi) / 2
+		if l.Model.PathLoss(math.Pow(10, mid), conf.Frequency) <= budget {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return math.Pow(10, lo)
+}
+
+// Suggest returns conf with the fastest SF/BW from bandwidths, or
+// DefaultBandwidths if nil, whose margin at distance is at least target.
+func (l Link) Suggest(conf SX1276.LoraConf, distance float64, target float64, bandwidths []uint64) (SX1276.LoraConf, Estimate, error) {
+	if bandwidths == nil {
+		bandwidths = DefaultBandwidths
+	}
+	denum := conf.Denum
+	if denum < 5 {
+		denum = 5
+	}
+	var candidates []SX1276.LoraConf
+	for _, bw := range bandwidths {
+		for sf := uint8(7); sf <= 12; sf++ {
+			c := conf
+			c.SF, c.BW = sf, bw
+			candidates = append(candidates, c)
+		}
+	}
+	bitrate := func(c SX1276.LoraConf) float64 {
+		return airtime.Params{SF: c.SF, BW: c.BW, Denum: denum}.Bitrate()
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return bitrate(candidates[i]) > bitrate(candidates[j]) })
+	for _, c := range candidates {
+		if est := l.Estimate(c, distance); est.Margin >= target {
+			return c, est, nil
+		}
+	}
+	return conf, Estimate{}, ErrNoDataRate
+}
@@ -0,0 +1,94 @@
+package linkbudget
+
+import (
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModels_PathLoss(t *testing.T) {
+	urban := Hata{BaseHeight: 30, MobileHeight: 1.5}
+	suburban := Hata{BaseHeight: 30, MobileHeight: 1.5, Suburban: true}
+	twoRay := TwoRay{TxHeight: 10, RxHeight: 2}
+	tests := []struct {
+		name     string
+		model    Model
+		distance float64
+		want     float64
+	}{
+		{name: "free space 1 km", model: FreeSpace{}, distance: 1e3, want: 91.22},
+		{name: "free space 10 km", model: FreeSpace{}, distance: 10e3, want: 111.22},
+		{name: "hata urban 1 km", model: urban, distance: 1e3, want: 126.0},
+		{name: "hata urban 10 km", model: urban, distance: 10e3, want: 161.2},
+		{name: "hata suburban 1 km", model: suburban, distance: 1e3, want: 116.2},
+		{name: "two-ray before breakpoint", model: twoRay, distance: 100, want: 71.22},
+		{name: "two-ray after breakpoint", model: twoRay, distance: 10e3, want: 133.98},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, tt.model.PathLoss(tt.distance, 868000000), 0.1)
+		})
+	}
+}
+
+func newConf(sf uint8, bw uint64) SX1276.LoraConf {
+	return SX1276.LoraConf{TxPower: 14, SF: sf, BW: bw, Denum: 5, PreambleLength: 8, Frequency: 868000000}
+}
+
+func TestLink_Estimate(t *testing.T) {
+	l := Link{TxAntennaGain: 2, RxAntennaGain: 3, TxCableLoss: 1, RxCableLoss: 1, Model: FreeSpace{}}
+	est := l.Estimate(newConf(7, 125000), 1e3)
+	assert.InDelta(t, -74.22, est.RSSI, 0.05)
+	assert.InDelta(t, -124.5, est.Sensitivity, 0.05)
+	assert.InDelta(t, 50.28, est.Margin, 0.05)
+	assert.InDelta(t, est.Margin-7.5, est.SNR, 0.05)
+	assert.InDelta(t, 0, l.Estimate(newConf(7, 125000), est.MaxRange).Margin, 0.01)
+
+	slow := l.Estimate(newConf(12, 125000), 1e3)
+	assert.Greater(t, slow.MaxRange, est.MaxRange)
+}
+
+func TestLink_MaxRange(t *testing.T) {
+	l := Link{Model: Hata{BaseHeight: 30, MobileHeight: 1.5}}
+	conf := newConf(9, 125000)
+	d := l.MaxRange(conf, 10)
+	assert.InDelta(t, 10, l.Estimate(conf, d).Margin, 0.01)
+	assert.Less(t, d, l.MaxRange(conf, 0))
+
+	conf.TxPower = 2
+	assert.Zero(t, Link{Model: FreeSpace{}, RxCableLoss: 200}.MaxRange(conf, 0))
+	assert.Equal(t, float64(maxRange), Link{Model: FreeSpace{}, RxAntennaGain: 100}.MaxRange(conf, 0))
+}
+
+func TestLink_Suggest(t *testing.T) {
+	l := Link{Model: Hata{BaseHeight: 30, MobileHeight: 1.5}}
+	tests := []struct {
+		name       string
+		distance   float64
+		bandwidths []uint64
+		sf         uint8
+		bw         uint64
+		err        error
+	}{
+		{name: "short range", distance: 300, sf: 7, bw: 500000},
+		// SF12/250 kHz is faster than SF11/125 kHz and both close the link
+		{name: "2 km", distance: 2e3, sf: 12, bw: 250000},
+		{name: "2 km at 125 kHz", distance: 2e3, bandwidths: []uint64{125000}, sf: 11, bw: 125000},
+		{name: "out of reach", distance: 20e3, err: ErrNoDataRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, est, err := l.Suggest(newConf(7, 125000), tt.distance, 10, tt.bandwidths)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.sf, conf.SF)
+			assert.Equal(t, tt.bw, conf.BW)
+			assert.GreaterOrEqual(t, est.Margin, 10.0)
+			assert.Equal(t, uint8(14), conf.TxPower)
+		})
+	}
+}
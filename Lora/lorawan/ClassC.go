@@ -0,0 +1,201 @@
+package lorawan
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+)
+
+// ErrNotContinuous is returned by StartClassC when the radio cannot listen
+// continuously.
+var ErrNotContinuous = errors.New("radio cannot receive continuously")
+
+// ContinuousRadio is a Radio that can stay in RxContinuous, which Class C
+// needs. *SX1276.GoLora is one.
+type ContinuousRadio interface {
+	Radio
+	ChangeMode(mode SX1276.LoraMode) error
+}
+
+// MulticastGroup is a multicast session. Its frames are unconfirmed, carry
+// no MAC commands and have their own downlink counter.
+type MulticastGroup struct {
+	Addr     DevAddr
+	NwkSKey  Key
+	AppSKey  Key
+	FCntDown uint32
+}
+
+// AddMulticast joins a multicast group, replacing any group with the same
+// address.
+func (d *Device) AddMulticast(g MulticastGroup) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &d.session
+	for i := range s.Multicast {
+		if s.Multicast[i].Addr == g.Addr {
+			s.Multicast[i] = g
+			return d.save()
+		}
+	}
+	s.Multicast = append(s.Multicast, g)
+	return d.save()
+}
+
+// RemoveMulticast leaves the group with address addr.
+func (d *Device) RemoveMulticast(addr DevAddr) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &d.session
+	for i := range s.Multicast {
+		if s.Multicast[i].Addr == addr {
+			s.Multicast = append(s.Multicast[:i], s.Multicast[i+1:]...)
+			return d.save()
+		}
+	}
+	return nil
+}
+
+// StartClassC keeps the radio listening on the RX2 channel whenever it is
+// neither transmitting nor in RX1. Packets heard there go to HandlePacket,
+// which Attach arranges for a GoLora radio.
+func (d *Device) StartClassC() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	radio, ok := d.radio.(ContinuousRadio)
+	if !ok {
+		return ErrNotContinuous
+	}
+	if !d.session.Activated {
+		return ErrNotActivated
+	}
+	d.continuous = radio
+	return d.listen()
+}
+
+// StopClassC goes back to Class A and leaves the radio in standby.
+func (d *Device) StopClassC() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.continuous == nil {
+		return nil
+	}
+	radio := d.continuous
+	d.continuous = nil
+	return radio.ChangeMode(SX1276.Idle)
+}
+
+// listen puts a Class C device into continuous receive with the RX2
+// parameters.
+func (d *Device) listen() error {
+	if d.continuous == nil {
+		return nil
+	}
+	conf, err := d.cfg.Region.ConfFor(d.session.RX2Frequency, d.session.RX2DataRate)
+	if err != nil {
+		return err
+	}
+	if err := d.continuous.ChangeMode(SX1276.Idle); err != nil {
+		return fmt.Errorf("failed to stop continuous receive: %w", err)
+	}
+	conf.InvertIQ = SX1276.InvertIQ{RX: true}
+	if err := d.radio.ApplyConfig(conf); err != nil {
+		return fmt.Errorf("failed to configure continuous receive: %w", err)
+	}
+	if err := d.continuous.ChangeMode(SX1276.RxContinuous); err != nil {
+		return fmt.Errorf("failed to start continuous receive: %w", err)
+	}
+	return nil
+}
+
+// standby stops continuous receive before the radio is reconfigured for an
+// uplink or a receive window.
+func (d *Device) standby() error {
+	if d.continuous == nil {
+		return nil
+	}
+	if err := d.continuous.ChangeMode(SX1276.Idle); err != nil {
+		return fmt.Errorf("failed to stop continuous receive: %w", err)
+	}
+	return nil
+}
+
+// HandlePacket takes a packet heard outside the receive windows, as happens
+// in Class C. Frames for other devices fail with ErrDevAddr.
+func (d *Device) HandlePacket(pkt *SX1276.Packet) (*Downlink, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.session.Activated {
+		return nil, ErrNotActivated
+	}
+	if len(pkt.Data) < 12 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrame, len(pkt.Data))
+	}
+	var addr DevAddr
+	copy(addr[:], reversed(pkt.Data[1:5]))
+	if addr == d.session.DevAddr {
+		dl, err := d.handleDownlink(pkt, 0)
+		if err != nil {
+			return nil, err
+		}
+		return dl, d.save()
+	}
+	for i := range d.session.Multicast {
+		if g := &d.session.Multicast[i]; g.Addr == addr {
+			dl, err := handleMulticast(g, pkt)
+			if err != nil {
+				return nil, err
+			}
+			return dl, d.save()
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDevAddr, addr)
+}
+
+func handleMulticast(g *MulticastGroup, pkt *SX1276.Packet) (*Downlink, error) {
+	f, err := unmarshalData(pkt.Data, g.Addr, g.FCntDown, g.NwkSKey, g.AppSKey)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case f.mtype != UnconfirmedDataDown:
+		return nil, fmt.Errorf("%w: %v on multicast group %s", ErrFrame, f.mtype, g.Addr)
+	case len(f.fopts) > 0 || f.hasPort && f.fport == 0 || f.fctrl.ACK:
+		return nil, fmt.Errorf("%w: MAC commands on multicast group %s", ErrFrame, g.Addr)
+	}
+	g.FCntDown = f.fcnt + 1
+	return &Downlink{
+		Addr:      g.Addr,
+		Multicast: true,
+		Port:      f.fport,
+		Payload:   f.payload,
+		FPending:  f.fctrl.FPending,
+		RSSI:      pkt.RSSI,
+		SNR:       pkt.SNR,
+	}, nil
+}
+
+// EventSource is the part of the radio Attach listens to.
+type EventSource interface {
+	Subscribe(event SX1276.Event, handler func(SX1276.EventData)) (*SX1276.Subscription, error)
+}
+
+// Attach feeds every packet gl receives in continuous mode to HandlePacket
+// and hands each outcome to onDownlink: the downlink, or the error for a
+// packet that was lost on the air or rejected. Frames for other devices come
+// with ErrDevAddr.
+func (d *Device) Attach(gl EventSource, onDownlink func(*Downlink, error)) (*SX1276.Subscription, error) {
+	return gl.Subscribe(SX1276.OnRxDone, func(ev SX1276.EventData) {
+		var (
+			dl  *Downlink
+			err = ev.Err
+		)
+		if err == nil && ev.Packet != nil {
+			dl, err = d.HandlePacket(ev.Packet)
+		}
+		if (dl != nil || err != nil) && onDownlink != nil {
+			onDownlink(dl, err)
+		}
+	})
+}
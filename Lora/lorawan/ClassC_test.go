@@ -0,0 +1,162 @@
+package lorawan
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// classARadio hides ChangeMode, like a radio without continuous receive.
+type classARadio struct {
+	Radio
+}
+
+func TestDevice_StartClassC(t *testing.T) {
+	d, _ := newTestDevice(t, Config{})
+	assert.ErrorIs(t, d.StartClassC(), ErrNotActivated)
+
+	a, err := NewDevice(classARadio{&fakeRadio{clock: &fakeClock{}}}, Config{Region: d.cfg.Region})
+	require.NoError(t, err)
+	require.NoError(t, a.ActivateABP(testAddr, testNwkSKey, testAppSKey))
+	assert.ErrorIs(t, a.StartClassC(), ErrNotContinuous)
+}
+
+func TestDevice_ClassCSwitching(t *testing.T) {
+	d, radio := newTestDevice(t, Config{})
+	activateABP(t, d)
+	require.NoError(t, d.StartClassC())
+	listen := []string{"mode Idle", "apply SF12 869525000 iq true", "mode RxContinuous"}
+	assert.Equal(t, listen, radio.ops)
+
+	radio.ops = nil
+	_, err := d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+	up := radio.sent[0].conf
+	var want []string
+	want = append(want, "mode Idle", fmt.Sprintf("apply SF12 %d iq false", up.Frequency), "send")
+	want = append(want, listen...)
+	want = append(want, "mode Idle", fmt.Sprintf("apply SF12 %d iq true", up.Frequency), "receive")
+	want = append(want, "mode Idle", "apply SF12 869525000 iq true", "receive")
+	want = append(want, listen...)
+	assert.Equal(t, want, radio.ops)
+
+	radio.ops = nil
+	require.NoError(t, d.StopClassC())
+	assert.Equal(t, []string{"mode Idle"}, radio.ops)
+	_, err = d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+	assert.NotContains(t, radio.ops[1:], "mode RxContinuous")
+}
+
+func TestDevice_HandlePacket(t *testing.T) {
+	d, radio := newTestDevice(t, Config{})
+	activateABP(t, d)
+	var uplinks []dataFrame
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+		uplinks = append(uplinks, uplinkOf(t, phy))
+		return nil, nil
+	}
+
+	phy := downlink(t, dataFrame{mtype: ConfirmedDataDown, fcnt: 4, hasPort: true, fport: 10, payload: []byte("open")})
+	dl, err := d.HandlePacket(&SX1276.Packet{Data: phy, RSSI: -90, SNR: 2})
+	require.NoError(t, err)
+	assert.Equal(t, &Downlink{Addr: testAddr, Port: 10, Payload: []byte("open"), Confirmed: true, RSSI: -90, SNR: 2}, dl)
+	assert.Equal(t, uint32(5), d.Session().FCntDown)
+
+	_, err = d.HandlePacket(&SX1276.Packet{Data: phy})
+	assert.ErrorIs(t, err, ErrFCnt)
+	_, err = d.HandlePacket(&SX1276.Packet{Data: []byte{0x60, 1, 2}})
+	assert.ErrorIs(t, err, ErrFrame)
+
+	_, err = d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+	require.Len(t, uplinks, 1)
+	assert.True(t, uplinks[0].fctrl.ACK)
+}
+
+type fakeEvents struct {
+	handler func(SX1276.EventData)
+}
+
+func (fe *fakeEvents) Subscribe(event SX1276.Event, handler func(SX1276.EventData)) (*SX1276.Subscription, error) {
+	if event != SX1276.OnRxDone {
+		return nil, fmt.Errorf("unexpected event %v", event)
+	}
+	fe.handler = handler
+	return nil, nil
+}
+
+func TestDevice_Attach(t *testing.T) {
+	d, _ := newTestDevice(t, Config{})
+	activateABP(t, d)
+	events := &fakeEvents{}
+	type outcome struct {
+		dl  *Downlink
+		err error
+	}
+	var got []outcome
+	_, err := d.Attach(events, func(dl *Downlink, err error) { got = append(got, outcome{dl, err}) })
+	require.NoError(t, err)
+
+	phy := downlink(t, dataFrame{mtype: UnconfirmedDataDown, fcnt: 1, hasPort: true, fport: 2, payload: []byte("on")})
+	events.handler(SX1276.EventData{Event: SX1276.OnRxDone, Packet: &SX1276.Packet{Data: phy}})
+	events.handler(SX1276.EventData{Event: SX1276.OnRxDone, Packet: &SX1276.Packet{Data: phy}})
+	events.handler(SX1276.EventData{Event: SX1276.OnRxDone, Err: SX1276.ErrCrc})
+	events.handler(SX1276.EventData{Event: SX1276.OnRxDone})
+
+	require.Len(t, got, 3)
+	assert.Equal(t, &Downlink{Addr: testAddr, Port: 2, Payload: []byte("on")}, got[0].dl)
+	assert.NoError(t, got[0].err)
+	assert.ErrorIs(t, got[1].err, ErrFCnt)
+	assert.ErrorIs(t, got[2].err, SX1276.ErrCrc)
+}
+
+func TestDevice_Multicast(t *testing.T) {
+	store := &MemoryStore{}
+	d, _ := newTestDevice(t, Config{Store: store})
+	activateABP(t, d)
+	group := MulticastGroup{Addr: DevAddr{0x01, 0xaa, 0xbb, 0xcc}, NwkSKey: Key{9}, AppSKey: Key{8}}
+	require.NoError(t, d.AddMulticast(group))
+
+	mc := func(f dataFrame) []byte {
+		f.devAddr = group.Addr
+		phy, err := f.marshal(group.NwkSKey, group.AppSKey)
+		require.NoError(t, err)
+		return phy
+	}
+	dl, err := d.HandlePacket(&SX1276.Packet{Data: mc(dataFrame{mtype: UnconfirmedDataDown, fcnt: 1, hasPort: true, fport: 200, payload: []byte("all on")})})
+	require.NoError(t, err)
+	assert.Equal(t, &Downlink{Addr: group.Addr, Multicast: true, Port: 200, Payload: []byte("all on")}, dl)
+
+	saved, err := store.Load()
+	require.NoError(t, err)
+	require.Len(t, saved.Multicast, 1)
+	assert.Equal(t, uint32(2), saved.Multicast[0].FCntDown)
+	assert.Equal(t, uint32(0), saved.FCntDown)
+
+	tests := []struct {
+		name  string
+		frame dataFrame
+		want  error
+	}{
+		{name: "replay", frame: dataFrame{mtype: UnconfirmedDataDown, fcnt: 1, hasPort: true, fport: 1}, want: ErrFCnt},
+		{name: "confirmed", frame: dataFrame{mtype: ConfirmedDataDown, fcnt: 2, hasPort: true, fport: 1}, want: ErrFrame},
+		{name: "mac commands", frame: dataFrame{mtype: UnconfirmedDataDown, fcnt: 2, fopts: []byte{0x06}}, want: ErrFrame},
+		{name: "port 0", frame: dataFrame{mtype: UnconfirmedDataDown, fcnt: 2, hasPort: true, payload: []byte{0x06}}, want: ErrFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := d.HandlePacket(&SX1276.Packet{Data: mc(tt.frame)})
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	require.NoError(t, d.RemoveMulticast(group.Addr))
+	_, err = d.HandlePacket(&SX1276.Packet{Data: mc(dataFrame{mtype: UnconfirmedDataDown, fcnt: 3})})
+	assert.ErrorIs(t, err, ErrDevAddr)
+	assert.Empty(t, d.Session().Multicast)
+}
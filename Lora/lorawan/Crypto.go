@@ -0,0 +1,180 @@
+package lorawan
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Key is an AES-128 key such as the AppKey or a session key.
+type Key [16]byte
+
+// EUI64 is a DevEUI or JoinEUI, written most significant byte first.
+type EUI64 [8]byte
+
+// DevAddr is the 32-bit device address, written most significant byte first.
+type DevAddr [4]byte
+
+func (k Key) String() string     { return hex.EncodeToString(k[:]) }
+func (e EUI64) String() string   { return hex.EncodeToString(e[:]) }
+func (a DevAddr) String() string { return hex.EncodeToString(a[:]) }
+
+func (k Key) MarshalText() ([]byte, error)     { return []byte(k.String()), nil }
+func (e EUI64) MarshalText() ([]byte, error)   { return []byte(e.String()), nil }
+func (a DevAddr) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+
+func (k *Key) UnmarshalText(text []byte) error     { return decodeHex(k[:], text) }
+func (e *EUI64) UnmarshalText(text []byte) error   { return decodeHex(e[:], text) }
+func (a *DevAddr) UnmarshalText(text []byte) error { return decodeHex(a[:], text) }
+
+func decodeHex(dst []byte, text []byte) error {
+	if hex.DecodedLen(len(text)) != len(dst) {
+		return fmt.Errorf("want %d hex digits, got %d", 2*len(dst), len(text))
+	}
+	_, err := hex.Decode(dst, text)
+	return err
+}
+
+// ParseKey, ParseEUI and ParseDevAddr read the hex form used by network
+// server consoles.
+func ParseKey(s string) (Key, error) {
+	var k Key
+	err := k.UnmarshalText([]byte(s))
+	return k, err
+}
+
+func ParseEUI(s string) (EUI64, error) {
+	var e EUI64
+	err := e.UnmarshalText([]byte(s))
+	return e, err
+}
+
+func ParseDevAddr(s string) (DevAddr, error) {
+	var a DevAddr
+	err := a.UnmarshalText([]byte(s))
+	return a, err
+}
+
+// reversed returns b least significant byte first, the order LoRaWAN puts
+// EUIs and addresses on the air.
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+// shiftLeft doubles b in GF(2^128) as RFC 4493 subkey generation needs.
+func shiftLeft(b [16]byte) [16]byte {
+	var out [16]byte
+	for i := 0; i < 15; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[15] = b[15] << 1
+	if b[0]&0x80 != 0 {
+		out[15] ^= 0x87
+	}
+	return out
+}
+
+// cmac is AES-CMAC (RFC 4493).
+func cmac(key Key, msg []byte) [16]byte {
+	block, _ := aes.NewCipher(key[:])
+	var l [16]byte
+	block.Encrypt(l[:], l[:])
+	k1 := shiftLeft(l)
+	k2 := shiftLeft(k1)
+
+	n := (len(msg) + 15) / 16
+	complete := n > 0 && len(msg)%16 == 0
+	if n == 0 {
+		n = 1
+	}
+	var last [16]byte
+	if complete {
+		subtle.XORBytes(last[:], msg[16*(n-1):], k1[:])
+	} else {
+		rest := msg[16*(n-1):]
+		copy(last[:], rest)
+		last[len(rest)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+
+	var x [16]byte
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[16*i:16*i+16])
+		block.Encrypt(x[:], x[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+func mic(key Key, msg []byte) [4]byte {
+	full := cmac(key, msg)
+	return [4]byte(full[:4])
+}
+
+const (
+	dirUp   byte = 0
+	dirDown byte = 1
+)
+
+// dataMIC is the MIC of a data frame: CMAC over the B0 block and msg, which
+// is the frame without its MIC.
+func dataMIC(key Key, dir byte, addr DevAddr, fcnt uint32, msg []byte) [4]byte {
+	b0 := make([]byte, 16, 16+len(msg))
+	b0[0] = 0x49
+	b0[5] = dir
+	copy(b0[6:10], reversed(addr[:]))
+	binary.LittleEndian.PutUint32(b0[10:], fcnt)
+	b0[15] = byte(len(msg))
+	return mic(key, append(b0, msg...))
+}
+
+// cryptPayload encrypts or decrypts an FRMPayload: it is XORed with the AES
+// keystream of the A blocks.
+func cryptPayload(key Key, dir byte, addr DevAddr, fcnt uint32, payload []byte) []byte {
+	block, _ := aes.NewCipher(key[:])
+	out := make([]byte, len(payload))
+	var a, s [16]byte
+	a[0] = 0x01
+	a[5] = dir
+	copy(a[6:10], reversed(addr[:]))
+	binary.LittleEndian.PutUint32(a[10:], fcnt)
+	for i := 0; i*16 < len(payload); i++ {
+		a[15] = byte(i + 1)
+		block.Encrypt(s[:], a[:])
+		end := min(len(payload), 16*i+16)
+		subtle.XORBytes(out[16*i:end], payload[16*i:end], s[:])
+	}
+	return out
+}
+
+// sessionKey derives the NwkSKey (prefix 0x01) or AppSKey (prefix 0x02) of a
+// LoRaWAN 1.0.x join.
+func sessionKey(appKey Key, prefix byte, appNonce, netID [3]byte, devNonce uint16) Key {
+	block, _ := aes.NewCipher(appKey[:])
+	var in Key
+	in[0] = prefix
+	copy(in[1:4], appNonce[:])
+	copy(in[4:7], netID[:])
+	binary.LittleEndian.PutUint16(in[7:], devNonce)
+	var out Key
+	block.Encrypt(out[:], in[:])
+	return out
+}
+
+// decryptJoinAccept undoes the network server's encryption of a JoinAccept,
+// which is done with an AES decrypt so the device only needs AES encrypt.
+func decryptJoinAccept(appKey Key, enc []byte) []byte {
+	block, _ := aes.NewCipher(appKey[:])
+	out := make([]byte, len(enc))
+	for i := 0; i+16 <= len(enc); i += 16 {
+		block.Encrypt(out[i:i+16], enc[i:i+16])
+	}
+	return out
+}
@@ -0,0 +1,65 @@
+package lorawan
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestCMAC(t *testing.T) {
+	key, err := ParseKey("2b7e151628aed2a6abf7158809cf4f3c")
+	require.NoError(t, err)
+	// RFC 4493 section 4
+	msg := "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{n: 0, want: "bb1d6929e95937287fa37d129b756746"},
+		{n: 16, want: "070a16b46b4d4144f79bdd9dd04a287c"},
+		{n: 40, want: "dfa66747de9ae63030ca32611497c827"},
+		{n: 64, want: "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tt := range tests {
+		got := cmac(key, mustHex(t, msg)[:tt.n])
+		assert.Equal(t, tt.want, hex.EncodeToString(got[:]), "length %d", tt.n)
+	}
+}
+
+func TestCryptPayload_RoundTrip(t *testing.T) {
+	key := Key{1, 2, 3}
+	addr := DevAddr{0x26, 0x01, 0x12, 0x34}
+	plain := []byte("a payload longer than one AES block")
+	enc := cryptPayload(key, dirUp, addr, 7, plain)
+	assert.NotEqual(t, plain, enc)
+	assert.Len(t, enc, len(plain))
+	assert.Equal(t, plain, cryptPayload(key, dirUp, addr, 7, enc))
+	assert.NotEqual(t, enc, cryptPayload(key, dirDown, addr, 7, plain))
+	assert.NotEqual(t, enc, cryptPayload(key, dirUp, addr, 8, plain))
+}
+
+func TestParse(t *testing.T) {
+	_, err := ParseKey("00112233")
+	assert.EqualError(t, err, "want 32 hex digits, got 8")
+	_, err = ParseEUI("zz00000000000000")
+	assert.Error(t, err)
+
+	addr, err := ParseDevAddr("260B1234")
+	require.NoError(t, err)
+	assert.Equal(t, DevAddr{0x26, 0x0b, 0x12, 0x34}, addr)
+	assert.Equal(t, "260b1234", addr.String())
+
+	b, err := json.Marshal(struct{ EUI EUI64 }{EUI64{0x70, 0xb3, 0xd5, 0x7e, 0xd0, 0, 0, 1}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"EUI":"70b3d57ed0000001"}`, string(b))
+}
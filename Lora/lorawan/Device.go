@@ -0,0 +1,645 @@
+package lorawan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/airtime"
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	joinAcceptDelay1 = 5 * time.Second
+	joinAcceptDelay2 = 6 * time.Second
+	// rxMargin opens each receive window early to absorb timing jitter.
+	rxMargin = 20 * time.Millisecond
+	// minRxSymbols is the preamble the chip needs to lock on.
+	minRxSymbols = 6
+	ackTimeout   = 2 * time.Second
+	adrAckLimit  = 64
+	adrAckDelay  = 32
+	maxAppPort   = 223
+	unknownLevel = 255
+	defaultTries = 8
+)
+
+var (
+	ErrNotActivated = errors.New("device is not activated")
+	ErrNoJoinAccept = errors.New("no join accept received")
+	ErrNoAck        = errors.New("confirmed uplink was not acknowledged")
+	ErrPort         = errors.New("FPort must be 1-223")
+	ErrNoChannel    = errors.New("no enabled channel allows the data rate")
+)
+
+// Radio is the part of *SX1276.GoLora the device drives.
+type Radio interface {
+	ApplyConfig(conf SX1276.LoraConf) error
+	SendPacket(ctx context.Context, buff []byte) error
+	ReceiveSingle(ctx context.Context, symbols uint16) (*SX1276.Packet, error)
+}
+
+// Config describes the device; zero fields take the defaults noted.
+type Config struct {
+	Region  *region.Region
+	DevEUI  EUI64
+	JoinEUI EUI64
+	AppKey  Key
+	// ADR lets the network manage the data rate and tx power.
+	ADR bool
+	// DataRate is used for joins and right after activation; zero means the
+	// region's lowest data rate that can carry uplinks.
+	DataRate    int
+	AntennaGain float64
+	// ConfirmedTries is how often a confirmed uplink is sent before giving
+	// up, 8 by default.
+	ConfirmedTries int
+	// Battery reports the level for DevStatusAns: 0 on external power,
+	// 1-254 for the charge and 255 when unknown, the default.
+	Battery func() uint8
+	// Store defaults to a MemoryStore.
+	Store Store
+}
+
+func (c Config) withDefaults() Config {
+	if c.DataRate == 0 && c.Region != nil {
+		for i, dr := range c.Region.DataRates {
+			if dr.MaxPayload > 0 {
+				c.DataRate = i
+				break
+			}
+		}
+	}
+	if c.ConfirmedTries <= 0 {
+		c.ConfirmedTries = defaultTries
+	}
+	if c.Battery == nil {
+		c.Battery = func() uint8 { return unknownLevel }
+	}
+	if c.Store == nil {
+		c.Store = &MemoryStore{}
+	}
+	return c
+}
+
+// Uplink is one application message. Port 0 with no payload sends an empty
+// frame, which is useful to flush pending MAC answers.
+type Uplink struct {
+	Port      uint8
+	Payload   []byte
+	Confirmed bool
+	// LinkCheck asks the network for a LinkCheckAns.
+	LinkCheck bool
+}
+
+// Downlink is a message received in RX1 or RX2, or in Class C outside the
+// windows, when Window is 0. Port and Payload are zero when the network only
+// sent MAC commands.
+type Downlink struct {
+	// Addr is the device address, or the group address of a multicast.
+	Addr      DevAddr
+	Multicast bool
+	Port      uint8
+	Payload   []byte
+	Confirmed bool
+	// Ack is set when the network acknowledges a confirmed uplink.
+	Ack      bool
+	FPending bool
+	RSSI     int
+	SNR      float64
+	Window   int
+	// LinkCheck holds the answer to Uplink.LinkCheck, if one came.
+	LinkCheck *LinkCheckResult
+}
+
+// Device is a LoRaWAN 1.0.x Class A end device.
+type Device struct {
+	cfg   Config
+	radio Radio
+
+	mu      sync.Mutex
+	session Session
+	// nextTx is when the aggregated duty cycle set by DutyCycleReq allows
+	// the next transmission.
+	nextTx time.Time
+
+	// continuous is set while the device runs in Class C.
+	continuous ContinuousRadio
+
+	now        func() time.Time
+	sleepUntil func(ctx context.Context, t time.Time) error
+}
+
+// NewDevice restores the session saved in cfg.Store, if it belongs to the
+// same region, and otherwise starts unactivated.
+func NewDevice(radio Radio, cfg Config) (*Device, error) {
+	if cfg.Region == nil {
+		return nil, errors.New("config has no region")
+	}
+	cfg = cfg.withDefaults()
+	d := &Device{
+		cfg:        cfg,
+		radio:      radio,
+		now:        time.Now,
+		sleepUntil: sleepUntil,
+	}
+	s, err := cfg.Store.Load()
+	switch {
+	case errors.Is(err, ErrNoSession):
+		d.session = newSession(cfg.Region)
+		d.session.DataRate = cfg.DataRate
+	case err != nil:
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	case s.Region != cfg.Region.Name:
+		d.session = newSession(cfg.Region)
+		d.session.DataRate = cfg.DataRate
+		d.session.DevNonce = s.DevNonce
+	default:
+		d.session = s
+	}
+	return d, nil
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Session returns a copy of the current session.
+func (d *Device) Session() Session {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.session.clone()
+}
+
+func (d *Device) save() error {
+	if err := d.cfg.Store.Save(d.session); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// ActivateABP activates the device with personalised keys. A saved session
+// for the same address and keys keeps its frame counters.
+func (d *Device) ActivateABP(addr DevAddr, nwkSKey, appSKey Key) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &d.session
+	if s.Activated && s.DevAddr == addr && s.NwkSKey == nwkSKey && s.AppSKey == appSKey {
+		return nil
+	}
+	s.resetMAC(d.cfg.Region)
+	s.DataRate = d.cfg.DataRate
+	s.Activated = true
+	s.DevAddr, s.NwkSKey, s.AppSKey = addr, nwkSKey, appSKey
+	s.FCntUp, s.FCntDown = 0, 0
+	return d.save()
+}
+
+// Join runs one OTAA join attempt. The DevNonce is saved before the
+// JoinRequest goes out, so a nonce is never reused.
+func (d *Device) Join(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.cfg.Region
+	s := &d.session
+	devNonce := s.DevNonce
+	s.DevNonce++
+	if err := d.save(); err != nil {
+		return err
+	}
+
+	phy := joinRequest(d.cfg.JoinEUI, d.cfg.DevEUI, devNonce, d.cfg.AppKey)
+	dr := d.cfg.DataRate
+	ch, err := d.pickChannel(r.Channels, allEnabled(len(r.Channels)), dr)
+	if err != nil {
+		return err
+	}
+	txEnd, err := d.transmit(ctx, r.Channels[ch].Frequency, dr, d.txPower(0), phy)
+	if err != nil {
+		return err
+	}
+
+	rx1DR, err := r.RX1DataRate(dr, 0)
+	if err != nil {
+		return err
+	}
+	windows := []rxWindow{
+		{at: txEnd.Add(joinAcceptDelay1), freq: d.rx1Frequency(r.Channels[ch].Frequency, ch), dr: rx1DR, n: 1},
+		{at: txEnd.Add(joinAcceptDelay2), freq: r.RX2.Frequency, dr: r.RX2.MinDR, n: 2},
+	}
+	for _, w := range windows {
+		pkt, err := d.receive(ctx, w)
+		if err != nil {
+			return err
+		}
+		if pkt == nil {
+			continue
+		}
+		ja, err := parseJoinAccept(pkt.Data, d.cfg.AppKey)
+		if err != nil {
+			continue
+		}
+		d.activate(ja, devNonce)
+		return errors.Join(d.save(), d.listen())
+	}
+	return errors.Join(ErrNoJoinAccept, d.listen())
+}
+
+func (d *Device) activate(ja joinAccept, devNonce uint16) {
+	r := d.cfg.Region
+	s := &d.session
+	s.resetMAC(r)
+	s.Activated = true
+	s.DevAddr = ja.devAddr
+	s.NwkSKey = sessionKey(d.cfg.AppKey, 0x01, ja.appNonce, ja.netID, devNonce)
+	s.AppSKey = sessionKey(d.cfg.AppKey, 0x02, ja.appNonce, ja.netID, devNonce)
+	s.FCntUp, s.FCntDown = 0, 0
+	s.DataRate = d.cfg.DataRate
+	s.RX1DROffset = ja.rx1DROffset
+	if validDR(r, ja.rx2DataRate) {
+		s.RX2DataRate = ja.rx2DataRate
+	}
+	s.RX1Delay = max(1, ja.rxDelay)
+	if ja.cfList != nil {
+		s.applyCFList(r, ja.cfList)
+	}
+}
+
+const (
+	cfListFrequencies = 0
+	cfListChMask      = 1
+)
+
+// applyCFList takes the extra channels of dynamic plans, or the channel
+// mask of fixed plans, from a JoinAccept.
+func (s *Session) applyCFList(r *region.Region, cf []byte) {
+	switch cf[15] {
+	case cfListFrequencies:
+		if r.FixedPlan {
+			return
+		}
+		for i := 0; i < 5; i++ {
+			freq := physic.Frequency(uint24(cf[3*i:])) * 100
+			if freq == 0 || freq < r.Min || freq > r.Max {
+				continue
+			}
+			idx := len(r.Channels) + i
+			for len(s.Channels) <= idx {
+				s.Channels = append(s.Channels, region.Channel{})
+				s.ChannelMask = append(s.ChannelMask, false)
+			}
+			s.Channels[idx] = region.Channel{Frequency: freq, MinDR: 0, MaxDR: r.Channels[0].MaxDR}
+			s.ChannelMask[idx] = true
+		}
+	case cfListChMask:
+		if !r.FixedPlan {
+			return
+		}
+		for i := range s.ChannelMask {
+			if i/16 >= 5 {
+				break
+			}
+			s.ChannelMask[i] = cf[2*(i/16)+(i%16)/8]&(1<<(i%8)) != 0
+		}
+	}
+}
+
+// Send transmits up and listens in RX1 and RX2 after each transmission. An
+// unconfirmed uplink is sent NbTrans times unless a downlink arrives first;
+// a confirmed one until the network acknowledges it, up to ConfirmedTries
+// times, and ErrNoAck is returned if it never does. The last downlink, if
+// any, is returned either way.
+func (d *Device) Send(ctx context.Context, up Uplink) (*Downlink, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &d.session
+	if !s.Activated {
+		return nil, ErrNotActivated
+	}
+	if up.Port > maxAppPort || up.Port == 0 && len(up.Payload) > 0 {
+		return nil, fmt.Errorf("%w: %d", ErrPort, up.Port)
+	}
+
+	phy, err := d.buildUplink(up)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.save(); err != nil {
+		return nil, err
+	}
+
+	tries := s.NbTrans
+	if up.Confirmed {
+		tries = d.cfg.ConfirmedTries
+	}
+	var dl *Downlink
+	for try := 0; try < tries; try++ {
+		if try > 0 && up.Confirmed {
+			wait := ackTimeout + time.Duration(rand.Int64N(int64(2*time.Second))) - time.Second
+			if err := d.sleepUntil(ctx, d.now().Add(wait)); err != nil {
+				return dl, err
+			}
+		}
+		got, err := d.sendOnce(ctx, phy)
+		if err != nil {
+			return dl, err
+		}
+		if got == nil {
+			continue
+		}
+		dl = got
+		if !up.Confirmed || got.Ack {
+			return dl, nil
+		}
+	}
+	if up.Confirmed {
+		return dl, ErrNoAck
+	}
+	return dl, nil
+}
+
+// buildUplink encodes the next uplink and advances FCntUp, so every
+// transmission of it reuses the same counter.
+func (d *Device) buildUplink(up Uplink) ([]byte, error) {
+	s := &d.session
+	r := d.cfg.Region
+	var cmds []MACCommand
+	if up.LinkCheck {
+		cmds = append(cmds, MACCommand{CID: LinkCheck})
+	}
+	cmds = append(cmds, s.StickyAnswers...)
+	queued := len(cmds)
+	cmds = append(cmds, s.MACAnswers...)
+
+	f := dataFrame{
+		mtype:   UnconfirmedDataUp,
+		devAddr: s.DevAddr,
+		fctrl:   FCtrl{ADR: d.cfg.ADR, ACK: s.AckDownlink, ADRAckReq: d.cfg.ADR && s.ADRAckCnt >= adrAckLimit},
+		fcnt:    s.FCntUp,
+		hasPort: up.Port != 0,
+		fport:   up.Port,
+		payload: up.Payload,
+	}
+	if up.Confirmed {
+		f.mtype = ConfirmedDataUp
+	}
+	// answers that do not fit in FOpts ride on port 0 when the uplink has no
+	// payload of its own, and otherwise wait for the next uplink
+	sent := 0
+	for _, cmd := range cmds {
+		if len(f.fopts)+1+len(cmd.Payload) > maxFOptsLen {
+			break
+		}
+		f.fopts = append(f.fopts, encodeMACCommands([]MACCommand{cmd})...)
+		sent++
+	}
+	if sent < len(cmds) && up.Port == 0 {
+		f.fopts, f.hasPort, f.fport = nil, true, 0
+		f.payload = encodeMACCommands(cmds)
+		sent = len(cmds)
+	}
+	if err := r.CheckPayload(s.DataRate, 8+len(f.fopts)+len(f.payload)); err != nil {
+		return nil, err
+	}
+	phy, err := f.marshal(s.NwkSKey, s.AppSKey)
+	if err != nil {
+		return nil, err
+	}
+	if sent > queued {
+		s.MACAnswers = s.MACAnswers[sent-queued:]
+	}
+	if len(s.MACAnswers) == 0 {
+		s.MACAnswers = nil
+	}
+	s.AckDownlink = false
+	s.FCntUp++
+	if d.cfg.ADR {
+		s.ADRAckCnt++
+		d.adrBackoff()
+	}
+	return phy, nil
+}
+
+// adrBackoff recovers the link when the network stops answering ADR
+// requests: first full power, then one data rate lower every adrAckDelay
+// uplinks, and at the lowest data rate every default channel again.
+func (d *Device) adrBackoff() {
+	s := &d.session
+	n := s.ADRAckCnt - adrAckLimit
+	if n <= 0 || n%adrAckDelay != 0 {
+		return
+	}
+	switch {
+	case s.TxPowerIndex != 0:
+		s.TxPowerIndex = 0
+	case s.DataRate > d.cfg.DataRate:
+		s.DataRate--
+	default:
+		for i := range s.ChannelMask {
+			if i < len(d.cfg.Region.Channels) {
+				s.ChannelMask[i] = true
+			}
+		}
+	}
+}
+
+// sendOnce makes one transmission of phy and listens for the answer.
+func (d *Device) sendOnce(ctx context.Context, phy []byte) (*Downlink, error) {
+	s := &d.session
+	r := d.cfg.Region
+	if err := d.sleepUntil(ctx, d.nextTx); err != nil {
+		return nil, err
+	}
+	ch, err := d.pickChannel(s.Channels, s.ChannelMask, s.DataRate)
+	if err != nil {
+		return nil, err
+	}
+	freq := s.Channels[ch].Frequency
+	txEnd, err := d.transmit(ctx, freq, s.DataRate, d.txPower(s.TxPowerIndex), phy)
+	if err != nil {
+		return nil, err
+	}
+
+	rx1DR, err := r.RX1DataRate(s.DataRate, s.RX1DROffset)
+	if err != nil {
+		return nil, err
+	}
+	delay := time.Duration(s.RX1Delay) * time.Second
+	windows := []rxWindow{
+		{at: txEnd.Add(delay), freq: d.rx1Frequency(freq, ch), dr: rx1DR, n: 1},
+		{at: txEnd.Add(delay + time.Second), freq: s.RX2Frequency, dr: s.RX2DataRate, n: 2},
+	}
+	var dl *Downlink
+	for _, w := range windows {
+		pkt, err := d.receive(ctx, w)
+		if err != nil {
+			return nil, err
+		}
+		if pkt == nil {
+			continue
+		}
+		if dl, err = d.handleDownlink(pkt, w.n); err == nil {
+			break
+		}
+	}
+	return dl, errors.Join(d.save(), d.listen())
+}
+
+// handleDownlink authenticates a data downlink and applies its MAC commands.
+func (d *Device) handleDownlink(pkt *SX1276.Packet, window int) (*Downlink, error) {
+	s := &d.session
+	f, err := unmarshalData(pkt.Data, s.DevAddr, s.FCntDown, s.NwkSKey, s.AppSKey)
+	if err != nil {
+		return nil, err
+	}
+	if f.mtype != UnconfirmedDataDown && f.mtype != ConfirmedDataDown {
+		return nil, fmt.Errorf("%w: %v in a receive window", ErrFrame, f.mtype)
+	}
+	s.FCntDown = f.fcnt + 1
+	s.ADRAckCnt = 0
+	s.StickyAnswers = nil
+	s.AckDownlink = f.mtype == ConfirmedDataDown
+
+	dl := &Downlink{
+		Addr:      s.DevAddr,
+		Confirmed: f.mtype == ConfirmedDataDown,
+		Ack:       f.fctrl.ACK,
+		FPending:  f.fctrl.FPending,
+		RSSI:      pkt.RSSI,
+		SNR:       pkt.SNR,
+		Window:    window,
+	}
+	macBytes := f.fopts
+	if f.hasPort && f.fport == 0 {
+		macBytes = f.payload
+	} else if f.hasPort {
+		dl.Port, dl.Payload = f.fport, f.payload
+	}
+	// commands before a malformed one are still applied
+	cmds, _ := parseMACCommands(macBytes)
+	env := macEnv{region: d.cfg.Region, battery: d.cfg.Battery(), snr: pkt.SNR}
+	dl.LinkCheck = s.handleMAC(env, cmds)
+	return dl, nil
+}
+
+type rxWindow struct {
+	at   time.Time
+	freq physic.Frequency
+	dr   int
+	n    int
+}
+
+// receive opens one window and returns nil when nothing was heard in it. A
+// frame that arrived damaged cannot have been for us either, so it counts as
+// nothing heard and the next window still opens.
+func (d *Device) receive(ctx context.Context, w rxWindow) (*SX1276.Packet, error) {
+	conf, err := d.cfg.Region.ConfFor(w.freq, w.dr)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.sleepUntil(ctx, w.at.Add(-rxMargin)); err != nil {
+		return nil, err
+	}
+	if err := d.standby(); err != nil {
+		return nil, err
+	}
+	conf.InvertIQ = SX1276.InvertIQ{RX: true}
+	if err := d.radio.ApplyConfig(conf); err != nil {
+		return nil, fmt.Errorf("failed to configure RX%d: %w", w.n, err)
+	}
+	pkt, err := d.radio.ReceiveSingle(ctx, rxSymbols(conf))
+	if errors.Is(err, SX1276.ErrRxTimeout) || errors.Is(err, SX1276.ErrCrc) || errors.Is(err, SX1276.ErrHeader) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive in RX%d: %w", w.n, err)
+	}
+	return pkt, nil
+}
+
+// rxSymbols is a symbol timeout that covers the early opening on both sides
+// of the expected preamble plus what the chip needs to lock on.
+func rxSymbols(conf SX1276.LoraConf) uint16 {
+	tsym := airtime.Params{SF: conf.SF, BW: conf.BW}.SymbolTime()
+	n := int(math.Ceil(float64(2*rxMargin)/float64(tsym))) + minRxSymbols
+	return uint16(max(SX1276.MinSymbTimeout, min(SX1276.MaxSymbTimeout, n)))
+}
+
+// transmit sends phy and returns when it left the antenna. A Class C
+// device listens again right after, until RX1 opens.
+func (d *Device) transmit(ctx context.Context, freq physic.Frequency, dr int, power uint8, phy []byte) (time.Time, error) {
+	conf, err := d.cfg.Region.ConfFor(freq, dr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := d.standby(); err != nil {
+		return time.Time{}, err
+	}
+	conf.TxPower = power
+	if err := d.radio.ApplyConfig(conf); err != nil {
+		return time.Time{}, fmt.Errorf("failed to configure uplink: %w", err)
+	}
+	if err := d.radio.SendPacket(ctx, phy); err != nil {
+		return time.Time{}, fmt.Errorf("failed to send uplink: %w", err)
+	}
+	end := d.now()
+	if dc := d.session.MaxDutyCycle; dc > 0 {
+		onAir := SX1276.AirtimeParams(conf).TimeOnAir(len(phy))
+		d.nextTx = end.Add(onAir * time.Duration(1<<dc-1))
+	}
+	return end, d.listen()
+}
+
+// txPower is the LoraConf.TxPower for a TXPower index with the configured
+// antenna, within what the SX1276 can set.
+func (d *Device) txPower(idx int) uint8 {
+	eirp, err := d.cfg.Region.TxPowerEIRP(idx)
+	if err != nil {
+		eirp = d.cfg.Region.MaxEIRP
+	}
+	return uint8(math.Max(2, math.Min(17, math.Floor(eirp-d.cfg.AntennaGain))))
+}
+
+// rx1Frequency is the uplink frequency, or on fixed plans the downlink
+// channel paired with uplink channel ch.
+func (d *Device) rx1Frequency(up physic.Frequency, ch int) physic.Frequency {
+	if dl := d.cfg.Region.Downlink; dl != nil {
+		return dl[ch%len(dl)].Frequency
+	}
+	return up
+}
+
+// pickChannel chooses a random enabled channel that allows dr.
+func (d *Device) pickChannel(channels []region.Channel, mask []bool, dr int) (int, error) {
+	var ok []int
+	for i, c := range channels {
+		if i < len(mask) && mask[i] && c.Frequency != 0 && dr >= c.MinDR && dr <= c.MaxDR {
+			ok = append(ok, i)
+		}
+	}
+	if len(ok) == 0 {
+		return 0, fmt.Errorf("%w: DR%d", ErrNoChannel, dr)
+	}
+	return ok[rand.IntN(len(ok))], nil
+}
+
+func allEnabled(n int) []bool {
+	mask := make([]bool, n)
+	for i := range mask {
+		mask[i] = true
+	}
+	return mask
+}
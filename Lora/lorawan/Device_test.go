@@ -0,0 +1,399 @@
+package lorawan
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"periph.io/x/conn/v3/physic"
+)
+
+type sentFrame struct {
+	phy  []byte
+	conf SX1276.LoraConf
+	at   time.Time
+}
+
+type rxCall struct {
+	conf    SX1276.LoraConf
+	at      time.Time
+	symbols uint16
+	iqRx    bool
+}
+
+// fakeRadio hands every uplink to network, which plays the network server
+// and returns what it sends in RX1 and RX2.
+type fakeRadio struct {
+	clock   *fakeClock
+	network func(phy []byte, conf SX1276.LoraConf) (rx1, rx2 []byte)
+
+	mu      sync.Mutex
+	conf    SX1276.LoraConf
+	sent    []sentFrame
+	rx      []rxCall
+	pending [2][]byte
+	rxErrs  [2]error
+	window  int
+	// ops logs every call in order, for checking Class C switching.
+	ops []string
+}
+
+func (r *fakeRadio) ApplyConfig(conf SX1276.LoraConf) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conf = conf
+	r.ops = append(r.ops, fmt.Sprintf("apply SF%d %d iq %v", conf.SF, conf.Frequency, conf.InvertIQ.RX))
+	return nil
+}
+
+func (r *fakeRadio) ChangeMode(mode SX1276.LoraMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, fmt.Sprintf("mode %v", mode))
+	return nil
+}
+
+func (r *fakeRadio) SendPacket(ctx context.Context, buff []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, "send")
+	r.sent = append(r.sent, sentFrame{phy: append([]byte(nil), buff...), conf: r.conf, at: r.clock.now()})
+	r.pending = [2][]byte{}
+	r.window = 0
+	if r.network != nil && !r.conf.InvertIQ.TX {
+		r.pending[0], r.pending[1] = r.network(buff, r.conf)
+	}
+	return nil
+}
+
+func (r *fakeRadio) ReceiveSingle(ctx context.Context, symbols uint16) (*SX1276.Packet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, "receive")
+	r.rx = append(r.rx, rxCall{conf: r.conf, at: r.clock.now(), symbols: symbols, iqRx: r.conf.InvertIQ.RX})
+	w := r.window
+	r.window++
+	if w < len(r.rxErrs) && r.rxErrs[w] != nil {
+		return nil, r.rxErrs[w]
+	}
+	if w < len(r.pending) && r.pending[w] != nil {
+		return &SX1276.Packet{Data: r.pending[w], RSSI: -80, SNR: 6.5, Conf: r.conf}, nil
+	}
+	return nil, SX1276.ErrRxTimeout
+}
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) sleepUntil(ctx context.Context, t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.t) {
+		c.t = t
+	}
+	return ctx.Err()
+}
+
+var (
+	testAppKey  = Key{0x2b, 0x7e, 0x15, 0x16, 0x28, 0xae, 0xd2, 0xa6, 0xab, 0xf7, 0x15, 0x88, 0x09, 0xcf, 0x4f, 0x3c}
+	testDevEUI  = EUI64{0x70, 0xb3, 0xd5, 0x7e, 0xd0, 0x00, 0x00, 0x01}
+	testJoinEUI = EUI64{0x70, 0xb3, 0xd5, 0x7e, 0xf0, 0x00, 0x00, 0x02}
+)
+
+func newTestDevice(t *testing.T, cfg Config) (*Device, *fakeRadio) {
+	t.Helper()
+	if cfg.Region == nil {
+		cfg.Region = region.EU868
+	}
+	cfg.DevEUI, cfg.JoinEUI, cfg.AppKey = testDevEUI, testJoinEUI, testAppKey
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	radio := &fakeRadio{clock: clock}
+	d, err := NewDevice(radio, cfg)
+	require.NoError(t, err)
+	d.now, d.sleepUntil = clock.now, clock.sleepUntil
+	return d, radio
+}
+
+func activateABP(t *testing.T, d *Device) {
+	t.Helper()
+	require.NoError(t, d.ActivateABP(testAddr, testNwkSKey, testAppSKey))
+}
+
+// uplinkOf decodes an uplink the way the network server would.
+func uplinkOf(t *testing.T, phy []byte) dataFrame {
+	t.Helper()
+	f, err := unmarshalData(phy, testAddr, 0, testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+	return f
+}
+
+func downlink(t *testing.T, f dataFrame) []byte {
+	t.Helper()
+	if f.mtype == 0 {
+		f.mtype = UnconfirmedDataDown
+	}
+	f.devAddr = testAddr
+	phy, err := f.marshal(testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+	return phy
+}
+
+func TestDevice_Join(t *testing.T) {
+	d, radio := newTestDevice(t, Config{})
+	var devNonce uint16
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+		require.Len(t, phy, 23)
+		assert.Equal(t, mhdr(JoinRequest), phy[0])
+		assert.Equal(t, reversed(testJoinEUI[:]), phy[1:9])
+		assert.Equal(t, reversed(testDevEUI[:]), phy[9:17])
+		m := mic(testAppKey, phy[:19])
+		assert.Equal(t, m[:], phy[19:])
+		devNonce = binary.LittleEndian.Uint16(phy[17:])
+
+		plain := []byte{mhdr(JoinAccept), 0x01, 0x02, 0x03, 0x13, 0x00, 0x00, 0xf1, 0x7d, 0xbe, 0x49, 0x13, 0x02}
+		cfList := make([]byte, 16)
+		cfList[0], cfList[1], cfList[2] = 0x18, 0x4f, 0x84
+		return nil, encryptJoinAccept(testAppKey, append(plain, cfList...))
+	}
+
+	require.NoError(t, d.Join(context.Background()))
+	s := d.Session()
+	assert.True(t, s.Activated)
+	assert.Equal(t, testAddr, s.DevAddr)
+	assert.Equal(t, sessionKey(testAppKey, 0x01, [3]byte{1, 2, 3}, [3]byte{0x13, 0, 0}, devNonce), s.NwkSKey)
+	assert.Equal(t, sessionKey(testAppKey, 0x02, [3]byte{1, 2, 3}, [3]byte{0x13, 0, 0}, devNonce), s.AppSKey)
+	assert.NotEqual(t, s.NwkSKey, s.AppSKey)
+	assert.Equal(t, uint16(1), s.DevNonce)
+	assert.Equal(t, 1, s.RX1DROffset)
+	assert.Equal(t, 3, s.RX2DataRate)
+	assert.Equal(t, 2, s.RX1Delay)
+	require.Len(t, s.Channels, 4)
+	assert.Equal(t, physic.Frequency(867100000), s.Channels[3].Frequency)
+
+	require.Len(t, radio.sent, 1)
+	up := radio.sent[0]
+	assert.Equal(t, uint8(12), up.conf.SF)
+	assert.Equal(t, uint8(region.PublicSyncWord), up.conf.SyncWord)
+	require.Len(t, radio.rx, 2)
+	assert.Equal(t, up.at.Add(5*time.Second-rxMargin), radio.rx[0].at)
+	assert.Equal(t, up.conf.Frequency, radio.rx[0].conf.Frequency)
+	assert.Equal(t, up.at.Add(6*time.Second-rxMargin), radio.rx[1].at)
+	assert.Equal(t, physic.Frequency(869525000), radio.rx[1].conf.Frequency)
+	assert.Equal(t, uint8(12), radio.rx[1].conf.SF)
+	assert.True(t, radio.rx[1].iqRx)
+	assert.Equal(t, uint16(8), radio.rx[1].symbols)
+
+	saved, err := d.cfg.Store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, s, saved)
+}
+
+func TestDevice_Join_DamagedRX1(t *testing.T) {
+	for _, rxErr := range []error{SX1276.ErrCrc, SX1276.ErrHeader} {
+		t.Run(rxErr.Error(), func(t *testing.T) {
+			d, radio := newTestDevice(t, Config{})
+			radio.rxErrs[0] = rxErr
+			radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+				plain := []byte{mhdr(JoinAccept), 0x01, 0x02, 0x03, 0x13, 0x00, 0x00, 0xf1, 0x7d, 0xbe, 0x49, 0x13, 0x02}
+				return nil, encryptJoinAccept(testAppKey, plain)
+			}
+
+			require.NoError(t, d.Join(context.Background()))
+			assert.True(t, d.Session().Activated)
+			require.Len(t, radio.rx, 2)
+			assert.Equal(t, physic.Frequency(869525000), radio.rx[1].conf.Frequency)
+		})
+	}
+}
+
+func TestDevice_Join_NoAccept(t *testing.T) {
+	d, radio := newTestDevice(t, Config{})
+	assert.ErrorIs(t, d.Join(context.Background()), ErrNoJoinAccept)
+	assert.ErrorIs(t, d.Join(context.Background()), ErrNoJoinAccept)
+	assert.Len(t, radio.rx, 4)
+	require.Len(t, radio.sent, 2)
+	assert.Equal(t, []byte{0, 0}, radio.sent[0].phy[17:19])
+	assert.Equal(t, []byte{1, 0}, radio.sent[1].phy[17:19])
+	assert.False(t, d.Session().Activated)
+	assert.Equal(t, uint16(2), d.Session().DevNonce)
+
+	_, err := d.Send(context.Background(), Uplink{Port: 1})
+	assert.ErrorIs(t, err, ErrNotActivated)
+}
+
+func TestDevice_Send(t *testing.T) {
+	d, radio := newTestDevice(t, Config{Battery: func() uint8 { return 100 }})
+	activateABP(t, d)
+	var uplinks []dataFrame
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+		f := uplinkOf(t, phy)
+		uplinks = append(uplinks, f)
+		if len(uplinks) == 1 {
+			// DevStatusReq in FOpts with application data in RX2
+			return nil, downlink(t, dataFrame{fcnt: 0, fopts: []byte{0x06}, hasPort: true, fport: 5, payload: []byte("hi"), fctrl: FCtrl{FPending: true}})
+		}
+		return nil, nil
+	}
+
+	dl, err := d.Send(context.Background(), Uplink{Port: 2, Payload: []byte("temp=21")})
+	require.NoError(t, err)
+	require.NotNil(t, dl)
+	assert.Equal(t, &Downlink{Addr: testAddr, Port: 5, Payload: []byte("hi"), FPending: true, RSSI: -80, SNR: 6.5, Window: 2}, dl)
+	assert.Equal(t, uint8(2), uplinks[0].fport)
+	assert.Equal(t, []byte("temp=21"), uplinks[0].payload)
+	assert.Equal(t, uint32(0), uplinks[0].fcnt)
+	require.Len(t, radio.rx, 2)
+	assert.Equal(t, radio.sent[0].at.Add(time.Second-rxMargin), radio.rx[0].at)
+	assert.Equal(t, radio.sent[0].at.Add(2*time.Second-rxMargin), radio.rx[1].at)
+	assert.Equal(t, uint8(12), radio.rx[1].conf.SF)
+
+	dl, err = d.Send(context.Background(), Uplink{})
+	require.NoError(t, err)
+	assert.Nil(t, dl)
+	require.Len(t, uplinks, 2)
+	assert.Equal(t, uint32(1), uplinks[1].fcnt)
+	assert.False(t, uplinks[1].hasPort)
+	// DevStatusAns: battery 100, margin 7 dB
+	assert.Equal(t, []byte{0x06, 100, 7}, uplinks[1].fopts)
+
+	s := d.Session()
+	assert.Equal(t, uint32(2), s.FCntUp)
+	assert.Equal(t, uint32(1), s.FCntDown)
+	assert.Empty(t, s.MACAnswers)
+
+	_, err = d.Send(context.Background(), Uplink{Port: 224})
+	assert.ErrorIs(t, err, ErrPort)
+	_, err = d.Send(context.Background(), Uplink{Payload: []byte{1}})
+	assert.ErrorIs(t, err, ErrPort)
+	_, err = d.Send(context.Background(), Uplink{Port: 1, Payload: make([]byte, 60)})
+	assert.ErrorContains(t, err, "exceeds 59 at DR0")
+}
+
+func TestDevice_Send_Confirmed(t *testing.T) {
+	d, radio := newTestDevice(t, Config{ConfirmedTries: 4})
+	activateABP(t, d)
+	tries := 0
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+		f := uplinkOf(t, phy)
+		assert.Equal(t, ConfirmedDataUp, f.mtype)
+		assert.Equal(t, uint32(0), f.fcnt)
+		tries++
+		if tries < 3 {
+			return nil, nil
+		}
+		return downlink(t, dataFrame{fctrl: FCtrl{ACK: true}}), nil
+	}
+
+	dl, err := d.Send(context.Background(), Uplink{Port: 1, Payload: []byte{1}, Confirmed: true})
+	require.NoError(t, err)
+	assert.True(t, dl.Ack)
+	assert.Equal(t, 1, dl.Window)
+	assert.Len(t, radio.sent, 3)
+	for i := 1; i < 3; i++ {
+		gap := radio.sent[i].at.Sub(radio.sent[i-1].at)
+		assert.GreaterOrEqual(t, gap, 3*time.Second-rxMargin, "retry %d", i)
+		assert.LessOrEqual(t, gap, 5*time.Second, "retry %d", i)
+	}
+
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) { return nil, nil }
+	_, err = d.Send(context.Background(), Uplink{Port: 1, Confirmed: true})
+	assert.ErrorIs(t, err, ErrNoAck)
+	assert.Len(t, radio.sent, 7)
+	assert.Equal(t, uint32(2), d.Session().FCntUp)
+}
+
+func TestDevice_Send_NbTrans(t *testing.T) {
+	d, radio := newTestDevice(t, Config{})
+	activateABP(t, d)
+	d.session.NbTrans = 3
+
+	_, err := d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+	assert.Len(t, radio.sent, 3)
+	for _, f := range radio.sent {
+		assert.Equal(t, radio.sent[0].phy, f.phy)
+	}
+
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+		return downlink(t, dataFrame{}), nil
+	}
+	_, err = d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+	assert.Len(t, radio.sent, 4)
+}
+
+func TestDevice_StickyAnswersAndDutyCycle(t *testing.T) {
+	d, radio := newTestDevice(t, Config{})
+	activateABP(t, d)
+	var uplinks []dataFrame
+	radio.network = func(phy []byte, conf SX1276.LoraConf) ([]byte, []byte) {
+		uplinks = append(uplinks, uplinkOf(t, phy))
+		if len(uplinks) == 1 {
+			// RXTimingSetupReq of 3 s and DutyCycleReq of 1/16
+			return downlink(t, dataFrame{fopts: []byte{0x08, 0x03, 0x04, 0x04}}), nil
+		}
+		return nil, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := d.Send(context.Background(), Uplink{Port: 1, Payload: []byte{byte(i)}})
+		require.NoError(t, err)
+	}
+	require.Len(t, uplinks, 3)
+	assert.Equal(t, []byte{0x08, 0x04}, uplinks[1].fopts)
+	assert.Equal(t, []byte{0x08}, uplinks[2].fopts)
+	assert.Equal(t, 3, d.Session().RX1Delay)
+	assert.Equal(t, radio.sent[1].at.Add(3*time.Second-rxMargin), radio.rx[1].at)
+
+	// the third uplink waits out 15 times the airtime of the second
+	onAir := radio.sent[1].conf
+	assert.Equal(t, uint8(12), onAir.SF)
+	gap := radio.sent[2].at.Sub(radio.sent[1].at)
+	assert.Greater(t, gap, 15*time.Second)
+}
+
+func TestDevice_FixedPlanWindows(t *testing.T) {
+	d, radio := newTestDevice(t, Config{Region: region.US915})
+	activateABP(t, d)
+	_, err := d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+
+	up := radio.sent[0].conf
+	ch := int((up.Frequency - 902300000) / 200000)
+	assert.Equal(t, uint8(10), up.SF)
+	assert.Equal(t, region.US915.Downlink[ch%8].Frequency, radio.rx[0].conf.Frequency)
+	// DR0 answers at DR10, SF10 at 500 kHz
+	assert.Equal(t, uint8(10), radio.rx[0].conf.SF)
+	assert.Equal(t, uint64(500000), radio.rx[0].conf.BW)
+	assert.Equal(t, physic.Frequency(923300000), radio.rx[1].conf.Frequency)
+	assert.Equal(t, uint8(12), radio.rx[1].conf.SF)
+}
+
+func TestDevice_ABPKeepsCounters(t *testing.T) {
+	store := &MemoryStore{}
+	d, _ := newTestDevice(t, Config{Store: store})
+	activateABP(t, d)
+	_, err := d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+
+	d, radio := newTestDevice(t, Config{Store: store})
+	activateABP(t, d)
+	_, err = d.Send(context.Background(), Uplink{Port: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), uplinkOf(t, radio.sent[0].phy).fcnt)
+}
@@ -0,0 +1,238 @@
+package lorawan
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type MType byte
+
+const (
+	JoinRequest MType = iota
+	JoinAccept
+	UnconfirmedDataUp
+	UnconfirmedDataDown
+	ConfirmedDataUp
+	ConfirmedDataDown
+)
+
+func (m MType) String() string {
+	switch m {
+	case JoinRequest:
+		return "JoinRequest"
+	case JoinAccept:
+		return "JoinAccept"
+	case UnconfirmedDataUp:
+		return "UnconfirmedDataUp"
+	case UnconfirmedDataDown:
+		return "UnconfirmedDataDown"
+	case ConfirmedDataUp:
+		return "ConfirmedDataUp"
+	case ConfirmedDataDown:
+		return "ConfirmedDataDown"
+	}
+	return fmt.Sprintf("MType(%d)", byte(m))
+}
+
+func mhdr(m MType) byte {
+	return byte(m) << 5
+}
+
+const (
+	fctrlADR       byte = 0x80
+	fctrlADRAckReq byte = 0x40
+	fctrlACK       byte = 0x20
+	fctrlFPending  byte = 0x10
+	fctrlFOptsLen  byte = 0x0f
+
+	maxFOptsLen = 15
+	// maxFCntGap is the largest jump in FCntDown a device accepts.
+	maxFCntGap = 16384
+)
+
+var (
+	ErrMIC     = errors.New("MIC mismatch")
+	ErrFrame   = errors.New("malformed frame")
+	ErrDevAddr = errors.New("frame for another device")
+	ErrFCnt    = errors.New("frame counter replayed or out of range")
+)
+
+// FCtrl holds the frame control bits. FPending is only used downstream.
+type FCtrl struct {
+	ADR       bool
+	ADRAckReq bool
+	ACK       bool
+	FPending  bool
+}
+
+// dataFrame is a data message with its FRMPayload in plain text. The frame
+// counter is the full 32-bit value; only its low 16 bits go on the air.
+type dataFrame struct {
+	mtype   MType
+	devAddr DevAddr
+	fctrl   FCtrl
+	fcnt    uint32
+	fopts   []byte
+	hasPort bool
+	fport   uint8
+	payload []byte
+}
+
+func uplinkDir(m MType) bool {
+	return m == UnconfirmedDataUp || m == ConfirmedDataUp
+}
+
+// marshal builds the PHYPayload. FRMPayload is encrypted with the NwkSKey
+// on port 0 and with the AppSKey on every other port.
+func (f dataFrame) marshal(nwkSKey, appSKey Key) ([]byte, error) {
+	if len(f.fopts) > maxFOptsLen {
+		return nil, fmt.Errorf("%w: %d bytes of FOpts", ErrFrame, len(f.fopts))
+	}
+	dir := dirDown
+	if uplinkDir(f.mtype) {
+		dir = dirUp
+	}
+	ctrl := byte(len(f.fopts))
+	if f.fctrl.ADR {
+		ctrl |= fctrlADR
+	}
+	if f.fctrl.ADRAckReq {
+		ctrl |= fctrlADRAckReq
+	}
+	if f.fctrl.ACK {
+		ctrl |= fctrlACK
+	}
+	if f.fctrl.FPending {
+		ctrl |= fctrlFPending
+	}
+	phy := []byte{mhdr(f.mtype)}
+	phy = append(phy, reversed(f.devAddr[:])...)
+	phy = append(phy, ctrl)
+	phy = binary.LittleEndian.AppendUint16(phy, uint16(f.fcnt))
+	phy = append(phy, f.fopts...)
+	if f.hasPort {
+		key := appSKey
+		if f.fport == 0 {
+			key = nwkSKey
+		}
+		phy = append(phy, f.fport)
+		phy = append(phy, cryptPayload(key, dir, f.devAddr, f.fcnt, f.payload)...)
+	}
+	m := dataMIC(nwkSKey, dir, f.devAddr, f.fcnt, phy)
+	return append(phy, m[:]...), nil
+}
+
+// fullFCnt extends a 16-bit counter from the air to the first 32-bit value
+// at or after next.
+func fullFCnt(next uint32, fcnt uint16) uint32 {
+	full := next&^0xffff | uint32(fcnt)
+	if full < next {
+		full += 0x10000
+	}
+	return full
+}
+
+// unmarshalData decodes and authenticates a data frame for addr. next is the
+// lowest frame counter still acceptable.
+func unmarshalData(phy []byte, addr DevAddr, next uint32, nwkSKey, appSKey Key) (dataFrame, error) {
+	if len(phy) < 12 {
+		return dataFrame{}, fmt.Errorf("%w: %d bytes", ErrFrame, len(phy))
+	}
+	f := dataFrame{mtype: MType(phy[0] >> 5)}
+	switch {
+	case phy[0]&0x03 != 0:
+		return dataFrame{}, fmt.Errorf("%w: unknown LoRaWAN major version %d", ErrFrame, phy[0]&0x03)
+	case f.mtype != UnconfirmedDataUp && f.mtype != UnconfirmedDataDown &&
+		f.mtype != ConfirmedDataUp && f.mtype != ConfirmedDataDown:
+		return dataFrame{}, fmt.Errorf("%w: %v is not a data frame", ErrFrame, f.mtype)
+	}
+	copy(f.devAddr[:], reversed(phy[1:5]))
+	if f.devAddr != addr {
+		return dataFrame{}, fmt.Errorf("%w: %s", ErrDevAddr, f.devAddr)
+	}
+	ctrl := phy[5]
+	f.fctrl = FCtrl{
+		ADR:       ctrl&fctrlADR != 0,
+		ADRAckReq: ctrl&fctrlADRAckReq != 0,
+		ACK:       ctrl&fctrlACK != 0,
+		FPending:  ctrl&fctrlFPending != 0,
+	}
+	f.fcnt = fullFCnt(next, binary.LittleEndian.Uint16(phy[6:]))
+	if f.fcnt-next >= maxFCntGap {
+		return dataFrame{}, fmt.Errorf("%w: %d, expected %d", ErrFCnt, f.fcnt, next)
+	}
+
+	body := phy[:len(phy)-4]
+	foptsEnd := 8 + int(ctrl&fctrlFOptsLen)
+	if foptsEnd > len(body) {
+		return dataFrame{}, fmt.Errorf("%w: FOpts run past the frame", ErrFrame)
+	}
+	dir := dirDown
+	if uplinkDir(f.mtype) {
+		dir = dirUp
+	}
+	want := dataMIC(nwkSKey, dir, f.devAddr, f.fcnt, body)
+	if subtle.ConstantTimeCompare(want[:], phy[len(phy)-4:]) != 1 {
+		return dataFrame{}, ErrMIC
+	}
+
+	f.fopts = append([]byte(nil), body[8:foptsEnd]...)
+	if foptsEnd < len(body) {
+		f.hasPort = true
+		f.fport = body[foptsEnd]
+		key := appSKey
+		if f.fport == 0 {
+			key = nwkSKey
+		}
+		f.payload = cryptPayload(key, dir, f.devAddr, f.fcnt, body[foptsEnd+1:])
+	}
+	return f, nil
+}
+
+func joinRequest(joinEUI, devEUI EUI64, devNonce uint16, appKey Key) []byte {
+	phy := []byte{mhdr(JoinRequest)}
+	phy = append(phy, reversed(joinEUI[:])...)
+	phy = append(phy, reversed(devEUI[:])...)
+	phy = binary.LittleEndian.AppendUint16(phy, devNonce)
+	m := mic(appKey, phy)
+	return append(phy, m[:]...)
+}
+
+type joinAccept struct {
+	appNonce    [3]byte
+	netID       [3]byte
+	devAddr     DevAddr
+	rx1DROffset int
+	rx2DataRate int
+	rxDelay     int
+	cfList      []byte
+}
+
+func parseJoinAccept(phy []byte, appKey Key) (joinAccept, error) {
+	if len(phy) != 17 && len(phy) != 33 {
+		return joinAccept{}, fmt.Errorf("%w: join accept of %d bytes", ErrFrame, len(phy))
+	}
+	if phy[0] != mhdr(JoinAccept) {
+		return joinAccept{}, fmt.Errorf("%w: %v is not a join accept", ErrFrame, MType(phy[0]>>5))
+	}
+	plain := append([]byte{phy[0]}, decryptJoinAccept(appKey, phy[1:])...)
+	body := plain[:len(plain)-4]
+	want := mic(appKey, body)
+	if subtle.ConstantTimeCompare(want[:], plain[len(plain)-4:]) != 1 {
+		return joinAccept{}, ErrMIC
+	}
+	ja := joinAccept{
+		rx1DROffset: int(body[11]>>4) & 0x07,
+		rx2DataRate: int(body[11] & 0x0f),
+		rxDelay:     int(body[12] & 0x0f),
+	}
+	copy(ja.appNonce[:], body[1:4])
+	copy(ja.netID[:], body[4:7])
+	copy(ja.devAddr[:], reversed(body[7:11]))
+	if len(body) > 13 {
+		ja.cfList = append([]byte(nil), body[13:]...)
+	}
+	return ja, nil
+}
@@ -0,0 +1,114 @@
+package lorawan
+
+import (
+	"crypto/aes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testAddr    = DevAddr{0x49, 0xbe, 0x7d, 0xf1}
+	testNwkSKey = Key{0x44, 0x02, 0x42, 0x41, 0xed, 0x4c, 0xe9, 0xa6, 0x8c, 0x6a, 0x8b, 0xc0, 0x55, 0x23, 0x3f, 0xd3}
+	testAppSKey = Key{0xec, 0x92, 0x58, 0x02, 0xae, 0x43, 0x0c, 0xa7, 0x7f, 0xd3, 0xdd, 0x73, 0xcb, 0x2c, 0xc5, 0x88}
+)
+
+func TestUnmarshalData_KnownFrame(t *testing.T) {
+	phy := mustHex(t, "40F17DBE4900020001954378762B11FF0D")
+	f, err := unmarshalData(phy, testAddr, 0, testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+	assert.Equal(t, UnconfirmedDataUp, f.mtype)
+	assert.Equal(t, uint32(2), f.fcnt)
+	assert.True(t, f.hasPort)
+	assert.Equal(t, uint8(1), f.fport)
+	assert.Equal(t, []byte("test"), f.payload)
+
+	again, err := f.marshal(testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+	assert.Equal(t, phy, again)
+}
+
+func TestUnmarshalData_Errors(t *testing.T) {
+	f := dataFrame{mtype: ConfirmedDataDown, devAddr: testAddr, fcnt: 10, fopts: []byte{0x06}, hasPort: true, fport: 3, payload: []byte{1, 2}}
+	phy, err := f.marshal(testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+
+	tampered := append([]byte(nil), phy...)
+	tampered[len(tampered)-6] ^= 0xff
+
+	tests := []struct {
+		name string
+		phy  []byte
+		addr DevAddr
+		next uint32
+		want error
+	}{
+		{name: "short", phy: phy[:11], addr: testAddr, want: ErrFrame},
+		{name: "join accept", phy: append([]byte{mhdr(JoinAccept)}, phy[1:]...), addr: testAddr, want: ErrFrame},
+		{name: "other device", phy: phy, addr: DevAddr{1, 2, 3, 4}, want: ErrDevAddr},
+		{name: "replay", phy: phy, addr: testAddr, next: 11, want: ErrFCnt},
+		{name: "mic", phy: tampered, addr: testAddr, want: ErrMIC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unmarshalData(tt.phy, tt.addr, tt.next, testNwkSKey, testAppSKey)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	got, err := unmarshalData(phy, testAddr, 10, testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+	assert.Equal(t, f, got)
+}
+
+func TestFullFCnt(t *testing.T) {
+	assert.Equal(t, uint32(5), fullFCnt(3, 5))
+	assert.Equal(t, uint32(0x10002), fullFCnt(0xfff0, 2))
+	assert.Equal(t, uint32(0x1fff0), fullFCnt(0x1fff0, 0xfff0))
+}
+
+func TestMarshal_PortZeroUsesNwkSKey(t *testing.T) {
+	f := dataFrame{mtype: UnconfirmedDataUp, devAddr: testAddr, hasPort: true, payload: []byte{0x02}}
+	phy, err := f.marshal(testNwkSKey, testAppSKey)
+	require.NoError(t, err)
+	got, err := unmarshalData(phy, testAddr, 0, testNwkSKey, Key{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x02}, got.payload)
+
+	_, err = dataFrame{mtype: UnconfirmedDataUp, fopts: make([]byte, 16)}.marshal(testNwkSKey, testAppSKey)
+	assert.ErrorIs(t, err, ErrFrame)
+}
+
+func TestJoinAccept(t *testing.T) {
+	appKey := Key{0x2b, 0x7e, 0x15, 0x16}
+	plain := []byte{mhdr(JoinAccept), 0x01, 0x02, 0x03, 0x13, 0x00, 0x00, 0x34, 0x12, 0x0b, 0x26, 0x23, 0x05}
+	cfList := make([]byte, 16)
+	cfList[0], cfList[1], cfList[2] = 0x18, 0x4f, 0x84 // 867.1 MHz
+	ja, err := parseJoinAccept(encryptJoinAccept(appKey, append(plain, cfList...)), appKey)
+	require.NoError(t, err)
+	assert.Equal(t, [3]byte{1, 2, 3}, ja.appNonce)
+	assert.Equal(t, [3]byte{0x13, 0, 0}, ja.netID)
+	assert.Equal(t, DevAddr{0x26, 0x0b, 0x12, 0x34}, ja.devAddr)
+	assert.Equal(t, 2, ja.rx1DROffset)
+	assert.Equal(t, 3, ja.rx2DataRate)
+	assert.Equal(t, 5, ja.rxDelay)
+	assert.Equal(t, cfList, ja.cfList)
+
+	_, err = parseJoinAccept(encryptJoinAccept(appKey, plain), Key{})
+	assert.ErrorIs(t, err, ErrMIC)
+	_, err = parseJoinAccept(make([]byte, 20), appKey)
+	assert.ErrorIs(t, err, ErrFrame)
+}
+
+// encryptJoinAccept is the network server's side of parseJoinAccept: it
+// appends the MIC to plain and encrypts all but the MHDR with AES decrypt.
+func encryptJoinAccept(appKey Key, plain []byte) []byte {
+	m := mic(appKey, plain)
+	body := append(append([]byte(nil), plain[1:]...), m[:]...)
+	block, _ := aes.NewCipher(appKey[:])
+	for i := 0; i+16 <= len(body); i += 16 {
+		block.Decrypt(body[i:i+16], body[i:i+16])
+	}
+	return append([]byte{plain[0]}, body...)
+}
@@ -0,0 +1,317 @@
+package lorawan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"periph.io/x/conn/v3/physic"
+)
+
+type CID byte
+
+const (
+	LinkCheck     CID = 0x02
+	LinkADR       CID = 0x03
+	DutyCycle     CID = 0x04
+	RXParamSetup  CID = 0x05
+	DevStatus     CID = 0x06
+	NewChannel    CID = 0x07
+	RXTimingSetup CID = 0x08
+)
+
+// downlinkLen is the payload length of each MAC command the network sends.
+var downlinkLen = map[CID]int{
+	LinkCheck:     2,
+	LinkADR:       4,
+	DutyCycle:     1,
+	RXParamSetup:  4,
+	DevStatus:     0,
+	NewChannel:    5,
+	RXTimingSetup: 1,
+}
+
+type MACCommand struct {
+	CID     CID
+	Payload []byte
+}
+
+// parseMACCommands splits downlink MAC commands. An unknown CID ends the
+// parse, since the length of whatever follows cannot be known; the commands
+// before it are still returned.
+func parseMACCommands(b []byte) ([]MACCommand, error) {
+	var cmds []MACCommand
+	for len(b) > 0 {
+		cid := CID(b[0])
+		n, ok := downlinkLen[cid]
+		if !ok {
+			return cmds, fmt.Errorf("%w: unknown MAC command 0x%02x", ErrFrame, byte(cid))
+		}
+		if 1+n > len(b) {
+			return cmds, fmt.Errorf("%w: MAC command 0x%02x truncated", ErrFrame, byte(cid))
+		}
+		cmds = append(cmds, MACCommand{CID: cid, Payload: append([]byte(nil), b[1:1+n]...)})
+		b = b[1+n:]
+	}
+	return cmds, nil
+}
+
+func encodeMACCommands(cmds []MACCommand) []byte {
+	var b []byte
+	for _, cmd := range cmds {
+		b = append(b, byte(cmd.CID))
+		b = append(b, cmd.Payload...)
+	}
+	return b
+}
+
+// LinkCheckResult is the network's answer to a link check: the demodulation
+// margin in dB of the uplink at the best gateway and the number of gateways
+// that heard it.
+type LinkCheckResult struct {
+	Margin  uint8
+	GwCount uint8
+}
+
+// macEnv is what MAC command handling needs to know about the device.
+type macEnv struct {
+	region  *region.Region
+	battery uint8
+	snr     float64
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func validDR(r *region.Region, dr int) bool {
+	return dr >= 0 && dr < len(r.DataRates) && r.DataRates[dr].SF != 0
+}
+
+// handleMAC applies the commands of one downlink and queues their answers.
+func (s *Session) handleMAC(env macEnv, cmds []MACCommand) *LinkCheckResult {
+	var lc *LinkCheckResult
+	for i := 0; i < len(cmds); i++ {
+		cmd := cmds[i]
+		switch cmd.CID {
+		case LinkCheck:
+			lc = &LinkCheckResult{Margin: cmd.Payload[0], GwCount: cmd.Payload[1]}
+		case LinkADR:
+			// consecutive LinkADRReqs form one block that is applied, or
+			// rejected, as a whole
+			end := i + 1
+			for end < len(cmds) && cmds[end].CID == LinkADR {
+				end++
+			}
+			status := s.linkADR(env.region, cmds[i:end])
+			for ; i < end; i++ {
+				s.answer(MACCommand{CID: LinkADR, Payload: []byte{status}}, false)
+			}
+			i--
+		case DutyCycle:
+			s.MaxDutyCycle = int(cmd.Payload[0] & 0x0f)
+			s.answer(MACCommand{CID: DutyCycle}, false)
+		case RXParamSetup:
+			s.answer(MACCommand{CID: RXParamSetup, Payload: []byte{s.rxParamSetup(env.region, cmd.Payload)}}, true)
+		case DevStatus:
+			margin := int8(math.Max(-32, math.Min(31, math.Round(env.snr))))
+			s.answer(MACCommand{CID: DevStatus, Payload: []byte{env.battery, byte(margin) & 0x3f}}, false)
+		case NewChannel:
+			s.answer(MACCommand{CID: NewChannel, Payload: []byte{s.newChannel(env.region, cmd.Payload)}}, false)
+		case RXTimingSetup:
+			s.RX1Delay = max(1, int(cmd.Payload[0]&0x0f))
+			s.answer(MACCommand{CID: RXTimingSetup}, true)
+		}
+	}
+	return lc
+}
+
+// answer queues an uplink MAC command. Sticky answers are repeated in every
+// uplink until a downlink arrives.
+func (s *Session) answer(cmd MACCommand, sticky bool) {
+	if sticky {
+		s.StickyAnswers = append(s.StickyAnswers, cmd)
+		return
+	}
+	s.MACAnswers = append(s.MACAnswers, cmd)
+}
+
+const (
+	adrPowerAck   byte = 0x04
+	adrDRAck      byte = 0x02
+	adrChMaskAck  byte = 0x01
+	adrKeepDR          = 0x0f
+	adrKeepPower       = 0x0f
+	fixedPlanSize      = 72
+)
+
+func (s *Session) linkADR(r *region.Region, reqs []MACCommand) byte {
+	mask := append([]bool(nil), s.ChannelMask...)
+	chOK := true
+	for _, req := range reqs {
+		bits := binary.LittleEndian.Uint16(req.Payload[1:])
+		cntl := int(req.Payload[3]>>4) & 0x07
+		if !applyChMask(r, s.Channels, mask, cntl, bits) {
+			chOK = false
+		}
+	}
+	enabled := false
+	for i, on := range mask {
+		enabled = enabled || on && s.Channels[i].Frequency != 0
+	}
+	chOK = chOK && enabled
+
+	last := reqs[len(reqs)-1].Payload
+	dr, power := int(last[0]>>4), int(last[0]&0x0f)
+	nbTrans := int(last[3] & 0x0f)
+	if dr == adrKeepDR {
+		dr = s.DataRate
+	}
+	drOK := false
+	if validDR(r, dr) {
+		for i, on := range mask {
+			c := s.Channels[i]
+			drOK = drOK || on && c.Frequency != 0 && dr >= c.MinDR && dr <= c.MaxDR
+		}
+	}
+	if power == adrKeepPower {
+		power = s.TxPowerIndex
+	}
+	powerOK := power <= r.MaxTxPowerIndex
+
+	var status byte
+	if chOK {
+		status |= adrChMaskAck
+	}
+	if drOK {
+		status |= adrDRAck
+	}
+	if powerOK {
+		status |= adrPowerAck
+	}
+	if status == adrPowerAck|adrDRAck|adrChMaskAck {
+		s.ChannelMask = mask
+		s.DataRate = dr
+		s.TxPowerIndex = power
+		if nbTrans > 0 {
+			s.NbTrans = nbTrans
+		}
+	}
+	return status
+}
+
+// applyChMask applies one ChMask/ChMaskCntl pair to mask. The 72 channel
+// plans of US915 and AU915 have their own ChMaskCntl meanings.
+func applyChMask(r *region.Region, channels []region.Channel, mask []bool, cntl int, bits uint16) bool {
+	set := func(first int, bits uint16, n int) bool {
+		for i := 0; i < n; i++ {
+			on := bits&(1<<i) != 0
+			ch := first + i
+			if ch >= len(mask) || channels[ch].Frequency == 0 {
+				if on {
+					return false
+				}
+				continue
+			}
+			mask[ch] = on
+		}
+		return true
+	}
+	all := func(n int, on bool) {
+		for i := 0; i < n && i < len(mask); i++ {
+			mask[i] = on && channels[i].Frequency != 0
+		}
+	}
+	blocks := (len(channels) + 15) / 16
+
+	switch {
+	case r.FixedPlan && len(channels) == fixedPlanSize:
+		switch {
+		case cntl <= 3:
+			return set(16*cntl, bits, 16)
+		case cntl == 4:
+			return set(64, bits, 8)
+		case cntl == 5:
+			for bank := 0; bank < 8; bank++ {
+				on := bits&(1<<bank) != 0
+				for i := 0; i < 8; i++ {
+					mask[8*bank+i] = on
+				}
+				mask[64+bank] = on
+			}
+			return bits>>8 == 0
+		default:
+			all(64, cntl == 6)
+			return set(64, bits, 8)
+		}
+	case cntl < blocks:
+		return set(16*cntl, bits, 16)
+	case cntl == 6:
+		all(len(mask), true)
+		return true
+	}
+	return false
+}
+
+const (
+	rxParamOffsetAck  byte = 0x04
+	rxParamRX2DRAck   byte = 0x02
+	rxParamChannelAck byte = 0x01
+)
+
+func (s *Session) rxParamSetup(r *region.Region, p []byte) byte {
+	offset, rx2DR := int(p[0]>>4)&0x07, int(p[0]&0x0f)
+	freq := physic.Frequency(uint24(p[1:])) * 100
+	var status byte
+	if offset <= r.MaxRX1DROffset {
+		status |= rxParamOffsetAck
+	}
+	if validDR(r, rx2DR) {
+		status |= rxParamRX2DRAck
+	}
+	if freq >= r.Min && freq <= r.Max {
+		status |= rxParamChannelAck
+	}
+	if status == rxParamOffsetAck|rxParamRX2DRAck|rxParamChannelAck {
+		s.RX1DROffset = offset
+		s.RX2DataRate = rx2DR
+		s.RX2Frequency = freq
+	}
+	return status
+}
+
+const (
+	newChannelDRAck   byte = 0x02
+	newChannelFreqAck byte = 0x01
+	maxChannels            = 16
+)
+
+// newChannel adds, changes or, with a zero frequency, removes a channel.
+// The region's default channels cannot be touched, and fixed plans accept
+// no new channels at all.
+func (s *Session) newChannel(r *region.Region, p []byte) byte {
+	idx := int(p[0])
+	freq := physic.Frequency(uint24(p[1:4])) * 100
+	minDR, maxDR := int(p[4]&0x0f), int(p[4]>>4)
+	if r.FixedPlan || idx < len(r.Channels) || idx >= maxChannels {
+		return 0
+	}
+	var status byte
+	if freq == 0 || freq >= r.Min && freq <= r.Max {
+		status |= newChannelFreqAck
+	}
+	if minDR <= maxDR && validDR(r, minDR) && validDR(r, maxDR) {
+		status |= newChannelDRAck
+	}
+	if status != newChannelDRAck|newChannelFreqAck {
+		return status
+	}
+	for len(s.Channels) <= idx {
+		s.Channels = append(s.Channels, region.Channel{})
+		s.ChannelMask = append(s.ChannelMask, false)
+	}
+	s.Channels[idx] = region.Channel{Frequency: freq, MinDR: minDR, MaxDR: maxDR}
+	s.ChannelMask[idx] = freq != 0
+	return status
+}
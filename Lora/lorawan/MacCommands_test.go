@@ -0,0 +1,213 @@
+package lorawan
+
+import (
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMACCommands(t *testing.T) {
+	cmds, err := parseMACCommands([]byte{0x06, 0x04, 0x03, 0x02, 0x05, 0x01})
+	require.NoError(t, err)
+	assert.Equal(t, []MACCommand{{CID: DevStatus}, {CID: DutyCycle, Payload: []byte{0x03}}, {CID: LinkCheck, Payload: []byte{0x05, 0x01}}}, cmds)
+
+	cmds, err = parseMACCommands([]byte{0x06, 0x80, 0x01})
+	assert.ErrorIs(t, err, ErrFrame)
+	assert.Len(t, cmds, 1)
+	_, err = parseMACCommands([]byte{0x03, 0x01})
+	assert.ErrorIs(t, err, ErrFrame)
+
+	assert.Equal(t, []byte{0x03, 0x07, 0x04, 0x06, 0x01, 0x08}, encodeMACCommands([]MACCommand{
+		{CID: LinkADR, Payload: []byte{0x07}}, {CID: DutyCycle}, {CID: DevStatus, Payload: []byte{0x01, 0x08}},
+	}))
+}
+
+func linkADRReq(dr, power int, mask uint16, cntl, nbTrans int) MACCommand {
+	return MACCommand{CID: LinkADR, Payload: []byte{byte(dr<<4 | power), byte(mask), byte(mask >> 8), byte(cntl<<4 | nbTrans)}}
+}
+
+func TestSession_LinkADR(t *testing.T) {
+	tests := []struct {
+		name    string
+		region  *region.Region
+		reqs    []MACCommand
+		status  byte
+		dr      int
+		power   int
+		nbTrans int
+		enabled []int
+	}{
+		{
+			name:    "eu868 accept",
+			region:  region.EU868,
+			reqs:    []MACCommand{linkADRReq(5, 2, 0x0003, 0, 2)},
+			status:  0x07,
+			dr:      5,
+			power:   2,
+			nbTrans: 2,
+			enabled: []int{0, 1},
+		},
+		{
+			name:    "keep dr and power",
+			region:  region.EU868,
+			reqs:    []MACCommand{linkADRReq(15, 15, 0x0007, 0, 0)},
+			status:  0x07,
+			nbTrans: 1,
+			enabled: []int{0, 1, 2},
+		},
+		{
+			name:    "undefined channel",
+			region:  region.EU868,
+			reqs:    []MACCommand{linkADRReq(5, 2, 0x0009, 0, 0)},
+			status:  0x06,
+			nbTrans: 1,
+			enabled: []int{0, 1, 2},
+		},
+		{
+			name:    "power too high",
+			region:  region.EU868,
+			reqs:    []MACCommand{linkADRReq(5, 8, 0x0007, 0, 0)},
+			status:  0x03,
+			nbTrans: 1,
+			enabled: []int{0, 1, 2},
+		},
+		{
+			name:    "rfu data rate",
+			region:  region.US915,
+			reqs:    []MACCommand{linkADRReq(6, 0, 0x00ff, 0, 0)},
+			status:  0x05,
+			nbTrans: 1,
+			enabled: allIndexes(72),
+		},
+		{
+			name:   "us915 second sub-band block",
+			region: region.US915,
+			reqs: []MACCommand{
+				linkADRReq(3, 5, 0x0002, 7, 0),
+				linkADRReq(3, 5, 0xff00, 0, 1),
+			},
+			status:  0x07,
+			dr:      3,
+			power:   5,
+			nbTrans: 1,
+			enabled: []int{8, 9, 10, 11, 12, 13, 14, 15, 65},
+		},
+		{
+			name:    "us915 bank mask",
+			region:  region.US915,
+			reqs:    []MACCommand{linkADRReq(4, 0, 0x0002, 5, 0)},
+			status:  0x07,
+			dr:      4,
+			nbTrans: 1,
+			enabled: []int{8, 9, 10, 11, 12, 13, 14, 15, 65},
+		},
+		{
+			name:    "all channels off",
+			region:  region.EU868,
+			reqs:    []MACCommand{linkADRReq(5, 0, 0, 0, 0)},
+			status:  0x04,
+			nbTrans: 1,
+			enabled: []int{0, 1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSession(tt.region)
+			s.handleMAC(macEnv{region: tt.region}, tt.reqs)
+			require.Len(t, s.MACAnswers, len(tt.reqs))
+			for _, ans := range s.MACAnswers {
+				assert.Equal(t, MACCommand{CID: LinkADR, Payload: []byte{tt.status}}, ans)
+			}
+			assert.Equal(t, tt.dr, s.DataRate)
+			assert.Equal(t, tt.power, s.TxPowerIndex)
+			assert.Equal(t, tt.nbTrans, s.NbTrans)
+			assert.Equal(t, tt.enabled, enabledIndexes(s.ChannelMask))
+		})
+	}
+}
+
+func allIndexes(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+func enabledIndexes(mask []bool) []int {
+	var idx []int
+	for i, on := range mask {
+		if on {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func TestSession_RXParamSetup(t *testing.T) {
+	s := newSession(region.EU868)
+	// RX1DROffset 2, RX2 DR3 on 869.525 MHz
+	s.handleMAC(macEnv{region: region.EU868}, []MACCommand{{CID: RXParamSetup, Payload: []byte{0x23, 0xd2, 0xad, 0x84}}})
+	assert.Equal(t, []MACCommand{{CID: RXParamSetup, Payload: []byte{0x07}}}, s.StickyAnswers)
+	assert.Equal(t, 2, s.RX1DROffset)
+	assert.Equal(t, 3, s.RX2DataRate)
+	assert.EqualValues(t, 869525000, s.RX2Frequency)
+
+	s = newSession(region.EU868)
+	// offset 6 and a frequency outside the region
+	s.handleMAC(macEnv{region: region.EU868}, []MACCommand{{CID: RXParamSetup, Payload: []byte{0x63, 0x00, 0x00, 0x10}}})
+	assert.Equal(t, []MACCommand{{CID: RXParamSetup, Payload: []byte{0x02}}}, s.StickyAnswers)
+	assert.Equal(t, 0, s.RX1DROffset)
+	assert.Equal(t, region.EU868.RX2.Frequency, s.RX2Frequency)
+}
+
+func TestSession_NewChannel(t *testing.T) {
+	s := newSession(region.EU868)
+	env := macEnv{region: region.EU868}
+	s.handleMAC(env, []MACCommand{
+		// channel 3 at 867.1 MHz, DR0-DR5
+		{CID: NewChannel, Payload: []byte{0x03, 0x18, 0x4f, 0x84, 0x50}},
+		// default channels cannot change
+		{CID: NewChannel, Payload: []byte{0x01, 0x18, 0x4f, 0x84, 0x50}},
+		// max DR below min DR
+		{CID: NewChannel, Payload: []byte{0x04, 0x18, 0x4f, 0x84, 0x05}},
+	})
+	assert.Equal(t, []MACCommand{
+		{CID: NewChannel, Payload: []byte{0x03}},
+		{CID: NewChannel, Payload: []byte{0x00}},
+		{CID: NewChannel, Payload: []byte{0x01}},
+	}, s.MACAnswers)
+	require.Len(t, s.Channels, 4)
+	assert.Equal(t, region.Channel{Frequency: 867100000, MinDR: 0, MaxDR: 5}, s.Channels[3])
+	assert.True(t, s.ChannelMask[3])
+
+	s.handleMAC(env, []MACCommand{{CID: NewChannel, Payload: []byte{0x03, 0, 0, 0, 0}}})
+	assert.False(t, s.ChannelMask[3])
+
+	us := newSession(region.US915)
+	us.handleMAC(macEnv{region: region.US915}, []MACCommand{{CID: NewChannel, Payload: []byte{0x50, 0x18, 0x4f, 0x84, 0x50}}})
+	assert.Equal(t, []MACCommand{{CID: NewChannel, Payload: []byte{0x00}}}, us.MACAnswers)
+}
+
+func TestSession_OtherCommands(t *testing.T) {
+	s := newSession(region.EU868)
+	lc := s.handleMAC(macEnv{region: region.EU868, battery: 200, snr: -7.4}, []MACCommand{
+		{CID: DutyCycle, Payload: []byte{0x04}},
+		{CID: DevStatus},
+		{CID: RXTimingSetup, Payload: []byte{0x00}},
+		{CID: LinkCheck, Payload: []byte{12, 3}},
+	})
+	assert.Equal(t, &LinkCheckResult{Margin: 12, GwCount: 3}, lc)
+	assert.Equal(t, 4, s.MaxDutyCycle)
+	assert.Equal(t, 1, s.RX1Delay)
+	assert.Equal(t, []MACCommand{
+		{CID: DutyCycle},
+		{CID: DevStatus, Payload: []byte{200, 0x39}},
+	}, s.MACAnswers)
+	assert.Equal(t, []MACCommand{{CID: RXTimingSetup}}, s.StickyAnswers)
+
+	s.handleMAC(macEnv{region: region.EU868}, []MACCommand{{CID: RXTimingSetup, Payload: []byte{0x05}}})
+	assert.Equal(t, 5, s.RX1Delay)
+}
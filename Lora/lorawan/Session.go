@@ -0,0 +1,182 @@
+package lorawan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"periph.io/x/conn/v3/physic"
+)
+
+// ErrNoSession is returned by a Store that has nothing saved yet.
+var ErrNoSession = errors.New("no saved session")
+
+// Session is everything a device must keep across restarts. FCntUp and
+// DevNonce must never go back, so it is saved after every uplink and join.
+type Session struct {
+	Region    string
+	Activated bool
+	DevAddr   DevAddr
+	NwkSKey   Key
+	AppSKey   Key
+	// FCntUp is the counter of the next uplink, FCntDown of the next
+	// downlink accepted.
+	FCntUp   uint32
+	FCntDown uint32
+	// DevNonce is the nonce of the next JoinRequest.
+	DevNonce uint16
+
+	DataRate     int
+	TxPowerIndex int
+	NbTrans      int
+	RX1DROffset  int
+	RX2DataRate  int
+	RX2Frequency physic.Frequency
+	// RX1Delay is in seconds; RX2 opens one second later.
+	RX1Delay     int
+	MaxDutyCycle int
+	ADRAckCnt    int
+	// AckDownlink is set when a confirmed downlink still needs its ACK.
+	AckDownlink bool
+
+	Channels    []region.Channel
+	ChannelMask []bool
+	// MACAnswers go out with the next uplink; StickyAnswers with every
+	// uplink until a downlink is received.
+	MACAnswers    []MACCommand
+	StickyAnswers []MACCommand
+
+	Multicast []MulticastGroup
+}
+
+// newSession returns the state of a device that is not yet activated in r.
+func newSession(r *region.Region) Session {
+	s := Session{Region: r.Name}
+	s.resetMAC(r)
+	return s
+}
+
+// resetMAC restores the region defaults that every activation starts with.
+func (s *Session) resetMAC(r *region.Region) {
+	s.DataRate = 0
+	s.TxPowerIndex = 0
+	s.NbTrans = 1
+	s.RX1DROffset = 0
+	s.RX2DataRate = r.RX2.MinDR
+	s.RX2Frequency = r.RX2.Frequency
+	s.RX1Delay = 1
+	s.MaxDutyCycle = 0
+	s.ADRAckCnt = 0
+	s.AckDownlink = false
+	s.Channels = append([]region.Channel(nil), r.Channels...)
+	s.ChannelMask = make([]bool, len(s.Channels))
+	for i := range s.ChannelMask {
+		s.ChannelMask[i] = true
+	}
+	s.MACAnswers = nil
+	s.StickyAnswers = nil
+}
+
+func (c MACCommand) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		CID     byte
+		Payload []byte
+	}{byte(c.CID), c.Payload})
+}
+
+func (c *MACCommand) UnmarshalJSON(b []byte) error {
+	var v struct {
+		CID     byte
+		Payload []byte
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = MACCommand{CID: CID(v.CID), Payload: v.Payload}
+	return nil
+}
+
+// Store keeps a Session across restarts.
+type Store interface {
+	Load() (Session, error)
+	Save(Session) error
+}
+
+// FileStore keeps the session as JSON in a file. Saves go through a
+// temporary file so a crash never leaves a half written session behind.
+type FileStore struct {
+	Path string
+}
+
+func (fs FileStore) Load() (Session, error) {
+	b, err := os.ReadFile(fs.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Session{}, ErrNoSession
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to read session: %w", err)
+	}
+	var s Session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return Session{}, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return s, nil
+}
+
+func (fs FileStore) Save(s Session) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fs.Path), filepath.Base(fs.Path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fs.Path); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// MemoryStore keeps the session in memory, for tests and devices that
+// rejoin after every restart.
+type MemoryStore struct {
+	session *Session
+}
+
+func (ms *MemoryStore) Load() (Session, error) {
+	if ms.session == nil {
+		return Session{}, ErrNoSession
+	}
+	return ms.session.clone(), nil
+}
+
+func (ms *MemoryStore) Save(s Session) error {
+	c := s.clone()
+	ms.session = &c
+	return nil
+}
+
+func (s Session) clone() Session {
+	s.Channels = append([]region.Channel(nil), s.Channels...)
+	s.ChannelMask = append([]bool(nil), s.ChannelMask...)
+	s.MACAnswers = append([]MACCommand(nil), s.MACAnswers...)
+	s.StickyAnswers = append([]MACCommand(nil), s.StickyAnswers...)
+	s.Multicast = append([]MulticastGroup(nil), s.Multicast...)
+	return s
+}
@@ -0,0 +1,51 @@
+package lorawan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	fs := FileStore{Path: filepath.Join(t.TempDir(), "session.json")}
+	_, err := fs.Load()
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	s := newSession(region.EU868)
+	s.Activated = true
+	s.DevAddr = testAddr
+	s.NwkSKey = testNwkSKey
+	s.FCntUp = 70000
+	s.DevNonce = 12
+	s.StickyAnswers = []MACCommand{{CID: RXParamSetup, Payload: []byte{0x07}}}
+	require.NoError(t, fs.Save(s))
+
+	got, err := fs.Load()
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	entries, err := os.ReadDir(filepath.Dir(fs.Path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, os.WriteFile(fs.Path, []byte("{"), 0o600))
+	_, err = fs.Load()
+	assert.ErrorContains(t, err, "failed to decode session")
+}
+
+func TestMemoryStore(t *testing.T) {
+	var ms MemoryStore
+	_, err := ms.Load()
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	s := newSession(region.EU868)
+	require.NoError(t, ms.Save(s))
+	s.ChannelMask[0] = false
+	got, err := ms.Load()
+	require.NoError(t, err)
+	assert.True(t, got.ChannelMask[0])
+}
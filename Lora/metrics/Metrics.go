@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/driver"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var radioStates = []SX1276.RadioState{
+	SX1276.StateUninitialised,
+	SX1276.StateReady,
+	SX1276.StateSleeping,
+	SX1276.StateTransmitting,
+	SX1276.StateReceiving,
+	SX1276.StateClosed,
+}
+
+var modes = []SX1276.LoraMode{SX1276.Sleep, SX1276.Idle, SX1276.Tx, SX1276.RxSingle, SX1276.RxContinuous}
+
+type radio struct {
+	name      string
+	gl        *SX1276.GoLora
+	dutyCycle *SX1276.DutyCycleLimiter
+}
+
+type Option func(r *radio)
+
+// WithDutyCycle adds the per sub-band usage of l to the radio's metrics.
+func WithDutyCycle(l *SX1276.DutyCycleLimiter) Option {
+	return func(r *radio) {
+		r.dutyCycle = l
+	}
+}
+
+// Exporter serves the metrics of every registered radio in the Prometheus
+// text exposition format. Each radio is told apart by its "radio" label.
+type Exporter struct {
+	mu     sync.Mutex
+	radios map[string]*radio
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{radios: map[string]*radio{}}
+}
+
+func (e *Exporter) Register(name string, gl *SX1276.GoLora, opts ...Option) error {
+	if name == "" {
+		return errors.New("radio name must not be empty")
+	}
+	r := &radio{name: name, gl: gl}
+	for _, opt := range opts {
+		opt(r)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.radios[name]; ok {
+		return fmt.Errorf("radio %q already registered", name)
+	}
+	e.radios[name] = r
+	return nil
+}
+
+func (e *Exporter) Unregister(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.radios, name)
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = e.Write(w)
+}
+
+type sample struct {
+	suffix string
+	labels string
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	samples []sample
+}
+
+func (f *family) add(value float64, labels ...string) {
+	f.samples = append(f.samples, sample{labels: formatLabels(labels), value: value})
+}
+
+// formatLabels turns name, value pairs into {name="value",...}.
+func formatLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// histogram adds h to f as a Prometheus histogram named after f.
+func histogram(f *family, h SX1276.Histogram, name string) {
+	var cum uint64
+	for i, bound := range h.Bounds {
+		cum += h.Counts[i]
+		f.samples = append(f.samples, sample{suffix: "_bucket", labels: formatLabels([]string{"radio", name, "le", formatValue(bound)}), value: float64(cum)})
+	}
+	labels := formatLabels([]string{"radio", name})
+	f.samples = append(f.samples,
+		sample{suffix: "_bucket", labels: formatLabels([]string{"radio", name, "le", "+Inf"}), value: float64(h.Count)},
+		sample{suffix: "_sum", labels: labels, value: h.Sum},
+		sample{suffix: "_count", labels: labels, value: float64(h.Count)},
+	)
+}
+
+func (e *Exporter) collect() []*family {
+	e.mu.Lock()
+	radios := make([]*radio, 0, len(e.radios))
+	for _, r := range e.radios {
+		radios = append(radios, r)
+	}
+	e.mu.Unlock()
+	sort.Slice(radios, func(i, j int) bool { return radios[i].name < radios[j].name })
+
+	counter := func(name, help string) *family { return &family{name: name, help: help, kind: "counter"} }
+	gauge := func(name, help string) *family { return &family{name: name, help: help, kind: "gauge"} }
+	var (
+		txAttempts  = counter("golora_tx_attempts_total", "Packets handed to the modem for transmission.")
+		txSuccess   = counter("golora_tx_success_total", "Transmissions that ended with TxDone.")
+		txTimeouts  = counter("golora_tx_timeouts_total", "Transmissions that did not finish in time.")
+		rxPackets   = counter("golora_rx_packets_total", "Packets received intact.")
+		rxCrc       = counter("golora_rx_crc_errors_total", "Packets dropped on a payload CRC error.")
+		rxHeader    = counter("golora_rx_header_errors_total", "Packets dropped on a damaged header.")
+		onAir       = counter("golora_on_air_seconds_total", "Time spent transmitting.")
+		modeSeconds = counter("golora_mode_seconds_total", "Time spent in each operating mode.")
+		spiErrors   = counter("golora_spi_errors_total", "Failed register transfers on the bus.")
+		state       = gauge("golora_state", "Current lifecycle state; 1 for the active state.")
+		dutyCycle   = gauge("golora_duty_cycle_used_ratio", "Share of each sub-band's duty-cycle budget used in the window.")
+		rssi        = &family{name: "golora_rssi_dbm", help: "RSSI of received packets.", kind: "histogram"}
+		snr         = &family{name: "golora_snr_db", help: "SNR of received packets.", kind: "histogram"}
+		families    = []*family{txAttempts, txSuccess, txTimeouts, rxPackets, rxCrc, rxHeader, onAir, modeSeconds, spiErrors, state, dutyCycle, rssi, snr}
+	)
+
+	now := time.Now()
+	for _, r := range radios {
+		st := r.gl.Stats()
+		txAttempts.add(float64(st.TxAttempts), "radio", r.name)
+		txSuccess.add(float64(st.TxSuccess), "radio", r.name)
+		txTimeouts.add(float64(st.TxTimeouts), "radio", r.name)
+		rxPackets.add(float64(st.RxPackets), "radio", r.name)
+		rxCrc.add(float64(st.RxCrcErrors), "radio", r.name)
+		rxHeader.add(float64(st.RxHeaderErrors), "radio", r.name)
+		onAir.add(st.OnAir.Seconds(), "radio", r.name)
+		for _, mode := range modes {
+			modeSeconds.add(st.ModeTime[mode].Seconds(), "radio", r.name, "mode", mode.String())
+		}
+		if ec, ok := r.gl.ModComm.(driver.ErrorCounter); ok {
+			spiErrors.add(float64(ec.ErrorCount()), "radio", r.name)
+		}
+		current := r.gl.State()
+		for _, s := range radioStates {
+			v := 0.0
+			if s == current {
+				v = 1
+			}
+			state.add(v, "radio", r.name, "state", s.String())
+		}
+		if r.dutyCycle != nil {
+			for _, u := range r.dutyCycle.Usage(now) {
+				ratio := 0.0
+				if u.Budget > 0 {
+					ratio = float64(u.Used) / float64(u.Budget)
+				}
+				dutyCycle.add(ratio, "radio", r.name, "band", u.Band.Name)
+			}
+		}
+		histogram(rssi, st.RSSITotal, r.name)
+		histogram(snr, st.SNRTotal, r.name)
+	}
+	return families
+}
+
+// Write renders every registered radio's metrics to w.
+func (e *Exporter) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range e.collect() {
+		if len(f.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(bw, "%s%s%s %s\n", f.name, s.suffix, s.labels, formatValue(s.value))
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/driver"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBus struct {
+	errs uint64
+}
+
+func (fb *fakeBus) SendToMod(reg, value byte) error    { return nil }
+func (fb *fakeBus) ReadFromMod(reg byte) (byte, error) { return 0, nil }
+func (fb *fakeBus) ErrorCount() uint64                 { return fb.errs }
+
+func newRadio(t *testing.T, bus driver.ModComm) *SX1276.GoLora {
+	gl := SX1276.NewGoLoraSX1276(&driver.Driver{ModComm: bus}, SX1276.LoraConf{})
+	t.Cleanup(func() { _ = gl.Close() })
+	return gl
+}
+
+func scrape(t *testing.T, e *Exporter) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	body, err := io.ReadAll(rec.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestExporter(t *testing.T) {
+	e := NewExporter()
+	l := SX1276.NewDutyCycleLimiter(time.Hour, SX1276.EU868SubBands)
+	l.Record(868100000, time.Now(), 9*time.Second)
+	assert.NoError(t, e.Register("gw-1", newRadio(t, &fakeBus{errs: 3}), WithDutyCycle(l)))
+	assert.NoError(t, e.Register(`gw "2"`, newRadio(t, &struct{ driver.ModComm }{})))
+	assert.EqualError(t, e.Register("gw-1", newRadio(t, &fakeBus{})), `radio "gw-1" already registered`)
+
+	out := scrape(t, e)
+	for _, line := range []string{
+		"# HELP golora_tx_attempts_total Packets handed to the modem for transmission.",
+		"# TYPE golora_tx_attempts_total counter",
+		`golora_tx_attempts_total{radio="gw-1"} 0`,
+		`golora_tx_attempts_total{radio="gw \"2\""} 0`,
+		`golora_spi_errors_total{radio="gw-1"} 3`,
+		`golora_state{radio="gw-1",state="uninitialised"} 1`,
+		`golora_state{radio="gw-1",state="ready"} 0`,
+		`golora_duty_cycle_used_ratio{radio="gw-1",band="g1"} 0.25`,
+		`golora_duty_cycle_used_ratio{radio="gw-1",band="g"} 0`,
+		"# TYPE golora_rssi_dbm histogram",
+		`golora_rssi_dbm_bucket{radio="gw-1",le="-130"} 0`,
+		`golora_rssi_dbm_bucket{radio="gw-1",le="+Inf"} 0`,
+		`golora_rssi_dbm_count{radio="gw-1"} 0`,
+		`golora_snr_db_sum{radio="gw-1"} 0`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.Contains(t, out, `golora_mode_seconds_total{radio="gw-1",mode="Sleep"} `)
+	assert.NotContains(t, out, `golora_spi_errors_total{radio="gw \"2\""}`)
+	assert.Equal(t, 1, strings.Count(out, "# TYPE golora_state gauge"))
+	assert.Less(t, strings.Index(out, `{radio="gw \"2\""}`), strings.Index(out, `{radio="gw-1"}`), "radios are sorted")
+
+	e.Unregister("gw-1")
+	e.Unregister(`gw "2"`)
+	assert.Empty(t, scrape(t, e))
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Equal(t, "0.25", formatValue(0.25))
+	assert.Equal(t, "1e+06", formatValue(1e6))
+	assert.Equal(t, "-130", formatValue(-130))
+}
@@ -0,0 +1,203 @@
+package region
+
+import (
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"periph.io/x/conn/v3/physic"
+)
+
+// Values follow the LoRaWAN Regional Parameters RP002-1.0.x. MaxPayload is
+// the MACPayload size M with the default dwell-time setting of each region.
+
+const (
+	bw125 = uint64(SX1276.BW_7)
+	bw250 = uint64(SX1276.BW_8)
+	bw500 = 500000
+)
+
+// sixDR is DR0-DR5, SF12 down to SF7 at 125 kHz, shared by most regions.
+var sixDR = []DataRate{
+	{SF: 12, BW: bw125, MaxPayload: 59},
+	{SF: 11, BW: bw125, MaxPayload: 59},
+	{SF: 10, BW: bw125, MaxPayload: 59},
+	{SF: 9, BW: bw125, MaxPayload: 123},
+	{SF: 8, BW: bw125, MaxPayload: 250},
+	{SF: 7, BW: bw125, MaxPayload: 250},
+}
+
+func withSF7BW250() []DataRate {
+	return append(append([]DataRate(nil), sixDR...), DataRate{SF: 7, BW: bw250, MaxPayload: 250})
+}
+
+// plan lays out n evenly spaced channels starting at first.
+func plan(first physic.Frequency, step physic.Frequency, n int, minDR, maxDR int) []Channel {
+	chs := make([]Channel, n)
+	for i := range chs {
+		chs[i] = Channel{Frequency: first + physic.Frequency(i)*step, MinDR: minDR, MaxDR: maxDR}
+	}
+	return chs
+}
+
+// fixedDownlinkDR is DR8-DR13 of US915 and AU915, SF12 down to SF7 at 500 kHz.
+var fixedDownlinkDR = []DataRate{
+	{SF: 12, BW: bw500, MaxPayload: 61},
+	{SF: 11, BW: bw500, MaxPayload: 137},
+	{SF: 10, BW: bw500, MaxPayload: 250},
+	{SF: 9, BW: bw500, MaxPayload: 250},
+	{SF: 8, BW: bw500, MaxPayload: 250},
+	{SF: 7, BW: bw500, MaxPayload: 250},
+}
+
+// fixedRX1DR is the RX1 table of US915 and AU915: uplink DR0 answers at
+// DR first, clamped to the 500 kHz downlink rates DR8-DR13.
+func fixedRX1DR(first int) func(up, offset int) int {
+	return func(up, offset int) int {
+		return max(8, min(13, first+up-offset))
+	}
+}
+
+// extendedRX1DR is the RX1 table of regions where offsets 6 and 7 raise the
+// data rate by one and two, floored at minDR and capped at DR5.
+func extendedRX1DR(minDR int) func(up, offset int) int {
+	return func(up, offset int) int {
+		if offset > 5 {
+			offset = 5 - offset
+		}
+		return max(minDR, min(5, up-offset))
+	}
+}
+
+var EU868 = register(&Region{
+	Name:            "EU868",
+	Min:             863000000,
+	Max:             870000000,
+	DataRates:       withSF7BW250(),
+	Channels:        plan(868100000, 200000, 3, 0, 5),
+	RX2:             Channel{Frequency: 869525000, MinDR: 0, MaxDR: 0},
+	MaxEIRP:         16,
+	DutyCycle:       SX1276.EU868SubBands,
+	MaxRX1DROffset:  5,
+	MaxTxPowerIndex: 7,
+})
+
+var US915 = register(&Region{
+	Name:      "US915",
+	Min:       902000000,
+	Max:       928000000,
+	FixedPlan: true,
+	DataRates: append([]DataRate{
+		{SF: 10, BW: bw125, MaxPayload: 19},
+		{SF: 9, BW: bw125, MaxPayload: 61},
+		{SF: 8, BW: bw125, MaxPayload: 133},
+		{SF: 7, BW: bw125, MaxPayload: 250},
+		{SF: 8, BW: bw500, MaxPayload: 250},
+		{}, {}, {},
+	}, fixedDownlinkDR...),
+	Channels:        append(plan(902300000, 200000, 64, 0, 3), plan(903000000, 1600000, 8, 4, 4)...),
+	Downlink:        plan(923300000, 600000, 8, 8, 13),
+	RX2:             Channel{Frequency: 923300000, MinDR: 8, MaxDR: 8},
+	MaxEIRP:         30,
+	DwellTime:       400 * time.Millisecond,
+	MaxRX1DROffset:  3,
+	MaxTxPowerIndex: 14,
+	rx1DR:           fixedRX1DR(10),
+})
+
+var AU915 = register(&Region{
+	Name:      "AU915",
+	Min:       915000000,
+	Max:       928000000,
+	FixedPlan: true,
+	DataRates: append(append(append([]DataRate(nil), sixDR...),
+		DataRate{SF: 8, BW: bw500, MaxPayload: 250}, DataRate{}), fixedDownlinkDR...),
+	Channels:        append(plan(915200000, 200000, 64, 0, 5), plan(915900000, 1600000, 8, 6, 6)...),
+	Downlink:        plan(923300000, 600000, 8, 8, 13),
+	RX2:             Channel{Frequency: 923300000, MinDR: 8, MaxDR: 8},
+	MaxEIRP:         30,
+	MaxRX1DROffset:  5,
+	MaxTxPowerIndex: 14,
+	rx1DR:           fixedRX1DR(8),
+})
+
+// AS923 uses the 400 ms dwell time most AS923 countries require, which rules
+// out DR0 and DR1 for uplinks. The LBT rule is the one Japan mandates.
+var AS923 = register(&Region{
+	Name: "AS923",
+	Min:  915000000,
+	Max:  928000000,
+	DataRates: []DataRate{
+		{SF: 12, BW: bw125},
+		{SF: 11, BW: bw125},
+		{SF: 10, BW: bw125, MaxPayload: 19},
+		{SF: 9, BW: bw125, MaxPayload: 61},
+		{SF: 8, BW: bw125, MaxPayload: 133},
+		{SF: 7, BW: bw125, MaxPayload: 250},
+		{SF: 7, BW: bw250, MaxPayload: 250},
+	},
+	Channels:        plan(923200000, 200000, 2, 0, 5),
+	RX2:             Channel{Frequency: 923200000, MinDR: 2, MaxDR: 2},
+	MaxEIRP:         16,
+	DwellTime:       400 * time.Millisecond,
+	LBT:             &SX1276.LbtConfig{Listen: 5 * time.Millisecond, ThresholdDbm: -80},
+	MaxRX1DROffset:  7,
+	MaxTxPowerIndex: 7,
+	rx1DR:           extendedRX1DR(2),
+})
+
+var IN865 = register(&Region{
+	Name:      "IN865",
+	Min:       865000000,
+	Max:       867000000,
+	DataRates: sixDR,
+	Channels: []Channel{
+		{Frequency: 865062500, MinDR: 0, MaxDR: 5},
+		{Frequency: 865402500, MinDR: 0, MaxDR: 5},
+		{Frequency: 865985000, MinDR: 0, MaxDR: 5},
+	},
+	RX2:             Channel{Frequency: 866550000, MinDR: 2, MaxDR: 2},
+	MaxEIRP:         30,
+	MaxRX1DROffset:  7,
+	MaxTxPowerIndex: 10,
+	rx1DR:           extendedRX1DR(0),
+})
+
+var KR920 = register(&Region{
+	Name:            "KR920",
+	Min:             920900000,
+	Max:             923300000,
+	DataRates:       sixDR,
+	Channels:        plan(922100000, 200000, 3, 0, 5),
+	RX2:             Channel{Frequency: 921900000, MinDR: 0, MaxDR: 0},
+	MaxEIRP:         14,
+	LBT:             &SX1276.LbtConfig{Listen: 5 * time.Millisecond, ThresholdDbm: -65},
+	MaxRX1DROffset:  5,
+	MaxTxPowerIndex: 7,
+})
+
+var EU433 = register(&Region{
+	Name:            "EU433",
+	Min:             433175000,
+	Max:             434665000,
+	DataRates:       withSF7BW250(),
+	Channels:        plan(433175000, 200000, 3, 0, 5),
+	RX2:             Channel{Frequency: 434665000, MinDR: 0, MaxDR: 0},
+	MaxEIRP:         12.15,
+	DutyCycle:       []SX1276.SubBand{{Name: "433", Min: 433050000, Max: 434790000, Limit: 0.01}},
+	MaxRX1DROffset:  5,
+	MaxTxPowerIndex: 5,
+})
+
+var CN470 = register(&Region{
+	Name:            "CN470",
+	Min:             470000000,
+	Max:             510000000,
+	FixedPlan:       true,
+	DataRates:       sixDR,
+	Channels:        plan(470300000, 200000, 96, 0, 5),
+	Downlink:        plan(500300000, 200000, 48, 0, 5),
+	RX2:             Channel{Frequency: 505300000, MinDR: 0, MaxDR: 0},
+	MaxEIRP:         19.15,
+	MaxRX1DROffset:  5,
+	MaxTxPowerIndex: 7,
+})
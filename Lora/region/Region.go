@@ -0,0 +1,241 @@
+package region
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	// PublicSyncWord is the LoRaWAN sync word; private networks use 0x12.
+	PublicSyncWord  = SX1276.SyncWordPublic
+	defaultPreamble = 8
+	defaultDenum    = 5
+	minTxPower      = 2
+	maxTxPower      = 17
+)
+
+// DataRate is one LoRaWAN DRx entry. MaxPayload is the largest MACPayload
+// allowed at that rate; a zero SF marks an RFU or non-LoRa data rate.
+type DataRate struct {
+	SF         uint8
+	BW         uint64
+	MaxPayload int
+}
+
+func (dr DataRate) valid() bool {
+	return dr.SF != 0
+}
+
+// Channel is a centre frequency in plain hertz, like LoraConf.Frequency, and
+// the data rates allowed on it.
+type Channel struct {
+	Frequency physic.Frequency
+	MinDR     int
+	MaxDR     int
+}
+
+// Region describes one set of LoRaWAN regional parameters. Min and Max bound
+// every channel centre frequency in the region.
+type Region struct {
+	Name string
+	Min  physic.Frequency
+	Max  physic.Frequency
+	// FixedPlan regions only allow the channels listed in Channels and
+	// Downlink; the others may add channels anywhere between Min and Max.
+	FixedPlan bool
+	DataRates []DataRate
+	// Channels is the whole uplink plan of fixed plans and the default
+	// channels every device starts with otherwise.
+	Channels []Channel
+	// Downlink holds the RX1 channels of fixed plans; nil means RX1 uses the
+	// uplink frequency.
+	Downlink  []Channel
+	RX2       Channel
+	MaxEIRP   float64
+	DwellTime time.Duration
+	DutyCycle []SX1276.SubBand
+	LBT       *SX1276.LbtConfig
+	// MaxRX1DROffset and MaxTxPowerIndex are the highest RX1DROffset and
+	// TXPower the network may set.
+	MaxRX1DROffset  int
+	MaxTxPowerIndex int
+	// rx1DR maps an uplink DR and RX1DROffset to the RX1 DR; nil means the
+	// uplink DR minus the offset, floored at DR0.
+	rx1DR func(up, offset int) int
+}
+
+var (
+	ErrUnknownRegion     = errors.New("unknown region")
+	ErrFrequency         = errors.New("frequency not allowed")
+	ErrDataRate          = errors.New("data rate not allowed")
+	ErrTxPower           = errors.New("tx power above max EIRP")
+	ErrChannelOutOfRange = errors.New("channel out of range")
+)
+
+var regions = map[string]*Region{}
+
+func register(r *Region) *Region {
+	regions[r.Name] = r
+	return r
+}
+
+// Lookup finds a region by name, e.g. "EU868"; the match ignores case.
+func Lookup(name string) (*Region, error) {
+	r, ok := regions[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRegion, name)
+	}
+	return r, nil
+}
+
+// DataRateIndex finds the DR using sf and bw, or -1 if the region has none.
+func (r *Region) DataRateIndex(sf uint8, bw uint64) int {
+	for i, dr := range r.DataRates {
+		if dr.valid() && dr.SF == sf && dr.BW == bw {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Region) dataRate(dr int) (DataRate, error) {
+	if dr < 0 || dr >= len(r.DataRates) || !r.DataRates[dr].valid() {
+		return DataRate{}, fmt.Errorf("%w: DR%d in %s", ErrDataRate, dr, r.Name)
+	}
+	return r.DataRates[dr], nil
+}
+
+// MaxTxPower is the highest LoraConf.TxPower that stays within the region's
+// EIRP with the given antenna gain, clamped to what the SX1276 can set.
+func (r *Region) MaxTxPower(antennaGainDbi float64) uint8 {
+	p := math.Floor(r.MaxEIRP - antennaGainDbi)
+	return uint8(math.Max(minTxPower, math.Min(maxTxPower, p)))
+}
+
+// channels returns every channel the region defines, uplink first.
+func (r *Region) channels() []Channel {
+	all := append([]Channel(nil), r.Channels...)
+	all = append(all, r.Downlink...)
+	return append(all, r.RX2)
+}
+
+// Conf builds a LoraConf for channel ch of Channels at data rate dr, with the
+// highest tx power allowed for a 0 dBi antenna.
+func (r *Region) Conf(ch int, dr int) (SX1276.LoraConf, error) {
+	if ch < 0 || ch >= len(r.Channels) {
+		return SX1276.LoraConf{}, fmt.Errorf("%w: %d not in 0-%d", ErrChannelOutOfRange, ch, len(r.Channels)-1)
+	}
+	c := r.Channels[ch]
+	if dr < c.MinDR || dr > c.MaxDR {
+		return SX1276.LoraConf{}, fmt.Errorf("%w: DR%d on channel %d (DR%d-DR%d)", ErrDataRate, dr, ch, c.MinDR, c.MaxDR)
+	}
+	return r.ConfFor(c.Frequency, dr)
+}
+
+// RX1DataRate is the data rate of the RX1 window that follows an uplink at
+// DR up.
+func (r *Region) RX1DataRate(up, offset int) (int, error) {
+	if _, err := r.dataRate(up); err != nil {
+		return 0, err
+	}
+	if offset < 0 || offset > r.MaxRX1DROffset {
+		return 0, fmt.Errorf("%w: RX1DROffset %d in %s", ErrDataRate, offset, r.Name)
+	}
+	if r.rx1DR == nil {
+		return max(up-offset, 0), nil
+	}
+	return r.rx1DR(up, offset), nil
+}
+
+// TxPowerEIRP is the EIRP in dBm the LinkADRReq TXPower index idx stands for.
+func (r *Region) TxPowerEIRP(idx int) (float64, error) {
+	if idx < 0 || idx > r.MaxTxPowerIndex {
+		return 0, fmt.Errorf("%w: TXPower %d in %s", ErrTxPower, idx, r.Name)
+	}
+	return r.MaxEIRP - 2*float64(idx), nil
+}
+
+// RX2Conf builds the LoraConf for the region's default RX2 window.
+func (r *Region) RX2Conf() (SX1276.LoraConf, error) {
+	return r.ConfFor(r.RX2.Frequency, r.RX2.MinDR)
+}
+
+// ConfFor builds a LoraConf for any frequency at data rate dr; unlike Conf it
+// does not check the frequency against the channel plan.
+func (r *Region) ConfFor(freq physic.Frequency, dr int) (SX1276.LoraConf, error) {
+	rate, err := r.dataRate(dr)
+	if err != nil {
+		return SX1276.LoraConf{}, err
+	}
+	return SX1276.LoraConf{
+		TxPower:        r.MaxTxPower(0),
+		SF:             rate.SF,
+		BW:             rate.BW,
+		Denum:          defaultDenum,
+		PreambleLength: defaultPreamble,
+		SyncWord:       PublicSyncWord,
+		Frequency:      freq,
+		Header:         SX1276.Explicit,
+		EnableCrc:      true,
+	}, nil
+}
+
+// Validate checks that conf is legal in the region with the given antenna
+// gain. Every problem found is reported.
+func (r *Region) Validate(conf SX1276.LoraConf, antennaGainDbi float64) error {
+	var errs []error
+	if conf.Frequency < r.Min || conf.Frequency > r.Max {
+		errs = append(errs, fmt.Errorf("%w: %d Hz outside %s (%d-%d Hz)", ErrFrequency, conf.Frequency, r.Name, r.Min, r.Max))
+	}
+	dr := r.DataRateIndex(conf.SF, conf.BW)
+	if dr < 0 {
+		errs = append(errs, fmt.Errorf("%w: SF%d/%d Hz in %s", ErrDataRate, conf.SF, conf.BW, r.Name))
+	}
+	if r.FixedPlan && len(errs) == 0 {
+		if err := r.checkFixedChannel(conf.Frequency, dr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if eirp := float64(conf.TxPower) + antennaGainDbi; eirp > r.MaxEIRP {
+		errs = append(errs, fmt.Errorf("%w: %.2f dBm EIRP, %s allows %.2f dBm", ErrTxPower, eirp, r.Name, r.MaxEIRP))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Region) checkFixedChannel(freq physic.Frequency, dr int) error {
+	onChannel := false
+	for _, c := range r.channels() {
+		if c.Frequency != freq {
+			continue
+		}
+		if dr >= c.MinDR && dr <= c.MaxDR {
+			return nil
+		}
+		onChannel = true
+	}
+	if onChannel {
+		return fmt.Errorf("%w: DR%d on %d Hz", ErrDataRate, dr, freq)
+	}
+	return fmt.Errorf("%w: %d Hz is not a %s channel", ErrFrequency, freq, r.Name)
+}
+
+// CheckPayload reports whether a MACPayload of n bytes may be sent at dr.
+func (r *Region) CheckPayload(dr int, n int) error {
+	rate, err := r.dataRate(dr)
+	if err != nil {
+		return err
+	}
+	if rate.MaxPayload == 0 {
+		return fmt.Errorf("%w: DR%d cannot carry uplinks in %s", ErrDataRate, dr, r.Name)
+	}
+	if n > rate.MaxPayload {
+		return fmt.Errorf("payload of %d bytes exceeds %d at DR%d in %s", n, rate.MaxPayload, dr, r.Name)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package region
+
+import (
+	"testing"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"EU868", "US915", "AS923", "AU915", "IN865", "KR920", "EU433", "CN470"} {
+		r, err := Lookup(name)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, name, r.Name)
+		}
+	}
+	r, err := Lookup("eu868")
+	assert.NoError(t, err)
+	assert.Same(t, EU868, r)
+	_, err = Lookup("XX123")
+	assert.ErrorIs(t, err, ErrUnknownRegion)
+}
+
+func TestRegion_Plans(t *testing.T) {
+	assert.Len(t, US915.Channels, 72)
+	assert.Equal(t, uint64(902300000), uint64(US915.Channels[0].Frequency))
+	assert.Equal(t, uint64(914900000), uint64(US915.Channels[63].Frequency))
+	assert.Equal(t, uint64(914200000), uint64(US915.Channels[71].Frequency))
+	assert.Equal(t, uint64(927500000), uint64(US915.Downlink[7].Frequency))
+	assert.Equal(t, uint64(927800000), uint64(AU915.Channels[63].Frequency))
+	assert.Len(t, CN470.Channels, 96)
+	assert.Equal(t, uint64(489300000), uint64(CN470.Channels[95].Frequency))
+
+	for _, r := range regions {
+		for i, c := range r.channels() {
+			assert.True(t, c.Frequency >= r.Min && c.Frequency <= r.Max, "%s channel %d out of band", r.Name, i)
+			for dr := c.MinDR; dr <= c.MaxDR; dr++ {
+				_, err := r.dataRate(dr)
+				assert.NoError(t, err, "%s channel %d", r.Name, i)
+			}
+		}
+		assert.LessOrEqual(t, float64(r.MaxTxPower(0)), r.MaxEIRP, r.Name)
+	}
+}
+
+func TestRegion_Conf(t *testing.T) {
+	tests := []struct {
+		name   string
+		region *Region
+		ch, dr int
+		want   SX1276.LoraConf
+		err    error
+	}{
+		{
+			name: "EU868 ch0 DR5", region: EU868, ch: 0, dr: 5,
+			want: SX1276.LoraConf{TxPower: 16, SF: 7, BW: 125000, Denum: 5, PreambleLength: 8, SyncWord: 0x34, Frequency: 868100000, Header: SX1276.Explicit, EnableCrc: true},
+		},
+		{
+			name: "US915 ch64 DR4", region: US915, ch: 64, dr: 4,
+			want: SX1276.LoraConf{TxPower: 17, SF: 8, BW: 500000, Denum: 5, PreambleLength: 8, SyncWord: 0x34, Frequency: 903000000, Header: SX1276.Explicit, EnableCrc: true},
+		},
+		{
+			name: "EU433 power", region: EU433, ch: 2, dr: 0,
+			want: SX1276.LoraConf{TxPower: 12, SF: 12, BW: 125000, Denum: 5, PreambleLength: 8, SyncWord: 0x34, Frequency: 433575000, Header: SX1276.Explicit, EnableCrc: true},
+		},
+		{name: "US915 125 kHz channel at DR4", region: US915, ch: 0, dr: 4, err: ErrDataRate},
+		{name: "EU868 DR7 is FSK", region: EU868, ch: 0, dr: 7, err: ErrDataRate},
+		{name: "AS923 channel 2", region: AS923, ch: 2, dr: 2, err: ErrChannelOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := tt.region.Conf(tt.ch, tt.dr)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, conf)
+			assert.NoError(t, tt.region.Validate(conf, 0))
+		})
+	}
+
+	rx2, err := EU868.RX2Conf()
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(12), rx2.SF)
+	assert.Equal(t, uint64(869525000), uint64(rx2.Frequency))
+}
+
+func TestRegion_Validate(t *testing.T) {
+	eu, _ := EU868.Conf(0, 5)
+	us, _ := US915.Conf(8, 3)
+	tests := []struct {
+		name   string
+		region *Region
+		edit   func(c *SX1276.LoraConf)
+		gain   float64
+		errs   []error
+	}{
+		{name: "extra EU868 channel", region: EU868, edit: func(c *SX1276.LoraConf) { c.Frequency = 867100000 }},
+		{name: "915 MHz in EU868", region: EU868, edit: func(c *SX1276.LoraConf) { c.Frequency = 915000000 }, errs: []error{ErrFrequency}},
+		{name: "SF7 BW500 in EU868", region: EU868, edit: func(c *SX1276.LoraConf) { c.BW = 500000 }, errs: []error{ErrDataRate}},
+		{name: "antenna gain", region: EU868, gain: 3, errs: []error{ErrTxPower}},
+		{name: "everything wrong", region: EU868, gain: 3, edit: func(c *SX1276.LoraConf) { c.Frequency = 915000000; c.SF = 6 }, errs: []error{ErrFrequency, ErrDataRate, ErrTxPower}},
+		{name: "US915 ch8", region: US915},
+		{name: "US915 off grid", region: US915, edit: func(c *SX1276.LoraConf) { c.Frequency = 904000000 }, errs: []error{ErrFrequency}},
+		{name: "US915 SF12 uplink", region: US915, edit: func(c *SX1276.LoraConf) { c.BW = 500000; c.SF = 12 }, errs: []error{ErrDataRate}},
+		{name: "US915 SF12 downlink", region: US915, edit: func(c *SX1276.LoraConf) { c.BW = 500000; c.SF = 12; c.Frequency = 923900000 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := eu
+			if tt.region == US915 {
+				conf = us
+			}
+			if tt.edit != nil {
+				tt.edit(&conf)
+			}
+			err := tt.region.Validate(conf, tt.gain)
+			if len(tt.errs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			for _, want := range tt.errs {
+				assert.ErrorIs(t, err, want)
+			}
+		})
+	}
+}
+
+func TestRegion_CheckPayload(t *testing.T) {
+	assert.NoError(t, EU868.CheckPayload(0, 51))
+	assert.Error(t, EU868.CheckPayload(0, 60))
+	assert.NoError(t, US915.CheckPayload(0, 11))
+	assert.EqualError(t, AS923.CheckPayload(0, 1), "data rate not allowed: DR0 cannot carry uplinks in AS923")
+	assert.ErrorIs(t, US915.CheckPayload(6, 1), ErrDataRate)
+}
+
+func TestRegion_RX1DataRate(t *testing.T) {
+	tests := []struct {
+		region     *Region
+		up, offset int
+		want       int
+	}{
+		{region: EU868, up: 5, offset: 0, want: 5},
+		{region: EU868, up: 5, offset: 2, want: 3},
+		{region: EU868, up: 1, offset: 3, want: 0},
+		{region: US915, up: 0, offset: 0, want: 10},
+		{region: US915, up: 0, offset: 3, want: 8},
+		{region: US915, up: 4, offset: 1, want: 13},
+		{region: US915, up: 4, offset: 3, want: 11},
+		{region: AU915, up: 0, offset: 0, want: 8},
+		{region: AU915, up: 6, offset: 1, want: 13},
+		{region: AU915, up: 5, offset: 5, want: 8},
+		{region: AS923, up: 2, offset: 0, want: 2},
+		{region: AS923, up: 4, offset: 5, want: 2},
+		{region: AS923, up: 4, offset: 6, want: 5},
+		{region: IN865, up: 3, offset: 7, want: 5},
+		{region: IN865, up: 2, offset: 6, want: 3},
+	}
+	for _, tt := range tests {
+		got, err := tt.region.RX1DataRate(tt.up, tt.offset)
+		if assert.NoError(t, err, "%s DR%d offset %d", tt.region.Name, tt.up, tt.offset) {
+			assert.Equal(t, tt.want, got, "%s DR%d offset %d", tt.region.Name, tt.up, tt.offset)
+		}
+	}
+
+	_, err := US915.RX1DataRate(0, 4)
+	assert.ErrorIs(t, err, ErrDataRate)
+	_, err = US915.RX1DataRate(5, 0)
+	assert.ErrorIs(t, err, ErrDataRate)
+}
+
+func TestRegion_TxPowerEIRP(t *testing.T) {
+	eirp, err := EU868.TxPowerEIRP(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 16.0, eirp)
+	eirp, err = US915.TxPowerEIRP(14)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, eirp)
+	_, err = EU868.TxPowerEIRP(8)
+	assert.ErrorIs(t, err, ErrTxPower)
+}
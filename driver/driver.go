@@ -20,6 +20,12 @@ type Driver struct {
 	ModComm
 }
 
+// ErrorCounter is implemented by ModComm transports that count failed
+// transfers.
+type ErrorCounter interface {
+	ErrorCount() uint64
+}
+
 type HwDriver interface {
 	Init() (*Driver, error)
 }
@@ -5,6 +5,7 @@ import (
 	"log"
 	"regexp"
 	"sync"
+	"sync/atomic"
 
 	"periph.io/x/conn/v3/gpio"
 	"periph.io/x/conn/v3/gpio/gpioreg"
@@ -28,6 +29,7 @@ type SPI struct {
 	*SpiConf
 	CSPin gpio.PinIO
 	mu    sync.Mutex
+	errs  atomic.Uint64
 }
 
 func NewDefaultConf() *SpiConf {
@@ -205,6 +207,9 @@ func (pi *SPI) SendToMod(reg, value byte) error {
 	} else {
 		err = pi.hardCSTx(reg, value)
 	}
+	if err != nil {
+		pi.errs.Add(1)
+	}
 	return err
 }
 
@@ -219,5 +224,13 @@ func (pi *SPI) ReadFromMod(reg byte) (byte, error) {
 	} else {
 		rx, err = pi.hardCSRx(reg)
 	}
+	if err != nil {
+		pi.errs.Add(1)
+	}
 	return rx, err
 }
+
+// ErrorCount is the number of register transfers that failed.
+func (pi *SPI) ErrorCount() uint64 {
+	return pi.errs.Load()
+}
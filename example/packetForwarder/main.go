@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/Fsyahputra/GoLora/Lora/SX1276"
+	"github.com/Fsyahputra/GoLora/Lora/gateway"
+	"github.com/Fsyahputra/GoLora/Lora/lorawan"
+	"github.com/Fsyahputra/GoLora/Lora/region"
+	"github.com/Fsyahputra/GoLora/driver/periphIO"
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/host/v3"
+)
+
+// packetForwarder is a single-channel gateway forwarding to a network server,
+// such as ChirpStack, over the Semtech UDP protocol, or over LoRa Basics
+// Station when -lns is given.
+func main() {
+	server := flag.String("server", "localhost:1700", "network server host:port")
+	lns := flag.String("lns", "", "Basics Station LNS URI, e.g. wss://lns.example.com:8887")
+	auth := flag.String("auth", "", "Authorization header sent to the LNS")
+	eui := flag.String("eui", "", "gateway EUI, 16 hex digits")
+	regionName := flag.String("region", "EU868", "regional parameters")
+	freq := flag.Uint64("freq", 868100000, "receive frequency in Hz")
+	sf := flag.Uint("sf", 7, "receive spreading factor")
+	bw := flag.Uint64("bw", 125000, "receive bandwidth in Hz")
+	syncWord := flag.String("sync", "public", `sync word: "public", "private" or a number`)
+	gain := flag.Float64("gain", 0, "antenna gain in dBi")
+	cbPin := flag.String("cb", "GPIO6", "DIO0 pin")
+	rstPin := flag.String("rst", "GPIO7", "reset pin")
+	spiDev := flag.String("spi", "", "SPI device, e.g. /dev/spidev1.0")
+	flag.Parse()
+
+	gwEUI, err := lorawan.ParseEUI(*eui)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reg, err := region.Lookup(*regionName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sw, err := SX1276.ParseSyncWord(*syncWord)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dr := reg.DataRateIndex(uint8(*sf), *bw)
+	if dr < 0 {
+		log.Fatalf("SF%d/%d Hz is not a %s data rate", *sf, *bw, reg.Name)
+	}
+	rx, err := reg.ConfFor(physic.Frequency(*freq), dr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rx.SyncWord = sw
+
+	if _, err := host.Init(); err != nil {
+		log.Fatal(err)
+	}
+	spiConf := periphIO.NewDefaultConf()
+	if *spiDev != "" {
+		spiConf.Reg = *spiDev
+	}
+	drv, err := periphIO.NewDriver(*cbPin, *rstPin, spiConf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hwDrv, err := drv.Init()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gl := SX1276.NewGoLoraSX1276(hwDrv, rx)
+	if err := gl.Begin(); err != nil {
+		log.Fatal(err)
+	}
+	defer gl.Destroy()
+
+	gw, err := gateway.New(gl, gateway.Config{RX: rx, Region: reg, AntennaGain: *gain})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sub, err := gw.Attach(gl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	var run func(ctx context.Context) error
+	if *lns != "" {
+		header := http.Header{}
+		if *auth != "" {
+			header.Set("Authorization", *auth)
+		}
+		st, err := gateway.NewStation(gw, gateway.StationConfig{GatewayEUI: gwEUI, URI: *lns, Header: header, OnError: func(err error) { log.Print(err) }})
+		if err != nil {
+			log.Fatal(err)
+		}
+		run = st.Run
+	} else {
+		fwd, err := gateway.NewForwarder(gw, gateway.ForwarderConfig{GatewayEUI: gwEUI, Server: *server, OnError: func(err error) { log.Print(err) }})
+		if err != nil {
+			log.Fatal(err)
+		}
+		run = fwd.Run
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		if err := gw.Run(ctx); err != nil && ctx.Err() == nil {
+			fmt.Println("gateway stopped:", err)
+			stop()
+		}
+	}()
+	fmt.Printf("forwarding %d Hz SF%d as %s\n", *freq, *sf, gwEUI)
+	if err := run(ctx); err != nil && ctx.Err() == nil {
+		fmt.Println(err)
+	}
+}
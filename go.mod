@@ -3,6 +3,7 @@ module github.com/Fsyahputra/GoLora
 go 1.25.1
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/stretchr/testify v1.11.1
 	periph.io/x/conn/v3 v3.7.2
 	periph.io/x/host/v3 v3.8.5